@@ -37,6 +37,18 @@ func StatsFlowHash(r *FlowRecord) string {
 	hash.Write([]byte(r.Action.String())) // nolint errcheck
 	hash.Write([]byte(r.DropReason))      // nolint errcheck
 	hash.Write([]byte(r.Destination.URI)) // nolint errcheck
+	if r.Accounting {
+		hash.Write([]byte("accounting")) // nolint errcheck
+	}
+	// Terminations are reported for every flow and are never aggregated
+	if r.Termination != nil {
+		hash.Write([]byte("termination")) // nolint errcheck
+		hash.Write([]byte(r.Source.IP))   // nolint errcheck
+		binary.BigEndian.PutUint16(port, r.Source.Port)
+		hash.Write(port)                                    // nolint errcheck
+		hash.Write([]byte(r.Termination.Reason))            // nolint errcheck
+		hash.Write([]byte(r.Termination.Duration.String())) // nolint errcheck
+	}
 
 	return fmt.Sprintf("%d", hash.Sum64())
 }
@@ -0,0 +1,157 @@
+package collector
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// OtherMethod is the method of the metrics of the requests with a method that
+// is not a standard HTTP method. The methods are chosen by the clients, so they
+// can not create new APIs in the metrics.
+const OtherMethod = "OTHER"
+
+// LatencyBuckets are the upper bounds of the buckets of the latency histograms.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// LatencyHistogram counts latencies in the LatencyBuckets. The last count is
+// for the latencies above the last bucket.
+type LatencyHistogram struct {
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// Observe adds a latency to the histogram.
+func (h *LatencyHistogram) Observe(d time.Duration) {
+
+	if len(h.Counts) == 0 {
+		h.Counts = make([]uint64, len(LatencyBuckets)+1)
+	}
+
+	i := 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+}
+
+// Merge adds the counts of another histogram.
+func (h *LatencyHistogram) Merge(o LatencyHistogram) {
+
+	if len(h.Counts) == 0 {
+		h.Counts = make([]uint64, len(LatencyBuckets)+1)
+	}
+
+	for i := 0; i < len(o.Counts) && i < len(h.Counts); i++ {
+		h.Counts[i] += o.Counts[i]
+	}
+	h.Count += o.Count
+	h.Sum += o.Sum
+}
+
+// HTTPMetrics aggregates the metrics of the APIs until they are reported.
+type HTTPMetrics struct {
+	records map[string]*HTTPMetricsRecord
+	sync.Mutex
+}
+
+// NewHTTPMetrics returns an empty aggregate of the metrics of the APIs.
+func NewHTTPMetrics() *HTTPMetrics {
+	return &HTTPMetrics{
+		records: map[string]*HTTPMetricsRecord{},
+	}
+}
+
+// Observe adds a request to the metrics of its API.
+func (m *HTTPMetrics) Observe(r *HTTPRecord) {
+
+	record := &HTTPMetricsRecord{
+		ContextID:   r.ContextID,
+		Port:        r.Port,
+		Method:      metricsMethod(r.Method),
+		PolicyID:    r.PolicyID,
+		Network:     r.Network,
+		StatusCodes: map[int]uint64{r.StatusCode: 1},
+	}
+	record.Latency.Observe(r.Latency)
+
+	m.Merge(record)
+}
+
+// Merge adds the metrics of an API.
+func (m *HTTPMetrics) Merge(record *HTTPMetricsRecord) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	key := StatsHTTPMetricsHash(record)
+
+	current, ok := m.records[key]
+	if !ok {
+		current = &HTTPMetricsRecord{
+			ContextID:   record.ContextID,
+			Port:        record.Port,
+			Method:      metricsMethod(record.Method),
+			PolicyID:    record.PolicyID,
+			Network:     record.Network,
+			StatusCodes: map[int]uint64{},
+		}
+		m.records[key] = current
+	}
+
+	current.Latency.Merge(record.Latency)
+	for code, count := range record.StatusCodes {
+		current.StatusCodes[code] += count
+	}
+}
+
+// Flush returns the metrics aggregated since the previous flush.
+func (m *HTTPMetrics) Flush() map[string]*HTTPMetricsRecord {
+
+	m.Lock()
+	defer m.Unlock()
+
+	if len(m.records) == 0 {
+		return nil
+	}
+
+	records := m.records
+	m.records = map[string]*HTTPMetricsRecord{}
+
+	return records
+}
+
+// StatsHTTPMetricsHash returns the key of the API of metrics.
+func StatsHTTPMetricsHash(r *HTTPMetricsRecord) string {
+	return fmt.Sprintf("%s/%d/%t/%s/%s", r.ContextID, r.Port, r.Network, metricsMethod(r.Method), r.PolicyID)
+}
+
+// metricsMethod returns the method of the metrics of a request.
+func metricsMethod(method string) string {
+
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return OtherMethod
+	}
+}
@@ -0,0 +1,87 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+
+	Convey("Given a latency histogram", t, func() {
+		h := LatencyHistogram{}
+		h.Observe(500 * time.Microsecond)
+		h.Observe(time.Millisecond)
+		h.Observe(30 * time.Millisecond)
+		h.Observe(time.Minute)
+
+		Convey("Then the latencies should be counted in their buckets", func() {
+			So(h.Count, ShouldEqual, 4)
+			So(h.Counts[0], ShouldEqual, 2)
+			So(h.Counts[4], ShouldEqual, 1)
+			So(h.Counts[len(LatencyBuckets)], ShouldEqual, 1)
+		})
+
+		Convey("Then merged histograms should add their counts", func() {
+			o := LatencyHistogram{}
+			o.Merge(h)
+			o.Merge(h)
+			So(o.Count, ShouldEqual, 8)
+			So(o.Counts[0], ShouldEqual, 4)
+			So(o.Sum, ShouldEqual, 2*h.Sum)
+		})
+	})
+}
+
+func TestHTTPMetrics(t *testing.T) {
+
+	Convey("Given the metrics of APIs", t, func() {
+		m := NewHTTPMetrics()
+		m.Observe(&HTTPRecord{ContextID: "pu", Port: 80, Method: "GET", PolicyID: "read", StatusCode: 200, Latency: time.Millisecond})
+		m.Observe(&HTTPRecord{ContextID: "pu", Port: 80, Method: "GET", PolicyID: "read", StatusCode: 200, Latency: time.Second})
+		m.Observe(&HTTPRecord{ContextID: "pu", Port: 80, Method: "GET", PolicyID: "read", StatusCode: 403, Latency: time.Millisecond})
+		m.Observe(&HTTPRecord{ContextID: "pu", Port: 80, Method: "POST", PolicyID: "write", StatusCode: 201, Latency: time.Millisecond})
+
+		Convey("Then the requests should be aggregated per API", func() {
+			records := m.Flush()
+			So(records, ShouldHaveLength, 2)
+
+			read := records[StatsHTTPMetricsHash(&HTTPMetricsRecord{ContextID: "pu", Port: 80, Method: "GET", PolicyID: "read"})]
+			So(read, ShouldNotBeNil)
+			So(read.Latency.Count, ShouldEqual, 3)
+			So(read.StatusCodes, ShouldResemble, map[int]uint64{200: 2, 403: 1})
+
+			Convey("Then the metrics should be reset", func() {
+				So(m.Flush(), ShouldBeNil)
+			})
+		})
+
+		Convey("Then the requests with non-standard methods should be aggregated in one API", func() {
+			m.Flush()
+			m.Observe(&HTTPRecord{ContextID: "pu", Port: 80, Method: "FOO", PolicyID: "read", StatusCode: 405, Latency: time.Millisecond})
+			m.Observe(&HTTPRecord{ContextID: "pu", Port: 80, Method: "BAR", PolicyID: "read", StatusCode: 405, Latency: time.Millisecond})
+
+			records := m.Flush()
+			So(records, ShouldHaveLength, 1)
+
+			other := records[StatsHTTPMetricsHash(&HTTPMetricsRecord{ContextID: "pu", Port: 80, Method: OtherMethod, PolicyID: "read"})]
+			So(other, ShouldNotBeNil)
+			So(other.Method, ShouldEqual, OtherMethod)
+			So(other.Latency.Count, ShouldEqual, 2)
+		})
+
+		Convey("Then merged metrics should add to the current ones", func() {
+			records := m.Flush()
+			for _, r := range records {
+				m.Merge(r)
+				m.Merge(r)
+			}
+			merged := m.Flush()
+			So(merged, ShouldHaveLength, 2)
+			for key, r := range merged {
+				So(r.Latency.Count, ShouldEqual, 2*records[key].Latency.Count)
+			}
+		})
+	})
+}
@@ -2,6 +2,7 @@ package collector
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/aporeto-inc/trireme-lib/policy"
 )
@@ -28,6 +29,24 @@ const (
 	InvalidNonse = "nonse"
 	// PolicyDrop indicates that the flow is rejected because of the policy decision
 	PolicyDrop = "policy"
+	// RateLimitDrop indicates that the flow is rejected because it exceeded the rate limit of the policy
+	RateLimitDrop = "ratelimit"
+	// RevokedCertificate indicates that the certificate or the key of the remote has been revoked
+	RevokedCertificate = "revoked"
+	// ReplayedToken indicates that the token has already been received
+	ReplayedToken = "replay"
+)
+
+// Flow termination description
+const (
+	// FlowClosedFin indicates that the flow was closed with a FIN
+	FlowClosedFin = "fin"
+	// FlowClosedRst indicates that the flow was reset
+	FlowClosedRst = "rst"
+	// FlowClosed indicates that the flow was released to the kernel and is no longer tracked by conntrack
+	FlowClosed = "closed"
+	// FlowExpired indicates that the flow state expired after a period of inactivity
+	FlowExpired = "expired"
 )
 
 // Container event description
@@ -70,6 +89,18 @@ type EventCollector interface {
 	CollectUserEvent(record *UserRecord)
 }
 
+// HTTPCollector is an optional interface of the event collectors. Collectors
+// that implement it receive the access logs and the metrics of the APIs of the
+// HTTP application proxy.
+type HTTPCollector interface {
+
+	// CollectHTTPEvent collects the access log record of an HTTP request.
+	CollectHTTPEvent(record *HTTPRecord)
+
+	// CollectHTTPMetrics collects the metrics of an API since the previous report.
+	CollectHTTPMetrics(record *HTTPMetricsRecord)
+}
+
 // EndPointType is the type of an endpoint (PU or an external IP address )
 type EndPointType byte
 
@@ -110,9 +141,123 @@ type FlowRecord struct {
 	Action           policy.ActionType
 	ObservedAction   policy.ActionType
 	L4Protocol       uint8
+	// Accounting is set on the records that only report the traffic of an
+	// accepted flow. They are sent periodically while the flow is active and
+	// once more when it is closed. Their counters hold the traffic since the
+	// previous record of the same flow.
+	Accounting bool
+	FlowCounters
+	// Termination is set on the records that report the end of an accepted
+	// flow. They do not count as a new flow.
+	Termination *FlowTermination
+}
+
+// FlowTermination describes the end of an accepted flow.
+type FlowTermination struct {
+	// Reason is one of the flow termination descriptions
+	Reason string
+	// Duration is the time between the first packet and the end of the flow
+	Duration time.Duration
+	// State is the last state of the connection in the datapath
+	State string
+}
+
+// FlowCounters are the bytes and packets sent by the source and by the
+// destination of a flow.
+type FlowCounters struct {
+	SourceBytes        uint64
+	SourcePackets      uint64
+	DestinationBytes   uint64
+	DestinationPackets uint64
+}
+
+// Add returns the sum of the counters.
+func (c FlowCounters) Add(o FlowCounters) FlowCounters {
+	return FlowCounters{
+		SourceBytes:        c.SourceBytes + o.SourceBytes,
+		SourcePackets:      c.SourcePackets + o.SourcePackets,
+		DestinationBytes:   c.DestinationBytes + o.DestinationBytes,
+		DestinationPackets: c.DestinationPackets + o.DestinationPackets,
+	}
+}
+
+// Sub returns the traffic counted since the previous counters. Counters that
+// went backwards are returned as they are.
+func (c FlowCounters) Sub(prev FlowCounters) FlowCounters {
+
+	sub := func(cur, prev uint64) uint64 {
+		if cur < prev {
+			return cur
+		}
+		return cur - prev
+	}
+
+	return FlowCounters{
+		SourceBytes:        sub(c.SourceBytes, prev.SourceBytes),
+		SourcePackets:      sub(c.SourcePackets, prev.SourcePackets),
+		DestinationBytes:   sub(c.DestinationBytes, prev.DestinationBytes),
+		DestinationPackets: sub(c.DestinationPackets, prev.DestinationPackets),
+	}
+}
+
+// Zero returns true if no traffic was counted.
+func (c FlowCounters) Zero() bool {
+	return c == FlowCounters{}
+}
+
+// NewAccountingRecord returns the accounting record of the traffic of the
+// accepted flow reported by r.
+func NewAccountingRecord(r *FlowRecord, counters FlowCounters) *FlowRecord {
+
+	record := *r
+	record.Count = 0
+	record.Accounting = true
+	record.FlowCounters = counters
+
+	return &record
+}
+
+// NewTerminationRecord returns the record of the end of the accepted flow
+// reported by r.
+func NewTerminationRecord(r *FlowRecord, termination *FlowTermination) *FlowRecord {
+
+	record := *r
+	record.Count = 0
+	record.Termination = termination
+
+	return &record
 }
 
 func (f *FlowRecord) String() string {
+	if f.Termination != nil {
+		return fmt.Sprintf("<flowrecord contextID:%s sourceID:%s destinationID:%s sourceIP: %s destinationIP:%s destinationPort:%d reason:%s duration:%s state:%s>",
+			f.ContextID,
+			f.Source.ID,
+			f.Destination.ID,
+			f.Source.IP,
+			f.Destination.IP,
+			f.Destination.Port,
+			f.Termination.Reason,
+			f.Termination.Duration,
+			f.Termination.State,
+		)
+	}
+
+	if f.Accounting {
+		return fmt.Sprintf("<flowrecord contextID:%s sourceID:%s destinationID:%s sourceIP: %s destinationIP:%s destinationPort:%d sourceBytes:%d sourcePackets:%d destinationBytes:%d destinationPackets:%d>",
+			f.ContextID,
+			f.Source.ID,
+			f.Destination.ID,
+			f.Source.IP,
+			f.Destination.IP,
+			f.Destination.Port,
+			f.SourceBytes,
+			f.SourcePackets,
+			f.DestinationBytes,
+			f.DestinationPackets,
+		)
+	}
+
 	return fmt.Sprintf("<flowrecord contextID:%s count:%d sourceID:%s destinationID:%s sourceIP: %s destinationIP:%s destinationPort:%d action:%s mode:%s>",
 		f.ContextID,
 		f.Count,
@@ -140,3 +285,54 @@ type UserRecord struct {
 	ID     string
 	Claims []string
 }
+
+// HTTPRecord is the access log record of an HTTP request handled by the
+// application proxy.
+type HTTPRecord struct {
+	ContextID     string
+	Method        string
+	URI           string
+	Host          string
+	StatusCode    int
+	Latency       time.Duration
+	RequestBytes  int64
+	ResponseBytes int64
+	SourceID      string
+	UserID        string
+	// PolicyID is the ID of the HTTP rule that matched the request
+	PolicyID string
+	Action   policy.ActionType
+	Port     uint16
+	// Network is true for the requests received from the network and false
+	// for the requests of the applications
+	Network bool
+}
+
+func (r *HTTPRecord) String() string {
+	return fmt.Sprintf("<httprecord contextID:%s method:%s uri:%s host:%s status:%d latency:%s requestBytes:%d responseBytes:%d sourceID:%s userID:%s policyID:%s action:%s>",
+		r.ContextID,
+		r.Method,
+		r.URI,
+		r.Host,
+		r.StatusCode,
+		r.Latency,
+		r.RequestBytes,
+		r.ResponseBytes,
+		r.SourceID,
+		r.UserID,
+		r.PolicyID,
+		r.Action.String(),
+	)
+}
+
+// HTTPMetricsRecord holds the latency and status histograms of the requests of
+// an API. The API is identified by the port, the method and the HTTP rule.
+type HTTPMetricsRecord struct {
+	ContextID   string
+	Port        uint16
+	Method      string
+	PolicyID    string
+	Network     bool
+	Latency     LatencyHistogram
+	StatusCodes map[int]uint64
+}
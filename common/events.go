@@ -28,6 +28,9 @@ const (
 
 	// TriremeSocket is the standard API server Trireme socket path
 	TriremeSocket = "/var/run/trireme.sock"
+
+	// TriremeIntrospectionSocket is the standard introspection API socket path
+	TriremeIntrospectionSocket = "/var/run/trireme-introspection.sock"
 )
 
 // EventInfo is a generic structure that defines all the information related to a PU event.
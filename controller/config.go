@@ -15,7 +15,10 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/internal/supervisor/proxy"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packetprocessor"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/revocation"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
+	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/allocator"
 	"go.uber.org/zap"
 )
@@ -40,6 +43,11 @@ type config struct {
 	procMountPoint         string
 	externalIPcacheTimeout time.Duration
 	targetNetworks         []string
+	introspectionSocket    string
+	tokenEngine            tokens.EngineType
+	tagDictionary          *tokens.TagDictionary
+	attestation            *policy.Attestation
+	revocationList         *revocation.List
 }
 
 // Option is provided using functional arguments.
@@ -108,6 +116,51 @@ func OptionPacketLogs() Option {
 	}
 }
 
+// OptionIntrospection is an option to serve the introspection API on the
+// given unix socket. common.TriremeIntrospectionSocket is the standard path.
+func OptionIntrospection(socket string) Option {
+	return func(cfg *config) {
+		cfg.introspectionSocket = socket
+	}
+}
+
+// OptionTokenEngine is an option to select the engine of the tokens. The JWT
+// engine is used by default. All the enforcers must use the same engine.
+func OptionTokenEngine(e tokens.EngineType) Option {
+	return func(cfg *config) {
+		cfg.tokenEngine = e
+	}
+}
+
+// OptionTagDictionary is an option to encode the tags of the tokens with a
+// dictionary shared by all the enforcers. It keeps the tokens of PUs with many
+// tags small.
+func OptionTagDictionary(d *tokens.TagDictionary) Option {
+	return func(cfg *config) {
+		cfg.tagDictionary = d
+	}
+}
+
+// OptionNodeAttestation is an option to attest the facts of the node in the
+// tokens of the enforcers. The remote enforcers can then select the PUs by the
+// kernel version, enforcer binary hash or boot ID of their node with the
+// policy.NodeKernelKey, policy.NodeEnforcerKey and policy.NodeBootIDKey tags.
+// The attestation of the node is returned by extractors.NodeAttestation.
+func OptionNodeAttestation(a *policy.Attestation) Option {
+	return func(cfg *config) {
+		cfg.attestation = a
+	}
+}
+
+// OptionRevocationList is an option to reject the certificates and keys of the
+// peers in the revocation list. The list is set on the secrets of the
+// enforcers and on the secrets given to UpdateSecrets.
+func OptionRevocationList(l *revocation.List) Option {
+	return func(cfg *config) {
+		cfg.revocationList = l
+	}
+}
+
 func (t *trireme) newEnforcers() error {
 	zap.L().Debug("LinuxProcessSupport", zap.Bool("Status", t.config.linuxProcess))
 	var err error
@@ -124,6 +177,12 @@ func (t *trireme) newEnforcers() error {
 			t.config.procMountPoint,
 			t.config.externalIPcacheTimeout,
 			t.config.packetLogs,
+			t.config.tokenEngine,
+			t.config.tagDictionary,
+			t.config.attestation,
+			// No BPF loader is available yet. The flow program is not exposed
+			// until the kernel can decide on the established flows.
+			nil,
 		)
 		if err != nil {
 			return fmt.Errorf("Failed to initialize enforcer: %s ", err)
@@ -145,6 +204,9 @@ func (t *trireme) newEnforcers() error {
 			t.config.procMountPoint,
 			t.config.externalIPcacheTimeout,
 			t.config.packetLogs,
+			t.config.tokenEngine,
+			t.config.tagDictionary,
+			t.config.attestation,
 		)
 	}
 
@@ -197,6 +259,8 @@ func newTrireme(c *config) TriremeController {
 		locks:                sync.Map{},
 	}
 
+	setRevocationList(c.secret, c.revocationList)
+
 	zap.L().Debug("Creating Enforcers")
 	if err = t.newEnforcers(); err != nil {
 		zap.L().Error("Unable to create datapath enforcers", zap.Error(err))
@@ -14,6 +14,8 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/utils/rpcwrapper"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/supervisor"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/revocation"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/allocator"
@@ -32,6 +34,8 @@ type trireme struct {
 	port                 allocator.Allocator
 	rpchdl               rpcwrapper.RPCClient
 	locks                sync.Map
+	// secretsLock protects the secrets and the revocation list of the config
+	secretsLock sync.Mutex
 }
 
 // New returns a trireme interface implementation based on configuration provided.
@@ -76,6 +80,17 @@ func (t *trireme) Run(ctx context.Context) error {
 		}
 	}
 
+	if t.config.introspectionSocket != "" {
+		server, err := introspection.NewServer(t.config.introspectionSocket, t)
+		if err != nil {
+			return fmt.Errorf("unable to create the introspection server: %s", err)
+		}
+
+		if err := server.Run(ctx); err != nil {
+			return fmt.Errorf("unable to start the introspection server: %s", err)
+		}
+	}
+
 	return nil
 }
 
@@ -118,8 +133,16 @@ func (t *trireme) UpdatePolicy(ctx context.Context, puID string, plc *policy.PUP
 	return t.doUpdatePolicy(puID, plc, runtime)
 }
 
-// UpdateSecrets updates the secrets of the controllers.
+// UpdateSecrets updates the secrets of the controllers. The revocation list
+// of the controller is set on the new secrets.
 func (t *trireme) UpdateSecrets(secrets secrets.Secrets) error {
+
+	t.secretsLock.Lock()
+	defer t.secretsLock.Unlock()
+
+	setRevocationList(secrets, t.config.revocationList)
+	t.config.secret = secrets
+
 	for _, enforcer := range t.enforcers {
 		if err := enforcer.UpdateSecrets(secrets); err != nil {
 			zap.L().Error("unable to update secrets", zap.Error(err))
@@ -128,6 +151,34 @@ func (t *trireme) UpdateSecrets(secrets secrets.Secrets) error {
 	return nil
 }
 
+// UpdateRevocationList replaces the revocation list of the controller and
+// updates the secrets of the enforcers with it.
+func (t *trireme) UpdateRevocationList(l *revocation.List) error {
+
+	t.secretsLock.Lock()
+	t.config.revocationList = l
+	current := t.config.secret
+	t.secretsLock.Unlock()
+
+	if current == nil {
+		return fmt.Errorf("no secrets to update")
+	}
+
+	return t.UpdateSecrets(current)
+}
+
+// setRevocationList sets the revocation list on the secrets that support it.
+func setRevocationList(s secrets.Secrets, l *revocation.List) {
+
+	if l == nil {
+		return
+	}
+
+	if setter, ok := s.(secrets.RevocationListSetter); ok {
+		setter.SetRevocationList(l)
+	}
+}
+
 // UpdateConfiguration updates the configuration of the controller. Only
 // a limited number of parameters can be updated at run time.
 func (t *trireme) UpdateConfiguration(networks []string) error {
@@ -149,6 +200,60 @@ func (t *trireme) UpdateConfiguration(networks []string) error {
 	return nil
 }
 
+// ListPUs returns the processing units of all the enforcers.
+func (t *trireme) ListPUs() ([]*introspection.PU, error) {
+
+	pus := []*introspection.PU{}
+
+	for _, e := range t.enforcers {
+		list, err := e.ListPUs()
+		if err != nil {
+			return nil, fmt.Errorf("unable to list processing units: %s", err)
+		}
+		pus = append(pus, list...)
+	}
+
+	return pus, nil
+}
+
+// ListFlows returns the flows of all the enforcers for the given processing
+// unit or for all of them if puID is empty.
+func (t *trireme) ListFlows(puID string) ([]*introspection.Flow, error) {
+
+	flows := []*introspection.Flow{}
+
+	for _, e := range t.enforcers {
+		list, err := e.ListFlows(puID)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list flows: %s", err)
+		}
+		flows = append(flows, list...)
+	}
+
+	return flows, nil
+}
+
+// Simulate evaluates a flow against the policy of the enforcer of the
+// processing unit of the query.
+func (t *trireme) Simulate(query *introspection.Query) (*introspection.Decision, error) {
+
+	if err := query.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid query: %s", err)
+	}
+
+	for _, e := range t.enforcers {
+		decision, err := e.Simulate(query)
+		if err != nil {
+			return nil, fmt.Errorf("unable to simulate flow: %s", err)
+		}
+		if decision != nil {
+			return decision, nil
+		}
+	}
+
+	return nil, fmt.Errorf("processing unit %s is not enforced", query.ContextID)
+}
+
 // doHandleCreate is the detailed implementation of the create event.
 func (t *trireme) doHandleCreate(contextID string, policyInfo *policy.PUPolicy, runtimeInfo *policy.PURuntime) error {
 
@@ -3,6 +3,8 @@ package controller
 import (
 	"context"
 
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/revocation"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
 	"github.com/aporeto-inc/trireme-lib/policy"
 )
@@ -24,10 +26,27 @@ type TriremeController interface {
 	// UpdatePolicy updates the policy of the isolator for a container.
 	UpdatePolicy(ctx context.Context, puID string, policy *policy.PUPolicy, runtime *policy.PURuntime) error
 
-	// UpdateSecrets updates the secrets of running enforcers managed by trireme. Remote enforcers will get the secret updates with the next policy push
+	// UpdateSecrets updates the secrets of running enforcers managed by trireme. Remote enforcers get the secret updates over the UpdateSecrets RPC
 	UpdateSecrets(secrets secrets.Secrets) error
 
+	// UpdateRevocationList replaces the revocation list of the secrets of the
+	// enforcers. Remote enforcers get a copy of the list over the UpdateSecrets
+	// RPC, so later changes of the list must be applied with a new call.
+	UpdateRevocationList(l *revocation.List) error
+
 	// UpdateConfiguration updates the configuration of the controller. Only specific configuration
 	// parameters can be updated during run time.
 	UpdateConfiguration(networks []string) error
+
+	// ListPUs returns the state of the processing units held by the enforcers,
+	// including the remote enforcers.
+	ListPUs() ([]*introspection.PU, error)
+
+	// ListFlows returns the active flows of a processing unit or of all the
+	// processing units if the puID is empty.
+	ListFlows(puID string) ([]*introspection.Flow, error)
+
+	// Simulate evaluates a flow against the live policy of a processing unit
+	// without generating any traffic.
+	Simulate(query *introspection.Query) (*introspection.Decision, error)
 }
@@ -13,16 +13,29 @@ import (
 )
 
 func newACL() *acl {
+	return newACLForProtocol("tcp")
+}
+
+func newACLForProtocol(protocol string) *acl {
 	return &acl{
-		sortedPrefixLens: make([]int, 0),
-		prefixLenMap:     make(map[int]*prefixRules),
+		protocol:           protocol,
+		sortedPrefixLens:   make([]int, 0),
+		prefixLenMap:       make(map[int]*prefixRules),
+		sortedPrefixLensV6: make([]int, 0),
+		prefixLenMapV6:     make(map[int]*prefixRulesV6),
 	}
 }
 
-// acl holds all the ACLS in an internal DB
+// acl holds all the ACLS in an internal DB. IPv4 and IPv6 rules are
+// kept in separate tables since their keys differ in size. Only rules
+// of the given protocol are kept.
 type acl struct {
-	sortedPrefixLens []int
-	prefixLenMap     map[int]*prefixRules
+	protocol           string
+	sortedPrefixLens   []int
+	prefixLenMap       map[int]*prefixRules
+	sortedPrefixLensV6 []int
+	prefixLenMapV6     map[int]*prefixRulesV6
+	rules              policy.IPRuleList
 }
 
 func (a *acl) reverseSort() {
@@ -32,13 +45,18 @@ func (a *acl) reverseSort() {
 		a.sortedPrefixLens = append(a.sortedPrefixLens, k)
 	}
 	sort.Sort(sort.Reverse(sort.IntSlice(a.sortedPrefixLens)))
+
+	for k := range a.prefixLenMapV6 {
+		a.sortedPrefixLensV6 = append(a.sortedPrefixLensV6, k)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(a.sortedPrefixLensV6)))
 }
 
 func (a *acl) addRule(rule policy.IPRule) (err error) {
 
 	var subnet, mask uint32
 
-	if strings.ToLower(rule.Protocol) != "tcp" {
+	if strings.ToLower(rule.Protocol) != a.protocol {
 		return nil
 	}
 
@@ -49,6 +67,14 @@ func (a *acl) addRule(rule policy.IPRule) (err error) {
 		return fmt.Errorf("invalid ip address: %s", parts[0])
 	}
 
+	if subnetSlice.To4() == nil {
+		if err = a.addRuleV6(rule, subnetSlice, parts); err != nil {
+			return err
+		}
+		a.rules = append(a.rules, rule)
+		return nil
+	}
+
 	subnet = binary.BigEndian.Uint32(subnetSlice.To4())
 
 	maskValue := 0
@@ -88,6 +114,51 @@ func (a *acl) addRule(rule policy.IPRule) (err error) {
 
 	subnet = subnet & mask
 	plenRules.rules[subnet] = append(plenRules.rules[subnet], r)
+	a.rules = append(a.rules, rule)
+	return nil
+}
+
+// addRuleV6 adds a rule with an IPv6 address to the IPv6 tables.
+func (a *acl) addRuleV6(rule policy.IPRule, subnetSlice net.IP, parts []string) (err error) {
+
+	maskValue := 0
+
+	switch len(parts) {
+	case 1:
+		maskValue = 128
+
+	case 2:
+		maskValue, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return fmt.Errorf("invalid address: %s", err)
+		}
+
+		if maskValue < 0 || maskValue > 128 {
+			return fmt.Errorf("invalid mask value: %d", maskValue)
+		}
+
+	default:
+		return fmt.Errorf("invalid address: %s", rule.Address)
+	}
+
+	mask := net.CIDRMask(maskValue, 128)
+
+	plenRules, ok := a.prefixLenMapV6[maskValue]
+	if !ok {
+		plenRules = &prefixRulesV6{
+			mask:  mask,
+			rules: make(map[[net.IPv6len]byte]portActionList),
+		}
+		a.prefixLenMapV6[maskValue] = plenRules
+	}
+
+	r, err := newPortAction(rule)
+	if err != nil {
+		return fmt.Errorf("unable to create port action: %s", err)
+	}
+
+	subnet := maskedV6(subnetSlice.To16(), mask)
+	plenRules.rules[subnet] = append(plenRules.rules[subnet], r)
 	return nil
 }
 
@@ -96,6 +167,14 @@ func (a *acl) getMatchingAction(ip []byte, port uint16, preReport *policy.FlowPo
 
 	report = preReport
 
+	if len(ip) == net.IPv6len {
+		if v4 := net.IP(ip).To4(); v4 != nil {
+			ip = v4
+		} else {
+			return a.getMatchingActionV6(ip, port, report)
+		}
+	}
+
 	addr := binary.BigEndian.Uint32(ip)
 
 	// Iterate over all the bitmasks we have
@@ -120,3 +199,41 @@ func (a *acl) getMatchingAction(ip []byte, port uint16, preReport *policy.FlowPo
 
 	return report, packet, errors.New("No match")
 }
+
+// getMatchingActionV6 does the lookup for an IPv6 address.
+func (a *acl) getMatchingActionV6(ip []byte, port uint16, preReport *policy.FlowPolicy) (report *policy.FlowPolicy, packet *policy.FlowPolicy, err error) {
+
+	report = preReport
+
+	// Iterate over all the bitmasks we have
+	for _, len := range a.sortedPrefixLensV6 {
+
+		rules, ok := a.prefixLenMapV6[len]
+		if !ok {
+			continue
+		}
+
+		// Do a lookup as a hash to see if we have a match
+		actionList, ok := rules.rules[maskedV6(ip, rules.mask)]
+		if !ok {
+			continue
+		}
+
+		report, packet, err = actionList.lookup(port, report)
+		if err == nil {
+			return
+		}
+	}
+
+	return report, packet, errors.New("No match")
+}
+
+// maskedV6 applies the mask to a 16 byte address and returns a key usable in maps.
+func maskedV6(ip []byte, mask net.IPMask) (key [net.IPv6len]byte) {
+
+	for i := 0; i < net.IPv6len; i++ {
+		key[i] = ip[i] & mask[i]
+	}
+
+	return key
+}
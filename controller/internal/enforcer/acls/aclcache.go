@@ -2,6 +2,7 @@ package acls
 
 import (
 	"errors"
+	"net"
 
 	"github.com/aporeto-inc/trireme-lib/policy"
 )
@@ -21,12 +22,23 @@ type prefixRules struct {
 	rules map[uint32]portActionList
 }
 
-// NewACLCache creates a new ACL cache
+type prefixRulesV6 struct {
+	mask  net.IPMask
+	rules map[[net.IPv6len]byte]portActionList
+}
+
+// NewACLCache creates a new ACL cache for TCP rules
 func NewACLCache() *ACLCache {
+	return NewACLCacheForProtocol("tcp")
+}
+
+// NewACLCacheForProtocol creates a new ACL cache that holds the rules of the
+// given protocol. Rules of other protocols are ignored.
+func NewACLCacheForProtocol(protocol string) *ACLCache {
 	return &ACLCache{
-		reject:  newACL(),
-		accept:  newACL(),
-		observe: newACL(),
+		reject:  newACLForProtocol(protocol),
+		accept:  newACLForProtocol(protocol),
+		observe: newACLForProtocol(protocol),
 	}
 }
 
@@ -59,7 +71,20 @@ func (c *ACLCache) AddRuleList(rules policy.IPRuleList) (err error) {
 	return
 }
 
-// GetMatchingAction gets the matching action
+// Rules returns the rules held by the cache in the order they are looked up.
+// Rules of other protocols are not part of the cache.
+func (c *ACLCache) Rules() policy.IPRuleList {
+
+	rules := policy.IPRuleList{}
+	rules = append(rules, c.reject.rules...)
+	rules = append(rules, c.accept.rules...)
+	rules = append(rules, c.observe.rules...)
+
+	return rules
+}
+
+// GetMatchingAction gets the matching action. The ip can be either
+// an IPv4 or an IPv6 address.
 func (c *ACLCache) GetMatchingAction(ip []byte, port uint16) (report *policy.FlowPolicy, packet *policy.FlowPolicy, err error) {
 
 	report, packet, err = c.reject.getMatchingAction(ip, port, report)
@@ -200,3 +200,170 @@ func TestObserveContinueApplyCacheLookup(t *testing.T) {
 		})
 	})
 }
+
+func TestIPv6CacheLookup(t *testing.T) {
+
+	rulesV6 := policy.IPRuleList{
+		policy.IPRule{
+			Address:  "fd00::/16",
+			Port:     "80",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "tcpfd00/16"},
+		},
+		policy.IPRule{
+			Address:  "fd00:1::/32",
+			Port:     "80",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Reject,
+				PolicyID: "tcpfd00:1/32"},
+		},
+		policy.IPRule{
+			Address:  "2001:db8::1",
+			Port:     "443",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "tcp2001:db8::1"},
+		},
+		policy.IPRule{
+			Address:  "0.0.0.0/0",
+			Port:     "443",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "tcp0/0"},
+		},
+	}
+
+	Convey("Given an ACL Cache with IPv4 and IPv6 rules", t, func() {
+		c := NewACLCache()
+		So(c, ShouldNotBeNil)
+		err := c.AddRuleList(rulesV6)
+		So(err, ShouldBeNil)
+
+		Convey("When I lookup for an address in the IPv6 prefix, I should get accept", func() {
+			a, p, err := c.GetMatchingAction(net.ParseIP("fd00:2::1"), 80)
+			So(err, ShouldBeNil)
+			So(a.Action, ShouldEqual, policy.Accept)
+			So(p.PolicyID, ShouldEqual, "tcpfd00/16")
+		})
+
+		Convey("When I lookup for an address in the IPv6 reject prefix, I should get reject", func() {
+			a, p, err := c.GetMatchingAction(net.ParseIP("fd00:1::1"), 80)
+			So(err, ShouldBeNil)
+			So(a.Action, ShouldEqual, policy.Reject)
+			So(p.PolicyID, ShouldEqual, "tcpfd00:1/32")
+		})
+
+		Convey("When I lookup for an IPv6 host rule, I should get accept", func() {
+			_, p, err := c.GetMatchingAction(net.ParseIP("2001:db8::1"), 443)
+			So(err, ShouldBeNil)
+			So(p.PolicyID, ShouldEqual, "tcp2001:db8::1")
+		})
+
+		Convey("When I lookup for an IPv6 address, the IPv4 catch all should not match", func() {
+			_, p, err := c.GetMatchingAction(net.ParseIP("2001:db8::2"), 443)
+			So(err, ShouldNotBeNil)
+			So(p.PolicyID, ShouldEqual, "default")
+		})
+
+		Convey("When I lookup for an IPv4 address in 16 byte form, I should match the IPv4 rules", func() {
+			_, p, err := c.GetMatchingAction(net.ParseIP("10.1.1.1"), 443)
+			So(err, ShouldBeNil)
+			So(p.PolicyID, ShouldEqual, "tcp0/0")
+		})
+	})
+}
+
+func TestUDPCacheLookup(t *testing.T) {
+
+	rules := policy.IPRuleList{
+		policy.IPRule{
+			Address:  "10.1.1.0/24",
+			Port:     "53",
+			Protocol: "udp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "udp10.1.1/24"},
+		},
+		policy.IPRule{
+			Address:  "10.1.1.0/24",
+			Port:     "80",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "tcp10.1.1/24"},
+		},
+	}
+
+	Convey("Given an ACL Cache for udp rules", t, func() {
+		c := NewACLCacheForProtocol("udp")
+		So(c, ShouldNotBeNil)
+		So(c.AddRuleList(rules), ShouldBeNil)
+
+		Convey("When I lookup a udp rule, it should match", func() {
+			r, p, err := c.GetMatchingAction(net.ParseIP("10.1.1.5").To4(), 53)
+			So(err, ShouldBeNil)
+			So(p.Action, ShouldEqual, policy.Accept)
+			So(r.PolicyID, ShouldEqual, "udp10.1.1/24")
+		})
+
+		Convey("When I lookup a tcp rule, it should not match", func() {
+			_, _, err := c.GetMatchingAction(net.ParseIP("10.1.1.5").To4(), 80)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given an ACL Cache for tcp rules", t, func() {
+		c := NewACLCache()
+		So(c.AddRuleList(rules), ShouldBeNil)
+
+		Convey("When I lookup a udp rule, it should not match", func() {
+			_, _, err := c.GetMatchingAction(net.ParseIP("10.1.1.5").To4(), 53)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestACLCacheRules(t *testing.T) {
+
+	accept := policy.IPRule{
+		Address:  "10.1.1.0/24",
+		Port:     "80",
+		Protocol: "tcp",
+		Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "accept"},
+	}
+
+	reject := policy.IPRule{
+		Address:  "2001:db8::/32",
+		Port:     "443",
+		Protocol: "tcp",
+		Policy:   &policy.FlowPolicy{Action: policy.Reject, PolicyID: "reject"},
+	}
+
+	udp := policy.IPRule{
+		Address:  "10.1.1.0/24",
+		Port:     "53",
+		Protocol: "udp",
+		Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "udp"},
+	}
+
+	Convey("Given an empty ACL Cache", t, func() {
+		c := NewACLCache()
+
+		Convey("It should hold no rules", func() {
+			So(c.Rules(), ShouldBeEmpty)
+		})
+
+		Convey("When I add rules of several protocols", func() {
+			So(c.AddRuleList(policy.IPRuleList{accept, reject, udp}), ShouldBeNil)
+
+			Convey("It should hold the rules of its protocol in lookup order", func() {
+				So(c.Rules(), ShouldResemble, policy.IPRuleList{reject, accept})
+			})
+		})
+	})
+}
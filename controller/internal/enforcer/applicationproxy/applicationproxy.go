@@ -2,6 +2,7 @@ package applicationproxy
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -57,8 +58,10 @@ type AppProxy struct {
 	dependentAPICache cache.DataStore
 	portMappingCache  cache.DataStore
 	jwtcache          cache.DataStore
+	oidccache         cache.DataStore
 	systemCAPool      *x509.CertPool
 	secrets           secrets.Secrets
+	sessionKey        []byte
 
 	clients cache.DataStore
 	sync.RWMutex
@@ -79,6 +82,18 @@ func NewAppProxy(tp tokenaccessor.TokenAccessor, c collector.EventCollector, puF
 		}
 	}
 
+	// With SPIFFE secrets the SVID is the default certificate of the services.
+	if svid, ok := s.(*secrets.SPIFFESecrets); ok && certificate == nil {
+		certificate = svid.TLSCertificate()
+	}
+
+	// The sessions of the users are signed with a key of the enforcer, so
+	// that they are kept when the proxies of the PUs are restarted.
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, fmt.Errorf("unable to create session key: %s", err)
+	}
+
 	return &AppProxy{
 		collector:         c,
 		tokenaccessor:     tp,
@@ -90,7 +105,9 @@ func NewAppProxy(tp tokenaccessor.TokenAccessor, c collector.EventCollector, puF
 		dependentAPICache: cache.NewCache("dependencies"),
 		portMappingCache:  cache.NewCache("portmappings"),
 		jwtcache:          cache.NewCache("jwtcache"),
+		oidccache:         cache.NewCache("oidccache"),
 		systemCAPool:      systemPool,
+		sessionKey:        sessionKey,
 	}, nil
 }
 
@@ -109,9 +126,10 @@ func (p *AppProxy) Enforce(ctx context.Context, puID string, puInfo *policy.PUIn
 	defer p.Unlock()
 
 	// First update the caches with the new policy information.
-	apicache, dependentCache, portMapping, jwtcache, caPool := buildCaches(puInfo.Policy.ExposedServices(), puInfo.Policy.DependentServices())
+	apicache, dependentCache, portMapping, jwtcache, oidccache, caPool := buildCaches(puInfo.Policy.ExposedServices(), puInfo.Policy.DependentServices())
 	p.exposedAPICache.AddOrUpdate(puID, apicache)
 	p.jwtcache.AddOrUpdate(puID, jwtcache)
+	p.oidccache.AddOrUpdate(puID, oidccache)
 	p.dependentAPICache.AddOrUpdate(puID, dependentCache)
 	p.portMappingCache.AddOrUpdate(puID, portMapping)
 
@@ -195,6 +213,10 @@ func (p *AppProxy) Unenforce(ctx context.Context, puID string) error {
 	if err := p.jwtcache.Remove(puID); err != nil {
 		zap.L().Warn("Cannot find PU in the JWT cache")
 	}
+
+	if err := p.oidccache.Remove(puID); err != nil {
+		zap.L().Warn("Cannot find PU in the OIDC cache")
+	}
 	if err := p.portMappingCache.Remove(puID); err != nil {
 		zap.L().Warn("Cannot find PU in the PortMapping cache")
 	}
@@ -233,8 +255,8 @@ func (p *AppProxy) GetPortSetInstance() portset.PortSet {
 	return nil
 }
 
-// UpdateSecrets updates the secrets of running enforcers managed by trireme. Remote enforcers will
-// get the secret updates with the next policy push.
+// UpdateSecrets updates the secrets of running enforcers managed by trireme. Remote enforcers
+// get the secret updates over the UpdateSecrets RPC.
 func (p *AppProxy) UpdateSecrets(secret secrets.Secrets) error {
 	p.Lock()
 	defer p.Unlock()
@@ -319,7 +341,7 @@ func (p *AppProxy) registerAndRun(ctx context.Context, puID string, ltype protom
 	// Start the corresponding proxy
 	switch ltype {
 	case protomux.HTTPApplication, protomux.HTTPSApplication, protomux.HTTPNetwork, protomux.HTTPSNetwork:
-		c := httpproxy.NewHTTPProxy(p.tokenaccessor, p.collector, puID, p.puFromID, p.systemCAPool, p.exposedAPICache, p.dependentAPICache, p.portMappingCache, p.jwtcache, appproxy, proxyMarkInt, p.secrets)
+		c := httpproxy.NewHTTPProxy(p.tokenaccessor, p.collector, puID, p.puFromID, p.systemCAPool, p.exposedAPICache, p.dependentAPICache, p.portMappingCache, p.jwtcache, p.oidccache, appproxy, proxyMarkInt, p.secrets, p.sessionKey)
 		return c, c.RunNetworkServer(ctx, listener, encrypted)
 	default:
 		c := tcp.NewTCPProxy(p.tokenaccessor, p.collector, p.puFromID, puID, p.cert, p.systemCAPool)
@@ -372,7 +394,7 @@ func (p *AppProxy) processCertificateUpdates(puInfo *policy.PUInfo, client *clie
 
 func serviceTypeToNetworkListenerType(serviceType policy.ServiceType) protomux.ListenerType {
 	switch serviceType {
-	case policy.ServiceHTTP:
+	case policy.ServiceHTTP, policy.ServiceGRPC:
 		return protomux.HTTPSNetwork
 	default:
 		return protomux.TCPNetwork
@@ -381,29 +403,34 @@ func serviceTypeToNetworkListenerType(serviceType policy.ServiceType) protomux.L
 
 func serviceTypeToApplicationListenerType(serviceType policy.ServiceType) protomux.ListenerType {
 	switch serviceType {
-	case policy.ServiceHTTP:
+	case policy.ServiceHTTP, policy.ServiceGRPC:
 		return protomux.HTTPApplication
 	default:
 		return protomux.TCPApplication
 	}
 }
 
-func buildCaches(exposedServices, dependentServices policy.ApplicationServicesList) (map[string]*urisearch.APICache, map[string]*urisearch.APICache, map[string]string, map[string]*x509.Certificate, [][]byte) {
+func buildCaches(exposedServices, dependentServices policy.ApplicationServicesList) (map[string]*urisearch.APICache, map[string]*urisearch.APICache, map[string]string, map[string]*x509.Certificate, map[string]*policy.OIDCConfig, [][]byte) {
 	apicache := map[string]*urisearch.APICache{}
 	jwtcache := map[string]*x509.Certificate{}
+	oidccache := map[string]*policy.OIDCConfig{}
 	dependentCache := map[string]*urisearch.APICache{}
 	caPool := [][]byte{}
 	portMapping := map[string]string{}
 
 	for _, service := range exposedServices {
-		if service.Type != policy.ServiceHTTP {
+		if service.Type != policy.ServiceHTTP && service.Type != policy.ServiceGRPC {
 			continue
 		}
 		if service.NetworkInfo.Ports.IsMultiPort() || service.PrivateNetworkInfo.Ports.IsMultiPort() {
 			zap.L().Error("Multiport services are not supported")
 			continue
 		}
-		ruleCache := urisearch.NewAPICache(service.HTTPRules, false)
+		ruleCache, err := newAPICache(service, false)
+		if err != nil {
+			zap.L().Error("Invalid HTTP rules", zap.Error(err))
+			continue
+		}
 		for _, fqdn := range service.NetworkInfo.FQDNs {
 			rhost := fqdn + ":" + service.NetworkInfo.Ports.String()
 			portMapping[rhost] = service.PrivateNetworkInfo.Ports.String()
@@ -414,6 +441,9 @@ func buildCaches(exposedServices, dependentServices policy.ApplicationServicesLi
 			portMapping[rhost] = service.PrivateNetworkInfo.Ports.String()
 			apicache[rhost] = ruleCache
 		}
+		if service.OIDC != nil {
+			oidccache[service.NetworkInfo.Ports.String()] = service.OIDC
+		}
 		cert, err := cryptoutils.LoadCertificate(service.JWTCertificate)
 		if err != nil {
 			// We just ignore bad certificates and move on.
@@ -424,14 +454,18 @@ func buildCaches(exposedServices, dependentServices policy.ApplicationServicesLi
 	}
 
 	for _, service := range dependentServices {
-		if service.Type != policy.ServiceHTTP {
+		if service.Type != policy.ServiceHTTP && service.Type != policy.ServiceGRPC {
 			continue
 		}
 		if service.NetworkInfo.Ports.IsMultiPort() || service.PrivateNetworkInfo.Ports.IsMultiPort() {
 			zap.L().Error("Multiport services are not supported")
 			continue
 		}
-		uricache := urisearch.NewAPICache(service.HTTPRules, service.External)
+		uricache, err := newAPICache(service, service.External)
+		if err != nil {
+			zap.L().Error("Invalid HTTP rules", zap.Error(err))
+			continue
+		}
 		for _, fqdn := range service.NetworkInfo.FQDNs {
 			dependentCache[fqdn+":"+service.NetworkInfo.Ports.String()] = uricache
 		}
@@ -442,7 +476,15 @@ func buildCaches(exposedServices, dependentServices policy.ApplicationServicesLi
 			caPool = append(caPool, service.CACert)
 		}
 	}
-	return apicache, dependentCache, portMapping, jwtcache, caPool
+	return apicache, dependentCache, portMapping, jwtcache, oidccache, caPool
+}
+
+// newAPICache returns the API cache of the rules of an HTTP or gRPC service.
+func newAPICache(service *policy.ApplicationService, external bool) (*urisearch.APICache, error) {
+	if service.Type == policy.ServiceGRPC {
+		return urisearch.NewGRPCAPICache(service.HTTPRules, external)
+	}
+	return urisearch.NewAPICache(service.HTTPRules, external)
 }
 
 func serviceFromProxySet(pair string) (*common.Service, error) {
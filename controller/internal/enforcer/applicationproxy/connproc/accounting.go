@@ -0,0 +1,45 @@
+package connproc
+
+import (
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+)
+
+// AccountFlow reports the bytes copied for the accepted flow of record every
+// interval and once more when done is closed. The proxies see streams and not
+// packets, so only the byte counters are reported.
+func AccountFlow(c collector.EventCollector, record *collector.FlowRecord, counters *Counters, interval time.Duration, done <-chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var reported collector.FlowCounters
+
+	report := func() {
+
+		current := collector.FlowCounters{
+			SourceBytes:      counters.Outgoing(),
+			DestinationBytes: counters.Incoming(),
+		}
+
+		delta := current.Sub(reported)
+		reported = current
+
+		if delta.Zero() {
+			return
+		}
+
+		c.CollectFlowEvent(collector.NewAccountingRecord(record, delta))
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			report()
+		case <-done:
+			report()
+			return
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package connproc
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/collector/mock"
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccountFlow(t *testing.T) {
+
+	Convey("Given a collector and the accepted record of a flow", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		var lock sync.Mutex
+		records := []*collector.FlowRecord{}
+		mockCollector := mockcollector.NewMockEventCollector(ctrl)
+		mockCollector.EXPECT().CollectFlowEvent(gomock.Any()).Do(func(r *collector.FlowRecord) {
+			lock.Lock()
+			records = append(records, r)
+			lock.Unlock()
+		}).AnyTimes()
+
+		record := &collector.FlowRecord{
+			ContextID:   "pu",
+			Source:      &collector.EndPoint{ID: "client", IP: "10.1.10.76"},
+			Destination: &collector.EndPoint{ID: "server", IP: "10.1.10.77", Port: 80},
+			PolicyID:    "accepted",
+			Count:       1,
+		}
+
+		Convey("When bytes are copied while the flow is accounted", func() {
+
+			counters := &Counters{}
+			done := make(chan struct{})
+			stopped := make(chan struct{})
+			go func() {
+				AccountFlow(mockCollector, record, counters, 10*time.Millisecond, done)
+				close(stopped)
+			}()
+
+			counters.AddOutgoing(100)
+			counters.AddIncoming(1000)
+			time.Sleep(50 * time.Millisecond)
+
+			counters.AddOutgoing(20)
+			close(done)
+			<-stopped
+
+			Convey("Then the traffic should be reported periodically and when the flow is done", func() {
+				lock.Lock()
+				defer lock.Unlock()
+
+				So(len(records), ShouldEqual, 2)
+				So(records[0].Accounting, ShouldBeTrue)
+				So(records[0].Count, ShouldEqual, 0)
+				So(records[0].PolicyID, ShouldEqual, "accepted")
+				So(records[0].FlowCounters, ShouldResemble, collector.FlowCounters{SourceBytes: 100, DestinationBytes: 1000})
+				So(records[1].FlowCounters, ShouldResemble, collector.FlowCounters{SourceBytes: 20})
+			})
+		})
+	})
+}
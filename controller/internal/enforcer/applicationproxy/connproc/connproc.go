@@ -106,8 +106,9 @@ func ReadMsg(fd int) (int, []byte, error) {
 	return n, msg, err
 }
 
-// Pipe proxies data bi-directionally between in and out.
-func Pipe(ctx context.Context, inConn, outConn net.Conn) error {
+// Pipe proxies data bi-directionally between in and out. The bytes copied
+// in each direction are added to the counters if they are not nil.
+func Pipe(ctx context.Context, inConn, outConn net.Conn, counters *Counters) error {
 
 	inFile, inFd, err := Fd(inConn)
 	if err != nil {
@@ -124,8 +125,8 @@ func Pipe(ctx context.Context, inConn, outConn net.Conn) error {
 	var wg sync.WaitGroup
 	wg.Add(2)
 
-	go copyBytes(ctx, "incoming", inFd, outFd, &wg)
-	go copyBytes(ctx, "outgoing", outFd, inFd, &wg)
+	go copyBytes(ctx, "incoming", inFd, outFd, &wg, counters.AddIncoming)
+	go copyBytes(ctx, "outgoing", outFd, inFd, &wg, counters.AddOutgoing)
 	wg.Wait()
 	if err := outConn.Close(); err != nil {
 		fmt.Println("inconn close", err)
@@ -137,7 +138,7 @@ func Pipe(ctx context.Context, inConn, outConn net.Conn) error {
 	return nil
 }
 
-func copyBytes(ctx context.Context, direction string, destFd, srcFd int, wg *sync.WaitGroup) {
+func copyBytes(ctx context.Context, direction string, destFd, srcFd int, wg *sync.WaitGroup, count func(int64)) {
 	var total int64
 	var nwrote int64
 
@@ -195,6 +196,7 @@ func copyBytes(ctx context.Context, direction string, destFd, srcFd int, wg *syn
 					}
 				}
 				total += nwrote
+				count(nwrote)
 			}
 		}
 	}
@@ -18,7 +18,7 @@ func GetInterfaces() map[string]struct{} {
 }
 
 // Pipe creates a spliced connection
-func Pipe(ctx context.Context, in, out net.Conn) error {
+func Pipe(ctx context.Context, in, out net.Conn, counters *Counters) error {
 	return nil
 }
 
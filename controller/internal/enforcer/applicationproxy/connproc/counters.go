@@ -0,0 +1,44 @@
+package connproc
+
+import "sync/atomic"
+
+// Counters holds the bytes copied by a pipe in each direction. Outgoing bytes
+// are copied from the incoming connection to the outgoing connection and
+// incoming bytes the other way around. The counters are updated atomically
+// and can be read while the pipe runs.
+type Counters struct {
+	incoming uint64
+	outgoing uint64
+}
+
+// AddIncoming adds n bytes to the incoming counter.
+func (c *Counters) AddIncoming(n int64) {
+	if c == nil || n <= 0 {
+		return
+	}
+	atomic.AddUint64(&c.incoming, uint64(n))
+}
+
+// AddOutgoing adds n bytes to the outgoing counter.
+func (c *Counters) AddOutgoing(n int64) {
+	if c == nil || n <= 0 {
+		return
+	}
+	atomic.AddUint64(&c.outgoing, uint64(n))
+}
+
+// Incoming returns the bytes copied from the outgoing connection.
+func (c *Counters) Incoming() uint64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&c.incoming)
+}
+
+// Outgoing returns the bytes copied from the incoming connection.
+func (c *Counters) Outgoing() uint64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&c.outgoing)
+}
@@ -0,0 +1,182 @@
+package httpproxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// httpMetricsInterval is the interval of the reports of the metrics of the APIs.
+var httpMetricsInterval = 10 * time.Second
+
+// flowRecordKey is the key of the flow record of a request in its context.
+type flowRecordKey struct{}
+
+// setFlowRecord attaches the flow record of a request to the request, so that
+// its access log record reports the source and the rule of the request.
+func setFlowRecord(r *http.Request, record *collector.FlowRecord) {
+	if holder, ok := r.Context().Value(flowRecordKey{}).(**collector.FlowRecord); ok {
+		*holder = record
+	}
+}
+
+// instrument wraps a request processor with the collection of the access logs
+// and of the metrics of the APIs. Only the collectors that implement the
+// collector.HTTPCollector interface get them.
+func (p *Config) instrument(processor http.HandlerFunc) http.HandlerFunc {
+
+	httpCollector, ok := p.collector.(collector.HTTPCollector)
+	if !ok {
+		return processor
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		record := &collector.HTTPRecord{
+			ContextID: p.puContext,
+			Method:    r.Method,
+			URI:       r.RequestURI,
+			Host:      r.Host,
+			Network:   !p.applicationProxy,
+		}
+
+		start := time.Now()
+
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+
+		rw := &responseRecorder{ResponseWriter: w, tunnel: r.Method == http.MethodConnect}
+
+		var flow *collector.FlowRecord
+		processor(rw, r.WithContext(context.WithValue(r.Context(), flowRecordKey{}, &flow)))
+
+		record.Latency = time.Since(start)
+		record.StatusCode = rw.statusCode()
+		record.RequestBytes = body.count
+		record.ResponseBytes = rw.count
+		record.Action = rw.action()
+
+		if flow != nil {
+			record.Action = flow.Action
+			record.PolicyID = flow.PolicyID
+			if flow.Source != nil {
+				record.SourceID = flow.Source.ID
+				record.UserID = flow.Source.UserID
+			}
+			if flow.Destination != nil {
+				record.Port = flow.Destination.Port
+			}
+		}
+
+		httpCollector.CollectHTTPEvent(record)
+		p.metrics.Observe(record)
+	}
+}
+
+// reportMetrics reports the metrics of the APIs periodically until the
+// context is done.
+func (p *Config) reportMetrics(ctx context.Context, httpCollector collector.HTTPCollector) {
+
+	ticker := time.NewTicker(httpMetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, record := range p.metrics.Flush() {
+				httpCollector.CollectHTTPMetrics(record)
+			}
+		case <-ctx.Done():
+			for _, record := range p.metrics.Flush() {
+				httpCollector.CollectHTTPMetrics(record)
+			}
+			return
+		}
+	}
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	count int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.ReadCloser.Read(b)
+	c.count += int64(n)
+	return n, err
+}
+
+// responseRecorder records the status and the size of a response. It keeps
+// the optional interfaces of the response writers that the forwarders use.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	count  int64
+	tunnel bool
+}
+
+func (w *responseRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.count += int64(n)
+	return n, err
+}
+
+// Flush implements the http.Flusher interface.
+func (w *responseRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements the http.Hijacker interface.
+func (w *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("connection cannot be hijacked")
+	}
+	// The connections are only taken over once the destination accepted the
+	// upgrade or the tunnel.
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+		if w.tunnel {
+			w.status = http.StatusOK
+		}
+	}
+	return h.Hijack()
+}
+
+// statusCode returns the status of the response.
+func (w *responseRecorder) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// action returns the action of a request without flow record.
+func (w *responseRecorder) action() policy.ActionType {
+	if w.statusCode() >= http.StatusBadRequest {
+		return policy.Reject
+	}
+	return policy.Accept
+}
@@ -0,0 +1,158 @@
+package httpproxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testHTTPCollector records the HTTP and flow events.
+type testHTTPCollector struct {
+	collector.DefaultCollector
+	records []*collector.HTTPRecord
+	metrics []*collector.HTTPMetricsRecord
+	flows   []*collector.FlowRecord
+	sync.Mutex
+}
+
+func (c *testHTTPCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	c.Lock()
+	defer c.Unlock()
+	c.flows = append(c.flows, record)
+}
+
+func (c *testHTTPCollector) CollectHTTPEvent(record *collector.HTTPRecord) {
+	c.Lock()
+	defer c.Unlock()
+	c.records = append(c.records, record)
+}
+
+func (c *testHTTPCollector) CollectHTTPMetrics(record *collector.HTTPMetricsRecord) {
+	c.Lock()
+	defer c.Unlock()
+	c.metrics = append(c.metrics, record)
+}
+
+func (c *testHTTPCollector) metricsCount() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.metrics)
+}
+
+func TestInstrument(t *testing.T) {
+
+	Convey("Given a proxy with a collector of HTTP events", t, func() {
+		c := &testHTTPCollector{}
+		p := &Config{
+			collector: c,
+			metrics:   collector.NewHTTPMetrics(),
+			puContext: "pu",
+		}
+
+		processor := func(w http.ResponseWriter, r *http.Request) {
+			record := &collector.FlowRecord{
+				Source:      &collector.EndPoint{ID: "source", UserID: "user"},
+				Destination: &collector.EndPoint{Port: 443},
+				Action:      policy.Reject,
+			}
+			setFlowRecord(r, record)
+
+			body, _ := ioutil.ReadAll(r.Body) // nolint
+			if string(body) != "request" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+
+			record.Action = policy.Accept
+			record.PolicyID = "rule"
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("response")) // nolint
+		}
+
+		handler := p.instrument(processor)
+
+		Convey("When a request is accepted", func() {
+			r := httptest.NewRequest(http.MethodPost, "/users?id=1", strings.NewReader("request"))
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			Convey("Then an access log record should be collected", func() {
+				So(w.Code, ShouldEqual, http.StatusCreated)
+				So(c.records, ShouldHaveLength, 1)
+
+				record := c.records[0]
+				So(record.ContextID, ShouldEqual, "pu")
+				So(record.Method, ShouldEqual, http.MethodPost)
+				So(record.URI, ShouldEqual, "/users?id=1")
+				So(record.StatusCode, ShouldEqual, http.StatusCreated)
+				So(record.RequestBytes, ShouldEqual, len("request"))
+				So(record.ResponseBytes, ShouldEqual, len("response"))
+				So(record.SourceID, ShouldEqual, "source")
+				So(record.UserID, ShouldEqual, "user")
+				So(record.PolicyID, ShouldEqual, "rule")
+				So(record.Port, ShouldEqual, 443)
+				So(record.Action, ShouldEqual, policy.Accept)
+				So(record.Latency, ShouldBeGreaterThan, 0)
+			})
+
+			Convey("Then the metrics of the API should be aggregated", func() {
+				handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/users", strings.NewReader("invalid")))
+
+				metrics := p.metrics.Flush()
+				So(metrics, ShouldHaveLength, 2)
+				for _, m := range metrics {
+					So(m.Latency.Count, ShouldEqual, 1)
+					if m.PolicyID == "rule" {
+						So(m.StatusCodes, ShouldResemble, map[int]uint64{http.StatusCreated: 1})
+					} else {
+						So(m.StatusCodes, ShouldResemble, map[int]uint64{http.StatusBadRequest: 1})
+					}
+				}
+			})
+		})
+
+		Convey("When the metrics are reported", func() {
+			handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/users", strings.NewReader("request")))
+
+			interval := httpMetricsInterval
+			httpMetricsInterval = 10 * time.Millisecond
+			defer func() { httpMetricsInterval = interval }()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go p.reportMetrics(ctx, c)
+
+			Convey("Then the collector should get the metrics", func() {
+				deadline := time.Now().Add(5 * time.Second)
+				for c.metricsCount() == 0 && time.Now().Before(deadline) {
+					time.Sleep(10 * time.Millisecond)
+				}
+				So(c.metricsCount(), ShouldEqual, 1)
+			})
+		})
+	})
+
+	Convey("Given a proxy with a collector of flows only", t, func() {
+		p := &Config{collector: collector.NewDefaultCollector()}
+		called := false
+
+		Convey("Then the requests should not be instrumented", func() {
+			handler := p.instrument(func(w http.ResponseWriter, r *http.Request) {
+				_, instrumented := w.(*responseRecorder)
+				So(instrumented, ShouldBeFalse)
+				called = true
+			})
+			handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			So(called, ShouldBeTrue)
+		})
+	})
+}
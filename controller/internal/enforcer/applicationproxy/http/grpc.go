@@ -0,0 +1,162 @@
+package httpproxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/urisearch"
+	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const (
+	// grpcContentType is the content type of the gRPC requests
+	grpcContentType = "application/grpc"
+)
+
+// gRPC status codes returned by the proxy.
+const (
+	grpcUnknown          = 2
+	grpcInvalidArgument  = 3
+	grpcPermissionDenied = 7
+	grpcInternal         = 13
+	grpcUnavailable      = 14
+	grpcUnauthenticated  = 16
+)
+
+// isGRPCRequest returns true if the request is a gRPC call.
+func isGRPCRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), grpcContentType)
+}
+
+// newGRPCForwarder creates a forwarder for the gRPC calls. The requests are
+// already rewritten with their destination and the responses are streamed
+// back with their trailers.
+func newGRPCForwarder(transport http.RoundTripper) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Director:      func(r *http.Request) {},
+		Transport:     transport,
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			zap.L().Error("Cannot forward gRPC request", zap.Error(err))
+			grpcError(w, grpcUnavailable, err.Error())
+		},
+	}
+}
+
+// isGRPCHost returns true if the host is the host of a gRPC service of the
+// PU. An empty host matches any gRPC service of the PU, since the host is not
+// always known when HTTP/2 is negotiated.
+func (p *Config) isGRPCHost(host string) bool {
+
+	c := p.exposedAPICache
+	if p.applicationProxy {
+		c = p.dependentAPICache
+	}
+
+	data, err := c.Get(p.puContext)
+	if err != nil {
+		return false
+	}
+
+	for name, apiCache := range data.(map[string]*urisearch.APICache) {
+		if apiCache.GRPC && (host == "" || getServerName(name) == getServerName(host)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// grpcTLSConfig returns a function advertising HTTP/2 to the TLS clients of
+// the gRPC services. The other clients keep the configuration of the listener.
+func (p *Config) grpcTLSConfig(config *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if !p.isGRPCHost(hello.ServerName) {
+			return nil, nil
+		}
+
+		c := config.Clone()
+		c.NextProtos = []string{http2.NextProtoTLS, "http/1.1"}
+		return c, nil
+	}
+}
+
+// grpcHandler returns a handler serving HTTP/2 without TLS (h2c) to the
+// clients of the gRPC services. The other requests are served by h.
+func (p *Config) grpcHandler(h http.Handler, s *http2.Server) http.Handler {
+
+	h2cHandler := h2c.NewHandler(h, s)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.isGRPCHost(r.Host) {
+			h2cHandler.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// httpError replies to the request with an error. The errors of the gRPC
+// calls are returned as gRPC statuses since the clients ignore the HTTP
+// status and the body of the responses.
+func httpError(w http.ResponseWriter, r *http.Request, message string, code int) {
+
+	if isGRPCRequest(r) {
+		grpcError(w, grpcStatus(code), message)
+		return
+	}
+
+	http.Error(w, message, code)
+}
+
+// grpcError replies with a gRPC status. The status is sent in the headers of
+// a response without body, which is a trailers-only response for gRPC.
+func grpcError(w http.ResponseWriter, status int, message string) {
+
+	h := w.Header()
+	h.Set("Content-Type", grpcContentType)
+	h.Set("Grpc-Status", strconv.Itoa(status))
+	h.Set("Grpc-Message", grpcEncodeMessage(message))
+	w.WriteHeader(http.StatusOK)
+}
+
+// grpcStatus returns the gRPC status of an HTTP status.
+func grpcStatus(code int) int {
+
+	switch code {
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusUnprocessableEntity, http.StatusBadRequest:
+		return grpcInvalidArgument
+	case http.StatusInternalServerError:
+		return grpcInternal
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return grpcUnavailable
+	default:
+		return grpcUnknown
+	}
+}
+
+// grpcEncodeMessage percent-encodes the message of a gRPC status.
+func grpcEncodeMessage(message string) string {
+
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
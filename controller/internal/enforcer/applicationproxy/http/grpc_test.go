@@ -0,0 +1,132 @@
+package httpproxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/urisearch"
+	"github.com/aporeto-inc/trireme-lib/utils/cache"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/http2"
+)
+
+func TestHTTPError(t *testing.T) {
+
+	Convey("Given a gRPC request", t, func() {
+		r := httptest.NewRequest(http.MethodPost, "/helloworld.Greeter/SayHello", nil)
+		r.Header.Set("Content-Type", "application/grpc+proto")
+		w := httptest.NewRecorder()
+
+		Convey("When the request is rejected", func() {
+			httpError(w, r, "Unauthorized access: 100%", http.StatusForbidden)
+
+			Convey("Then a gRPC status should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Content-Type"), ShouldEqual, grpcContentType)
+				So(w.Header().Get("Grpc-Status"), ShouldEqual, "7")
+				So(w.Header().Get("Grpc-Message"), ShouldEqual, "Unauthorized access: 100%25")
+				So(w.Body.Len(), ShouldEqual, 0)
+			})
+		})
+	})
+
+	Convey("Given an HTTP request", t, func() {
+		r := httptest.NewRequest(http.MethodGet, "/users", nil)
+		w := httptest.NewRecorder()
+
+		Convey("When the request is rejected", func() {
+			httpError(w, r, "Unauthorized access", http.StatusForbidden)
+
+			Convey("Then an HTTP error should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusForbidden)
+				So(w.Header().Get("Grpc-Status"), ShouldBeEmpty)
+				So(w.Body.String(), ShouldContainSubstring, "Unauthorized access")
+			})
+		})
+	})
+}
+
+func TestGRPCStatus(t *testing.T) {
+
+	Convey("Given HTTP status codes", t, func() {
+		Convey("Then they should be mapped to gRPC status codes", func() {
+			So(grpcStatus(http.StatusForbidden), ShouldEqual, grpcPermissionDenied)
+			So(grpcStatus(http.StatusUnauthorized), ShouldEqual, grpcUnauthenticated)
+			So(grpcStatus(http.StatusUnprocessableEntity), ShouldEqual, grpcInvalidArgument)
+			So(grpcStatus(http.StatusInternalServerError), ShouldEqual, grpcInternal)
+			So(grpcStatus(http.StatusBadGateway), ShouldEqual, grpcUnavailable)
+			So(grpcStatus(http.StatusTeapot), ShouldEqual, grpcUnknown)
+		})
+	})
+}
+
+func TestGRPCEncodeMessage(t *testing.T) {
+
+	Convey("Given messages of gRPC statuses", t, func() {
+		Convey("Then the printable characters should be kept", func() {
+			So(grpcEncodeMessage("no policy found"), ShouldEqual, "no policy found")
+		})
+
+		Convey("Then the other characters should be percent-encoded", func() {
+			So(grpcEncodeMessage("50%\nfailed"), ShouldEqual, "50%25%0Afailed")
+			So(grpcEncodeMessage("é"), ShouldEqual, "%C3%A9")
+		})
+	})
+}
+
+func TestGRPCNegotiation(t *testing.T) {
+
+	Convey("Given a proxy of an HTTP and a gRPC service", t, func() {
+		grpcCache, err := urisearch.NewGRPCAPICache(nil, false)
+		So(err, ShouldBeNil)
+		httpCache, err := urisearch.NewAPICache(nil, false)
+		So(err, ShouldBeNil)
+
+		exposed := cache.NewCache("exposed")
+		exposed.AddOrUpdate("pu", map[string]*urisearch.APICache{
+			"grpc.example.com:443": grpcCache,
+			"web.example.com:80":   httpCache,
+		})
+		p := &Config{puContext: "pu", exposedAPICache: exposed}
+
+		Convey("Then HTTP/2 should only be advertised to the TLS clients of the gRPC service", func() {
+			getConfig := p.grpcTLSConfig(&tls.Config{})
+
+			config, err := getConfig(&tls.ClientHelloInfo{ServerName: "grpc.example.com"})
+			So(err, ShouldBeNil)
+			So(config.NextProtos, ShouldContain, http2.NextProtoTLS)
+
+			config, err = getConfig(&tls.ClientHelloInfo{ServerName: "web.example.com"})
+			So(err, ShouldBeNil)
+			So(config, ShouldBeNil)
+		})
+
+		Convey("Then h2c should only be served to the clients of the gRPC service", func() {
+			served := false
+			handler := p.grpcHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				served = true
+			}), &http2.Server{})
+
+			upgrade := func(url string) {
+				served = false
+				r := httptest.NewRequest(http.MethodGet, url, nil)
+				r.Header.Set("Connection", "Upgrade, HTTP2-Settings")
+				r.Header.Set("Upgrade", "h2c")
+				r.Header.Set("HTTP2-Settings", "")
+				handler.ServeHTTP(httptest.NewRecorder(), r)
+			}
+
+			upgrade("http://web.example.com/")
+			So(served, ShouldBeTrue)
+
+			upgrade("http://grpc.example.com/")
+			So(served, ShouldBeFalse)
+
+			So(p.isGRPCHost("grpc.example.com:443"), ShouldBeTrue)
+			So(p.isGRPCHost("web.example.com"), ShouldBeFalse)
+			So(p.isGRPCHost(""), ShouldBeTrue)
+		})
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/httputil"
 	"net/url"
 	"strconv"
 	"strings"
@@ -18,9 +19,11 @@ import (
 	"github.com/aporeto-inc/trireme-lib/collector"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/applicationproxy/markedconn"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/nfqdatapath/tokenaccessor"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/apiauth"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/signer"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/urisearch"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
@@ -28,6 +31,7 @@ import (
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/vulcand/oxy/forward"
+	"golang.org/x/net/http2"
 )
 
 // JWTClaims is the structure of the claims we are sending on the wire.
@@ -47,17 +51,23 @@ type Config struct {
 	secrets           secrets.Secrets
 	tokenaccessor     tokenaccessor.TokenAccessor
 	collector         collector.EventCollector
+	metrics           *collector.HTTPMetrics
 	puContext         string
 	puFromIDCache     cache.DataStore
 	exposedAPICache   cache.DataStore
 	dependentAPICache cache.DataStore
 	jwtCache          cache.DataStore
+	oidcCache         cache.DataStore
 	portMappingCache  cache.DataStore
+	authenticators    map[string]*oidcAuthenticator
+	sessionKey        []byte
 	applicationProxy  bool
 	mark              int
 	server            *http.Server
 	fwd               *forward.Forwarder
 	fwdTLS            *forward.Forwarder
+	grpcFwd           *httputil.ReverseProxy
+	grpcFwdTLS        *httputil.ReverseProxy
 	sync.RWMutex
 }
 
@@ -72,13 +82,16 @@ func NewHTTPProxy(
 	dependentAPICache cache.DataStore,
 	portMappingCache cache.DataStore,
 	jwtCache cache.DataStore,
+	oidcCache cache.DataStore,
 	applicationProxy bool,
 	mark int,
 	secrets secrets.Secrets,
+	sessionKey []byte,
 ) *Config {
 
 	return &Config{
 		collector:         c,
+		metrics:           collector.NewHTTPMetrics(),
 		tokenaccessor:     tp,
 		puFromIDCache:     puFromIDCache,
 		puContext:         puContext,
@@ -88,6 +101,9 @@ func NewHTTPProxy(
 		portMappingCache:  portMappingCache,
 		applicationProxy:  applicationProxy,
 		jwtCache:          jwtCache,
+		oidcCache:         oidcCache,
+		authenticators:    map[string]*oidcAuthenticator{},
+		sessionKey:        sessionKey,
 		mark:              mark,
 		secrets:           secrets,
 	}
@@ -104,12 +120,15 @@ func (p *Config) RunNetworkServer(ctx context.Context, l net.Listener, encrypted
 		return fmt.Errorf("Server already running")
 	}
 
-	// If its an encrypted, wrap it in a TLS context.
+	// If its an encrypted, wrap it in a TLS context. HTTP/2 is negotiated
+	// for the gRPC services only.
 	if encrypted {
 		config := &tls.Config{
-			GetCertificate: p.GetCertificateFunc(),
-			ClientAuth:     tls.RequestClientCert,
+			GetCertificate:        p.GetCertificateFunc(),
+			ClientAuth:            tls.RequestClientCert,
+			VerifyPeerCertificate: p.verifyClientSVID,
 		}
+		config.GetConfigForClient = p.grpcTLSConfig(config)
 		l = tls.NewListener(l, config)
 	}
 
@@ -129,11 +148,7 @@ func (p *Config) RunNetworkServer(ctx context.Context, l net.Listener, encrypted
 				return nil, err
 			}
 
-			tlsConn := tls.Client(conn, &tls.Config{
-				ServerName:         getServerName(addr),
-				RootCAs:            p.ca,
-				InsecureSkipVerify: false,
-			})
+			tlsConn := tls.Client(conn, p.clientTLSConfig(addr))
 			return tlsConn, nil
 		},
 	}
@@ -164,13 +179,53 @@ func (p *Config) RunNetworkServer(ctx context.Context, l net.Listener, encrypted
 		return fmt.Errorf("Cannot initialize unencrypted transport: %s", err)
 	}
 
+	// gRPC calls are forwarded over HTTP/2 with their trailers. The
+	// applications talk HTTP/2 without TLS (h2c).
+	p.grpcFwdTLS = newGRPCForwarder(&http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			raddr, err := net.ResolveTCPAddr(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			conn, err := markedconn.DialMarkedTCP("tcp", nil, raddr, p.mark)
+			if err != nil {
+				return nil, err
+			}
+
+			config := p.clientTLSConfig(addr)
+			config.NextProtos = []string{http2.NextProtoTLS}
+			return tls.Client(conn, config), nil
+		},
+	})
+
+	p.grpcFwd = newGRPCForwarder(&http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			raddr, err := net.ResolveTCPAddr(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			conn, err := markedconn.DialMarkedTCP("tcp", nil, raddr, p.mark)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to dial remote: %s", err)
+			}
+			return conn, nil
+		},
+	})
+
 	processor := p.processAppRequest
 	if !p.applicationProxy {
 		processor = p.processNetRequest
 	}
 
+	h2s := &http2.Server{}
 	p.server = &http.Server{
-		Handler: http.HandlerFunc(processor),
+		Handler: p.grpcHandler(p.instrument(processor), h2s),
+	}
+
+	if err := http2.ConfigureServer(p.server, h2s); err != nil {
+		return fmt.Errorf("Cannot initialize HTTP/2 server: %s", err)
 	}
 
 	go func() {
@@ -178,6 +233,10 @@ func (p *Config) RunNetworkServer(ctx context.Context, l net.Listener, encrypted
 		p.server.Close() // nolint
 	}()
 
+	if httpCollector, ok := p.collector.(collector.HTTPCollector); ok {
+		go p.reportMetrics(ctx, httpCollector)
+	}
+
 	go p.server.Serve(l) // nolint
 
 	return nil
@@ -214,11 +273,48 @@ func (p *Config) GetCertificateFunc() func(*tls.ClientHelloInfo) (*tls.Certifica
 	}
 }
 
+// clientTLSConfig returns the TLS configuration of the connections to the
+// remote services. With SPIFFE secrets the proxy presents its SVID and
+// verifies the SVID of the remote with the trust bundle instead of the
+// host name.
+func (p *Config) clientTLSConfig(addr string) *tls.Config {
+	p.RLock()
+	defer p.RUnlock()
+
+	if svid, ok := p.secrets.(*secrets.SPIFFESecrets); ok {
+		return &tls.Config{
+			Certificates:          []tls.Certificate{*svid.TLSCertificate()},
+			InsecureSkipVerify:    true, // nolint
+			VerifyPeerCertificate: svid.VerifyPeerCertificate,
+		}
+	}
+
+	return &tls.Config{
+		ServerName:         getServerName(addr),
+		RootCAs:            p.ca,
+		InsecureSkipVerify: false,
+	}
+}
+
+// verifyClientSVID verifies the SVID presented by a client with SPIFFE secrets.
+// Client certificates are optional and they are not verified otherwise.
+func (p *Config) verifyClientSVID(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+	p.RLock()
+	defer p.RUnlock()
+
+	svid, ok := p.secrets.(*secrets.SPIFFESecrets)
+	if !ok || len(rawCerts) == 0 {
+		return nil
+	}
+
+	return svid.VerifyPeerCertificate(rawCerts, chains)
+}
+
 func (p *Config) retrieveContextAndPolicy(c cache.DataStore, w http.ResponseWriter, r *http.Request) (*pucontext.PUContext, *urisearch.APICache, error) {
 	pu, err := p.puFromIDCache.Get(p.puContext)
 	if err != nil {
 		zap.L().Error("Cannot find policy, dropping request")
-		http.Error(w, fmt.Sprintf("Cannot handle request: %s", err), http.StatusInternalServerError)
+		httpError(w, r, fmt.Sprintf("Cannot handle request: %s", err), http.StatusInternalServerError)
 		return nil, nil, err
 	}
 	puContext := pu.(*pucontext.PUContext)
@@ -228,13 +324,13 @@ func (p *Config) retrieveContextAndPolicy(c cache.DataStore, w http.ResponseWrit
 	// the service.
 	data, err := c.Get(p.puContext)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Cannot handle request - unknown context: %s", p.puContext), http.StatusForbidden)
+		httpError(w, r, fmt.Sprintf("Cannot handle request - unknown context: %s", p.puContext), http.StatusForbidden)
 		return nil, nil, err
 	}
 
 	apiCache, ok := data.(map[string]*urisearch.APICache)[appendDefaultPort(r.Host)]
 	if !ok {
-		http.Error(w, fmt.Sprintf("Cannot handle request - unknown destination %s", r.Host), http.StatusForbidden)
+		httpError(w, r, fmt.Sprintf("Cannot handle request - unknown destination %s", r.Host), http.StatusForbidden)
 		return nil, nil, fmt.Errorf("Cannot handle request - unknown destination")
 	}
 
@@ -250,12 +346,13 @@ func (p *Config) processAppRequest(w http.ResponseWriter, r *http.Request) {
 	// For external services we validate policy at the ingress. Note that the
 	// certificate distribution service is considered as external and must
 	// be defined as external.
+	var record *collector.FlowRecord
 	if apiCache.External {
 		_, _port, perr := originalServicePort(w, r)
 		if perr != nil {
 			return
 		}
-		record := &collector.FlowRecord{
+		record = &collector.FlowRecord{
 			ContextID: p.puContext,
 			Destination: &collector.EndPoint{
 				URI:  r.RequestURI,
@@ -273,12 +370,13 @@ func (p *Config) processAppRequest(w http.ResponseWriter, r *http.Request) {
 			Tags:       puContext.Annotations(),
 		}
 		defer p.collector.CollectFlowEvent(record)
+		setFlowRecord(r, record)
 
 		// Get the corresponding scopes
-		found, t := apiCache.Find(r.Method, r.RequestURI)
+		found, t := apiCache.Find(r.Method, requestURI(r))
 		if !found {
 			zap.L().Error("Uknown  or unauthorized service - no policy found", zap.Error(err))
-			http.Error(w, fmt.Sprintf("Unknown or unauthorized service - no policy found"), http.StatusForbidden)
+			httpError(w, r, fmt.Sprintf("Unknown or unauthorized service - no policy found"), http.StatusForbidden)
 			return
 		}
 
@@ -290,28 +388,35 @@ func (p *Config) processAppRequest(w http.ResponseWriter, r *http.Request) {
 
 		// Validate the policy based on the scopes of the PU.
 		// TODO: Add user scopes
-		if err = p.verifyPolicy(t.([]string), puContext.Identity().Tags, puContext.Scopes(), []string{}); err != nil {
+		rule := t.(*apiauth.Rule)
+		req := &apiauth.Request{
+			Profile: puContext.Identity().Tags,
+			Scopes:  puContext.Scopes(),
+			Header:  r.Header,
+		}
+		if err = p.verifyPolicy(rule, req); err != nil {
 			zap.L().Error("Uknown  or unauthorized service", zap.Error(err))
-			http.Error(w, fmt.Sprintf("Unknown or unauthorized service - rejected by policy"), http.StatusForbidden)
+			httpError(w, r, fmt.Sprintf("Unknown or unauthorized service - rejected by policy"), http.StatusForbidden)
 			return
 		}
 
 		// All checks have passed. We can accept the request, log it, and create the
 		// right tokens. If it is not an external service, we do not log at the transmit side.
 		record.Action = policy.Accept
+		record.PolicyID = rule.ID
 	}
 
 	// Generate the client identity
 	token, err := p.createClientToken(puContext)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Cannot handle request - cannot create token"), http.StatusForbidden)
+		httpError(w, r, fmt.Sprintf("Cannot handle request - cannot create token"), http.StatusForbidden)
 		return
 	}
 
 	// Create the new target URL based on the Host parameter that we had.
 	r.URL, err = url.ParseRequestURI("http://" + r.Host)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Invalid destination host name"), http.StatusUnprocessableEntity)
+		httpError(w, r, fmt.Sprintf("Invalid destination host name"), http.StatusUnprocessableEntity)
 		return
 	}
 
@@ -320,7 +425,16 @@ func (p *Config) processAppRequest(w http.ResponseWriter, r *http.Request) {
 	r.Header.Add("X-APORETO-KEY", string(p.secrets.TransmittedKey()))
 	r.Header.Add("X-APORETO-AUTH", token)
 
-	// Forward the request.
+	// Forward the request. The upgraded streams of the external services
+	// are accounted in their record.
+	if isUpgradeRequest(r) {
+		p.forwardUpgrade(w, r, p.dialTLS, record)
+		return
+	}
+	if apiCache.GRPC {
+		p.grpcFwdTLS.ServeHTTP(w, r)
+		return
+	}
 	p.fwdTLS.ServeHTTP(w, r)
 }
 
@@ -339,6 +453,7 @@ func (p *Config) processNetRequest(w http.ResponseWriter, r *http.Request) {
 		L4Protocol: packet.IPProtocolTCP,
 	}
 	defer p.collector.CollectFlowEvent(record)
+	setFlowRecord(r, record)
 
 	// Retrieve the context and policy
 	puContext, apiCache, err := p.retrieveContextAndPolicy(p.exposedAPICache, w, r)
@@ -378,35 +493,82 @@ func (p *Config) processNetRequest(w http.ResponseWriter, r *http.Request) {
 		zap.L().Warn("No JWT found for this port", zap.String("port", port))
 	}
 
+	// The redirects of the OIDC provider complete the authentication of the users.
+	authenticator := p.userAuthenticator(port)
+	if authenticator != nil && authenticator.isCallback(r) {
+		if err := p.handleCallback(w, r, authenticator); err != nil {
+			zap.L().Error("User authentication failed", zap.Error(err))
+			return
+		}
+		record.Action = policy.Accept
+		return
+	}
+
 	// Look in the cache for the method and request URI for the associated scopes
 	// and policies.
-	found, t := apiCache.Find(r.Method, r.RequestURI)
+	found, t := apiCache.Find(r.Method, requestURI(r))
 	if !found {
 		zap.L().Error("Uknown  or unauthorized service", zap.Error(err))
-		http.Error(w, fmt.Sprintf("Unknown or unauthorized service"), http.StatusForbidden)
+		httpError(w, r, fmt.Sprintf("Unknown or unauthorized service"), http.StatusForbidden)
 		return
 	}
 
 	// Calculate the user attributes and claims.
 	userAttributes := parseUserAttributes(r, jwtCert)
+	authenticated := false
+	if authenticator != nil {
+		var sessionAttributes []string
+		if sessionAttributes, authenticated = p.sessionAttributes(r, authenticator); authenticated {
+			userAttributes = append(userAttributes, sessionAttributes...)
+		}
+	}
 	if len(userAttributes) > 0 {
 		userRecord := &collector.UserRecord{Claims: userAttributes}
 		p.collector.CollectUserEvent(userRecord)
 		record.Source.UserID = userRecord.ID
 	}
 
-	claims, err := p.parseClientToken(key, token)
-	if err != nil {
-		zap.L().Error("Unauthorized request", zap.Error(err))
-		http.Error(w, fmt.Sprintf("Unauthorized access: %s", err), http.StatusUnauthorized)
-		return
+	// Requests of the users authenticated by the provider do not carry a
+	// service token. Only their user attributes are authorized. The other
+	// requests must carry a valid service token, but the browsers of the
+	// users of a provider are sent to the provider first.
+	claims := &JWTClaims{}
+	if authenticator == nil || !authenticated {
+		claims, err = p.parseClientToken(key, token)
+		if err != nil {
+			if authenticator != nil && key == "" && token == "" && isBrowserRequest(r) {
+				p.redirectToProvider(w, r, authenticator)
+				return
+			}
+			zap.L().Error("Unauthorized request", zap.Error(err))
+			httpError(w, r, fmt.Sprintf("Unauthorized access: %s", err), http.StatusUnauthorized)
+			return
+		}
 	}
 	record.Source.ID = claims.SourceID
 
 	// Validate the policy and drop the request if there is no authorization.
-	if err = p.verifyPolicy(t.([]string), claims.Profile, claims.Scopes, userAttributes); err != nil {
+	// Unauthenticated users of browsers are sent to the provider first.
+	rule := t.(*apiauth.Rule)
+	req := &apiauth.Request{
+		UserAttributes: userAttributes,
+		Profile:        claims.Profile,
+		Scopes:         claims.Scopes,
+		Header:         r.Header,
+	}
+	if err = p.verifyPolicy(rule, req); err != nil {
+		if authenticator != nil && !authenticated && isBrowserRequest(r) {
+			p.redirectToProvider(w, r, authenticator)
+			return
+		}
+		// HTTP clients are asked to authenticate and gRPC clients are
+		// denied the call.
+		code := http.StatusUnauthorized
+		if apiCache.GRPC {
+			code = http.StatusForbidden
+		}
 		zap.L().Error("Unauthorized request", zap.Error(err))
-		http.Error(w, fmt.Sprintf("Unauthorized access: %s", err), http.StatusUnauthorized)
+		httpError(w, r, fmt.Sprintf("Unauthorized access: %s", err), code)
 		return
 	}
 
@@ -414,11 +576,20 @@ func (p *Config) processNetRequest(w http.ResponseWriter, r *http.Request) {
 	r.URL, err = p.createTargetURI(r)
 	if err != nil {
 		zap.L().Error("Invalid HTTP Host parameter", zap.Error(err))
-		http.Error(w, fmt.Sprintf("Invalid HTTP Host parameter: %s", err), http.StatusUnprocessableEntity)
+		httpError(w, r, fmt.Sprintf("Invalid HTTP Host parameter: %s", err), http.StatusUnprocessableEntity)
 		return
 	}
 
 	record.Action = policy.Accept
+	record.PolicyID = rule.ID
+	if isUpgradeRequest(r) {
+		p.forwardUpgrade(w, r, p.dial, record)
+		return
+	}
+	if apiCache.GRPC {
+		p.grpcFwd.ServeHTTP(w, r)
+		return
+	}
 	p.fwd.ServeHTTP(w, r)
 }
 
@@ -433,35 +604,27 @@ func (p *Config) createClientToken(puContext *pucontext.PUContext) (string, erro
 		Scopes:   puContext.Scopes(),
 		SourceID: puContext.ManagementID(),
 	}
-	return jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(p.secrets.EncodingKey())
+
+	key := p.secrets.EncodingKey()
+	signMethod, err := signer.SigningMethod(key)
+	if err != nil {
+		return "", err
+	}
+
+	return jwt.NewWithClaims(signMethod, claims).SignedString(key)
 }
 
-func (p *Config) verifyPolicy(apitags []string, profile, scopes []string, userAttributes []string) error {
+func (p *Config) verifyPolicy(rule *apiauth.Rule, req *apiauth.Request) error {
 
-	// TODO: Silly implementation. We can do a better lookup here.
-	for _, a := range apitags {
-		for _, user := range userAttributes {
-			if user == a {
-				return nil
-			}
-		}
-		for _, c := range profile {
-			if a == c {
-				return nil
-			}
-		}
-		for _, c := range scopes {
-			if a == c {
-				return nil
-			}
-		}
+	if rule.Authorize(req) {
+		return nil
 	}
 
 	zap.L().Warn("No match found in API token",
-		zap.Strings("User Attributes", userAttributes),
-		zap.Strings("API Policy", apitags),
-		zap.Strings("PU Claims", profile),
-		zap.Strings("PU Scopes", scopes),
+		zap.Strings("User Attributes", req.UserAttributes),
+		zap.String("API Policy", rule.ID),
+		zap.Strings("PU Claims", req.Profile),
+		zap.Strings("PU Scopes", req.Scopes),
 	)
 	return fmt.Errorf("No matching authorization policy")
 }
@@ -474,11 +637,17 @@ func (p *Config) parseClientToken(txtKey string, token string) (*JWTClaims, erro
 
 	claims := &JWTClaims{}
 	_, err = jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		ekey, ok := key.(*ecdsa.PublicKey)
-		if !ok {
+		if cert, ok := key.(*x509.Certificate); ok {
+			key = cert.PublicKey
+		}
+		signMethod, err := signer.SigningMethod(key)
+		if err != nil {
 			return nil, fmt.Errorf("Invalid key")
 		}
-		return ekey, nil
+		if token.Method.Alg() != signMethod.Alg() {
+			return nil, fmt.Errorf("Invalid signing method")
+		}
+		return key, nil
 	})
 	if err != nil {
 		return nil, fmt.Errorf("Error parsing token: %s", err)
@@ -544,6 +713,9 @@ func parseUserAttributes(r *http.Request, cert *x509.Certificate) []string {
 		for _, email := range cert.EmailAddresses {
 			attributes = append(attributes, "email="+email)
 		}
+		if id, err := secrets.SPIFFEID(cert); err == nil {
+			attributes = append(attributes, "spiffeid="+id)
+		}
 	}
 
 	authorization := r.Header.Get("Authorization")
@@ -558,7 +730,7 @@ func parseUserAttributes(r *http.Request, cert *x509.Certificate) []string {
 
 	// Use a generic claims map. This allows us to customize the user attributes
 	// by providing the right scopes in the API policy.
-	claims := &jwt.MapClaims{}
+	claims := jwt.MapClaims{}
 	token, err := jwt.ParseWithClaims(authorization, claims, func(token *jwt.Token) (interface{}, error) {
 		switch token.Method {
 		case token.Method.(*jwt.SigningMethodECDSA):
@@ -580,25 +752,7 @@ func parseUserAttributes(r *http.Request, cert *x509.Certificate) []string {
 		return attributes
 	}
 
-	for k, v := range *claims {
-		if slice, ok := v.([]string); ok {
-			for _, data := range slice {
-				attributes = append(attributes, k+"="+data)
-			}
-		}
-		if attr, ok := v.(string); ok {
-			attributes = append(attributes, k+"="+attr)
-		}
-		if kv, ok := v.(map[string]interface{}); ok {
-			for key, value := range kv {
-				if attr, ok := value.(string); ok {
-					attributes = append(attributes, k+":"+key+"="+attr)
-				}
-			}
-		}
-	}
-
-	return attributes
+	return append(attributes, claimsToAttributes(claims)...)
 }
 
 func originalServicePort(w http.ResponseWriter, r *http.Request) (string, uint16, error) {
@@ -608,7 +762,7 @@ func originalServicePort(w http.ResponseWriter, r *http.Request) (string, uint16
 		_, port, err = net.SplitHostPort(r.Host)
 		if err != nil {
 			zap.L().Error("Invalid HTTP port parameter", zap.Error(err))
-			http.Error(w, fmt.Sprintf("Invalid HTTP port parameter: %s", err), http.StatusUnprocessableEntity)
+			httpError(w, r, fmt.Sprintf("Invalid HTTP port parameter: %s", err), http.StatusUnprocessableEntity)
 			return "", 0, err
 		}
 	}
@@ -0,0 +1,57 @@
+package httpproxy
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/common"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/urisearch"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/cache"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestProcessNetRequest(t *testing.T) {
+
+	Convey("Given a proxy of a service whose users are not authenticated by a provider", t, func() {
+		pu, err := pucontext.NewPU("pu", policy.NewPUInfo("pu", common.ContainerPU), time.Second)
+		So(err, ShouldBeNil)
+		puFromID := cache.NewCache("pus")
+		puFromID.AddOrUpdate("pu", pu)
+
+		apiCache, err := urisearch.NewAPICache([]*policy.HTTPRule{
+			{URIs: []string{"/users"}, Verbs: []string{http.MethodGet}, Expression: "header:X-Env=prod"},
+		}, false)
+		So(err, ShouldBeNil)
+		exposed := cache.NewCache("exposed")
+		exposed.AddOrUpdate("pu", map[string]*urisearch.APICache{"service:80": apiCache})
+		jwt := cache.NewCache("jwt")
+		jwt.AddOrUpdate("pu", map[string]*x509.Certificate{})
+
+		c := &testHTTPCollector{}
+		p := NewHTTPProxy(nil, c, "pu", puFromID, nil, exposed,
+			cache.NewCache("dependent"), cache.NewCache("portmappings"), jwt, cache.NewCache("oidc"),
+			false, 0, secrets.NewPSKSecrets([]byte("psk")), []byte("session key"))
+
+		Convey("When a request without service token matches the policy", func() {
+			r := httptest.NewRequest(http.MethodGet, "https://service/users", nil)
+			r.RequestURI = "/users"
+			r.Header.Set("X-Env", "prod")
+			r.Header.Set("Accept", "text/html")
+			w := httptest.NewRecorder()
+			p.processNetRequest(w, r)
+
+			Convey("Then it should be rejected", func() {
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(c.flows, ShouldHaveLength, 1)
+				So(c.flows[0].Action, ShouldEqual, policy.Reject)
+				So(c.flows[0].PolicyID, ShouldBeEmpty)
+			})
+		})
+	})
+}
@@ -0,0 +1,409 @@
+package httpproxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// oidcDiscoveryPath is the path of the configuration document of the providers.
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+
+	// oidcKeysRefreshInterval is the minimum interval between two fetches of the
+	// keys of a provider. Tokens signed with unknown keys trigger a fetch in
+	// order to follow the rotations of the keys.
+	oidcKeysRefreshInterval = 30 * time.Second
+)
+
+// oidcProviderMetadata is the configuration of a provider.
+type oidcProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// jsonWebKey is a public key of a JWKS document.
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// oidcAuthenticator is an OpenID Connect relying party that authenticates the
+// users of a service with the authorization code flow.
+type oidcAuthenticator struct {
+	config      *policy.OIDCConfig
+	client      *http.Client
+	callback    *url.URL
+	metadata    *oidcProviderMetadata
+	keys        map[string]interface{}
+	keysFetched time.Time
+	sync.Mutex
+}
+
+// newOIDCAuthenticator creates an authenticator for a provider. The provider
+// is discovered on first use.
+func newOIDCAuthenticator(config *policy.OIDCConfig, client *http.Client) (*oidcAuthenticator, error) {
+
+	callback, err := url.Parse(config.RedirectURI)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect uri: %s", err)
+	}
+
+	if callback.Path == "" {
+		return nil, fmt.Errorf("redirect uri %s has no path", config.RedirectURI)
+	}
+
+	return &oidcAuthenticator{
+		config:   config,
+		client:   client,
+		callback: callback,
+		keys:     map[string]interface{}{},
+	}, nil
+}
+
+// isCallback returns true if the request is a redirect of the provider.
+func (a *oidcAuthenticator) isCallback(r *http.Request) bool {
+	return r.URL.Path == a.callback.Path
+}
+
+// clientKey identifies the client of the provider in the sessions.
+func (a *oidcAuthenticator) clientKey() string {
+	return strings.TrimSuffix(a.config.ProviderURL, "/") + " " + a.config.ClientID
+}
+
+// authCodeURL returns the URL of the provider where the users are redirected.
+func (a *oidcAuthenticator) authCodeURL(state, nonce string) (string, error) {
+
+	metadata, err := a.provider()
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization endpoint: %s", err)
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", a.config.ClientID)
+	q.Set("redirect_uri", a.config.RedirectURI)
+	q.Set("scope", strings.Join(append([]string{"openid"}, a.config.Scopes...), " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// exchange exchanges an authorization code for an ID token.
+func (a *oidcAuthenticator) exchange(ctx context.Context, code string) (string, error) {
+
+	metadata, err := a.provider()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {a.config.RedirectURI},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("invalid token endpoint: %s", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.config.ClientID), url.QueryEscape(a.config.ClientSecret))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("unable to exchange code: %s", err)
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to exchange code: %s", resp.Status)
+	}
+
+	token := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid token response: %s", err)
+	}
+
+	if token.IDToken == "" {
+		return "", fmt.Errorf("no id token in the token response")
+	}
+
+	return token.IDToken, nil
+}
+
+// verify verifies an ID token and returns its claims.
+func (a *oidcAuthenticator) verify(rawIDToken, nonce string) (jwt.MapClaims, error) {
+
+	metadata, err := a.provider()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(rawIDToken, claims, a.keyFunc); err != nil {
+		return nil, fmt.Errorf("invalid id token: %s", err)
+	}
+
+	if !claims.VerifyIssuer(metadata.Issuer, true) {
+		return nil, fmt.Errorf("invalid id token: unexpected issuer")
+	}
+
+	if !claims.VerifyAudience(a.config.ClientID, true) && !containsAudience(claims["aud"], a.config.ClientID) {
+		return nil, fmt.Errorf("invalid id token: unexpected audience")
+	}
+
+	if _, ok := claims["exp"]; !ok {
+		return nil, fmt.Errorf("invalid id token: no expiration")
+	}
+
+	if claims["nonce"] != nonce {
+		return nil, fmt.Errorf("invalid id token: unexpected nonce")
+	}
+
+	return claims, nil
+}
+
+// keyFunc returns the key of the provider that signed a token.
+func (a *oidcAuthenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+
+	switch token.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+	default:
+		return nil, fmt.Errorf("unsupported signing method %s", token.Method.Alg())
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	return a.key(kid)
+}
+
+// key returns a key of the provider. The keys are fetched again when the key
+// is unknown, at most once per refresh interval.
+func (a *oidcAuthenticator) key(kid string) (interface{}, error) {
+
+	metadata, err := a.provider()
+	if err != nil {
+		return nil, err
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	if key, ok := a.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	if time.Since(a.keysFetched) < oidcKeysRefreshInterval {
+		return nil, fmt.Errorf("unknown key %s", kid)
+	}
+
+	keys, err := a.fetchKeys(metadata.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	a.keys = keys
+	a.keysFetched = time.Now()
+
+	if key, ok := a.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unknown key %s", kid)
+}
+
+// lookupKey finds a key in the current keys. Tokens without key identifier
+// can only be verified by providers with a single key.
+func (a *oidcAuthenticator) lookupKey(kid string) (interface{}, bool) {
+
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, true
+		}
+	}
+
+	key, ok := a.keys[kid]
+	return key, ok
+}
+
+// fetchKeys fetches the signing keys of the provider.
+func (a *oidcAuthenticator) fetchKeys(jwksURI string) (map[string]interface{}, error) {
+
+	jwks := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := a.getJSON(jwksURI, &jwks); err != nil {
+		return nil, fmt.Errorf("unable to fetch keys: %s", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	return keys, nil
+}
+
+// provider returns the configuration of the provider. It is discovered once.
+func (a *oidcAuthenticator) provider() (*oidcProviderMetadata, error) {
+
+	a.Lock()
+	metadata := a.metadata
+	a.Unlock()
+
+	if metadata != nil {
+		return metadata, nil
+	}
+
+	issuer := strings.TrimSuffix(a.config.ProviderURL, "/")
+
+	metadata = &oidcProviderMetadata{}
+	if err := a.getJSON(issuer+oidcDiscoveryPath, metadata); err != nil {
+		return nil, fmt.Errorf("unable to discover provider: %s", err)
+	}
+
+	if strings.TrimSuffix(metadata.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("provider issuer %s does not match %s", metadata.Issuer, a.config.ProviderURL)
+	}
+
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("incomplete provider configuration")
+	}
+
+	a.Lock()
+	a.metadata = metadata
+	a.Unlock()
+
+	return metadata, nil
+}
+
+// getJSON fetches a JSON document.
+func (a *oidcAuthenticator) getJSON(address string, v interface{}) error {
+
+	resp, err := a.client.Get(address)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", address, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// publicKey returns the RSA or ECDSA public key of a JWK.
+func (k *jsonWebKey) publicKey() (interface{}, error) {
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("invalid ec key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+// decodeBigInt decodes a base64url encoded integer of a JWK.
+func decodeBigInt(s string) (*big.Int, error) {
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key parameter: %s", err)
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty key parameter")
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
+
+// containsAudience returns true if a list of audiences contains the client.
+func containsAudience(aud interface{}, clientID string) bool {
+
+	list, ok := aud.([]interface{})
+	if !ok {
+		return false
+	}
+
+	for _, a := range list {
+		if a == clientID {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,355 @@
+package httpproxy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testProvider is a local OIDC provider.
+type testProvider struct {
+	server   *httptest.Server
+	kid      string
+	key      crypto.Signer
+	method   jwt.SigningMethod
+	keys     []map[string]string
+	nonces   map[string]string
+	audience interface{}
+	sync.Mutex
+}
+
+func newTestProvider() *testProvider {
+
+	p := &testProvider{
+		nonces:   map[string]string{},
+		audience: "client",
+	}
+
+	key, _ := rsa.GenerateKey(rand.Reader, 2048) // nolint
+	p.rotate("rsa", key, jwt.SigningMethodRS256)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidcDiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{ // nolint
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		p.Lock()
+		p.nonces["code-"+q.Get("state")] = q.Get("nonce")
+		p.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code=code-"+url.QueryEscape(q.Get("state"))+"&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "client" || secret != "secret" || r.PostFormValue("grant_type") != "authorization_code" {
+			http.Error(w, "invalid client", http.StatusUnauthorized)
+			return
+		}
+		p.Lock()
+		nonce, ok := p.nonces[r.PostFormValue("code")]
+		p.Unlock()
+		if !ok {
+			http.Error(w, "invalid code", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.idToken(nonce, time.Hour)}) // nolint
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.Lock()
+		defer p.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": p.keys}) // nolint
+	})
+
+	p.server = httptest.NewServer(mux)
+
+	return p
+}
+
+// rotate replaces the signing key of the provider.
+func (p *testProvider) rotate(kid string, key crypto.Signer, method jwt.SigningMethod) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.kid = kid
+	p.key = key
+	p.method = method
+
+	encode := func(i *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(i.Bytes())
+	}
+
+	switch k := key.Public().(type) {
+	case *rsa.PublicKey:
+		p.keys = []map[string]string{{"kid": kid, "kty": "RSA", "use": "sig", "n": encode(k.N), "e": encode(big.NewInt(int64(k.E)))}}
+	case *ecdsa.PublicKey:
+		p.keys = []map[string]string{{"kid": kid, "kty": "EC", "crv": "P-256", "x": encode(k.X), "y": encode(k.Y)}}
+	}
+}
+
+// idToken returns an ID token signed with the current key.
+func (p *testProvider) idToken(nonce string, validity time.Duration) string {
+	p.Lock()
+	defer p.Unlock()
+
+	token := jwt.NewWithClaims(p.method, jwt.MapClaims{
+		"iss":    p.server.URL,
+		"aud":    p.audience,
+		"sub":    "1234",
+		"email":  "user@example.com",
+		"groups": []string{"admins", "users"},
+		"nonce":  nonce,
+		"exp":    time.Now().Add(validity).Unix(),
+		"iat":    time.Now().Unix(),
+	})
+	token.Header["kid"] = p.kid
+
+	signed, _ := token.SignedString(p.key) // nolint
+	return signed
+}
+
+func newTestAuthenticator(provider *testProvider) *oidcAuthenticator {
+	a, _ := newOIDCAuthenticator(&policy.OIDCConfig{ // nolint
+		ProviderURL:  provider.server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURI:  "https://service.example.com/oidc/callback",
+		Scopes:       []string{"email", "groups"},
+	}, provider.server.Client())
+	return a
+}
+
+func TestOIDCAuthenticator(t *testing.T) {
+
+	Convey("Given an authenticator for a provider", t, func() {
+		provider := newTestProvider()
+		defer provider.server.Close()
+
+		a := newTestAuthenticator(provider)
+		So(a, ShouldNotBeNil)
+
+		Convey("Then the callback requests should be identified", func() {
+			So(a.isCallback(httptest.NewRequest(http.MethodGet, "/oidc/callback?code=1", nil)), ShouldBeTrue)
+			So(a.isCallback(httptest.NewRequest(http.MethodGet, "/users", nil)), ShouldBeFalse)
+		})
+
+		Convey("Then the users should be redirected to the discovered endpoint", func() {
+			authURL, err := a.authCodeURL("state", "nonce")
+			So(err, ShouldBeNil)
+
+			u, err := url.Parse(authURL)
+			So(err, ShouldBeNil)
+			So(u.Path, ShouldEqual, "/authorize")
+			So(u.Query().Get("client_id"), ShouldEqual, "client")
+			So(u.Query().Get("scope"), ShouldEqual, "openid email groups")
+			So(u.Query().Get("state"), ShouldEqual, "state")
+			So(u.Query().Get("nonce"), ShouldEqual, "nonce")
+		})
+
+		Convey("Then a valid ID token should be verified", func() {
+			claims, err := a.verify(provider.idToken("nonce", time.Hour), "nonce")
+			So(err, ShouldBeNil)
+			So(claims["email"], ShouldEqual, "user@example.com")
+		})
+
+		Convey("Then a token with a list of audiences should be verified", func() {
+			provider.audience = []string{"other", "client"}
+			_, err := a.verify(provider.idToken("nonce", time.Hour), "nonce")
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Then a token for another client should be rejected", func() {
+			provider.audience = "other"
+			_, err := a.verify(provider.idToken("nonce", time.Hour), "nonce")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then a token with another nonce should be rejected", func() {
+			_, err := a.verify(provider.idToken("other", time.Hour), "nonce")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then an expired token should be rejected", func() {
+			_, err := a.verify(provider.idToken("nonce", -time.Minute), "nonce")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the provider rotates its keys", func() {
+			_, err := a.verify(provider.idToken("nonce", time.Hour), "nonce")
+			So(err, ShouldBeNil)
+
+			key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader) // nolint
+			provider.rotate("ec", key, jwt.SigningMethodES256)
+
+			Convey("Then the keys should not be fetched again before the refresh interval", func() {
+				_, err := a.verify(provider.idToken("nonce", time.Hour), "nonce")
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then the tokens signed with the new key should be verified after the refresh interval", func() {
+				a.keysFetched = time.Now().Add(-oidcKeysRefreshInterval)
+				_, err := a.verify(provider.idToken("nonce", time.Hour), "nonce")
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given an authenticator for a provider with another issuer", t, func() {
+		provider := newTestProvider()
+		defer provider.server.Close()
+
+		a := newTestAuthenticator(provider)
+		a.config.ProviderURL = provider.server.URL + "/other"
+
+		Convey("Then the discovery should fail", func() {
+			_, err := a.authCodeURL("state", "nonce")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestUserAuthentication(t *testing.T) {
+
+	Convey("Given a proxy with an authenticator", t, func() {
+		provider := newTestProvider()
+		defer provider.server.Close()
+
+		a := newTestAuthenticator(provider)
+		p := &Config{sessionKey: []byte("session key")}
+
+		Convey("When a browser is redirected to the provider", func() {
+			r := httptest.NewRequest(http.MethodGet, "/users?id=1", nil)
+			r.Header.Set("Accept", "text/html")
+			So(isBrowserRequest(r), ShouldBeTrue)
+
+			w := httptest.NewRecorder()
+			p.redirectToProvider(w, r, a)
+			So(w.Code, ShouldEqual, http.StatusFound)
+
+			resp := w.Result()
+			So(resp.Cookies(), ShouldHaveLength, 1)
+			state := resp.Cookies()[0]
+			So(state.Name, ShouldEqual, stateCookie)
+
+			// The provider authenticates the user and redirects to the callback.
+			client := provider.server.Client()
+			client.CheckRedirect = func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			}
+			authResp, err := client.Get(resp.Header.Get("Location"))
+			So(err, ShouldBeNil)
+			So(authResp.StatusCode, ShouldEqual, http.StatusFound)
+
+			callback, err := url.Parse(authResp.Header.Get("Location"))
+			So(err, ShouldBeNil)
+
+			Convey("Then the callback should create a session", func() {
+				r := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+				r.AddCookie(state)
+				w := httptest.NewRecorder()
+
+				So(p.handleCallback(w, r, a), ShouldBeNil)
+				So(w.Code, ShouldEqual, http.StatusFound)
+				So(w.Header().Get("Location"), ShouldEqual, "/users?id=1")
+
+				var session *http.Cookie
+				for _, c := range w.Result().Cookies() {
+					if c.Name == sessionCookie {
+						session = c
+					}
+				}
+				So(session, ShouldNotBeNil)
+
+				Convey("Then the session should provide the user attributes", func() {
+					r := httptest.NewRequest(http.MethodGet, "/users", nil)
+					r.AddCookie(session)
+					attributes, ok := p.sessionAttributes(r, a)
+					So(ok, ShouldBeTrue)
+					So(attributes, ShouldContain, "email=user@example.com")
+					So(attributes, ShouldContain, "groups=admins")
+					So(attributes, ShouldContain, "groups=users")
+				})
+
+				Convey("Then a modified session should be rejected", func() {
+					r := httptest.NewRequest(http.MethodGet, "/users", nil)
+					r.AddCookie(&http.Cookie{Name: sessionCookie, Value: "x" + session.Value})
+					_, ok := p.sessionAttributes(r, a)
+					So(ok, ShouldBeFalse)
+				})
+
+				Convey("Then the session should be rejected by another client", func() {
+					other := newTestAuthenticator(provider)
+					other.config.ClientID = "other"
+					r := httptest.NewRequest(http.MethodGet, "/users", nil)
+					r.AddCookie(session)
+					_, ok := p.sessionAttributes(r, other)
+					So(ok, ShouldBeFalse)
+				})
+			})
+
+			Convey("Then a callback with another state should be rejected", func() {
+				q := callback.Query()
+				q.Set("state", "other")
+				r := httptest.NewRequest(http.MethodGet, callback.Path+"?"+q.Encode(), nil)
+				r.AddCookie(state)
+				w := httptest.NewRecorder()
+
+				So(p.handleCallback(w, r, a), ShouldNotBeNil)
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+			})
+
+			Convey("Then a callback without the state cookie should be rejected", func() {
+				r := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+				w := httptest.NewRecorder()
+
+				So(p.handleCallback(w, r, a), ShouldNotBeNil)
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+
+		Convey("Then API requests should not be redirected", func() {
+			r := httptest.NewRequest(http.MethodGet, "/users", nil)
+			r.Header.Set("Accept", "application/json")
+			So(isBrowserRequest(r), ShouldBeFalse)
+		})
+	})
+}
+
+func TestLocalURI(t *testing.T) {
+
+	Convey("Given the URIs of the requests of the users", t, func() {
+		Convey("Then the paths of the service should be kept", func() {
+			So(localURI("/users?id=1"), ShouldEqual, "/users?id=1")
+			So(localURI("/"), ShouldEqual, "/")
+		})
+
+		Convey("Then the URIs of other hosts should be replaced by the root", func() {
+			So(localURI("//evil.com/x"), ShouldEqual, "/")
+			So(localURI("/\\evil.com/x"), ShouldEqual, "/")
+			So(localURI("https://evil.com/x"), ShouldEqual, "/")
+			So(localURI(""), ShouldEqual, "/")
+		})
+	})
+}
@@ -0,0 +1,340 @@
+package httpproxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/applicationproxy/markedconn"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/dgrijalva/jwt-go"
+	"go.uber.org/zap"
+)
+
+const (
+	// sessionCookie is the cookie of the sessions of the authenticated users.
+	sessionCookie = "X-APORETO-SESSION"
+
+	// stateCookie is the cookie that tracks an authentication in progress.
+	stateCookie = "X-APORETO-OIDC-STATE"
+
+	// stateDuration is the time given to the users to authenticate.
+	stateDuration = 10 * time.Minute
+)
+
+// userSession is the session of a user authenticated by a provider.
+type userSession struct {
+	Attributes []string `json:"a"`
+	Client     string   `json:"c"`
+	Expiry     int64    `json:"e"`
+}
+
+// authenticationState is the state of an authentication in progress.
+type authenticationState struct {
+	State  string `json:"s"`
+	Nonce  string `json:"n"`
+	URI    string `json:"u"`
+	Expiry int64  `json:"e"`
+}
+
+// userAuthenticator returns the OIDC authenticator of the service on the port,
+// or nil if the users of the service are not authenticated by a provider.
+func (p *Config) userAuthenticator(port string) *oidcAuthenticator {
+
+	data, err := p.oidcCache.Get(p.puContext)
+	if err != nil {
+		return nil
+	}
+
+	config, ok := data.(map[string]*policy.OIDCConfig)[port]
+	if !ok {
+		return nil
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	// Authenticators are kept as long as the policy does not change, so that
+	// the configuration and the keys of the provider are cached.
+	if a, ok := p.authenticators[port]; ok && a.config == config {
+		return a
+	}
+
+	a, err := newOIDCAuthenticator(config, p.oidcClient())
+	if err != nil {
+		zap.L().Error("Invalid OIDC configuration", zap.String("port", port), zap.Error(err))
+		return nil
+	}
+	p.authenticators[port] = a
+
+	return a
+}
+
+// oidcClient returns the client for the requests to the providers.
+func (p *Config) oidcClient() *http.Client {
+
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: p.ca,
+			},
+			Dial: func(network, addr string) (net.Conn, error) {
+				raddr, err := net.ResolveTCPAddr(network, addr)
+				if err != nil {
+					return nil, err
+				}
+				return markedconn.DialMarkedTCP("tcp", nil, raddr, p.mark)
+			},
+		},
+	}
+}
+
+// redirectToProvider starts the authentication of a user by redirecting the
+// browser to the provider.
+func (p *Config) redirectToProvider(w http.ResponseWriter, r *http.Request, a *oidcAuthenticator) {
+
+	state := &authenticationState{
+		URI:    r.URL.RequestURI(),
+		Expiry: time.Now().Add(stateDuration).Unix(),
+	}
+
+	var err error
+	if state.State, err = randomString(); err != nil {
+		httpError(w, r, "Cannot create authentication state", http.StatusInternalServerError)
+		return
+	}
+	if state.Nonce, err = randomString(); err != nil {
+		httpError(w, r, "Cannot create authentication state", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := a.authCodeURL(state.State, state.Nonce)
+	if err != nil {
+		zap.L().Error("Cannot redirect to OIDC provider", zap.Error(err))
+		httpError(w, r, "Authentication provider unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	value, err := p.encodeSignedValue(state)
+	if err != nil {
+		httpError(w, r, "Cannot create authentication state", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  time.Unix(state.Expiry, 0),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handleCallback completes the authentication of a user. The authorization
+// code is exchanged for an ID token and a session is created with the claims
+// of the token. The browser is then redirected to the original URI.
+func (p *Config) handleCallback(w http.ResponseWriter, r *http.Request, a *oidcAuthenticator) error {
+
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil {
+		httpError(w, r, "No authentication in progress", http.StatusBadRequest)
+		return fmt.Errorf("no state cookie")
+	}
+
+	state := &authenticationState{}
+	if err := p.decodeSignedValue(cookie.Value, state); err != nil || time.Now().Unix() > state.Expiry {
+		httpError(w, r, "Invalid authentication state", http.StatusBadRequest)
+		return fmt.Errorf("invalid state cookie")
+	}
+
+	q := r.URL.Query()
+	if reason := q.Get("error"); reason != "" {
+		httpError(w, r, fmt.Sprintf("Authentication failed: %s", reason), http.StatusUnauthorized)
+		return fmt.Errorf("provider error: %s", reason)
+	}
+
+	if !hmac.Equal([]byte(q.Get("state")), []byte(state.State)) {
+		httpError(w, r, "Invalid authentication state", http.StatusBadRequest)
+		return fmt.Errorf("state mismatch")
+	}
+
+	rawIDToken, err := a.exchange(r.Context(), q.Get("code"))
+	if err != nil {
+		httpError(w, r, "Authentication failed", http.StatusUnauthorized)
+		return err
+	}
+
+	claims, err := a.verify(rawIDToken, state.Nonce)
+	if err != nil {
+		httpError(w, r, "Authentication failed", http.StatusUnauthorized)
+		return err
+	}
+
+	exp, _ := claims["exp"].(float64)
+	session := &userSession{
+		Attributes: claimsToAttributes(claims),
+		Client:     a.clientKey(),
+		Expiry:     int64(exp),
+	}
+
+	value, err := p.encodeSignedValue(session)
+	if err != nil {
+		httpError(w, r, "Cannot create session", http.StatusInternalServerError)
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  time.Unix(session.Expiry, 0),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   stateCookie,
+		Path:   "/",
+		MaxAge: -1,
+	})
+
+	http.Redirect(w, r, localURI(state.URI), http.StatusFound)
+
+	return nil
+}
+
+// sessionAttributes returns the attributes of the session of the user. It
+// returns false if there is no valid session with the provider. Cookies are
+// shared by all the ports of a host, so the sessions are bound to a client.
+func (p *Config) sessionAttributes(r *http.Request, a *oidcAuthenticator) ([]string, bool) {
+
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, false
+	}
+
+	session := &userSession{}
+	if err := p.decodeSignedValue(cookie.Value, session); err != nil {
+		zap.L().Warn("Invalid user session", zap.Error(err))
+		return nil, false
+	}
+
+	if session.Client != a.clientKey() || time.Now().Unix() > session.Expiry {
+		return nil, false
+	}
+
+	return session.Attributes, true
+}
+
+// encodeSignedValue encodes a value for a cookie with its HMAC.
+func (p *Config) encodeSignedValue(v interface{}) (string, error) {
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(p.sign(payload)), nil
+}
+
+// decodeSignedValue verifies and decodes the value of a cookie.
+func (p *Config) decodeSignedValue(value string, v interface{}) error {
+
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid signed value")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid signature: %s", err)
+	}
+
+	if !hmac.Equal(signature, p.sign(parts[0])) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("invalid payload: %s", err)
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// sign returns the HMAC of a payload with the session key.
+func (p *Config) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, p.sessionKey)
+	mac.Write([]byte(payload)) // nolint
+	return mac.Sum(nil)
+}
+
+// localURI returns the URI if it is a path of the service, or the root of the
+// service otherwise, so that the users are never redirected to another host
+// after their authentication.
+func localURI(uri string) string {
+	if !strings.HasPrefix(uri, "/") || strings.HasPrefix(uri, "//") || strings.HasPrefix(uri, "/\\") {
+		return "/"
+	}
+	return uri
+}
+
+// isBrowserRequest returns true if the request can be redirected to a provider.
+func isBrowserRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// randomString returns a random URL safe string.
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("unable to read random data: %s", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// claimsToAttributes converts the claims of a token to user attributes.
+func claimsToAttributes(claims jwt.MapClaims) []string {
+
+	attributes := []string{}
+	for k, v := range claims {
+		if slice, ok := v.([]string); ok {
+			for _, data := range slice {
+				attributes = append(attributes, k+"="+data)
+			}
+		}
+		if slice, ok := v.([]interface{}); ok {
+			for _, data := range slice {
+				if attr, ok := data.(string); ok {
+					attributes = append(attributes, k+"="+attr)
+				}
+			}
+		}
+		if attr, ok := v.(string); ok {
+			attributes = append(attributes, k+"="+attr)
+		}
+		if kv, ok := v.(map[string]interface{}); ok {
+			for key, value := range kv {
+				if attr, ok := value.(string); ok {
+					attributes = append(attributes, k+":"+key+"="+attr)
+				}
+			}
+		}
+	}
+
+	return attributes
+}
@@ -0,0 +1,228 @@
+package httpproxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/applicationproxy/connproc"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/applicationproxy/markedconn"
+	enforcerconstants "github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/constants"
+	"go.uber.org/zap"
+)
+
+// isUpgradeRequest returns true if the request switches the connection to
+// another protocol, such as WebSocket or SPDY, or opens a tunnel.
+func isUpgradeRequest(r *http.Request) bool {
+
+	if r.Method == http.MethodConnect {
+		return true
+	}
+
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+
+	for _, value := range r.Header["Connection"] {
+		for _, option := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(option), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// requestURI returns the URI of a request in the API cache. The tunnels
+// are authorized on the root of the service.
+func requestURI(r *http.Request) string {
+	if r.Method == http.MethodConnect && !strings.HasPrefix(r.RequestURI, "/") {
+		return "/"
+	}
+	return r.RequestURI
+}
+
+// dial dials a marked connection to a destination.
+func (p *Config) dial(addr string) (net.Conn, error) {
+
+	raddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return markedconn.DialMarkedTCP("tcp", nil, raddr, p.mark)
+}
+
+// dialTLS dials a marked TLS connection to a remote service. HTTP/1.1 is
+// negotiated since the upgrades are not defined for HTTP/2.
+func (p *Config) dialTLS(addr string) (net.Conn, error) {
+
+	conn, err := p.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	config := p.clientTLSConfig(addr)
+	config.NextProtos = []string{"http/1.1"}
+
+	return tls.Client(conn, config), nil
+}
+
+// forwardUpgrade forwards an authorized upgrade request to the destination of
+// its URL. If the destination switches the protocol, the connections are
+// spliced until both sides are done and the bytes copied are accounted in the
+// record of the flow. The record is nil for the flows that are not reported.
+func (p *Config) forwardUpgrade(w http.ResponseWriter, r *http.Request, dial func(addr string) (net.Conn, error), record *collector.FlowRecord) {
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		httpError(w, r, "Protocol upgrades are not supported on this connection", http.StatusNotImplemented)
+		return
+	}
+
+	downConn, err := dial(appendDefaultPort(r.URL.Host))
+	if err != nil {
+		zap.L().Error("Cannot connect to the destination of the upgrade", zap.Error(err))
+		httpError(w, r, fmt.Sprintf("Cannot connect to destination: %s", err), http.StatusBadGateway)
+		return
+	}
+	defer downConn.Close() // nolint
+
+	if err = upgradeRequest(r).Write(downConn); err != nil {
+		zap.L().Error("Cannot forward the upgrade request", zap.Error(err))
+		httpError(w, r, fmt.Sprintf("Cannot forward request: %s", err), http.StatusBadGateway)
+		return
+	}
+
+	downReader := bufio.NewReader(downConn)
+	resp, err := http.ReadResponse(downReader, r)
+	if err != nil {
+		zap.L().Error("Invalid response to the upgrade request", zap.Error(err))
+		httpError(w, r, fmt.Sprintf("Invalid response: %s", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close() // nolint
+
+	// The destination refused the upgrade. Its response is sent as it is.
+	if !isUpgraded(r, resp) {
+		for key, values := range resp.Header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body) // nolint
+		return
+	}
+
+	upConn, upBuffer, err := hijacker.Hijack()
+	if err != nil {
+		zap.L().Error("Cannot take over the upgraded connection", zap.Error(err))
+		httpError(w, r, fmt.Sprintf("Cannot upgrade connection: %s", err), http.StatusInternalServerError)
+		return
+	}
+	defer upConn.Close() // nolint
+
+	// The body of the response is the stream of the new protocol. Only the
+	// status and the headers are sent before the connections are spliced.
+	var header bytes.Buffer
+	fmt.Fprintf(&header, "HTTP/1.1 %s\r\n", resp.Status)
+	resp.Header.Write(&header) // nolint
+	header.WriteString("\r\n")
+	if _, err = upConn.Write(header.Bytes()); err != nil {
+		zap.L().Error("Cannot send the upgrade response", zap.Error(err))
+		return
+	}
+
+	var counters *connproc.Counters
+	if record != nil {
+		counters = &connproc.Counters{}
+		done := make(chan struct{})
+		defer close(done)
+		go connproc.AccountFlow(p.collector, record, counters, enforcerconstants.DefaultFlowAccountingInterval, done)
+	}
+
+	splice(upConn, upBuffer.Reader, downConn, downReader, counters)
+}
+
+// upgradeRequest returns the request sent to the destination of an upgrade.
+// The hop-by-hop headers of the upgrade are kept.
+func upgradeRequest(r *http.Request) *http.Request {
+
+	out := r.WithContext(r.Context())
+	out.URL = &url.URL{
+		Host:   r.URL.Host,
+		Opaque: r.RequestURI,
+	}
+
+	return out
+}
+
+// isUpgraded returns true if the destination switched the protocol or opened
+// the tunnel.
+func isUpgraded(r *http.Request, resp *http.Response) bool {
+	if r.Method == http.MethodConnect {
+		return resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices
+	}
+	return resp.StatusCode == http.StatusSwitchingProtocols
+}
+
+// splice copies the streams between the connections in both directions until
+// both are done. The readers hold the bytes already buffered from the
+// connections. Outgoing bytes are copied from the upstream connection.
+func splice(upConn net.Conn, upReader io.Reader, downConn net.Conn, downReader io.Reader, counters *connproc.Counters) {
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		copyStream(downConn, upReader, counters.AddOutgoing, upConn)
+	}()
+
+	go func() {
+		defer wg.Done()
+		copyStream(upConn, downReader, counters.AddIncoming, downConn)
+	}()
+
+	wg.Wait()
+}
+
+// copyStream copies a stream to a connection. When the stream ends, the
+// write side of the connection is closed so that the peer sees the end of
+// the stream. Both connections are closed if the copy fails.
+func copyStream(dst net.Conn, src io.Reader, count func(int64), srcConn net.Conn) {
+
+	_, err := io.Copy(&countingWriter{Writer: dst, count: count}, src)
+	if err != nil {
+		dst.Close()     // nolint
+		srcConn.Close() // nolint
+		return
+	}
+
+	if c, ok := dst.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite() // nolint
+		return
+	}
+
+	dst.Close() // nolint
+}
+
+// countingWriter counts the bytes written to a stream.
+type countingWriter struct {
+	io.Writer
+	count func(int64)
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.Writer.Write(b)
+	c.count(int64(n))
+	return n, err
+}
@@ -0,0 +1,247 @@
+package httpproxy
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// echoBackend accepts one connection, answers the upgrade request with
+// response and echoes the stream of the new protocol.
+func echoBackend(t *testing.T, response string) (net.Listener, <-chan *http.Request) {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := make(chan *http.Request, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close() // nolint
+
+		reader := bufio.NewReader(conn)
+		r, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+		requests <- r
+
+		if _, err := conn.Write([]byte(response)); err != nil {
+			return
+		}
+		io.Copy(conn, reader)            // nolint
+		conn.(*net.TCPConn).CloseWrite() // nolint
+	}()
+
+	return l, requests
+}
+
+// upgradeClient sends a raw request to the proxy and returns the connection
+// and the response.
+func upgradeClient(addr string, request string) (*net.TCPConn, *bufio.Reader, *http.Response, error) {
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if _, err = conn.Write([]byte(request)); err != nil {
+		return nil, nil, nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	method := strings.Fields(request)[0]
+	resp, err := http.ReadResponse(reader, &http.Request{Method: method})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return conn.(*net.TCPConn), reader, resp, nil
+}
+
+func TestForwardUpgrade(t *testing.T) {
+
+	Convey("Given a proxy that forwards the upgrades to a backend", t, func() {
+		c := &testHTTPCollector{}
+		p := &Config{
+			collector: c,
+			metrics:   collector.NewHTTPMetrics(),
+			puContext: "pu",
+		}
+
+		record := &collector.FlowRecord{
+			ContextID:   "pu",
+			Source:      &collector.EndPoint{ID: "source"},
+			Destination: &collector.EndPoint{Port: 80},
+			Action:      policy.Accept,
+			PolicyID:    "rule",
+		}
+
+		dial := func(addr string) (net.Conn, error) {
+			return net.Dial("tcp", addr)
+		}
+
+		serve := func(backend net.Listener) *httptest.Server {
+			return httptest.NewServer(p.instrument(func(w http.ResponseWriter, r *http.Request) {
+				setFlowRecord(r, record)
+				r.URL, _ = url.ParseRequestURI("http://" + backend.Addr().String()) // nolint
+				p.forwardUpgrade(w, r, dial, record)
+			}))
+		}
+
+		// accounted waits for the accounting record reported at the end of
+		// the stream.
+		accounted := func() *collector.FlowRecord {
+			deadline := time.Now().Add(5 * time.Second)
+			for time.Now().Before(deadline) {
+				c.Lock()
+				for _, f := range c.flows {
+					if f.Accounting {
+						c.Unlock()
+						return f
+					}
+				}
+				c.Unlock()
+				time.Sleep(10 * time.Millisecond)
+			}
+			return nil
+		}
+
+		Convey("When a WebSocket upgrade is accepted by the backend", func() {
+			backend, requests := echoBackend(t, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+			defer backend.Close() // nolint
+			proxy := serve(backend)
+			defer proxy.Close()
+
+			conn, reader, resp, err := upgradeClient(proxy.Listener.Addr().String(),
+				"GET /ws?id=1 HTTP/1.1\r\nHost: service\r\nConnection: keep-alive, Upgrade\r\nUpgrade: websocket\r\n\r\n")
+			So(err, ShouldBeNil)
+			defer conn.Close() // nolint
+
+			Convey("Then the backend should get the upgrade request", func() {
+				r := <-requests
+				So(r.RequestURI, ShouldEqual, "/ws?id=1")
+				So(r.Host, ShouldEqual, "service")
+				So(r.Header.Get("Upgrade"), ShouldEqual, "websocket")
+				So(isUpgradeRequest(r), ShouldBeTrue)
+			})
+
+			Convey("Then the connections should be spliced and the stream accounted", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+
+				_, err := conn.Write([]byte("hello"))
+				So(err, ShouldBeNil)
+				So(conn.CloseWrite(), ShouldBeNil)
+
+				echo, err := ioutil.ReadAll(reader)
+				So(err, ShouldBeNil)
+				So(string(echo), ShouldEqual, "hello")
+
+				f := accounted()
+				So(f, ShouldNotBeNil)
+				So(f.PolicyID, ShouldEqual, "rule")
+				So(f.FlowCounters, ShouldResemble, collector.FlowCounters{SourceBytes: 5, DestinationBytes: 5})
+
+				c.Lock()
+				defer c.Unlock()
+				So(c.records, ShouldHaveLength, 1)
+				So(c.records[0].StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+			})
+		})
+
+		Convey("When a tunnel is opened by the backend", func() {
+			backend, requests := echoBackend(t, "HTTP/1.1 200 Connection established\r\n\r\n")
+			defer backend.Close() // nolint
+			proxy := serve(backend)
+			defer proxy.Close()
+
+			conn, reader, resp, err := upgradeClient(proxy.Listener.Addr().String(),
+				"CONNECT service:443 HTTP/1.1\r\nHost: service:443\r\n\r\n")
+			So(err, ShouldBeNil)
+			defer conn.Close() // nolint
+
+			Convey("Then the connections should be spliced", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So((<-requests).RequestURI, ShouldEqual, "service:443")
+
+				_, err := conn.Write([]byte("tunnel"))
+				So(err, ShouldBeNil)
+				So(conn.CloseWrite(), ShouldBeNil)
+
+				echo, err := ioutil.ReadAll(reader)
+				So(err, ShouldBeNil)
+				So(string(echo), ShouldEqual, "tunnel")
+
+				f := accounted()
+				So(f, ShouldNotBeNil)
+				So(f.FlowCounters, ShouldResemble, collector.FlowCounters{SourceBytes: 6, DestinationBytes: 6})
+
+				c.Lock()
+				defer c.Unlock()
+				So(c.records, ShouldHaveLength, 1)
+				So(c.records[0].StatusCode, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When the backend refuses the upgrade", func() {
+			backend, _ := echoBackend(t, "HTTP/1.1 403 Forbidden\r\nContent-Length: 6\r\n\r\ndenied")
+			defer backend.Close() // nolint
+			proxy := serve(backend)
+			defer proxy.Close()
+
+			conn, _, resp, err := upgradeClient(proxy.Listener.Addr().String(),
+				"GET /ws HTTP/1.1\r\nHost: service\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+			So(err, ShouldBeNil)
+			defer conn.Close() // nolint
+
+			Convey("Then the response of the backend should be returned", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+				body, err := ioutil.ReadAll(resp.Body)
+				So(err, ShouldBeNil)
+				So(string(body), ShouldEqual, "denied")
+			})
+		})
+	})
+}
+
+func TestUpgradeRequests(t *testing.T) {
+
+	Convey("Given requests", t, func() {
+		request := func(method, uri string, header map[string]string) *http.Request {
+			r := httptest.NewRequest(method, uri, nil)
+			for k, v := range header {
+				r.Header.Set(k, v)
+			}
+			return r
+		}
+
+		Convey("Then the upgrades should be detected", func() {
+			So(isUpgradeRequest(request(http.MethodGet, "/", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket"})), ShouldBeTrue)
+			So(isUpgradeRequest(request(http.MethodPost, "/exec", map[string]string{"Connection": "keep-alive, upgrade", "Upgrade": "SPDY/3.1"})), ShouldBeTrue)
+			So(isUpgradeRequest(request(http.MethodConnect, "service:443", nil)), ShouldBeTrue)
+			So(isUpgradeRequest(request(http.MethodGet, "/", map[string]string{"Upgrade": "websocket"})), ShouldBeFalse)
+			So(isUpgradeRequest(request(http.MethodGet, "/", map[string]string{"Connection": "Upgrade"})), ShouldBeFalse)
+			So(isUpgradeRequest(request(http.MethodGet, "/", nil)), ShouldBeFalse)
+		})
+
+		Convey("Then the tunnels should be authorized on the root of the service", func() {
+			So(requestURI(request(http.MethodConnect, "service:443", nil)), ShouldEqual, "/")
+			So(requestURI(request(http.MethodGet, "/ws?id=1", nil)), ShouldEqual, "/ws?id=1")
+		})
+	})
+}
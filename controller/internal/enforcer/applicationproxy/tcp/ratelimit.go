@@ -0,0 +1,95 @@
+package tcp
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/applicationproxy/connproc"
+	"github.com/aporeto-inc/trireme-lib/utils/tokenbucket"
+)
+
+// rateLimitedConn is a connection that throttles the bytes it reads with
+// the bandwidth limiter of the policy of the flow.
+type rateLimitedConn struct {
+	net.Conn
+	limiter *tokenbucket.TokenBucket
+}
+
+// Read reads into the whole buffer and waits until the limiter allows the
+// bytes read. The bytes above the burst are borrowed from the future so the
+// next reads wait longer.
+func (c *rateLimitedConn) Read(b []byte) (int, error) {
+
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		time.Sleep(c.limiter.Reserve(n))
+	}
+
+	return n, err
+}
+
+// CloseWrite closes the write side of the underlying connection if it supports it.
+func (c *rateLimitedConn) CloseWrite() error {
+
+	if cw, ok := c.Conn.(interface {
+		CloseWrite() error
+	}); ok {
+		return cw.CloseWrite()
+	}
+
+	return nil
+}
+
+// countingReader adds the bytes read to a counter.
+type countingReader struct {
+	io.Reader
+	count func(int64)
+}
+
+// Read reads from the underlying reader and counts the bytes.
+func (r *countingReader) Read(b []byte) (int, error) {
+
+	n, err := r.Reader.Read(b)
+	r.count(int64(n))
+
+	return n, err
+}
+
+// pipeRateLimited copies data in both directions until both sides are done
+// or the context is cancelled. The reads of the connections are throttled.
+// The bytes copied are added to the counters if they are not nil.
+func pipeRateLimited(ctx context.Context, upConn, downConn net.Conn, counters *connproc.Counters) {
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	pipe := func(dest, source net.Conn, count func(int64)) {
+		defer wg.Done()
+		io.Copy(dest, &countingReader{Reader: source, count: count}) // nolint errcheck
+		if cw, ok := dest.(interface {
+			CloseWrite() error
+		}); ok {
+			cw.CloseWrite() // nolint errcheck
+		}
+	}
+
+	go pipe(downConn, upConn, counters.AddOutgoing)
+	go pipe(upConn, downConn, counters.AddIncoming)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+		upConn.Close()   // nolint errcheck
+		downConn.Close() // nolint errcheck
+		<-done
+	case <-done:
+	}
+}
@@ -0,0 +1,99 @@
+package tcp
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/applicationproxy/connproc"
+	"github.com/aporeto-inc/trireme-lib/utils/tokenbucket"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRateLimitedConn(t *testing.T) {
+
+	Convey("Given a connection limited to 1000 bytes per second with a burst of 100 bytes", t, func() {
+
+		client, server := net.Pipe()
+		defer server.Close() // nolint errcheck
+
+		conn := &rateLimitedConn{Conn: server, limiter: tokenbucket.New(1000, 100)}
+
+		Convey("When I read 300 bytes", func() {
+
+			go func() {
+				client.Write(make([]byte, 300)) // nolint errcheck
+				client.Close()                  // nolint errcheck
+			}()
+
+			start := time.Now()
+			data, err := ioutil.ReadAll(conn)
+
+			Convey("Then the bytes above the burst should be throttled", func() {
+				So(err, ShouldBeNil)
+				So(len(data), ShouldEqual, 300)
+				So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 150*time.Millisecond)
+			})
+		})
+	})
+
+	Convey("Given a connection limited to 1000 bytes per second with a burst of 1 byte", t, func() {
+
+		client, server := net.Pipe()
+		defer server.Close() // nolint errcheck
+
+		conn := &rateLimitedConn{Conn: server, limiter: tokenbucket.New(1000, 1)}
+
+		Convey("When I read 100 bytes", func() {
+
+			go client.Write(make([]byte, 100)) // nolint errcheck
+
+			n, err := conn.Read(make([]byte, 100))
+
+			Convey("Then the read should not be cut to the burst", func() {
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 100)
+			})
+		})
+	})
+}
+
+func TestPipeRateLimited(t *testing.T) {
+
+	Convey("Given two connected pipes", t, func() {
+
+		upClient, upConn := net.Pipe()
+		downConn, downServer := net.Pipe()
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		counters := &connproc.Counters{}
+		done := make(chan struct{})
+		go func() {
+			pipeRateLimited(ctx, upConn, downConn, counters)
+			close(done)
+		}()
+
+		Convey("When I write on one side, I should read it on the other side", func() {
+			go upClient.Write([]byte("hello")) // nolint errcheck
+
+			buf := make([]byte, 5)
+			_, err := downServer.Read(buf)
+			So(err, ShouldBeNil)
+			So(string(buf), ShouldEqual, "hello")
+			So(counters.Outgoing(), ShouldEqual, 5)
+			So(counters.Incoming(), ShouldEqual, 0)
+
+			Convey("Then cancelling the context should stop the pipe", func() {
+				cancel()
+				select {
+				case <-done:
+				case <-time.After(time.Second):
+					t.Error("pipe did not stop")
+				}
+			})
+		})
+	})
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
+	"github.com/aporeto-inc/trireme-lib/utils/tokenbucket"
 )
 
 const (
@@ -139,25 +140,52 @@ func (p *Proxy) handle(ctx context.Context, upConn net.Conn) {
 	defer downConn.Close() // nolint
 
 	// Now let us handle the state machine for the down connection
-	isEncrypted, err := p.CompleteEndPointAuthorization(ip, port, upConn, downConn)
+	isEncrypted, flowPolicy, record, err := p.CompleteEndPointAuthorization(ip, port, upConn, downConn)
 	if err != nil {
 		zap.L().Error("Error on Authorization", zap.Error(err))
 		return
 	}
 
+	// The traffic of the flows accepted by this side of the connection is
+	// accounted from the bytes copied between the connections.
+	var counters *connproc.Counters
+	if record != nil {
+		counters = &connproc.Counters{}
+		done := make(chan struct{})
+		defer close(done)
+		go connproc.AccountFlow(p.collector, record, counters, enforcerconstants.DefaultFlowAccountingInterval, done)
+	}
+
+	// Flows with a bandwidth limit are copied in user space so that the
+	// bytes can be throttled.
+	var limiter *tokenbucket.TokenBucket
+	if puContext, err := p.puContextFromContextID(p.puContext); err == nil {
+		limiter = puContext.BandwidthLimiter(flowPolicy)
+	}
+
+	if limiter != nil {
+		upConn = &rateLimitedConn{Conn: upConn, limiter: limiter}
+		downConn = &rateLimitedConn{Conn: downConn, limiter: limiter}
+	}
+
 	if isEncrypted {
-		if err := p.handleEncryptedData(ctx, upConn, downConn, ip); err != nil {
+		if err := p.handleEncryptedData(ctx, upConn, downConn, ip, counters); err != nil {
 			zap.L().Error("Failed to process connection - aborting", zap.Error(err))
 		}
 		return
 	}
 
-	if err := connproc.Pipe(ctx, upConn, downConn); err != nil {
+	if limiter != nil {
+		pipeRateLimited(ctx, upConn, downConn, counters)
+		return
+	}
+
+	if err := connproc.Pipe(ctx, upConn, downConn, counters); err != nil {
 		zap.L().Error("Failed to handle data pipe - aborting", zap.Error(err))
 	}
 }
 
-func (p *Proxy) startEncryptedClientDataPath(ctx context.Context, downConn net.Conn, serverConn net.Conn, ip net.IP) error {
+func (p *Proxy) startEncryptedClientDataPath(ctx context.Context, downConn net.Conn, serverConn net.Conn, ip net.IP, counters *connproc.Counters) error {
 
 	p.RLock()
 	ca := p.ca
@@ -170,11 +198,11 @@ func (p *Proxy) startEncryptedClientDataPath(ctx context.Context, downConn net.C
 	defer tlsConn.Close() // nolint errcheck
 
 	// TLS will automatically start negotiation on write. Nothing to do for us.
-	p.copyData(ctx, serverConn, tlsConn)
+	p.copyData(ctx, serverConn, tlsConn, counters)
 	return nil
 }
 
-func (p *Proxy) startEncryptedServerDataPath(ctx context.Context, downConn net.Conn, serverConn net.Conn) error {
+func (p *Proxy) startEncryptedServerDataPath(ctx context.Context, downConn net.Conn, serverConn net.Conn, counters *connproc.Counters) error {
 
 	p.RLock()
 	certs := []tls.Certificate{*p.certificate}
@@ -186,30 +214,32 @@ func (p *Proxy) startEncryptedServerDataPath(ctx context.Context, downConn net.C
 	defer tlsConn.Close() // nolint errcheck
 
 	// TLS will automatically start negotiation on write. Nothing to for us.
-	p.copyData(ctx, tlsConn, downConn)
+	p.copyData(ctx, tlsConn, downConn, counters)
 	return nil
 }
 
-func (p *Proxy) copyData(ctx context.Context, source, dest net.Conn) {
+func (p *Proxy) copyData(ctx context.Context, source, dest net.Conn, counters *connproc.Counters) {
 	var wg sync.WaitGroup
 	wg.Add(2)
 	go func() {
-		dataprocessor(ctx, source, dest)
+		dataprocessor(ctx, source, dest, counters.AddOutgoing)
 		wg.Done()
 	}()
 	go func() {
-		dataprocessor(ctx, dest, source)
+		dataprocessor(ctx, dest, source, counters.AddIncoming)
 		wg.Done()
 	}()
 }
 
-func dataprocessor(ctx context.Context, source, dest net.Conn) {
+func dataprocessor(ctx context.Context, source, dest net.Conn, count func(int64)) {
 	defer func() {
 		switch dest.(type) {
 		case *tls.Conn:
 			dest.(*tls.Conn).CloseWrite() // nolint errcheck
 		case *net.TCPConn:
 			dest.(*net.TCPConn).CloseWrite() // nolint errcheck
+		case *rateLimitedConn:
+			dest.(*rateLimitedConn).CloseWrite() // nolint errcheck
 		}
 	}()
 	b := make([]byte, 16384)
@@ -228,7 +258,9 @@ func dataprocessor(ctx context.Context, source, dest net.Conn) {
 					continue
 				}
 			}
-			if _, err = dest.Write(b[:n]); err != nil {
+			written, err := dest.Write(b[:n])
+			count(int64(written))
+			if err != nil {
 				if checkErr(err) {
 					continue
 				}
@@ -238,12 +270,12 @@ func dataprocessor(ctx context.Context, source, dest net.Conn) {
 	}
 }
 
-func (p *Proxy) handleEncryptedData(ctx context.Context, upConn net.Conn, downConn net.Conn, ip net.IP) error {
+func (p *Proxy) handleEncryptedData(ctx context.Context, upConn net.Conn, downConn net.Conn, ip net.IP, counters *connproc.Counters) error {
 	// If the destination is not a local IP, it means that we are processing a client connection.
 	if _, ok := p.localIPs[ip.String()]; !ok {
-		return p.startEncryptedClientDataPath(ctx, downConn, upConn, ip)
+		return p.startEncryptedClientDataPath(ctx, downConn, upConn, ip, counters)
 	}
-	return p.startEncryptedServerDataPath(ctx, downConn, upConn)
+	return p.startEncryptedServerDataPath(ctx, downConn, upConn, counters)
 }
 
 func (p *Proxy) puContextFromContextID(puID string) (*pucontext.PUContext, error) {
@@ -275,7 +307,9 @@ func (p *Proxy) downConnection(ip net.IP, port int) (net.Conn, error) {
 
 // CompleteEndPointAuthorization -- Aporeto Handshake on top of a completed connection
 // We will define states here equivalent to SYN_SENT AND SYN_RECEIVED
-func (p *Proxy) CompleteEndPointAuthorization(downIP fmt.Stringer, downPort int, upConn, downConn net.Conn) (bool, error) {
+// It returns the flow policy that authorized the connection and the accepted
+// flow record if this side of the connection reported it.
+func (p *Proxy) CompleteEndPointAuthorization(downIP fmt.Stringer, downPort int, upConn, downConn net.Conn) (bool, *policy.FlowPolicy, *collector.FlowRecord, error) {
 
 	backendip := downIP.String()
 
@@ -284,21 +318,21 @@ func (p *Proxy) CompleteEndPointAuthorization(downIP fmt.Stringer, downPort int,
 		return p.StartClientAuthStateMachine(downIP, downPort, downConn)
 	}
 
-	isEncrypted, err := p.StartServerAuthStateMachine(downIP, downPort, upConn)
+	isEncrypted, flowPolicy, record, err := p.StartServerAuthStateMachine(downIP, downPort, upConn)
 	if err != nil {
-		return false, err
+		return false, nil, nil, err
 	}
 
-	return isEncrypted, nil
+	return isEncrypted, flowPolicy, record, nil
 }
 
 //StartClientAuthStateMachine -- Starts the aporeto handshake for client application
-func (p *Proxy) StartClientAuthStateMachine(downIP fmt.Stringer, downPort int, downConn net.Conn) (bool, error) {
+func (p *Proxy) StartClientAuthStateMachine(downIP fmt.Stringer, downPort int, downConn net.Conn) (bool, *policy.FlowPolicy, *collector.FlowRecord, error) {
 
 	// We are running on top of TCP nothing should be lost or come out of order makes the state machines easy....
 	puContext, err := p.puContextFromContextID(p.puContext)
 	if err != nil {
-		return false, fmt.Errorf("Cannot find policy context: %s", err)
+		return false, nil, nil, fmt.Errorf("Cannot find policy context: %s", err)
 	}
 	isEncrypted := false
 	conn := connection.NewProxyConnection()
@@ -312,18 +346,18 @@ func (p *Proxy) StartClientAuthStateMachine(downIP fmt.Stringer, downPort int, d
 
 	for {
 		if err := downConn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
-			return false, err
+			return false, nil, nil, err
 		}
 		switch conn.GetState() {
 		case connection.ClientTokenSend:
 
 			token, err := p.tokenaccessor.CreateSynPacketToken(puContext, &conn.Auth)
 			if err != nil {
-				return isEncrypted, fmt.Errorf("unable to create syn token: %s", err)
+				return isEncrypted, nil, nil, fmt.Errorf("unable to create syn token: %s", err)
 			}
 
 			if n, err := writeMsg(downConn, token); err != nil || n < len(token) {
-				return isEncrypted, fmt.Errorf("unable to send auth token: %s", err)
+				return isEncrypted, nil, nil, fmt.Errorf("unable to send auth token: %s", err)
 			}
 
 			conn.SetState(connection.ClientPeerTokenReceive)
@@ -331,47 +365,54 @@ func (p *Proxy) StartClientAuthStateMachine(downIP fmt.Stringer, downPort int, d
 		case connection.ClientPeerTokenReceive:
 			msg, err := readMsg(downConn)
 			if err != nil {
-				return false, fmt.Errorf("Failed to read peer token: %s", err)
+				return false, nil, nil, fmt.Errorf("Failed to read peer token: %s", err)
 			}
 
 			claims, err := p.tokenaccessor.ParsePacketToken(&conn.Auth, msg)
 			if err != nil || claims == nil {
 				p.reportRejectedFlow(flowproperties, conn, collector.DefaultEndPoint, puContext.ManagementID(), puContext, collector.InvalidToken, nil, nil)
-				return false, fmt.Errorf("peer token reject because of bad claims: error: %s, claims: %v %v", err, claims, string(msg))
+				return false, nil, nil, fmt.Errorf("peer token reject because of bad claims: error: %s, claims: %v %v", err, claims, string(msg))
 			}
 
 			report, packet := puContext.SearchTxtRules(claims.T, false)
 			if packet.Action.Rejected() {
 				p.reportRejectedFlow(flowproperties, conn, puContext.ManagementID(), conn.Auth.RemoteContextID, puContext, collector.PolicyDrop, report, packet)
-				return isEncrypted, errors.New("dropping because of reject rule on transmitter")
+				return isEncrypted, nil, nil, errors.New("dropping because of reject rule on transmitter")
+			}
+
+			if err := p.tokenaccessor.RecordPacketToken(&conn.Auth); err != nil {
+				p.reportRejectedFlow(flowproperties, conn, puContext.ManagementID(), conn.Auth.RemoteContextID, puContext, collector.InvalidToken, nil, nil)
+				return isEncrypted, nil, nil, fmt.Errorf("peer token reject because of bad claims: %s", err)
 			}
 
 			if packet.Action.Encrypted() {
 				isEncrypted = true
 			}
 
+			conn.PacketFlowPolicy = packet
+
 			conn.SetState(connection.ClientSendSignedPair)
 
 		case connection.ClientSendSignedPair:
 			token, err := p.tokenaccessor.CreateAckPacketToken(puContext, &conn.Auth)
 			if err != nil {
-				return isEncrypted, fmt.Errorf("unable to create ack token: %s", err)
+				return isEncrypted, nil, nil, fmt.Errorf("unable to create ack token: %s", err)
 			}
 
 			if n, err := writeMsg(downConn, token); err != nil || n < len(token) {
-				return isEncrypted, fmt.Errorf("unable to send ack: %s", err)
+				return isEncrypted, nil, nil, fmt.Errorf("unable to send ack: %s", err)
 			}
-			return isEncrypted, nil
+			return isEncrypted, conn.PacketFlowPolicy, nil, nil
 		}
 	}
 }
 
 // StartServerAuthStateMachine -- Start the aporeto handshake for a server application
-func (p *Proxy) StartServerAuthStateMachine(ip fmt.Stringer, backendport int, upConn net.Conn) (bool, error) {
+func (p *Proxy) StartServerAuthStateMachine(ip fmt.Stringer, backendport int, upConn net.Conn) (bool, *policy.FlowPolicy, *collector.FlowRecord, error) {
 
 	puContext, err := p.puContextFromContextID(p.puContext)
 	if err != nil {
-		return false, err
+		return false, nil, nil, err
 	}
 	isEncrypted := false
 
@@ -385,7 +426,7 @@ func (p *Proxy) StartServerAuthStateMachine(ip fmt.Stringer, backendport int, up
 
 	for {
 		if err := upConn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
-			return false, err
+			return false, nil, nil, err
 		}
 
 		switch conn.GetState() {
@@ -393,20 +434,25 @@ func (p *Proxy) StartServerAuthStateMachine(ip fmt.Stringer, backendport int, up
 
 			msg, err := readMsg(upConn)
 			if err != nil {
-				return false, fmt.Errorf("unable to receive syn token: %s", err)
+				return false, nil, nil, fmt.Errorf("unable to receive syn token: %s", err)
 			}
 
 			claims, err := p.tokenaccessor.ParsePacketToken(&conn.Auth, msg)
 			if err != nil || claims == nil {
 				p.reportRejectedFlow(flowProperties, conn, collector.DefaultEndPoint, puContext.ManagementID(), puContext, collector.InvalidToken, nil, nil)
-				return isEncrypted, fmt.Errorf("reported rejected flow due to invalid token: %s", err)
+				return isEncrypted, nil, nil, fmt.Errorf("reported rejected flow due to invalid token: %s", err)
 			}
 
 			claims.T.AppendKeyValue(enforcerconstants.PortNumberLabelString, strconv.Itoa(int(backendport)))
 			report, packet := puContext.SearchRcvRules(claims.T)
 			if packet.Action.Rejected() {
 				p.reportRejectedFlow(flowProperties, conn, collector.DefaultEndPoint, puContext.ManagementID(), puContext, collector.PolicyDrop, report, packet)
-				return isEncrypted, fmt.Errorf("connection dropped by policy %s: ", packet.PolicyID)
+				return isEncrypted, nil, nil, fmt.Errorf("connection dropped by policy %s: ", packet.PolicyID)
+			}
+
+			if err := p.tokenaccessor.RecordPacketToken(&conn.Auth); err != nil {
+				p.reportRejectedFlow(flowProperties, conn, collector.DefaultEndPoint, puContext.ManagementID(), puContext, collector.InvalidToken, nil, nil)
+				return isEncrypted, nil, nil, fmt.Errorf("reported rejected flow due to invalid token: %s", err)
 			}
 
 			if packet.Action.Encrypted() {
@@ -422,12 +468,12 @@ func (p *Proxy) StartServerAuthStateMachine(ip fmt.Stringer, backendport int, up
 
 			claims, err := p.tokenaccessor.CreateSynAckPacketToken(puContext, &conn.Auth)
 			if err != nil {
-				return isEncrypted, fmt.Errorf("unable to create synack token: %s", err)
+				return isEncrypted, nil, nil, fmt.Errorf("unable to create synack token: %s", err)
 			}
 
 			if n, err := writeMsg(upConn, claims); err != nil || n < len(claims) {
 				zap.L().Error("Failed to write", zap.Error(err))
-				return false, fmt.Errorf("Failed to write ack: %s", err)
+				return false, nil, nil, fmt.Errorf("Failed to write ack: %s", err)
 			}
 
 			conn.SetState(connection.ServerAuthenticatePair)
@@ -435,20 +481,20 @@ func (p *Proxy) StartServerAuthStateMachine(ip fmt.Stringer, backendport int, up
 		case connection.ServerAuthenticatePair:
 			msg, err := readMsg(upConn)
 			if err != nil {
-				return false, fmt.Errorf("unable to receive ack token: %s", err)
+				return false, nil, nil, fmt.Errorf("unable to receive ack token: %s", err)
 			}
 
 			if _, err := p.tokenaccessor.ParseAckToken(&conn.Auth, msg); err != nil {
 				p.reportRejectedFlow(flowProperties, conn, collector.DefaultEndPoint, puContext.ManagementID(), puContext, collector.InvalidFormat, nil, nil)
-				return isEncrypted, fmt.Errorf("ack packet dropped because signature validation failed %s", err)
+				return isEncrypted, nil, nil, fmt.Errorf("ack packet dropped because signature validation failed %s", err)
 			}
-			p.reportAcceptedFlow(flowProperties, conn, conn.Auth.RemoteContextID, puContext.ManagementID(), puContext, conn.ReportFlowPolicy, conn.PacketFlowPolicy)
-			return isEncrypted, nil
+			record := p.reportAcceptedFlow(flowProperties, conn, conn.Auth.RemoteContextID, puContext.ManagementID(), puContext, conn.ReportFlowPolicy, conn.PacketFlowPolicy)
+			return isEncrypted, conn.PacketFlowPolicy, record, nil
 		}
 	}
 }
 
-func (p *Proxy) reportFlow(flowproperties *proxyFlowProperties, conn *connection.ProxyConnection, sourceID string, destID string, context *pucontext.PUContext, mode string, reportAction *policy.FlowPolicy, packetAction *policy.FlowPolicy) {
+func (p *Proxy) reportFlow(flowproperties *proxyFlowProperties, conn *connection.ProxyConnection, sourceID string, destID string, context *pucontext.PUContext, mode string, reportAction *policy.FlowPolicy, packetAction *policy.FlowPolicy) *collector.FlowRecord {
 	c := &collector.FlowRecord{
 		ContextID: context.ID(),
 		Source: &collector.EndPoint{
@@ -476,11 +522,13 @@ func (p *Proxy) reportFlow(flowproperties *proxyFlowProperties, conn *connection
 	}
 
 	p.collector.CollectFlowEvent(c)
+
+	return c
 }
 
-func (p *Proxy) reportAcceptedFlow(flowproperties *proxyFlowProperties, conn *connection.ProxyConnection, sourceID string, destID string, context *pucontext.PUContext, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
+func (p *Proxy) reportAcceptedFlow(flowproperties *proxyFlowProperties, conn *connection.ProxyConnection, sourceID string, destID string, context *pucontext.PUContext, report *policy.FlowPolicy, packet *policy.FlowPolicy) *collector.FlowRecord {
 
-	p.reportFlow(flowproperties, conn, sourceID, destID, context, "N/A", report, packet)
+	return p.reportFlow(flowproperties, conn, sourceID, destID, context, "N/A", report, packet)
 }
 
 func (p *Proxy) reportRejectedFlow(flowproperties *proxyFlowProperties, conn *connection.ProxyConnection, sourceID string, destID string, context *pucontext.PUContext, mode string, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
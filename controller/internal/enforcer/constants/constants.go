@@ -1,10 +1,29 @@
 package enforcerconstants
 
+import "time"
+
 const (
 	// TCPAuthenticationOptionBaseLen specifies the length of base TCP Authentication Option packet
 	TCPAuthenticationOptionBaseLen = 4
+	// MaxTokenLength is the maximum length of the tokens of the handshake
+	// packets. Larger tokens would not fit in a packet of an Ethernet MTU with
+	// the IP and TCP headers and the authentication option. The IP header is
+	// sized for IPv6 so that the limit holds for both IP versions.
+	MaxTokenLength = 1500 - 40 - 60 - TCPAuthenticationOptionBaseLen
 	// TCPAuthenticationOptionAckLen specifies the length of TCP Authentication Option in the ack packet
 	TCPAuthenticationOptionAckLen = 20
+	// TCPEncryptionOptionLen specifies the length of the TCP option carrying the tag of encrypted segments
+	TCPEncryptionOptionLen = 20
+	// EncryptedConnectionTimeout is the idle timeout of encrypted connections. They are not released to the kernel.
+	EncryptedConnectionTimeout = 24 * time.Hour
+	// UDPAuthHeaderLen specifies the length of the header preceding the token in UDP datagrams
+	UDPAuthHeaderLen = 6
+	// UDPAuthMarker identifies UDP datagrams that carry an authorization token
+	UDPAuthMarker = uint16(0xa5a5)
+	// UDPSynToken identifies the token sent by the initiator of a UDP flow
+	UDPSynToken = uint8(1)
+	// UDPSynAckToken identifies the token sent by the responder of a UDP flow
+	UDPSynAckToken = uint8(2)
 	// PortNumberLabelString is the label to use for port numbers
 	PortNumberLabelString = "$sys:port"
 	// TransmitterLabel is the name of the label used to identify the Transmitter Context
@@ -13,4 +32,6 @@ const (
 	DefaultNetwork = "0.0.0.0/0"
 	// DefaultExternalIPTimeout is the default used for the cache for External IPTimeout.
 	DefaultExternalIPTimeout = "500ms"
+	// DefaultFlowAccountingInterval is the interval of the accounting records of active flows.
+	DefaultFlowAccountingInterval = time.Minute
 )
@@ -0,0 +1,75 @@
+package ebpfdatapath
+
+import (
+	"net"
+	"strings"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/ebpf"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/portspec"
+	"go.uber.org/zap"
+)
+
+// rejectRules converts the reject rules of an ACL list to program rules.
+// Accept and observed rules are left to the user space datapath since they
+// need the handshake or a flow report.
+func rejectRules(rules policy.IPRuleList) []ebpf.ACLRule {
+
+	acls := []ebpf.ACLRule{}
+
+	for _, rule := range rules {
+
+		if rule.Policy == nil || !rule.Policy.Action.Rejected() || rule.Policy.Action.Accepted() || rule.Policy.ObserveAction.Observed() {
+			continue
+		}
+
+		var protocol uint8
+		switch strings.ToLower(rule.Protocol) {
+		case "tcp":
+			protocol = packet.IPProtocolTCP
+		case "udp":
+			protocol = packet.IPProtocolUDP
+		default:
+			continue
+		}
+
+		network, err := parseNetwork(rule.Address)
+		if err != nil {
+			zap.L().Debug("Ignoring acl with invalid address", zap.String("address", rule.Address), zap.Error(err))
+			continue
+		}
+
+		ports, err := portspec.NewPortSpecFromString(rule.Port, nil)
+		if err != nil {
+			zap.L().Debug("Ignoring acl with invalid port", zap.String("port", rule.Port), zap.Error(err))
+			continue
+		}
+		min, max := ports.Range()
+
+		acls = append(acls, ebpf.ACLRule{
+			Network:   network,
+			Protocol:  protocol,
+			PortStart: min,
+			PortEnd:   max,
+		})
+	}
+
+	return acls
+}
+
+// parseNetwork parses an address in CIDR notation or a single IP address.
+func parseNetwork(address string) (*net.IPNet, error) {
+
+	if !strings.Contains(address, "/") {
+		if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+			address = address + "/32"
+		} else {
+			address = address + "/128"
+		}
+	}
+
+	_, network, err := net.ParseCIDR(address)
+
+	return network, err
+}
@@ -0,0 +1,111 @@
+package ebpfdatapath
+
+import (
+	"net"
+	"sync"
+
+	"github.com/aporeto-inc/netlink-go/conntrack"
+	"github.com/aporeto-inc/trireme-lib/controller/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/ebpf"
+	"go.uber.org/zap"
+)
+
+// flowReleaser wraps the conntrack handle of the handshake datapath. Every
+// flow that the handshake datapath releases to the kernel through a connmark
+// update is also installed in the flow map of the program, so that the rest
+// of the flow never leaves the kernel.
+type flowReleaser struct {
+	conntrack.Conntrack
+
+	program ebpf.Program
+	flows   map[ebpf.FlowKey]struct{}
+
+	sync.Mutex
+}
+
+func newFlowReleaser(hdl conntrack.Conntrack, program ebpf.Program) *flowReleaser {
+
+	return &flowReleaser{
+		Conntrack: hdl,
+		program:   program,
+		flows:     map[ebpf.FlowKey]struct{}{},
+	}
+}
+
+// ConntrackTableUpdateMark updates the flow map and then the conntrack entry.
+// The default connmark releases a flow and a zero mark brings it back to
+// user space.
+func (r *flowReleaser) ConntrackTableUpdateMark(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, newmark uint32) error {
+
+	key := ebpf.NewFlowKey(net.ParseIP(ipSrc), net.ParseIP(ipDst), protonum, srcport, dstport)
+
+	switch newmark {
+	case constants.DefaultConnMark:
+		r.install(key)
+	case 0:
+		r.remove(key)
+	}
+
+	return r.Conntrack.ConntrackTableUpdateMark(ipSrc, ipDst, protonum, srcport, dstport, newmark)
+}
+
+// install adds both directions of the flow to the flow map.
+func (r *flowReleaser) install(key ebpf.FlowKey) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	for _, k := range []ebpf.FlowKey{key, key.Reverse()} {
+		if err := r.program.UpdateFlow(k, ebpf.VerdictPass); err != nil {
+			zap.L().Error("Failed to install flow in bpf map",
+				zap.String("flow", k.String()),
+				zap.Error(err),
+			)
+			continue
+		}
+		r.flows[k] = struct{}{}
+	}
+}
+
+// remove deletes both directions of the flow from the flow map.
+func (r *flowReleaser) remove(key ebpf.FlowKey) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	for _, k := range []ebpf.FlowKey{key, key.Reverse()} {
+		if _, ok := r.flows[k]; !ok {
+			continue
+		}
+		if err := r.program.DeleteFlow(k); err != nil {
+			zap.L().Debug("Failed to remove flow from bpf map",
+				zap.String("flow", k.String()),
+				zap.Error(err),
+			)
+		}
+		delete(r.flows, k)
+	}
+}
+
+// purge deletes all the flows of the given addresses from the flow map.
+func (r *flowReleaser) purge(ips []net.IP) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	for k := range r.flows {
+		for _, ip := range ips {
+			if !ip.Equal(net.IP(k.SrcIP[:])) && !ip.Equal(net.IP(k.DstIP[:])) {
+				continue
+			}
+			if err := r.program.DeleteFlow(k); err != nil {
+				zap.L().Debug("Failed to remove flow from bpf map",
+					zap.String("flow", k.String()),
+					zap.Error(err),
+				)
+			}
+			delete(r.flows, k)
+			break
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package ebpfdatapath
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/common"
+	"github.com/aporeto-inc/trireme-lib/controller/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/nfqdatapath"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/portset"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/ebpf"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packetprocessor"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/cache"
+	"go.uber.org/zap"
+)
+
+// Datapath is a datapath that keeps the verdicts of established flows and
+// the reject ACLs of the processing units in the maps of a flow program.
+// Flows are installed in the maps when the handshake datapath releases them
+// to the kernel. The program is expected to let only undecided packets, such
+// as handshakes and unknown flows, reach the handshake datapath; with the
+// emulated program the netfilter queues still receive every packet.
+//
+// Processing units without their own addresses (linux processes) share the
+// host address and their ACLs are only enforced by the handshake datapath.
+type Datapath struct {
+	handshake *nfqdatapath.Datapath
+	program   ebpf.Program
+	releaser  *flowReleaser
+
+	// Key=ContextID Value=[]net.IP programmed in the ACL maps
+	puIPs cache.DataStore
+}
+
+// New creates a datapath that runs the given program in front of the
+// handshake datapath.
+func New(program ebpf.Program, handshake *nfqdatapath.Datapath) *Datapath {
+
+	releaser := newFlowReleaser(handshake.ConntrackHandle(), program)
+	handshake.SetConntrackHandle(releaser)
+
+	return &Datapath{
+		handshake: handshake,
+		program:   program,
+		releaser:  releaser,
+		puIPs:     cache.NewCache("ebpfPUIPs"),
+	}
+}
+
+// NewWithDefaults create a new data path with most things used by default
+func NewWithDefaults(
+	serverID string,
+	collector collector.EventCollector,
+	service packetprocessor.PacketProcessor,
+	secrets secrets.Secrets,
+	mode constants.ModeType,
+	procMountPoint string,
+	program ebpf.Program,
+) *Datapath {
+
+	return New(program, nfqdatapath.NewWithDefaults(serverID, collector, service, secrets, mode, procMountPoint))
+}
+
+// Enforce implements the Enforce interface method. It configures the handshake
+// datapath and programs the reject ACLs of the PU addresses.
+func (d *Datapath) Enforce(contextID string, puInfo *policy.PUInfo) error {
+
+	if err := d.handshake.Enforce(contextID, puInfo); err != nil {
+		return err
+	}
+
+	ips := []net.IP{}
+	if puInfo.Runtime.PUType() != common.LinuxProcessPU && puInfo.Runtime.PUType() != common.UIDLoginPU {
+		for _, address := range puInfo.Policy.IPAddresses() {
+			if ip := net.ParseIP(address); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+
+	// Remove the addresses that the PU no longer owns.
+	if item, err := d.puIPs.Get(contextID); err == nil {
+		for _, old := range item.([]net.IP) {
+			if !containsIP(ips, old) {
+				if err := d.program.DeleteACLs(old); err != nil {
+					zap.L().Debug("Unable to remove acls from bpf map", zap.String("ip", old.String()), zap.Error(err))
+				}
+			}
+		}
+	}
+
+	ingress := rejectRules(puInfo.Policy.NetworkACLs())
+	egress := rejectRules(puInfo.Policy.ApplicationACLs())
+
+	for _, ip := range ips {
+		if err := d.program.UpdateACLs(ip, ingress, egress); err != nil {
+			return fmt.Errorf("unable to program acls for %s: %s", ip, err)
+		}
+	}
+
+	d.puIPs.AddOrUpdate(contextID, ips)
+
+	return nil
+}
+
+// Unenforce removes the ACLs and flows of the PU from the maps and the
+// configuration of the handshake datapath.
+func (d *Datapath) Unenforce(contextID string) error {
+
+	if item, err := d.puIPs.Get(contextID); err == nil {
+		ips := item.([]net.IP)
+		for _, ip := range ips {
+			if err := d.program.DeleteACLs(ip); err != nil {
+				zap.L().Debug("Unable to remove acls from bpf map", zap.String("ip", ip.String()), zap.Error(err))
+			}
+		}
+		d.releaser.purge(ips)
+
+		if err := d.puIPs.Remove(contextID); err != nil {
+			zap.L().Debug("Unable to remove cache entry during unenforcement", zap.String("contextID", contextID), zap.Error(err))
+		}
+	}
+
+	return d.handshake.Unenforce(contextID)
+}
+
+// GetFilterQueue returns the filter queues used by the handshake datapath
+func (d *Datapath) GetFilterQueue() *fqconfig.FilterQueue {
+
+	return d.handshake.GetFilterQueue()
+}
+
+// GetPortSetInstance returns the portset instance used by the handshake datapath
+func (d *Datapath) GetPortSetInstance() portset.PortSet {
+
+	return d.handshake.GetPortSetInstance()
+}
+
+// Run loads the program and starts the handshake datapath. The program is
+// unloaded when the context is cancelled.
+func (d *Datapath) Run(ctx context.Context) error {
+
+	if err := d.program.Load(); err != nil {
+		return fmt.Errorf("unable to load bpf program: %s", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := d.program.Close(); err != nil {
+			zap.L().Error("Unable to unload bpf program", zap.Error(err))
+		}
+	}()
+
+	return d.handshake.Run(ctx)
+}
+
+// UpdateSecrets updates the secrets used by the handshake datapath
+func (d *Datapath) UpdateSecrets(token secrets.Secrets) error {
+
+	return d.handshake.UpdateSecrets(token)
+}
+
+// ListPUs returns the PUs enforced by the handshake datapath
+func (d *Datapath) ListPUs() ([]*introspection.PU, error) {
+
+	return d.handshake.ListPUs()
+}
+
+// ListFlows returns the flows tracked by the handshake datapath. Flows
+// offloaded to the program are listed until the handshake datapath expires
+// them.
+func (d *Datapath) ListFlows(contextID string) ([]*introspection.Flow, error) {
+
+	return d.handshake.ListFlows(contextID)
+}
+
+// Simulate evaluates a flow against the policy held by the handshake
+// datapath.
+func (d *Datapath) Simulate(query *introspection.Query) (*introspection.Decision, error) {
+
+	return d.handshake.Simulate(query)
+}
+
+// ProcessNetworkPacket runs a packet arriving from the network through the
+// ingress program and, if the program hands it to user space, through the
+// handshake datapath. It returns the packet as it is delivered and the
+// verdict of the program. A nil packet means that the packet was dropped.
+func (d *Datapath) ProcessNetworkPacket(buf []byte, mark string) ([]byte, ebpf.Verdict, error) {
+
+	return d.process(ebpf.HookIngress, buf, mark)
+}
+
+// ProcessApplicationPacket runs a packet sent by a local application through
+// the egress program and, if the program hands it to user space, through the
+// handshake datapath. It returns the packet as it is transmitted and the
+// verdict of the program. A nil packet means that the packet was dropped.
+func (d *Datapath) ProcessApplicationPacket(buf []byte, mark string) ([]byte, ebpf.Verdict, error) {
+
+	return d.process(ebpf.HookEgress, buf, mark)
+}
+
+func (d *Datapath) process(hook ebpf.Hook, buf []byte, mark string) ([]byte, ebpf.Verdict, error) {
+
+	verdict, err := d.program.Process(hook, buf)
+	if err != nil {
+		return nil, verdict, err
+	}
+
+	switch verdict {
+	case ebpf.VerdictPass:
+		return buf, verdict, nil
+	case ebpf.VerdictDrop:
+		return nil, verdict, nil
+	}
+
+	packetType := uint64(packet.PacketTypeNetwork)
+	if hook == ebpf.HookEgress {
+		packetType = packet.PacketTypeApplication
+	}
+
+	p, err := packet.New(packetType, append([]byte{}, buf...), mark)
+	if err != nil {
+		return nil, verdict, err
+	}
+
+	if hook == ebpf.HookEgress {
+		err = d.handshake.ProcessApplicationPacket(p)
+	} else {
+		err = d.handshake.ProcessNetworkPacket(p)
+	}
+	if err != nil {
+		return nil, verdict, err
+	}
+
+	return p.GetBytes(), verdict, nil
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
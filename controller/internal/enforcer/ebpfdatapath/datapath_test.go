@@ -0,0 +1,218 @@
+package ebpfdatapath
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/common"
+	"github.com/aporeto-inc/trireme-lib/controller/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/utils/packetgen"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/ebpf"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	clientIP = "10.1.10.76"
+	serverIP = "164.67.228.152"
+)
+
+// setupDatapath creates a datapath with a single processing unit, the way
+// the client and the server of a flow run on two different hosts.
+func setupDatapath(puID, ip string, networkACLs policy.IPRuleList) (*Datapath, ebpf.Program, error) {
+
+	tagSelector := policy.TagSelector{
+		Clause: []policy.KeyValueOperator{
+			{
+				Key:      enforcerconstants.TransmitterLabel,
+				Value:    []string{"value"},
+				Operator: policy.Equal,
+			},
+		},
+		Policy: &policy.FlowPolicy{Action: policy.Accept},
+	}
+
+	ips := policy.ExtendedMap{policy.DefaultNamespace: ip}
+	puPolicy := policy.NewPUPolicy(puID, policy.Police, nil, networkACLs, nil, nil, nil, nil, ips, []string{}, []string{}, &policy.ProxiedServicesInfo{}, nil, nil, []string{})
+	puInfo := policy.PUInfoFromPolicyAndRuntime(puID, puPolicy, policy.NewPURuntimeWithDefaults())
+	puInfo.Runtime.SetPUType(common.ContainerPU)
+	puInfo.Runtime.SetIPAddresses(policy.ExtendedMap{"bridge": ip})
+	puInfo.Policy.AddIdentityTag(enforcerconstants.TransmitterLabel, "value")
+	puInfo.Policy.AddReceiverRules(tagSelector)
+
+	program := ebpf.NewEmulatedProgram()
+	secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+	d := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", program)
+
+	if err := d.Enforce(puID, puInfo); err != nil {
+		return nil, nil, err
+	}
+
+	// Only load the program. Running the datapath starts the netfilter
+	// queues and loggers that need a kernel.
+	if err := program.Load(); err != nil {
+		return nil, nil, err
+	}
+
+	return d, program, nil
+}
+
+// flowPacket returns the nth packet of the flow with valid checksums.
+func flowPacket(flow packetgen.PacketFlowManipulator, n int) (*packet.Packet, error) {
+
+	buf, err := flow.GetNthPacket(n).ToBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := packet.New(0, buf, "0")
+	if err != nil {
+		return nil, err
+	}
+	p.UpdateIPChecksum()
+	p.UpdateTCPChecksum()
+
+	return p, nil
+}
+
+func TestReplayGoodFlow(t *testing.T) {
+
+	Convey("Given I create a client and a server datapath with the emulated program", t, func() {
+
+		client, clientProgram, err := setupDatapath("clientPU", clientIP, nil)
+		So(err, ShouldBeNil)
+		server, serverProgram, err := setupDatapath("serverPU", serverIP, nil)
+		So(err, ShouldBeNil)
+
+		flow := packetgen.NewTemplateFlow()
+		_, err = flow.GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlowTemplate)
+		So(err, ShouldBeNil)
+
+		Convey("When I replay the flow through both datapaths", func() {
+
+			verdicts := []ebpf.Verdict{}
+
+			for i := 0; i < flow.GetNumPackets(); i++ {
+				p, err := flowPacket(flow, i)
+				So(err, ShouldBeNil)
+				input := append([]byte{}, p.GetBytes()...)
+
+				sender, receiver := client, server
+				if p.SourceAddress.String() == serverIP {
+					sender, receiver = server, client
+				}
+
+				output, _, err := sender.ProcessApplicationPacket(input, "0")
+				So(err, ShouldBeNil)
+				So(output, ShouldNotBeNil)
+
+				delivered, verdict, err := receiver.ProcessNetworkPacket(output, "0")
+				So(err, ShouldBeNil)
+				So(delivered, ShouldResemble, input)
+
+				verdicts = append(verdicts, verdict)
+			}
+
+			Convey("Then only the handshake packets should reach user space", func() {
+				So(verdicts[0], ShouldEqual, ebpf.VerdictUserspace)
+				So(verdicts[1], ShouldEqual, ebpf.VerdictUserspace)
+				So(verdicts[2], ShouldEqual, ebpf.VerdictUserspace)
+				for _, verdict := range verdicts[3:] {
+					So(verdict, ShouldEqual, ebpf.VerdictPass)
+				}
+			})
+
+			Convey("Then unenforcing a processing unit should remove its flows", func() {
+				p, err := flowPacket(flow, flow.GetNumPackets()-1)
+				So(err, ShouldBeNil)
+
+				So(client.Unenforce("clientPU"), ShouldBeNil)
+				So(server.Unenforce("serverPU"), ShouldBeNil)
+
+				verdict, err := serverProgram.Process(ebpf.HookIngress, p.GetBytes())
+				So(err, ShouldBeNil)
+				So(verdict, ShouldEqual, ebpf.VerdictUserspace)
+
+				verdict, err = clientProgram.Process(ebpf.HookIngress, p.GetBytes())
+				So(err, ShouldBeNil)
+				So(verdict, ShouldEqual, ebpf.VerdictUserspace)
+			})
+		})
+	})
+}
+
+func TestRejectACLDroppedInProgram(t *testing.T) {
+
+	Convey("Given I create a server datapath with a network reject acl for the client", t, func() {
+
+		networkACLs := policy.IPRuleList{
+			{
+				Address:  clientIP + "/32",
+				Port:     "1:65535",
+				Protocol: "tcp",
+				Policy:   &policy.FlowPolicy{Action: policy.Reject, PolicyID: "blocked"},
+			},
+		}
+
+		client, _, err := setupDatapath("clientPU", clientIP, nil)
+		So(err, ShouldBeNil)
+		server, _, err := setupDatapath("serverPU", serverIP, networkACLs)
+		So(err, ShouldBeNil)
+
+		flow := packetgen.NewTemplateFlow()
+		_, err = flow.GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlowTemplate)
+		So(err, ShouldBeNil)
+
+		Convey("When the client sends a syn packet", func() {
+
+			p, err := flowPacket(flow, 0)
+			So(err, ShouldBeNil)
+
+			output, verdict, err := client.ProcessApplicationPacket(p.GetBytes(), "0")
+			So(err, ShouldBeNil)
+			So(verdict, ShouldEqual, ebpf.VerdictUserspace)
+
+			delivered, verdict, err := server.ProcessNetworkPacket(output, "0")
+
+			Convey("Then I expect the program to drop it", func() {
+				So(err, ShouldBeNil)
+				So(verdict, ShouldEqual, ebpf.VerdictDrop)
+				So(delivered, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestRejectRules(t *testing.T) {
+
+	Convey("Given I have a list of acls", t, func() {
+
+		rules := policy.IPRuleList{
+			{Address: "10.0.0.0/8", Port: "80", Protocol: "TCP", Policy: &policy.FlowPolicy{Action: policy.Reject}},
+			{Address: "10.1.1.1", Port: "53:54", Protocol: "udp", Policy: &policy.FlowPolicy{Action: policy.Reject}},
+			{Address: "10.0.0.0/8", Port: "80", Protocol: "tcp", Policy: &policy.FlowPolicy{Action: policy.Accept}},
+			{Address: "10.0.0.0/8", Port: "80", Protocol: "tcp", Policy: &policy.FlowPolicy{Action: policy.Reject, ObserveAction: policy.ObserveContinue}},
+			{Address: "10.0.0.0/8", Port: "80", Protocol: "icmp", Policy: &policy.FlowPolicy{Action: policy.Reject}},
+			{Address: "invalid", Port: "80", Protocol: "tcp", Policy: &policy.FlowPolicy{Action: policy.Reject}},
+			{Address: "10.0.0.0/8", Port: "http", Protocol: "tcp", Policy: &policy.FlowPolicy{Action: policy.Reject}},
+		}
+
+		Convey("Then only the plain tcp and udp reject rules should be converted", func() {
+			acls := rejectRules(rules)
+			So(len(acls), ShouldEqual, 2)
+
+			So(acls[0].Network.String(), ShouldEqual, "10.0.0.0/8")
+			So(acls[0].Protocol, ShouldEqual, packet.IPProtocolTCP)
+			So(acls[0].PortStart, ShouldEqual, 80)
+			So(acls[0].PortEnd, ShouldEqual, 80)
+
+			So(acls[1].Network.String(), ShouldEqual, "10.1.1.1/32")
+			So(acls[1].Protocol, ShouldEqual, packet.IPProtocolUDP)
+			So(acls[1].PortStart, ShouldEqual, 53)
+			So(acls[1].PortEnd, ShouldEqual, 54)
+		})
+	})
+}
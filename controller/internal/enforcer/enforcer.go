@@ -8,12 +8,16 @@ import (
 	"github.com/aporeto-inc/trireme-lib/collector"
 	"github.com/aporeto-inc/trireme-lib/controller/constants"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/applicationproxy"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/ebpfdatapath"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/nfqdatapath"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/nfqdatapath/tokenaccessor"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/portset"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/ebpf"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packetprocessor"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
 	"go.uber.org/zap"
@@ -38,14 +42,25 @@ type Enforcer interface {
 	// Run starts the PolicyEnforcer.
 	Run(ctx context.Context) error
 
-	// UpdateSecrets -- updates the secrets of running enforcers managed by trireme. Remote enforcers will get the secret updates with the next policy push
+	// UpdateSecrets -- updates the secrets of running enforcers managed by trireme. Remote enforcers get the secret updates over the UpdateSecrets RPC
 	UpdateSecrets(secrets secrets.Secrets) error
+
+	// ListPUs returns the state of the enforced processing units.
+	ListPUs() ([]*introspection.PU, error)
+
+	// ListFlows returns the active flows of a processing unit or of all of
+	// them if the contextID is empty.
+	ListFlows(contextID string) ([]*introspection.Flow, error)
+
+	// Simulate evaluates a flow against the policy of a processing unit. It
+	// returns nil if the processing unit is not enforced by this enforcer.
+	Simulate(query *introspection.Query) (*introspection.Decision, error)
 }
 
 // enforcer holds all the active implementations of the enforcer
 type enforcer struct {
 	proxy     *applicationproxy.AppProxy
-	transport *nfqdatapath.Datapath
+	transport Enforcer
 }
 
 // Run implements the run interfaces and runs the individual data paths
@@ -130,6 +145,21 @@ func (e *enforcer) UpdateSecrets(secrets secrets.Secrets) error {
 	return nil
 }
 
+// ListPUs returns the processing units enforced by the transport path.
+func (e *enforcer) ListPUs() ([]*introspection.PU, error) {
+	return e.transport.ListPUs()
+}
+
+// ListFlows returns the flows tracked by the transport path.
+func (e *enforcer) ListFlows(contextID string) ([]*introspection.Flow, error) {
+	return e.transport.ListFlows(contextID)
+}
+
+// Simulate evaluates a flow against the policy of the transport path.
+func (e *enforcer) Simulate(query *introspection.Query) (*introspection.Decision, error) {
+	return e.transport.Simulate(query)
+}
+
 // GetFilterQueue returns the current FilterQueueConfig of the transport path.
 func (e *enforcer) GetFilterQueue() *fqconfig.FilterQueue {
 	return e.transport.GetFilterQueue()
@@ -141,6 +171,11 @@ func (e *enforcer) GetPortSetInstance() portset.PortSet {
 }
 
 // New returns a new policy enforcer that implements both the data paths.
+// The tokens are created by an engine of the given type and their tags are
+// encoded with the dictionary if it is not nil. The Syn and SynAck tokens carry
+// the attestation of the node if it is not nil.
+// When a program is provided, the verdicts of established flows and the
+// reject ACLs are also programmed in its maps.
 func New(
 	mutualAuthorization bool,
 	fqConfig *fqconfig.FilterQueue,
@@ -153,16 +188,20 @@ func New(
 	procMountPoint string,
 	externalIPCacheTimeout time.Duration,
 	packetLogs bool,
+	engine tokens.EngineType,
+	dictionary *tokens.TagDictionary,
+	attestation *policy.Attestation,
+	program ebpf.Program,
 ) (Enforcer, error) {
 
-	tokenAccessor, err := tokenaccessor.New(serverID, validity, secrets)
+	tokenAccessor, err := tokenaccessor.New(serverID, validity, secrets, engine, dictionary, attestation)
 	if err != nil {
 		zap.L().Fatal("Cannot create a token engine")
 	}
 
 	puFromContextID := cache.NewCache("puFromContextID")
 
-	transport := nfqdatapath.New(
+	nfq := nfqdatapath.New(
 		mutualAuthorization,
 		fqConfig,
 		collector,
@@ -178,6 +217,11 @@ func New(
 		puFromContextID,
 	)
 
+	var transport Enforcer = nfq
+	if program != nil {
+		transport = ebpfdatapath.New(program, nfq)
+	}
+
 	tcpProxy, err := applicationproxy.NewAppProxy(tokenAccessor, collector, puFromContextID, nil, secrets)
 	if err != nil {
 		return nil, err
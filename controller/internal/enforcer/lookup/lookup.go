@@ -29,6 +29,7 @@ type PolicyDB struct {
 	notEqualMapTable       map[string]map[string][]*ForwardingPolicy
 	notStarTable           map[string][]*ForwardingPolicy
 	defaultNotExistsPolicy *ForwardingPolicy
+	selectors              policy.TagSelectorList
 }
 
 //NewPolicyDB creates a new PolicyDB for efficient search of policies
@@ -134,6 +135,8 @@ func (m *PolicyDB) AddPolicy(selector policy.TagSelector) (policyID int) {
 	// Give the policy an index
 	e.index = m.numberOfPolicies
 
+	m.selectors = append(m.selectors, selector)
+
 	// Return the ID
 	return e.index
 
@@ -236,6 +239,12 @@ func searchInMapTabe(table []*ForwardingPolicy, count []int, skip []bool) (int,
 	return -1, nil
 }
 
+// Selectors returns the selectors of the policies in the database in the
+// order they were added
+func (m *PolicyDB) Selectors() policy.TagSelectorList {
+	return m.selectors.Copy()
+}
+
 // PrintPolicyDB is a debugging function to dump the map
 func (m *PolicyDB) PrintPolicyDB() {
 
@@ -402,6 +402,24 @@ func TestFuncSearch(t *testing.T) {
 	})
 }
 
+// TestFuncSelectors tests the listing of the policies
+func TestFuncSelectors(t *testing.T) {
+	Convey("Given an empty policy DB", t, func() {
+		policyDB := NewPolicyDB()
+
+		Convey("It should have no selectors", func() {
+			So(policyDB.Selectors(), ShouldBeEmpty)
+		})
+
+		Convey("Given that I add two policy rules, I should get them back in order", func() {
+			policyDB.AddPolicy(appEqWebAndenvEqDemo)
+			policyDB.AddPolicy(policylangNotJava)
+
+			So(policyDB.Selectors(), ShouldResemble, policy.TagSelectorList{appEqWebAndenvEqDemo, policylangNotJava})
+		})
+	})
+}
+
 // TestFuncDumbDB is a mock test for the print function
 func TestFuncDumpDB(t *testing.T) {
 	Convey("Given an empty policy DB", t, func() {
@@ -10,6 +10,7 @@ import (
 
 	portset "github.com/aporeto-inc/trireme-lib/controller/internal/portset"
 	fqconfig "github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
+	introspection "github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
 	secrets "github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
 	policy "github.com/aporeto-inc/trireme-lib/policy"
 	gomock "github.com/golang/mock/gomock"
@@ -125,3 +126,48 @@ func (m *MockEnforcer) UpdateSecrets(secrets secrets.Secrets) error {
 func (mr *MockEnforcerMockRecorder) UpdateSecrets(secrets interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateSecrets", reflect.TypeOf((*MockEnforcer)(nil).UpdateSecrets), secrets)
 }
+
+// ListPUs mocks base method
+// nolint
+func (m *MockEnforcer) ListPUs() ([]*introspection.PU, error) {
+	ret := m.ctrl.Call(m, "ListPUs")
+	ret0, _ := ret[0].([]*introspection.PU)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListPUs indicates an expected call of ListPUs
+// nolint
+func (mr *MockEnforcerMockRecorder) ListPUs() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPUs", reflect.TypeOf((*MockEnforcer)(nil).ListPUs))
+}
+
+// ListFlows mocks base method
+// nolint
+func (m *MockEnforcer) ListFlows(contextID string) ([]*introspection.Flow, error) {
+	ret := m.ctrl.Call(m, "ListFlows", contextID)
+	ret0, _ := ret[0].([]*introspection.Flow)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListFlows indicates an expected call of ListFlows
+// nolint
+func (mr *MockEnforcerMockRecorder) ListFlows(contextID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListFlows", reflect.TypeOf((*MockEnforcer)(nil).ListFlows), contextID)
+}
+
+// Simulate mocks base method
+// nolint
+func (m *MockEnforcer) Simulate(query *introspection.Query) (*introspection.Decision, error) {
+	ret := m.ctrl.Call(m, "Simulate", query)
+	ret0, _ := ret[0].(*introspection.Decision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Simulate indicates an expected call of Simulate
+// nolint
+func (mr *MockEnforcerMockRecorder) Simulate(query interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Simulate", reflect.TypeOf((*MockEnforcer)(nil).Simulate), query)
+}
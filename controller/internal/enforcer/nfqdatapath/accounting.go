@@ -0,0 +1,153 @@
+package nfqdatapath
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/connection"
+	"go.uber.org/zap"
+)
+
+// counterReader returns the counters of the conntrack entry of the original
+// direction of the flow reported by record. It returns errFlowNotFound when
+// the flow is no longer in conntrack.
+type counterReader func(record *collector.FlowRecord) (collector.FlowCounters, error)
+
+// accountedFlow is an accepted flow and the counters already reported for it.
+type accountedFlow struct {
+	record   *collector.FlowRecord
+	conn     *connection.TCPConnection
+	counters collector.FlowCounters
+}
+
+// flowAccounting tracks the accepted flows that are released to the kernel
+// and reports their traffic from the conntrack counters.
+type flowAccounting struct {
+	flows     map[string]*accountedFlow
+	read      counterReader
+	collector collector.EventCollector
+	// closed is called for the TCP connections of the flows that are no
+	// longer in conntrack
+	closed func(conn *connection.TCPConnection)
+	// err is the last error of the reads of the counters
+	err error
+	sync.Mutex
+}
+
+// newFlowAccounting returns a flow accounting that queries the conntrack
+// counters of the tracked flows over netlink.
+func newFlowAccounting(c collector.EventCollector, closed func(conn *connection.TCPConnection)) *flowAccounting {
+
+	return &flowAccounting{
+		flows:     map[string]*accountedFlow{},
+		read:      newCounterReader(),
+		collector: c,
+		closed:    closed,
+	}
+}
+
+// flowHash returns the hash of the original direction of the flow of record.
+func flowHash(record *collector.FlowRecord) string {
+	return record.Source.IP + ":" + record.Destination.IP + ":" + strconv.Itoa(int(record.Source.Port)) + ":" + strconv.Itoa(int(record.Destination.Port))
+}
+
+// track starts the accounting of the accepted flow reported by record. The
+// TCP connection of the flow is nil for other protocols.
+func (a *flowAccounting) track(record *collector.FlowRecord, conn *connection.TCPConnection) {
+
+	hash := flowHash(record)
+
+	a.Lock()
+	defer a.Unlock()
+
+	if _, ok := a.flows[hash]; ok {
+		return
+	}
+
+	a.flows[hash] = &accountedFlow{record: record, conn: conn}
+}
+
+// tracked returns true if the flow with the given hash is accounted and
+// its end will be detected from the conntrack counters.
+func (a *flowAccounting) tracked(hash string) bool {
+
+	a.Lock()
+	defer a.Unlock()
+
+	_, ok := a.flows[hash]
+
+	return ok && a.err == nil
+}
+
+// report reports the traffic of the tracked flows since the last report.
+// Flows that are no longer in conntrack are closed and reported for the
+// last time with their remaining traffic. Flows whose counters cannot be
+// read are kept until the next report.
+func (a *flowAccounting) report() {
+
+	closed := []*connection.TCPConnection{}
+	defer func() {
+		// Connections are locked by the callback. They must not be locked
+		// while holding the accounting lock.
+		for _, conn := range closed {
+			a.closed(conn)
+		}
+	}()
+
+	a.Lock()
+	defer a.Unlock()
+
+	var failed error
+
+	for hash, flow := range a.flows {
+
+		current, err := a.read(flow.record)
+		if err == errFlowNotFound {
+			delete(a.flows, hash)
+			if flow.conn != nil && a.closed != nil {
+				closed = append(closed, flow.conn)
+			}
+			continue
+		}
+
+		if err != nil {
+			failed = err
+			continue
+		}
+
+		delta := current.Sub(flow.counters)
+		flow.counters = current
+
+		if delta.Zero() {
+			continue
+		}
+
+		a.collector.CollectFlowEvent(collector.NewAccountingRecord(flow.record, delta))
+	}
+
+	if failed != nil && a.err == nil {
+		zap.L().Warn("Unable to read conntrack counters", zap.Error(failed))
+	}
+
+	a.err = failed
+}
+
+// run reports the traffic of the tracked flows every interval until the
+// context is cancelled.
+func (a *flowAccounting) run(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.report()
+		}
+	}
+}
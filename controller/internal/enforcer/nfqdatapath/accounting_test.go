@@ -0,0 +1,193 @@
+package nfqdatapath
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/collector/mock"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/connection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// tableCounterReader returns a counter reader that reads the counters of the
+// flows from table.
+func tableCounterReader(table map[string]collector.FlowCounters) counterReader {
+
+	return func(record *collector.FlowRecord) (collector.FlowCounters, error) {
+		counters, ok := table[flowHash(record)]
+		if !ok {
+			return collector.FlowCounters{}, errFlowNotFound
+		}
+		return counters, nil
+	}
+}
+
+func TestFlowAccounting(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and two processing units that accept each other", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		records := []*collector.FlowRecord{}
+		mockCollector := mockcollector.NewMockEventCollector(ctrl)
+		mockCollector.EXPECT().CollectFlowEvent(gomock.Any()).Do(func(r *collector.FlowRecord) {
+			records = append(records, r)
+		}).AnyTimes()
+
+		enforcer, err := setupUDPProcessingUnits(mockCollector, &policy.FlowPolicy{Action: policy.Accept, PolicyID: "accounted"})
+		So(err, ShouldBeNil)
+
+		table := map[string]collector.FlowCounters{}
+		enforcer.accounting.read = tableCounterReader(table)
+
+		accounting := func() []*collector.FlowRecord {
+			list := []*collector.FlowRecord{}
+			for _, r := range records {
+				if r.Accounting {
+					list = append(list, r)
+				}
+			}
+			return list
+		}
+
+		Convey("When a flow is accepted", func() {
+
+			query, err := generateUDPDatagram("10.1.10.76", "10.1.10.77", 5353, 53, []byte("query"))
+			So(err, ShouldBeNil)
+
+			appPacket, err := packet.New(0, query, "0")
+			So(err, ShouldBeNil)
+			So(enforcer.processApplicationUDPPackets(appPacket), ShouldBeNil)
+
+			netPacket, err := packet.New(0, appPacket.GetBytes(), "0")
+			So(err, ShouldBeNil)
+			So(enforcer.processNetworkUDPPackets(netPacket), ShouldBeNil)
+
+			Convey("Then its traffic should be reported since the previous report until the flow is closed", func() {
+
+				table["10.1.10.76:10.1.10.77:5353:53"] = collector.FlowCounters{SourceBytes: 100, SourcePackets: 1, DestinationBytes: 1000, DestinationPackets: 2}
+				enforcer.accounting.report()
+
+				table["10.1.10.76:10.1.10.77:5353:53"] = collector.FlowCounters{SourceBytes: 150, SourcePackets: 2, DestinationBytes: 1000, DestinationPackets: 2}
+				enforcer.accounting.report()
+
+				// No traffic since the previous report
+				enforcer.accounting.report()
+
+				delete(table, "10.1.10.76:10.1.10.77:5353:53")
+				enforcer.accounting.report()
+
+				list := accounting()
+				So(len(list), ShouldEqual, 2)
+
+				So(list[0].Count, ShouldEqual, 0)
+				So(list[0].PolicyID, ShouldEqual, "accounted")
+				So(list[0].Source.Port, ShouldEqual, 5353)
+				So(list[0].Destination.Port, ShouldEqual, 53)
+				So(list[0].FlowCounters, ShouldResemble, collector.FlowCounters{SourceBytes: 100, SourcePackets: 1, DestinationBytes: 1000, DestinationPackets: 2})
+				So(list[1].FlowCounters, ShouldResemble, collector.FlowCounters{SourceBytes: 50, SourcePackets: 1})
+
+				So(len(enforcer.accounting.flows), ShouldEqual, 0)
+			})
+
+			Convey("Then the flow should still be tracked if the counters cannot be read", func() {
+
+				enforcer.accounting.read = func(record *collector.FlowRecord) (collector.FlowCounters, error) {
+					return collector.FlowCounters{}, fmt.Errorf("no conntrack socket")
+				}
+				enforcer.accounting.report()
+
+				So(len(accounting()), ShouldEqual, 0)
+				So(len(enforcer.accounting.flows), ShouldEqual, 1)
+				So(enforcer.accounting.tracked("10.1.10.76:10.1.10.77:5353:53"), ShouldBeFalse)
+
+				table["10.1.10.76:10.1.10.77:5353:53"] = collector.FlowCounters{SourceBytes: 100, SourcePackets: 1}
+				enforcer.accounting.read = tableCounterReader(table)
+				enforcer.accounting.report()
+
+				So(len(accounting()), ShouldEqual, 1)
+				So(enforcer.accounting.tracked("10.1.10.76:10.1.10.77:5353:53"), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestFlowTermination(t *testing.T) {
+
+	Convey("Given a datapath and an accepted TCP connection", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		records := []*collector.FlowRecord{}
+		mockCollector := mockcollector.NewMockEventCollector(ctrl)
+		mockCollector.EXPECT().CollectFlowEvent(gomock.Any()).Do(func(r *collector.FlowRecord) {
+			records = append(records, r)
+		}).AnyTimes()
+
+		d := &Datapath{collector: mockCollector}
+		d.accounting = newFlowAccounting(mockCollector, d.releasedFlowClosed)
+
+		conn := connection.NewTCPConnection(nil)
+		conn.SetState(connection.TCPData)
+		conn.AcceptedRecord = &collector.FlowRecord{
+			ContextID:   "pu",
+			Source:      &collector.EndPoint{IP: "10.1.10.76", Port: 41532},
+			Destination: &collector.EndPoint{IP: "10.1.10.77", Port: 80},
+			PolicyID:    "accepted",
+			Count:       1,
+			Action:      policy.Accept,
+		}
+
+		Convey("When the connection is reset and then closed", func() {
+
+			d.processFlowTermination(&packet.Packet{TCPFlags: packet.TCPRstMask | packet.TCPAckMask}, conn)
+			d.processFlowTermination(&packet.Packet{TCPFlags: packet.TCPFinMask | packet.TCPAckMask}, conn)
+
+			Convey("Then the termination should be reported once with the reset reason", func() {
+				So(len(records), ShouldEqual, 1)
+				So(records[0].Count, ShouldEqual, 0)
+				So(records[0].PolicyID, ShouldEqual, "accepted")
+				So(records[0].Termination, ShouldNotBeNil)
+				So(records[0].Termination.Reason, ShouldEqual, collector.FlowClosedRst)
+				So(records[0].Termination.State, ShouldEqual, "data")
+				So(records[0].Termination.Duration, ShouldBeGreaterThan, 0)
+			})
+		})
+
+		Convey("When a packet without FIN or RST is processed", func() {
+
+			d.processFlowTermination(&packet.Packet{TCPFlags: packet.TCPAckMask}, conn)
+
+			Convey("Then no termination should be reported", func() {
+				So(len(records), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the connection is released to the kernel and leaves conntrack", func() {
+
+			table := map[string]collector.FlowCounters{
+				"10.1.10.76:10.1.10.77:41532:80": {},
+			}
+			d.accounting.read = tableCounterReader(table)
+			d.accounting.track(conn.AcceptedRecord, conn)
+
+			d.accounting.report()
+			So(len(records), ShouldEqual, 0)
+
+			delete(table, "10.1.10.76:10.1.10.77:41532:80")
+			d.accounting.report()
+
+			Convey("Then the termination should be reported as closed", func() {
+				So(len(records), ShouldEqual, 1)
+				So(records[0].Termination, ShouldNotBeNil)
+				So(records[0].Termination.Reason, ShouldEqual, collector.FlowClosed)
+			})
+		})
+	})
+}
@@ -0,0 +1,242 @@
+package nfqdatapath
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"unsafe"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+)
+
+// Netlink and ctnetlink constants used to query a single conntrack entry.
+const (
+	nlmsgHdrLen    = 16
+	nfgenmsgLen    = 4
+	nlaHdrLen      = 4
+	nlaTypeMask    = 0x3fff
+	nlaFlagNested  = 0x8000
+	nlmsgError     = 0x2
+	nlmFlagRequest = 0x1
+
+	nfnlSubsysCTNetlink = 1
+	ipctnlMsgCTNew      = 0
+	ipctnlMsgCTGet      = 1
+
+	ctaTupleOrig     = 1
+	ctaCountersOrig  = 9
+	ctaCountersReply = 10
+
+	ctaTupleIP    = 1
+	ctaTupleProto = 2
+
+	ctaIPv4Src = 1
+	ctaIPv4Dst = 2
+	ctaIPv6Src = 3
+	ctaIPv6Dst = 4
+
+	ctaProtoNum     = 1
+	ctaProtoSrcPort = 2
+	ctaProtoDstPort = 3
+
+	ctaCountersPackets = 1
+	ctaCountersBytes   = 2
+
+	afInet  = 2
+	afInet6 = 10
+	enoent  = 2
+)
+
+var (
+	// errFlowNotFound is returned by the counter readers when the flow is
+	// no longer in conntrack.
+	errFlowNotFound = errors.New("flow not found in conntrack")
+
+	// errNoConntrackEntry is returned when a netlink response does not
+	// answer the query.
+	errNoConntrackEntry = errors.New("no conntrack entry in response")
+)
+
+// nativeEndian is the byte order of the netlink headers and attributes.
+var nativeEndian binary.ByteOrder
+
+func init() {
+	v := uint16(1)
+	if *(*byte)(unsafe.Pointer(&v)) == 1 {
+		nativeEndian = binary.LittleEndian
+	} else {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+// netlinkAttribute appends a netlink attribute to b.
+func netlinkAttribute(b []byte, attrType uint16, value []byte) []byte {
+
+	hdr := make([]byte, nlaHdrLen)
+	nativeEndian.PutUint16(hdr[0:2], uint16(nlaHdrLen+len(value)))
+	nativeEndian.PutUint16(hdr[2:4], attrType)
+
+	b = append(b, hdr...)
+	b = append(b, value...)
+
+	// Attributes are aligned on 4 bytes
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+
+	return b
+}
+
+// conntrackGetRequest builds the ctnetlink request for the conntrack entry
+// whose original direction matches the five tuple of the flow record.
+func conntrackGetRequest(record *collector.FlowRecord, seq uint32) ([]byte, error) {
+
+	src := net.ParseIP(record.Source.IP)
+	dst := net.ParseIP(record.Destination.IP)
+	if src == nil || dst == nil {
+		return nil, fmt.Errorf("invalid flow addresses: %s %s", record.Source.IP, record.Destination.IP)
+	}
+
+	family := uint8(afInet6)
+	ips := []byte{}
+	if src.To4() != nil && dst.To4() != nil {
+		family = afInet
+		ips = netlinkAttribute(ips, ctaIPv4Src, src.To4())
+		ips = netlinkAttribute(ips, ctaIPv4Dst, dst.To4())
+	} else {
+		ips = netlinkAttribute(ips, ctaIPv6Src, src.To16())
+		ips = netlinkAttribute(ips, ctaIPv6Dst, dst.To16())
+	}
+
+	sport := make([]byte, 2)
+	binary.BigEndian.PutUint16(sport, record.Source.Port)
+	dport := make([]byte, 2)
+	binary.BigEndian.PutUint16(dport, record.Destination.Port)
+
+	proto := []byte{}
+	proto = netlinkAttribute(proto, ctaProtoNum, []byte{record.L4Protocol})
+	proto = netlinkAttribute(proto, ctaProtoSrcPort, sport)
+	proto = netlinkAttribute(proto, ctaProtoDstPort, dport)
+
+	tuple := []byte{}
+	tuple = netlinkAttribute(tuple, ctaTupleIP|nlaFlagNested, ips)
+	tuple = netlinkAttribute(tuple, ctaTupleProto|nlaFlagNested, proto)
+
+	msg := make([]byte, nlmsgHdrLen+nfgenmsgLen)
+	msg[nlmsgHdrLen] = family
+	msg = netlinkAttribute(msg, ctaTupleOrig|nlaFlagNested, tuple)
+
+	nativeEndian.PutUint32(msg[0:4], uint32(len(msg)))
+	nativeEndian.PutUint16(msg[4:6], nfnlSubsysCTNetlink<<8|ipctnlMsgCTGet)
+	nativeEndian.PutUint16(msg[6:8], nlmFlagRequest)
+	nativeEndian.PutUint32(msg[8:12], seq)
+
+	return msg, nil
+}
+
+// netlinkAttributes calls fn for every attribute of b.
+func netlinkAttributes(b []byte, fn func(attrType uint16, value []byte) error) error {
+
+	for len(b) >= nlaHdrLen {
+		length := int(nativeEndian.Uint16(b[0:2]))
+		if length < nlaHdrLen || length > len(b) {
+			return fmt.Errorf("invalid netlink attribute length: %d", length)
+		}
+
+		if err := fn(nativeEndian.Uint16(b[2:4])&nlaTypeMask, b[nlaHdrLen:length]); err != nil {
+			return err
+		}
+
+		aligned := (length + 3) &^ 3
+		if aligned > len(b) {
+			break
+		}
+		b = b[aligned:]
+	}
+
+	return nil
+}
+
+// conntrackCounterValues parses the packets and bytes of a counters attribute.
+func conntrackCounterValues(b []byte) (packets uint64, bytes uint64, err error) {
+
+	err = netlinkAttributes(b, func(attrType uint16, value []byte) error {
+		if len(value) != 8 {
+			return nil
+		}
+		switch attrType {
+		case ctaCountersPackets:
+			packets = binary.BigEndian.Uint64(value)
+		case ctaCountersBytes:
+			bytes = binary.BigEndian.Uint64(value)
+		}
+		return nil
+	})
+
+	return packets, bytes, err
+}
+
+// parseConntrackGetResponse parses the response to a ctnetlink get request.
+// The original direction of an entry is sent by the source of the flow and
+// the reply direction by its destination. Entries of kernels without conntrack
+// accounting have no counters.
+func parseConntrackGetResponse(b []byte, seq uint32) (collector.FlowCounters, error) {
+
+	counters := collector.FlowCounters{}
+
+	for len(b) >= nlmsgHdrLen {
+		length := int(nativeEndian.Uint32(b[0:4]))
+		if length < nlmsgHdrLen || length > len(b) {
+			return counters, fmt.Errorf("invalid netlink message length: %d", length)
+		}
+
+		msgType := nativeEndian.Uint16(b[4:6])
+		msgSeq := nativeEndian.Uint32(b[8:12])
+		payload := b[nlmsgHdrLen:length]
+
+		next := (length + 3) &^ 3
+		if next > len(b) {
+			next = len(b)
+		}
+		b = b[next:]
+
+		if msgSeq != seq {
+			continue
+		}
+
+		switch msgType {
+		case nlmsgError:
+			if len(payload) < 4 {
+				return counters, errors.New("truncated netlink error")
+			}
+			errno := -int32(nativeEndian.Uint32(payload[0:4]))
+			if errno == enoent {
+				return counters, errFlowNotFound
+			}
+			if errno != 0 {
+				return counters, fmt.Errorf("conntrack query failed: errno %d", errno)
+			}
+
+		case nfnlSubsysCTNetlink<<8 | ipctnlMsgCTNew:
+			if len(payload) < nfgenmsgLen {
+				return counters, errors.New("truncated conntrack entry")
+			}
+
+			err := netlinkAttributes(payload[nfgenmsgLen:], func(attrType uint16, value []byte) error {
+				var err error
+				switch attrType {
+				case ctaCountersOrig:
+					counters.SourcePackets, counters.SourceBytes, err = conntrackCounterValues(value)
+				case ctaCountersReply:
+					counters.DestinationPackets, counters.DestinationBytes, err = conntrackCounterValues(value)
+				}
+				return err
+			})
+
+			return counters, err
+		}
+	}
+
+	return counters, errNoConntrackEntry
+}
@@ -0,0 +1,100 @@
+// +build linux
+
+package nfqdatapath
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"golang.org/x/sys/unix"
+)
+
+// netlinkCounterReader queries the counters of the conntrack entries of the
+// flows over a ctnetlink socket. The socket is opened on the first query and
+// reopened after an error.
+type netlinkCounterReader struct {
+	fd  int
+	seq uint32
+	sync.Mutex
+}
+
+// newCounterReader returns the counter reader of the platform.
+func newCounterReader() counterReader {
+
+	r := &netlinkCounterReader{fd: -1}
+
+	return r.read
+}
+
+// open opens the ctnetlink socket if it is not open.
+func (r *netlinkCounterReader) open() error {
+
+	if r.fd >= 0 {
+		return nil
+	}
+
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_NETFILTER)
+	if err != nil {
+		return fmt.Errorf("unable to open netlink socket: %s", err)
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd) // nolint errcheck
+		return fmt.Errorf("unable to bind netlink socket: %s", err)
+	}
+
+	r.fd = fd
+
+	return nil
+}
+
+// close closes the socket after an error so that the next query starts with
+// a new socket.
+func (r *netlinkCounterReader) close() {
+
+	if r.fd >= 0 {
+		unix.Close(r.fd) // nolint errcheck
+		r.fd = -1
+	}
+}
+
+// read returns the counters of the conntrack entry of the original direction
+// of the flow.
+func (r *netlinkCounterReader) read(record *collector.FlowRecord) (collector.FlowCounters, error) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	if err := r.open(); err != nil {
+		return collector.FlowCounters{}, err
+	}
+
+	r.seq++
+	req, err := conntrackGetRequest(record, r.seq)
+	if err != nil {
+		return collector.FlowCounters{}, err
+	}
+
+	if err := unix.Sendto(r.fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		r.close()
+		return collector.FlowCounters{}, fmt.Errorf("unable to send conntrack query: %s", err)
+	}
+
+	buf := make([]byte, unix.Getpagesize())
+	for {
+		n, _, err := unix.Recvfrom(r.fd, buf, 0)
+		if err != nil {
+			r.close()
+			return collector.FlowCounters{}, fmt.Errorf("unable to receive conntrack entry: %s", err)
+		}
+
+		counters, err := parseConntrackGetResponse(buf[:n], r.seq)
+		if err == errNoConntrackEntry {
+			// Late responses of previous queries are skipped
+			continue
+		}
+
+		return counters, err
+	}
+}
@@ -0,0 +1,18 @@
+// +build !linux
+
+package nfqdatapath
+
+import (
+	"errors"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+)
+
+// newCounterReader returns the counter reader of the platform. Conntrack is
+// only available on linux.
+func newCounterReader() counterReader {
+
+	return func(record *collector.FlowRecord) (collector.FlowCounters, error) {
+		return collector.FlowCounters{}, errors.New("conntrack not supported")
+	}
+}
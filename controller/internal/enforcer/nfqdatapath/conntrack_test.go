@@ -0,0 +1,191 @@
+package nfqdatapath
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// netlinkMessage builds a netlink message with the given type, sequence and payload.
+func netlinkMessage(msgType uint16, seq uint32, payload []byte) []byte {
+
+	msg := make([]byte, nlmsgHdrLen, nlmsgHdrLen+len(payload))
+	msg = append(msg, payload...)
+	nativeEndian.PutUint32(msg[0:4], uint32(len(msg)))
+	nativeEndian.PutUint16(msg[4:6], msgType)
+	nativeEndian.PutUint32(msg[8:12], seq)
+
+	return msg
+}
+
+// conntrackCountersAttribute builds a counters attribute.
+func conntrackCountersAttribute(attrType uint16, packets, bytes uint64) []byte {
+
+	p := make([]byte, 8)
+	binary.BigEndian.PutUint64(p, packets)
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, bytes)
+
+	counters := netlinkAttribute(nil, ctaCountersPackets, p)
+	counters = netlinkAttribute(counters, ctaCountersBytes, b)
+
+	return netlinkAttribute(nil, attrType|nlaFlagNested, counters)
+}
+
+// nestedAttribute returns the value of the attribute found by following the
+// attribute types from b.
+func nestedAttribute(b []byte, types ...uint16) []byte {
+
+	for _, t := range types {
+		var value []byte
+		netlinkAttributes(b, func(attrType uint16, v []byte) error { // nolint errcheck
+			if attrType == t {
+				value = v
+			}
+			return nil
+		})
+		b = value
+	}
+
+	return b
+}
+
+func TestConntrackGetRequest(t *testing.T) {
+
+	Convey("Given a flow record of an IPv4 TCP flow", t, func() {
+
+		record := &collector.FlowRecord{
+			Source:      &collector.EndPoint{IP: "10.1.10.76", Port: 41532},
+			Destination: &collector.EndPoint{IP: "10.1.10.77", Port: 80},
+			L4Protocol:  packet.IPProtocolTCP,
+		}
+
+		Convey("When I build the conntrack query", func() {
+
+			req, err := conntrackGetRequest(record, 7)
+			So(err, ShouldBeNil)
+
+			Convey("Then the header should be a ctnetlink get request for an IPv4 entry", func() {
+				So(int(nativeEndian.Uint32(req[0:4])), ShouldEqual, len(req))
+				So(nativeEndian.Uint16(req[4:6]), ShouldEqual, nfnlSubsysCTNetlink<<8|ipctnlMsgCTGet)
+				So(nativeEndian.Uint32(req[8:12]), ShouldEqual, 7)
+				So(req[nlmsgHdrLen], ShouldEqual, afInet)
+			})
+
+			Convey("Then the original tuple should hold the five tuple of the flow", func() {
+
+				tuple := req[nlmsgHdrLen+nfgenmsgLen:]
+				ip := func(attrType uint16) string {
+					return net.IP(nestedAttribute(tuple, ctaTupleOrig, ctaTupleIP, attrType)).String()
+				}
+				proto := func(attrType uint16) []byte {
+					return nestedAttribute(tuple, ctaTupleOrig, ctaTupleProto, attrType)
+				}
+
+				So(ip(ctaIPv4Src), ShouldEqual, "10.1.10.76")
+				So(ip(ctaIPv4Dst), ShouldEqual, "10.1.10.77")
+				So(proto(ctaProtoNum), ShouldResemble, []byte{packet.IPProtocolTCP})
+				So(binary.BigEndian.Uint16(proto(ctaProtoSrcPort)), ShouldEqual, 41532)
+				So(binary.BigEndian.Uint16(proto(ctaProtoDstPort)), ShouldEqual, 80)
+			})
+		})
+
+		Convey("When the flow is an IPv6 flow", func() {
+
+			record.Source.IP = "2001:db8::1"
+			record.Destination.IP = "2001:db8::2"
+			req, err := conntrackGetRequest(record, 1)
+
+			Convey("Then the query should be for an IPv6 entry", func() {
+				So(err, ShouldBeNil)
+				So(req[nlmsgHdrLen], ShouldEqual, afInet6)
+			})
+		})
+
+		Convey("When the flow addresses are invalid", func() {
+
+			record.Source.IP = "default"
+			_, err := conntrackGetRequest(record, 1)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestParseConntrackGetResponse(t *testing.T) {
+
+	Convey("Given a conntrack entry with counters", t, func() {
+
+		payload := make([]byte, nfgenmsgLen)
+		payload = append(payload, conntrackCountersAttribute(ctaCountersOrig, 10, 1200)...)
+		payload = append(payload, conntrackCountersAttribute(ctaCountersReply, 8, 90000)...)
+		entry := netlinkMessage(nfnlSubsysCTNetlink<<8|ipctnlMsgCTNew, 3, payload)
+
+		Convey("When I parse the response to the query", func() {
+
+			counters, err := parseConntrackGetResponse(entry, 3)
+
+			Convey("Then I should get the counters of both directions", func() {
+				So(err, ShouldBeNil)
+				So(counters, ShouldResemble, collector.FlowCounters{
+					SourceBytes:        1200,
+					SourcePackets:      10,
+					DestinationBytes:   90000,
+					DestinationPackets: 8,
+				})
+			})
+		})
+
+		Convey("When the response answers another query", func() {
+
+			_, err := parseConntrackGetResponse(entry, 4)
+
+			Convey("Then the entry should be ignored", func() {
+				So(err, ShouldEqual, errNoConntrackEntry)
+			})
+		})
+
+		Convey("When the response is truncated", func() {
+
+			_, err := parseConntrackGetResponse(entry[:len(entry)-6], 3)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given a netlink error response", t, func() {
+
+		errno := func(e int32) []byte {
+			b := make([]byte, 4)
+			nativeEndian.PutUint32(b, uint32(-e))
+			return netlinkMessage(nlmsgError, 5, b)
+		}
+
+		Convey("When the flow is not in conntrack", func() {
+
+			_, err := parseConntrackGetResponse(errno(enoent), 5)
+
+			Convey("Then I should get the not found error", func() {
+				So(err, ShouldEqual, errFlowNotFound)
+			})
+		})
+
+		Convey("When the query failed", func() {
+
+			_, err := parseConntrackGetResponse(errno(1), 5)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+				So(err, ShouldNotEqual, errFlowNotFound)
+			})
+		})
+	})
+}
@@ -3,6 +3,7 @@ package nfqdatapath
 // Go libraries
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os/exec"
 	"time"
@@ -23,6 +24,7 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packetprocessor"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
 	"github.com/aporeto-inc/trireme-lib/utils/portcache"
@@ -67,18 +69,24 @@ type Datapath struct {
 	netReplyConnectionTracker   cache.DataStore
 	unknownSynConnectionTracker cache.DataStore
 
+	// UDP flows hashed on the five-tuple of the originating direction.
+	// Flows initiated by a local application are tracked in the app cache
+	// and flows initiated from the network are tracked in the net cache.
+	udpAppOrigConnectionTracker cache.DataStore
+	udpNetOrigConnectionTracker cache.DataStore
+
 	// CacheTimeout used for Trireme auto-detecion
 	ExternalIPCacheTimeout time.Duration
 
 	// connctrack handle
 	conntrackHdl conntrack.Conntrack
 
+	// accounting of the accepted flows released to the kernel
+	accounting *flowAccounting
+
 	// mode captures the mode of the enforcer
 	mode constants.ModeType
 
-	// ack size
-	ackSize uint32
-
 	mutualAuthorization bool
 	packetLogs          bool
 
@@ -113,20 +121,59 @@ func New(
 	}
 
 	if mode == constants.RemoteContainer || mode == constants.LocalServer {
-		// Make conntrack liberal for TCP
+		configureConntrack()
+	}
 
-		sysctlCmd, err := exec.LookPath("sysctl")
-		if err != nil {
-			zap.L().Fatal("sysctl command must be installed", zap.Error(err))
-		}
+	return newDatapath(
+		mutualAuth,
+		filterQueue,
+		collector,
+		service,
+		secrets,
+		mode,
+		procMountPoint,
+		ExternalIPCacheTimeout,
+		packetLogs,
+		tokenaccessor,
+		puFromContextID,
+	)
+}
 
-		cmd := exec.Command(sysctlCmd, "-w", "net.netfilter.nf_conntrack_tcp_be_liberal=1")
-		if err := cmd.Run(); err != nil {
-			zap.L().Fatal("Failed to set conntrack options", zap.Error(err))
-		}
+// configureConntrack makes conntrack liberal for TCP and enables the counters
+// used for the accounting of the flows.
+func configureConntrack() {
+
+	sysctlCmd, err := exec.LookPath("sysctl")
+	if err != nil {
+		zap.L().Fatal("sysctl command must be installed", zap.Error(err))
+	}
 
+	cmd := exec.Command(sysctlCmd, "-w", "net.netfilter.nf_conntrack_tcp_be_liberal=1")
+	if err := cmd.Run(); err != nil {
+		zap.L().Fatal("Failed to set conntrack options", zap.Error(err))
 	}
 
+	cmd = exec.Command(sysctlCmd, "-w", "net.netfilter.nf_conntrack_acct=1")
+	if err := cmd.Run(); err != nil {
+		zap.L().Warn("Failed to enable conntrack accounting", zap.Error(err))
+	}
+}
+
+// newDatapath creates a datapath without configuring the host.
+func newDatapath(
+	mutualAuth bool,
+	filterQueue *fqconfig.FilterQueue,
+	collector collector.EventCollector,
+	service packetprocessor.PacketProcessor,
+	secrets secrets.Secrets,
+	mode constants.ModeType,
+	procMountPoint string,
+	ExternalIPCacheTimeout time.Duration,
+	packetLogs bool,
+	tokenaccessor tokenaccessor.TokenAccessor,
+	puFromContextID cache.DataStore,
+) *Datapath {
+
 	// This cache is shared with portSetInstance. The portSetInstance
 	// cleans up the entry corresponding to port when port is no longer
 	// part of ipset portset.
@@ -147,9 +194,10 @@ func New(
 		sourcePortConnectionCache:   cache.NewCacheWithExpiration("sourcePortConnectionCache", time.Second*24),
 		appOrigConnectionTracker:    cache.NewCacheWithExpiration("appOrigConnectionTracker", time.Second*24),
 		appReplyConnectionTracker:   cache.NewCacheWithExpiration("appReplyConnectionTracker", time.Second*24),
-		netOrigConnectionTracker:    cache.NewCacheWithExpiration("netOrigConnectionTracker", time.Second*24),
 		netReplyConnectionTracker:   cache.NewCacheWithExpiration("netReplyConnectionTracker", time.Second*24),
 		unknownSynConnectionTracker: cache.NewCacheWithExpiration("unknownSynConnectionTracker", time.Second*2),
+		udpAppOrigConnectionTracker: cache.NewCacheWithExpirationNotifier("udpAppOrigConnectionTracker", time.Second*60, connection.UDPConnectionExpirationNotifier),
+		udpNetOrigConnectionTracker: cache.NewCacheWithExpirationNotifier("udpNetOrigConnectionTracker", time.Second*60, connection.UDPConnectionExpirationNotifier),
 		ExternalIPCacheTimeout:      ExternalIPCacheTimeout,
 		filterQueue:                 filterQueue,
 		mutualAuthorization:         mutualAuth,
@@ -157,7 +205,6 @@ func New(
 		collector:                   collector,
 		tokenAccessor:               tokenaccessor,
 		secrets:                     secrets,
-		ackSize:                     secrets.AckSize(),
 		mode:                        mode,
 		procMountPoint:              procMountPoint,
 		conntrackHdl:                conntrack.NewHandle(),
@@ -165,6 +212,11 @@ func New(
 		packetLogs:                  packetLogs,
 	}
 
+	// Expired connections and released flows that leave conntrack are
+	// reported as terminated.
+	d.netOrigConnectionTracker = cache.NewCacheWithExpirationNotifier("netOrigConnectionTracker", time.Second*24, d.netOrigConnectionExpired)
+	d.accounting = newFlowAccounting(collector, d.releasedFlowClosed)
+
 	packet.PacketLogLevel = packetLogs
 
 	d.nflogger = nflog.NewNFLogger(11, 10, d.puInfoDelegate, collector)
@@ -195,7 +247,7 @@ func NewWithDefaults(
 	}
 	defaultPacketLogs := false
 
-	tokenaccessor, err := tokenaccessor.New(serverID, defaultValidity, secrets)
+	tokenaccessor, err := tokenaccessor.New(serverID, defaultValidity, secrets, tokens.JWTEngine, nil, nil)
 	if err != nil {
 		zap.L().Fatal("Cannot create a token engine")
 	}
@@ -219,6 +271,46 @@ func NewWithDefaults(
 	)
 }
 
+// NewOffline creates a data path enforcing remote container processing units
+// that does not configure the host. The tokens are issued and validated at
+// the time returned by the clock. It is used to process captured packets.
+func NewOffline(
+	serverID string,
+	collector collector.EventCollector,
+	secrets secrets.Secrets,
+	clock func() time.Time,
+) (*Datapath, error) {
+
+	if collector == nil {
+		return nil, errors.New("collector must be given to NewOffline")
+	}
+
+	validity := time.Hour * 8760
+	externalIPCacheTimeout, err := time.ParseDuration(enforcerconstants.DefaultExternalIPTimeout)
+	if err != nil {
+		externalIPCacheTimeout = time.Second
+	}
+
+	tokenaccessor, err := tokenaccessor.NewWithClock(serverID, validity, secrets, tokens.JWTEngine, nil, nil, clock)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create a token engine: %s", err)
+	}
+
+	return newDatapath(
+		false,
+		fqconfig.NewFilterQueueWithDefaults(),
+		collector,
+		nil,
+		secrets,
+		constants.RemoteContainer,
+		"/proc",
+		externalIPCacheTimeout,
+		false,
+		tokenaccessor,
+		cache.NewCache("puFromContextID"),
+	), nil
+}
+
 // Enforce implements the Enforce interface method and configures the data path for a new PU
 func (d *Datapath) Enforce(contextID string, puInfo *policy.PUInfo) error {
 
@@ -230,6 +322,16 @@ func (d *Datapath) Enforce(contextID string, puInfo *policy.PUInfo) error {
 		return fmt.Errorf("error creating new pu: %s", err)
 	}
 
+	// Handshake packets with tokens that exceed the MTU would be dropped
+	if err := d.tokenAccessor.CheckTokenSize(pu); err != nil {
+		return fmt.Errorf("unable to enforce pu: %s", err)
+	}
+
+	// Policy updates keep the rate state of the policies that did not change
+	if previous, err := d.puFromContextID.Get(contextID); err == nil {
+		pu.InheritRateLimiters(previous.(*pucontext.PUContext))
+	}
+
 	// Cache PUs for retrieval based on packet information
 	if pu.Type() == common.LinuxProcessPU || pu.Type() == common.UIDLoginPU {
 		mark, ports := pu.GetProcessKeys()
@@ -292,6 +394,47 @@ func (d *Datapath) Unenforce(contextID string) error {
 	return nil
 }
 
+// ProcessNetworkPacket processes a parsed packet arriving from the network
+// and dispatches it to the datapath of its protocol.
+func (d *Datapath) ProcessNetworkPacket(p *packet.Packet) error {
+
+	switch p.IPProto {
+	case packet.IPProtocolTCP:
+		return d.processNetworkTCPPackets(p)
+	case packet.IPProtocolUDP:
+		return d.processNetworkUDPPackets(p)
+	default:
+		return fmt.Errorf("invalid ip protocol: %d", p.IPProto)
+	}
+}
+
+// ProcessApplicationPacket processes a parsed packet sent by a local application
+// and dispatches it to the datapath of its protocol.
+func (d *Datapath) ProcessApplicationPacket(p *packet.Packet) error {
+
+	switch p.IPProto {
+	case packet.IPProtocolTCP:
+		return d.processApplicationTCPPackets(p)
+	case packet.IPProtocolUDP:
+		return d.processApplicationUDPPackets(p)
+	default:
+		return fmt.Errorf("invalid ip protocol: %d", p.IPProto)
+	}
+}
+
+// SetConntrackHandle replaces the conntrack handle used to release flows
+// from the datapath. Alternate datapaths use it to learn about released flows.
+func (d *Datapath) SetConntrackHandle(hdl conntrack.Conntrack) {
+
+	d.conntrackHdl = hdl
+}
+
+// ConntrackHandle returns the conntrack handle used by the datapath.
+func (d *Datapath) ConntrackHandle() conntrack.Conntrack {
+
+	return d.conntrackHdl
+}
+
 // GetFilterQueue returns the filter queues used by the data path
 func (d *Datapath) GetFilterQueue() *fqconfig.FilterQueue {
 
@@ -317,6 +460,8 @@ func (d *Datapath) Run(ctx context.Context) error {
 
 	go d.nflogger.Run(ctx)
 
+	go d.accounting.run(ctx, enforcerconstants.DefaultFlowAccountingInterval)
+
 	return nil
 }
 
@@ -325,6 +470,11 @@ func (d *Datapath) UpdateSecrets(token secrets.Secrets) error {
 	return d.tokenAccessor.SetToken(d.tokenAccessor.GetTokenServerID(), d.tokenAccessor.GetTokenValidity(), token)
 }
 
+// ReplayStatistics returns the counters of the detection of the replayed tokens.
+func (d *Datapath) ReplayStatistics() tokenaccessor.ReplayStatistics {
+	return d.tokenAccessor.ReplayStatistics()
+}
+
 func (d *Datapath) puInfoDelegate(contextID string) (ID string, tags *policy.TagStore) {
 
 	item, err := d.puFromContextID.Get(contextID)
@@ -340,7 +490,7 @@ func (d *Datapath) puInfoDelegate(contextID string) (ID string, tags *policy.Tag
 	return
 }
 
-func (d *Datapath) reportFlow(p *packet.Packet, connection *connection.TCPConnection, sourceID string, destID string, context *pucontext.PUContext, mode string, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
+func (d *Datapath) reportFlow(p *packet.Packet, connection *connection.TCPConnection, sourceID string, destID string, context *pucontext.PUContext, mode string, report *policy.FlowPolicy, packet *policy.FlowPolicy) *collector.FlowRecord {
 
 	c := &collector.FlowRecord{
 		ContextID: context.ID(),
@@ -369,4 +519,10 @@ func (d *Datapath) reportFlow(p *packet.Packet, connection *connection.TCPConnec
 	}
 
 	d.collector.CollectFlowEvent(c)
+
+	if mode == "" {
+		d.accounting.track(c, connection)
+	}
+
+	return c
 }
@@ -11,9 +11,11 @@ import (
 	"github.com/aporeto-inc/trireme-lib/collector"
 	"github.com/aporeto-inc/trireme-lib/controller/constants"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/nfqdatapath/tokenaccessor"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/connection"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/revocation"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
@@ -130,6 +132,8 @@ func (d *Datapath) processNetworkTCPPackets(p *packet.Packet) (err error) {
 
 	}
 
+	d.processFlowTermination(p, conn)
+
 	// Accept the packet
 	p.UpdateTCPChecksum()
 	p.Print(packet.PacketStageOutgoing)
@@ -239,6 +243,8 @@ func (d *Datapath) processApplicationTCPPackets(p *packet.Packet) (err error) {
 		}
 	}
 
+	d.processFlowTermination(p, conn)
+
 	// Accept the packet
 	p.UpdateTCPChecksum()
 	p.Print(packet.PacketStageOutgoing)
@@ -285,6 +291,15 @@ func (d *Datapath) processApplicationSynPacket(tcpPacket *packet.Packet, context
 	// Create TCP Option
 	tcpOptions := d.createTCPAuthenticationOption([]byte{})
 
+	// Announce our ephemeral key in case the policy of the server requires encryption
+	key, err := context.EphemeralKey()
+	if err != nil {
+		return nil, err
+	}
+
+	conn.EphemeralKey = key
+	conn.Auth.LocalServiceContext = key.Public
+
 	// Create a token
 	tcpData, err := d.tokenAccessor.CreateSynPacketToken(context, &conn.Auth)
 
@@ -342,12 +357,23 @@ func (d *Datapath) processApplicationSynAckPacket(tcpPacket *packet.Packet, cont
 	// Create TCP Option
 	tcpOptions := d.createTCPAuthenticationOption([]byte{})
 
+	// The client must not send segments too large for the encryption option
+	if conn.EphemeralKey != nil {
+		tcpPacket.DecreaseTCPMss(enforcerconstants.TCPEncryptionOptionLen)
+	}
+
 	tcpData, err := d.tokenAccessor.CreateSynAckPacketToken(context, &conn.Auth)
 
 	if err != nil {
 		return nil, err
 	}
 
+	if conn.EphemeralKey != nil {
+		if err := d.createSession(conn, false); err != nil {
+			return nil, err
+		}
+	}
+
 	// Set the state for future reference
 	conn.SetState(connection.TCPSynAckSend)
 
@@ -372,8 +398,8 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 		tcpOptions := d.createTCPAuthenticationOption([]byte{})
 
 		// Since we adjust sequence numbers let's make sure we haven't made a mistake
-		if len(token) != int(d.ackSize) {
-			return nil, fmt.Errorf("protocol error: tokenlen=%d acksize=%d", len(token), int(d.ackSize))
+		if ackSize := d.tokenAccessor.AckSize(); len(token) != int(ackSize) {
+			return nil, fmt.Errorf("protocol error: tokenlen=%d acksize=%d", len(token), int(ackSize))
 		}
 
 		// Attach the tags to the packet
@@ -386,8 +412,8 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 		// If its not a service connection, we release it to the kernel. Subsequent
 		// packets after the first data packet, that might be already in the queue
 		// will be transmitted through the kernel directly. Service connections are
-		// delegated to the service module
-		if !conn.ServiceConnection && tcpPacket.SourceAddress.String() != tcpPacket.DestinationAddress.String() {
+		// delegated to the service module and encrypted connections are kept.
+		if !conn.ServiceConnection && conn.Session == nil && tcpPacket.SourceAddress.String() != tcpPacket.DestinationAddress.String() {
 			if err := d.conntrackHdl.ConntrackTableUpdateMark(
 				tcpPacket.SourceAddress.String(),
 				tcpPacket.DestinationAddress.String(),
@@ -409,7 +435,7 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 
 	// If we are already in the connection.TCPData connection just forward the packet
 	if conn.GetState() == connection.TCPData {
-		return nil, nil
+		return nil, d.encryptTCPPayload(tcpPacket, conn)
 	}
 
 	if conn.GetState() == connection.UnknownState {
@@ -448,7 +474,7 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 	// We will let the caches expire.
 	if conn.GetState() == connection.TCPAckSend {
 		conn.SetState(connection.TCPData)
-		return nil, nil
+		return nil, d.encryptTCPPayload(tcpPacket, conn)
 	}
 
 	return nil, fmt.Errorf("received application ack packet in the wrong state: %d", conn.GetState())
@@ -487,7 +513,12 @@ func (d *Datapath) processNetworkSynPacket(context *pucontext.PUContext, conn *c
 
 		// If there is no auth option, attempt the ACLs
 		report, packet, perr := context.NetworkACLPolicy(tcpPacket)
-		d.reportExternalServiceFlow(context, report, packet, false, tcpPacket)
+		if perr == nil && !packet.Action.Rejected() && !tcpConnectionAllowed(context, conn, packet) {
+			d.reportExternalServiceFlow(context, collector.RateLimitDrop, rateLimitedFlowPolicy(report), rateLimitedFlowPolicy(packet), false, tcpPacket)
+			return nil, nil, fmt.Errorf("connection dropped by rate limit of policy: %s", packet.PolicyID)
+		}
+
+		d.reportExternalServiceFlow(context, collector.PolicyDrop, report, packet, false, tcpPacket)
 		if perr != nil || packet.Action.Rejected() {
 			return nil, nil, fmt.Errorf("no auth or acls: outgoing connection dropped: %s", perr)
 		}
@@ -507,7 +538,7 @@ func (d *Datapath) processNetworkSynPacket(context *pucontext.PUContext, conn *c
 	// we must drop the connection and we drop the Syn packet. The source will
 	// retry but we have no state to maintain here.
 	if err != nil {
-		d.reportRejectedFlow(tcpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, nil, nil)
+		d.reportRejectedFlow(tcpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, tokenErrorReason(err, collector.InvalidToken), nil, nil)
 		return nil, nil, fmt.Errorf("Syn packet dropped because of invalid token: %s", err)
 	}
 
@@ -543,6 +574,25 @@ func (d *Datapath) processNetworkSynPacket(context *pucontext.PUContext, conn *c
 		return nil, nil, fmt.Errorf("connection rejected because of policy: %s", claims.T.String())
 	}
 
+	if !tcpConnectionAllowed(context, conn, packet) {
+		d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID(), context, collector.RateLimitDrop, rateLimitedFlowPolicy(report), rateLimitedFlowPolicy(packet))
+		return nil, nil, fmt.Errorf("connection dropped by rate limit of policy: %s", packet.PolicyID)
+	}
+
+	// The nonce is remembered only once the connection is accepted. The Syn
+	// packets rejected by the policies can be retransmitted.
+	if err := d.tokenAccessor.RecordPacketToken(&conn.Auth); err != nil {
+		d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID(), context, tokenErrorReason(err, collector.InvalidToken), nil, nil)
+		return nil, nil, fmt.Errorf("Syn packet dropped because of invalid token: %s", err)
+	}
+
+	if packet.Action.Encrypted() {
+		if err := d.startEncryption(tcpPacket, conn); err != nil {
+			d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID(), context, collector.PolicyDrop, report, packet)
+			return nil, nil, fmt.Errorf("connection rejected: %s", err)
+		}
+	}
+
 	hash := tcpPacket.L4FlowHash()
 	// Update the connection state and store the Nonse send to us by the host.
 	// We use the nonse in the subsequent packets to achieve randomization.
@@ -575,6 +625,10 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 		flowHash := tcpPacket.SourceAddress.String() + ":" + strconv.Itoa(int(tcpPacket.SourcePort))
 		if plci, plerr := context.RetrieveCachedExternalFlowPolicy(flowHash); plerr == nil {
 			plc := plci.(*policyPair)
+			if !tcpConnectionAllowed(context, conn, plc.packet) {
+				d.reportReverseExternalServiceFlow(context, collector.RateLimitDrop, rateLimitedFlowPolicy(plc.report), rateLimitedFlowPolicy(plc.packet), true, tcpPacket)
+				return nil, nil, fmt.Errorf("connection dropped by rate limit of policy: %s", plc.packet.PolicyID)
+			}
 			d.releaseFlow(context, plc.report, plc.packet, tcpPacket)
 			return plc.packet, nil, nil
 		}
@@ -582,10 +636,15 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 		// Never seen this IP before, let's parse them.
 		report, packet, perr := context.ApplicationACLPolicy(tcpPacket)
 		if perr != nil || packet.Action.Rejected() {
-			d.reportReverseExternalServiceFlow(context, report, packet, true, tcpPacket)
+			d.reportReverseExternalServiceFlow(context, collector.PolicyDrop, report, packet, true, tcpPacket)
 			return nil, nil, fmt.Errorf("no auth or acls: drop synack packet and connection: %s: action=%d", perr, packet.Action)
 		}
 
+		if !tcpConnectionAllowed(context, conn, packet) {
+			d.reportReverseExternalServiceFlow(context, collector.RateLimitDrop, rateLimitedFlowPolicy(report), rateLimitedFlowPolicy(packet), true, tcpPacket)
+			return nil, nil, fmt.Errorf("connection dropped by rate limit of policy: %s", packet.PolicyID)
+		}
+
 		// Added to the cache if we can accept it
 		context.CacheExternalFlowPolicy(
 			tcpPacket,
@@ -635,7 +694,7 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 
 	claims, err = d.tokenAccessor.ParsePacketToken(&conn.Auth, tcpPacket.ReadTCPData())
 	if err != nil {
-		d.reportRejectedFlow(tcpPacket, nil, collector.DefaultEndPoint, context.ManagementID(), context, collector.MissingToken, nil, nil)
+		d.reportRejectedFlow(tcpPacket, nil, collector.DefaultEndPoint, context.ManagementID(), context, tokenErrorReason(err, collector.MissingToken), nil, nil)
 		return nil, nil, fmt.Errorf("SynAck packet dropped because of bad claims: %s", err)
 	}
 
@@ -659,8 +718,20 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 
 	tcpPacket.DropDetachedBytes()
 
+	// The server announces its ephemeral key only if the connection is encrypted
+	if len(conn.Auth.RemoteServiceContext) > 0 {
+		if err := d.createSession(conn, true); err != nil {
+			d.reportRejectedFlow(tcpPacket, conn, context.ManagementID(), conn.Auth.RemoteContextID, context, collector.InvalidFormat, nil, nil)
+			return nil, nil, fmt.Errorf("SynAck packet dropped: %s", err)
+		}
+	}
+
 	if !d.mutualAuthorization {
 		// If we dont do mutual authorization, dont lookup txt rules.
+		if err := d.recordSynAckToken(tcpPacket, conn, context); err != nil {
+			return nil, nil, err
+		}
+
 		conn.SetState(connection.TCPSynAckReceived)
 
 		// conntrack
@@ -674,6 +745,20 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 		return nil, nil, fmt.Errorf("dropping because of reject rule on transmitter: %s", claims.T.String())
 	}
 
+	if !tcpConnectionAllowed(context, conn, packet) {
+		d.reportRejectedFlow(tcpPacket, conn, context.ManagementID(), conn.Auth.RemoteContextID, context, collector.RateLimitDrop, rateLimitedFlowPolicy(report), rateLimitedFlowPolicy(packet))
+		return nil, nil, fmt.Errorf("dropping because of rate limit on transmitter: %s", packet.PolicyID)
+	}
+
+	if packet.Action.Encrypted() && conn.Session == nil {
+		d.reportRejectedFlow(tcpPacket, conn, context.ManagementID(), conn.Auth.RemoteContextID, context, collector.PolicyDrop, report, packet)
+		return nil, nil, errors.New("dropping because encryption is required by policy but not supported by the server")
+	}
+
+	if err := d.recordSynAckToken(tcpPacket, conn, context); err != nil {
+		return nil, nil, err
+	}
+
 	conn.SetState(connection.TCPSynAckReceived)
 
 	// conntrack
@@ -681,11 +766,22 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 	return packet, claims, nil
 }
 
+// recordSynAckToken records the nonce of the token of an accepted SynAck packet.
+func (d *Datapath) recordSynAckToken(tcpPacket *packet.Packet, conn *connection.TCPConnection, context *pucontext.PUContext) error {
+
+	if err := d.tokenAccessor.RecordPacketToken(&conn.Auth); err != nil {
+		d.reportRejectedFlow(tcpPacket, conn, context.ManagementID(), conn.Auth.RemoteContextID, context, tokenErrorReason(err, collector.MissingToken), nil, nil)
+		return fmt.Errorf("SynAck packet dropped because of bad claims: %s", err)
+	}
+
+	return nil
+}
+
 // processNetworkAckPacket processes an Ack packet arriving from the network
 func (d *Datapath) processNetworkAckPacket(context *pucontext.PUContext, conn *connection.TCPConnection, tcpPacket *packet.Packet) (action interface{}, claims *tokens.ConnectionClaims, err error) {
 
 	if conn.GetState() == connection.TCPData || conn.GetState() == connection.TCPAckSend {
-		return nil, nil, nil
+		return nil, nil, d.decryptTCPPayload(tcpPacket, conn)
 	}
 
 	if conn.GetState() == connection.UnknownState {
@@ -755,7 +851,7 @@ func (d *Datapath) processNetworkAckPacket(context *pucontext.PUContext, conn *c
 
 		conn.SetState(connection.TCPData)
 
-		if !conn.ServiceConnection {
+		if !conn.ServiceConnection && conn.Session == nil {
 			if err := d.conntrackHdl.ConntrackTableUpdateMark(
 				tcpPacket.SourceAddress.String(),
 				tcpPacket.DestinationAddress.String(),
@@ -982,12 +1078,28 @@ func (d *Datapath) netRetrieveState(p *packet.Packet) (*connection.TCPConnection
 
 }
 
-// updateTimer updates the timers for the service connections
+// tokenErrorReason returns the drop reason reported for a token that cannot be
+// parsed. Peers whose certificate or key has been revoked and replayed tokens
+// are reported apart.
+func tokenErrorReason(err error, reason string) string {
+
+	if revocation.IsRevoked(err) {
+		return collector.RevokedCertificate
+	}
+
+	if err == tokenaccessor.ErrTokenReplayed {
+		return collector.ReplayedToken
+	}
+
+	return reason
+}
+
+// updateTimer updates the timers for the service and encrypted connections
 func updateTimer(c cache.DataStore, hash string, conn *connection.TCPConnection) error {
 	conn.RLock()
 	defer conn.RUnlock()
 
-	if conn.ServiceConnection && conn.TimeOut > 0 {
+	if (conn.ServiceConnection || conn.Session != nil) && conn.TimeOut > 0 {
 		return c.SetTimeOut(hash, conn.TimeOut)
 	}
 	return nil
@@ -1047,5 +1159,54 @@ func (d *Datapath) releaseFlow(context *pucontext.PUContext, report *policy.Flow
 		zap.L().Error("Failed to update conntrack table", zap.Error(err))
 	}
 
-	d.reportReverseExternalServiceFlow(context, report, action, true, tcpPacket)
+	d.reportReverseExternalServiceFlow(context, collector.PolicyDrop, report, action, true, tcpPacket)
+}
+
+// processFlowTermination reports the termination of the flow of a connection
+// when a FIN or a RST packet is processed. The connection must be locked.
+func (d *Datapath) processFlowTermination(p *packet.Packet, conn *connection.TCPConnection) {
+
+	switch {
+	case p.TCPFlags&packet.TCPRstMask != 0:
+		d.reportFlowTermination(conn, collector.FlowClosedRst)
+	case p.TCPFlags&packet.TCPFinMask != 0:
+		d.reportFlowTermination(conn, collector.FlowClosedFin)
+	}
+}
+
+// netOrigConnectionExpired is the expiration notifier of the netOrigConnectionTracker.
+// Connections released to the kernel are not seen by the datapath anymore after
+// they expire. If they are accounted, their termination is reported when they
+// leave conntrack instead.
+func (d *Datapath) netOrigConnectionExpired(c cache.DataStore, id interface{}, item interface{}) {
+
+	conn, ok := item.(*connection.TCPConnection)
+	if !ok {
+		return
+	}
+
+	hash, _ := id.(string)
+
+	// The notifier is called with the cache locked. The connection is locked
+	// asynchronously since the packet processing locks them in the reverse order.
+	go func() {
+		conn.Lock()
+		defer conn.Unlock()
+
+		if conn.GetState() == connection.TCPData && !conn.ServiceConnection && conn.Session == nil && d.accounting.tracked(hash) {
+			return
+		}
+
+		d.reportFlowTermination(conn, collector.FlowExpired)
+	}()
+}
+
+// releasedFlowClosed reports the termination of a connection released to the
+// kernel when it is no longer in conntrack.
+func (d *Datapath) releasedFlowClosed(conn *connection.TCPConnection) {
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	d.reportFlowTermination(conn, collector.FlowClosed)
 }
@@ -22,6 +22,8 @@ import (
 	"github.com/aporeto-inc/trireme-lib/utils/portspec"
 	"github.com/bvandewalle/go-ipset/ipset"
 	"github.com/golang/mock/gomock"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -197,12 +199,15 @@ func (m *myMatcher) Matches(x interface{}) bool {
 	f1 := m.x.(*collector.FlowRecord)
 	f2 := x.(*collector.FlowRecord)
 
-	if f1.Destination.IP == f2.Destination.IP && f1.Source.IP == f2.Source.IP && f1.Destination.Port == f2.Destination.Port && f1.Action == f2.Action && f1.Count == f2.Count {
+	if f1.Destination.IP != f2.Destination.IP || f1.Source.IP != f2.Source.IP || f1.Destination.Port != f2.Destination.Port || f1.Action != f2.Action || f1.Count != f2.Count {
+		return false
+	}
 
-		return true
+	if f1.Termination == nil || f2.Termination == nil {
+		return f1.Termination == f2.Termination
 	}
 
-	return false
+	return f1.Termination.Reason == f2.Termination.Reason
 }
 
 func (m *myMatcher) String() string {
@@ -481,6 +486,476 @@ func TestPacketHandlingEndToEndPacketsMatch(t *testing.T) {
 	})
 }
 
+// generateIPv6Handshake returns the SYN, SYN-ACK and ACK packets of an IPv6 TCP handshake.
+func generateIPv6Handshake(src, dst string, sport, dport layers.TCPPort) ([][]byte, error) {
+
+	srcIP := net.ParseIP(src)
+	dstIP := net.ParseIP(dst)
+
+	segment := func(forward bool, syn, ack bool, seq, acknum uint32) ([]byte, error) {
+
+		ip := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolTCP, SrcIP: srcIP, DstIP: dstIP}
+		tcp := &layers.TCP{SrcPort: sport, DstPort: dport, SYN: syn, ACK: ack, Seq: seq, Ack: acknum, Window: 0xaaaa}
+		if !forward {
+			ip.SrcIP, ip.DstIP = dstIP, srcIP
+			tcp.SrcPort, tcp.DstPort = dport, sport
+		}
+
+		if err := tcp.SetNetworkLayerForChecksum(ip); err != nil {
+			return nil, err
+		}
+
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buf, opts, ip, tcp); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	flow := [][]byte{}
+	for _, p := range []struct {
+		forward, syn, ack bool
+		seq, ack2         uint32
+	}{
+		{true, true, false, 1000, 0},
+		{false, true, true, 2000, 1001},
+		{true, false, true, 1001, 2001},
+	} {
+		b, err := segment(p.forward, p.syn, p.ack, p.seq, p.ack2)
+		if err != nil {
+			return nil, err
+		}
+		flow = append(flow, b)
+	}
+
+	return flow, nil
+}
+
+func TestPacketHandlingIPv6Handshake(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and two processing units with ipv6 addresses", t, func() {
+
+		tagSelector := policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{
+					Key:      enforcerconstants.TransmitterLabel,
+					Value:    []string{"value"},
+					Operator: policy.Equal,
+				},
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		}
+
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		enforcer := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc")
+
+		for _, pu := range []struct{ id, ip string }{{"ipv6PU1", "fd00::1"}, {"ipv6PU2", "fd00::2"}} {
+			puInfo := policy.NewPUInfo(pu.id, common.ContainerPU)
+			puInfo.Runtime.SetIPAddresses(policy.ExtendedMap{"bridge": pu.ip})
+			puInfo.Policy.SetIPAddresses(policy.ExtendedMap{policy.DefaultNamespace: pu.ip})
+			puInfo.Policy.AddIdentityTag(enforcerconstants.TransmitterLabel, "value")
+			puInfo.Policy.AddReceiverRules(tagSelector)
+			So(enforcer.Enforce(pu.id, puInfo), ShouldBeNil)
+		}
+
+		flow, err := generateIPv6Handshake("fd00::1", "fd00::2", 40000, 80)
+		So(err, ShouldBeNil)
+
+		Convey("When I pass the handshake through the application and network paths", func() {
+
+			for i, input := range flow {
+				appPacket, err := packet.New(0, append([]byte{}, input...), "0")
+				So(err, ShouldBeNil)
+				So(appPacket.IsIPv6(), ShouldBeTrue)
+
+				err = enforcer.processApplicationTCPPackets(appPacket)
+				So(err, ShouldBeNil)
+
+				output := append([]byte{}, appPacket.GetBytes()...)
+				if i < 2 {
+					// SYN and SYN-ACK packets carry the authentication option and token
+					So(len(output), ShouldBeGreaterThan, len(input))
+				}
+
+				netPacket, err := packet.New(0, output, "0")
+				So(err, ShouldBeNil)
+				So(netPacket.VerifyTCPChecksum(), ShouldBeTrue)
+
+				err = enforcer.processNetworkTCPPackets(netPacket)
+				So(err, ShouldBeNil)
+
+				// The network path strips the option and token again
+				So(netPacket.GetBytes(), ShouldResemble, input)
+			}
+		})
+	})
+}
+
+func generateUDPDatagram(src, dst string, sport, dport layers.UDPPort, payload []byte) ([]byte, error) {
+
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.ParseIP(src), DstIP: net.ParseIP(dst)}
+	udp := &layers.UDP{SrcPort: sport, DstPort: dport}
+
+	if err := udp.SetNetworkLayerForChecksum(ip); err != nil {
+		return nil, err
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, udp, gopacket.Payload(payload)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func setupUDPProcessingUnits(eventCollector collector.EventCollector, flowPolicy *policy.FlowPolicy) (*Datapath, error) {
+
+	tagSelector := policy.TagSelector{
+		Clause: []policy.KeyValueOperator{
+			{
+				Key:      enforcerconstants.TransmitterLabel,
+				Value:    []string{"value"},
+				Operator: policy.Equal,
+			},
+		},
+		Policy: flowPolicy,
+	}
+
+	secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+	enforcer := NewWithDefaults("SomeServerId", eventCollector, nil, secret, constants.RemoteContainer, "/proc")
+
+	for _, pu := range []struct{ id, ip string }{{"udpPU1", "10.1.10.76"}, {"udpPU2", "10.1.10.77"}} {
+		puInfo := policy.NewPUInfo(pu.id, common.ContainerPU)
+		puInfo.Runtime.SetIPAddresses(policy.ExtendedMap{"bridge": pu.ip})
+		puInfo.Policy.SetIPAddresses(policy.ExtendedMap{policy.DefaultNamespace: pu.ip})
+		puInfo.Policy.AddIdentityTag(enforcerconstants.TransmitterLabel, "value")
+		puInfo.Policy.AddReceiverRules(tagSelector)
+		if err := enforcer.Enforce(pu.id, puInfo); err != nil {
+			return nil, err
+		}
+	}
+
+	return enforcer, nil
+}
+
+func TestPacketHandlingUDPFlow(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and two processing units that accept each other", t, func() {
+
+		enforcer, err := setupUDPProcessingUnits(&collector.DefaultCollector{}, &policy.FlowPolicy{Action: policy.Accept})
+		So(err, ShouldBeNil)
+
+		query, err := generateUDPDatagram("10.1.10.76", "10.1.10.77", 5353, 53, []byte("query"))
+		So(err, ShouldBeNil)
+		answer, err := generateUDPDatagram("10.1.10.77", "10.1.10.76", 53, 5353, []byte("answer"))
+		So(err, ShouldBeNil)
+
+		Convey("When I pass a query and its answer through the application and network paths", func() {
+
+			for i, input := range [][]byte{query, answer, query} {
+				appPacket, err := packet.New(0, append([]byte{}, input...), "0")
+				So(err, ShouldBeNil)
+
+				err = enforcer.processApplicationUDPPackets(appPacket)
+				So(err, ShouldBeNil)
+
+				output := append([]byte{}, appPacket.GetBytes()...)
+				if i < 2 {
+					// The first datagram of each direction carries a token
+					So(len(output), ShouldBeGreaterThan, len(input))
+				} else {
+					So(output, ShouldResemble, input)
+				}
+
+				netPacket, err := packet.New(0, output, "0")
+				So(err, ShouldBeNil)
+				So(netPacket.VerifyUDPChecksum(), ShouldBeTrue)
+
+				err = enforcer.processNetworkUDPPackets(netPacket)
+				So(err, ShouldBeNil)
+
+				// The network path strips the token again
+				So(netPacket.GetBytes(), ShouldResemble, input)
+				So(netPacket.VerifyUDPChecksum(), ShouldBeTrue)
+			}
+
+			Convey("Then both sides of the flow should be authorized", func() {
+				client, err := enforcer.udpAppOrigConnectionTracker.Get("10.1.10.76:10.1.10.77:5353:53")
+				So(err, ShouldBeNil)
+				So(client.(*connection.UDPConnection).GetState(), ShouldEqual, connection.UDPData)
+
+				server, err := enforcer.udpNetOrigConnectionTracker.Get("10.1.10.76:10.1.10.77:5353:53")
+				So(err, ShouldBeNil)
+				So(server.(*connection.UDPConnection).GetState(), ShouldEqual, connection.UDPData)
+			})
+		})
+
+		Convey("When I pass a datagram with a corrupted token through the network path", func() {
+
+			appPacket, err := packet.New(0, append([]byte{}, query...), "0")
+			So(err, ShouldBeNil)
+			So(enforcer.processApplicationUDPPackets(appPacket), ShouldBeNil)
+
+			output := append([]byte{}, appPacket.GetBytes()...)
+			// Corrupt a byte in the middle of the token
+			output[28+enforcerconstants.UDPAuthHeaderLen+40] ^= 0x01
+
+			netPacket, err := packet.New(0, output, "0")
+			So(err, ShouldBeNil)
+
+			Convey("Then I expect the datagram to be dropped", func() {
+				So(enforcer.processNetworkUDPPackets(netPacket), ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given I create a new enforcer instance and two processing units that reject each other", t, func() {
+
+		enforcer, err := setupUDPProcessingUnits(&collector.DefaultCollector{}, &policy.FlowPolicy{Action: policy.Reject})
+		So(err, ShouldBeNil)
+
+		query, err := generateUDPDatagram("10.1.10.76", "10.1.10.77", 5353, 53, []byte("query"))
+		So(err, ShouldBeNil)
+
+		Convey("When I pass a query through the application and network paths", func() {
+
+			appPacket, err := packet.New(0, query, "0")
+			So(err, ShouldBeNil)
+			So(enforcer.processApplicationUDPPackets(appPacket), ShouldBeNil)
+
+			netPacket, err := packet.New(0, appPacket.GetBytes(), "0")
+			So(err, ShouldBeNil)
+
+			Convey("Then I expect the datagram to be dropped", func() {
+				So(enforcer.processNetworkUDPPackets(netPacket), ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestPacketHandlingRateLimitedFlows(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and two processing units with a rate limited policy", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		records := []*collector.FlowRecord{}
+		mockCollector := mockcollector.NewMockEventCollector(ctrl)
+		mockCollector.EXPECT().CollectFlowEvent(gomock.Any()).Do(func(r *collector.FlowRecord) {
+			records = append(records, r)
+		}).AnyTimes()
+
+		flowPolicy := &policy.FlowPolicy{
+			Action:    policy.Accept | policy.RateLimit,
+			PolicyID:  "limited",
+			RateLimit: &policy.RateLimitParameters{ConnectionsPerSecond: 0.001, ConnectionBurst: 1},
+		}
+
+		enforcer, err := setupUDPProcessingUnits(mockCollector, flowPolicy)
+		So(err, ShouldBeNil)
+
+		Convey("When I open two flows within the burst of the policy", func() {
+
+			for _, sport := range []layers.UDPPort{5353, 5354} {
+				query, err := generateUDPDatagram("10.1.10.76", "10.1.10.77", sport, 53, []byte("query"))
+				So(err, ShouldBeNil)
+
+				appPacket, err := packet.New(0, query, "0")
+				So(err, ShouldBeNil)
+				So(enforcer.processApplicationUDPPackets(appPacket), ShouldBeNil)
+
+				netPacket, err := packet.New(0, appPacket.GetBytes(), "0")
+				So(err, ShouldBeNil)
+				err = enforcer.processNetworkUDPPackets(netPacket)
+				if sport == 5353 {
+					So(err, ShouldBeNil)
+				} else {
+					So(err, ShouldNotBeNil)
+				}
+			}
+
+			Convey("Then the second flow should be reported as dropped by the rate limit", func() {
+				dropped := []*collector.FlowRecord{}
+				for _, r := range records {
+					if r.DropReason == collector.RateLimitDrop {
+						dropped = append(dropped, r)
+					}
+				}
+				So(len(dropped), ShouldEqual, 1)
+				So(dropped[0].Source.Port, ShouldEqual, 5354)
+				So(dropped[0].PolicyID, ShouldEqual, "limited")
+				So(dropped[0].Action.Rejected(), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestPacketHandlingRateLimitPolicyUpdate(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and two processing units with a rate limited policy", t, func() {
+
+		limit := policy.RateLimitParameters{ConnectionsPerSecond: 0.001, ConnectionBurst: 1}
+		newPolicy := func(limit policy.RateLimitParameters) *policy.FlowPolicy {
+			return &policy.FlowPolicy{
+				Action:    policy.Accept | policy.RateLimit,
+				PolicyID:  "limited",
+				RateLimit: &limit,
+			}
+		}
+
+		enforcer, err := setupUDPProcessingUnits(&collector.DefaultCollector{}, newPolicy(limit))
+		So(err, ShouldBeNil)
+
+		updatePolicy := func(flowPolicy *policy.FlowPolicy) error {
+			puInfo := policy.NewPUInfo("udpPU2", common.ContainerPU)
+			puInfo.Runtime.SetIPAddresses(policy.ExtendedMap{"bridge": "10.1.10.77"})
+			puInfo.Policy.SetIPAddresses(policy.ExtendedMap{policy.DefaultNamespace: "10.1.10.77"})
+			puInfo.Policy.AddIdentityTag(enforcerconstants.TransmitterLabel, "value")
+			puInfo.Policy.AddReceiverRules(policy.TagSelector{
+				Clause: []policy.KeyValueOperator{
+					{
+						Key:      enforcerconstants.TransmitterLabel,
+						Value:    []string{"value"},
+						Operator: policy.Equal,
+					},
+				},
+				Policy: flowPolicy,
+			})
+			return enforcer.Enforce("udpPU2", puInfo)
+		}
+
+		openFlow := func(sport layers.UDPPort) error {
+			query, err := generateUDPDatagram("10.1.10.76", "10.1.10.77", sport, 53, []byte("query"))
+			So(err, ShouldBeNil)
+
+			appPacket, err := packet.New(0, query, "0")
+			So(err, ShouldBeNil)
+			So(enforcer.processApplicationUDPPackets(appPacket), ShouldBeNil)
+
+			netPacket, err := packet.New(0, appPacket.GetBytes(), "0")
+			So(err, ShouldBeNil)
+			return enforcer.processNetworkUDPPackets(netPacket)
+		}
+
+		Convey("When I open a flow and update the policy without changing the rate limit", func() {
+
+			So(openFlow(5353), ShouldBeNil)
+			So(updatePolicy(newPolicy(limit)), ShouldBeNil)
+
+			Convey("Then the rate state should be kept and the next flow should be dropped", func() {
+				So(openFlow(5354), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I open a flow and update the policy with a new rate limit", func() {
+
+			So(openFlow(5353), ShouldBeNil)
+			So(updatePolicy(newPolicy(policy.RateLimitParameters{ConnectionsPerSecond: 0.001, ConnectionBurst: 2})), ShouldBeNil)
+
+			Convey("Then the rate state should be reset and the next flow should be accepted", func() {
+				So(openFlow(5354), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestTCPConnectionAllowed(t *testing.T) {
+
+	Convey("Given a processing unit and a policy that allows one connection", t, func() {
+
+		context, err := pucontext.NewPU("SomePU", policy.NewPUInfo("SomePU", common.ContainerPU), 10*time.Second)
+		So(err, ShouldBeNil)
+
+		flowPolicy := &policy.FlowPolicy{
+			Action:    policy.Accept | policy.RateLimit,
+			PolicyID:  "limited",
+			RateLimit: &policy.RateLimitParameters{ConnectionsPerSecond: 0.001, ConnectionBurst: 1},
+		}
+
+		Convey("When the handshake of a connection is retransmitted", func() {
+
+			conn := connection.NewTCPConnection(context)
+			So(tcpConnectionAllowed(context, conn, flowPolicy), ShouldBeTrue)
+			So(tcpConnectionAllowed(context, conn, flowPolicy), ShouldBeTrue)
+
+			Convey("Then a new connection should still exceed the rate", func() {
+				So(tcpConnectionAllowed(context, connection.NewTCPConnection(context), flowPolicy), ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestPacketHandlingUDPExternalService(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and a processing unit with an application acl", t, func() {
+
+		appACLs := policy.IPRuleList{
+			{
+				Address:  "10.1.10.99/32",
+				Port:     "53",
+				Protocol: "udp",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "external"},
+			},
+		}
+
+		ips := policy.ExtendedMap{policy.DefaultNamespace: "10.1.10.76"}
+		puPolicy := policy.NewPUPolicy("udpPU1", policy.Police, appACLs, nil, nil, nil, nil, nil, ips, []string{}, []string{}, &policy.ProxiedServicesInfo{}, nil, nil, []string{})
+		puInfo := policy.PUInfoFromPolicyAndRuntime("udpPU1", puPolicy, policy.NewPURuntimeWithDefaults())
+		puInfo.Runtime.SetIPAddresses(policy.ExtendedMap{"bridge": "10.1.10.76"})
+
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		enforcer := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc")
+		So(enforcer.Enforce("udpPU1", puInfo), ShouldBeNil)
+
+		query, err := generateUDPDatagram("10.1.10.76", "10.1.10.99", 5353, 53, []byte("query"))
+		So(err, ShouldBeNil)
+
+		Convey("When I send a datagram to the external service", func() {
+
+			appPacket, err := packet.New(0, append([]byte{}, query...), "0")
+			So(err, ShouldBeNil)
+			So(enforcer.processApplicationUDPPackets(appPacket), ShouldBeNil)
+
+			Convey("Then I expect the datagram to be sent without a token", func() {
+				So(appPacket.GetBytes(), ShouldResemble, query)
+
+				conn, err := enforcer.udpAppOrigConnectionTracker.Get(appPacket.L4FlowHash())
+				So(err, ShouldBeNil)
+				So(conn.(*connection.UDPConnection).GetState(), ShouldEqual, connection.UDPData)
+			})
+		})
+	})
+}
+
+func TestUDPAuthenticationHeader(t *testing.T) {
+
+	Convey("Given I create a udp authentication header", t, func() {
+
+		header := createUDPAuthenticationHeader(enforcerconstants.UDPSynToken, []byte("token"))
+
+		Convey("Then I should be able to parse it back", func() {
+			tokenType, token, err := parseUDPAuthenticationHeader(append(header, []byte("payload")...))
+			So(err, ShouldBeNil)
+			So(tokenType, ShouldEqual, enforcerconstants.UDPSynToken)
+			So(string(token), ShouldEqual, "token")
+		})
+
+		Convey("Then a truncated header should fail to parse", func() {
+			_, _, err := parseUDPAuthenticationHeader(header[:len(header)-1])
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then a payload without the marker should fail to parse", func() {
+			_, _, err := parseUDPAuthenticationHeader([]byte("plain udp payload"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
 func TestPacketHandlingFirstThreePacketsHavePayload(t *testing.T) {
 
 	SIP := net.IPv4zero
@@ -1485,6 +1960,7 @@ func TestFlowReportingGoodFlow(t *testing.T) {
 							flowRecord.Action = policy.Accept
 
 							mockCollector.EXPECT().CollectFlowEvent(MyMatcher(&flowRecord)).Times(1)
+							mockCollector.EXPECT().CollectFlowEvent(MyMatcher(&collector.FlowRecord{Source: &srcEndPoint, Destination: &dstEndPoint, Action: policy.Accept, Termination: &collector.FlowTermination{Reason: collector.FlowClosedFin}})).Times(1)
 
 							puInfo1, puInfo2, enforcer, err1, err2, _, _ = setupProcessingUnitsInDatapathAndEnforce(mockCollector, false, "container")
 							So(puInfo1, ShouldNotBeNil)
@@ -1507,6 +1983,7 @@ func TestFlowReportingGoodFlow(t *testing.T) {
 							flowRecord.Action = policy.Accept
 
 							mockCollector.EXPECT().CollectFlowEvent(MyMatcher(&flowRecord)).Times(1)
+							mockCollector.EXPECT().CollectFlowEvent(MyMatcher(&collector.FlowRecord{Source: &srcEndPoint, Destination: &dstEndPoint, Action: policy.Accept, Termination: &collector.FlowTermination{Reason: collector.FlowClosedFin}})).Times(1)
 
 							puInfo1, puInfo2, enforcer, err1, err2, _, _ = setupProcessingUnitsInDatapathAndEnforce(mockCollector, false, "server")
 							So(puInfo1, ShouldNotBeNil)
@@ -2083,6 +2560,7 @@ func TestFlowReportingManyPacketsInFlow(t *testing.T) {
 							flowRecord.Action = policy.Accept
 
 							mockCollector.EXPECT().CollectFlowEvent(MyMatcher(&flowRecord)).Times(1)
+							mockCollector.EXPECT().CollectFlowEvent(MyMatcher(&collector.FlowRecord{Source: &srcEndPoint, Destination: &dstEndPoint, Action: policy.Accept, Termination: &collector.FlowTermination{Reason: collector.FlowClosedFin}})).Times(1)
 
 							puInfo1, puInfo2, enforcer, err1, err2, _, _ = setupProcessingUnitsInDatapathAndEnforce(mockCollector, false, "container")
 							So(puInfo1, ShouldNotBeNil)
@@ -2105,6 +2583,7 @@ func TestFlowReportingManyPacketsInFlow(t *testing.T) {
 							flowRecord.Action = policy.Accept
 
 							mockCollector.EXPECT().CollectFlowEvent(MyMatcher(&flowRecord)).Times(1)
+							mockCollector.EXPECT().CollectFlowEvent(MyMatcher(&collector.FlowRecord{Source: &srcEndPoint, Destination: &dstEndPoint, Action: policy.Accept, Termination: &collector.FlowTermination{Reason: collector.FlowClosedFin}})).Times(1)
 
 							puInfo1, puInfo2, enforcer, err1, err2, _, _ = setupProcessingUnitsInDatapathAndEnforce(mockCollector, false, "server")
 							So(puInfo1, ShouldNotBeNil)
@@ -2345,6 +2824,7 @@ func TestFlowReportingReplayAttack(t *testing.T) {
 							flowRecord.Action = policy.Accept
 
 							mockCollector.EXPECT().CollectFlowEvent(MyMatcher(&flowRecord)).Times(1)
+							mockCollector.EXPECT().CollectFlowEvent(MyMatcher(&collector.FlowRecord{Source: &srcEndPoint, Destination: &dstEndPoint, Action: policy.Accept, Termination: &collector.FlowTermination{Reason: collector.FlowClosedFin}})).Times(1)
 
 							puInfo1, puInfo2, enforcer, err1, err2, _, _ = setupProcessingUnitsInDatapathAndEnforce(mockCollector, false, "container")
 							So(puInfo1, ShouldNotBeNil)
@@ -2367,6 +2847,7 @@ func TestFlowReportingReplayAttack(t *testing.T) {
 							flowRecord.Action = policy.Accept
 
 							mockCollector.EXPECT().CollectFlowEvent(MyMatcher(&flowRecord)).Times(1)
+							mockCollector.EXPECT().CollectFlowEvent(MyMatcher(&collector.FlowRecord{Source: &srcEndPoint, Destination: &dstEndPoint, Action: policy.Accept, Termination: &collector.FlowTermination{Reason: collector.FlowClosedFin}})).Times(1)
 
 							puInfo1, puInfo2, enforcer, err1, err2, _, _ = setupProcessingUnitsInDatapathAndEnforce(mockCollector, false, "server")
 							So(puInfo1, ShouldNotBeNil)
@@ -2559,6 +3040,7 @@ func TestFlowReportingPacketDelays(t *testing.T) {
 							flowRecord.Action = policy.Accept
 
 							mockCollector.EXPECT().CollectFlowEvent(MyMatcher(&flowRecord)).Times(1)
+							mockCollector.EXPECT().CollectFlowEvent(MyMatcher(&collector.FlowRecord{Source: &srcEndPoint, Destination: &dstEndPoint, Action: policy.Accept, Termination: &collector.FlowTermination{Reason: collector.FlowClosedFin}})).Times(1)
 
 							puInfo1, puInfo2, enforcer, err1, err2, _, _ = setupProcessingUnitsInDatapathAndEnforce(mockCollector, false, "container")
 							So(puInfo1, ShouldNotBeNil)
@@ -2582,6 +3064,7 @@ func TestFlowReportingPacketDelays(t *testing.T) {
 							flowRecord.Action = policy.Accept
 
 							mockCollector.EXPECT().CollectFlowEvent(MyMatcher(&flowRecord)).Times(1)
+							mockCollector.EXPECT().CollectFlowEvent(MyMatcher(&collector.FlowRecord{Source: &srcEndPoint, Destination: &dstEndPoint, Action: policy.Accept, Termination: &collector.FlowTermination{Reason: collector.FlowClosedFin}})).Times(1)
 
 							puInfo1, puInfo2, enforcer, err1, err2, _, _ = setupProcessingUnitsInDatapathAndEnforce(mockCollector, false, "server")
 							So(puInfo1, ShouldNotBeNil)
@@ -0,0 +1,484 @@
+package nfqdatapath
+
+// Go libraries
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/controller/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/connection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
+)
+
+// UDP flows are authorized by carrying the identity tokens in front of the
+// payload of the first datagrams of each direction:
+//
+//   - The initiator attaches a Syn token to every datagram until it receives
+//     a datagram with a valid SynAck token from the responder.
+//   - The responder validates the Syn token against its receive rules, strips
+//     it and attaches a SynAck token to its replies until it receives a
+//     datagram without a token.
+//   - Once a side has authorized the flow, it releases it to the kernel by
+//     updating the conntrack mark.
+//
+// The header preceding the token is:
+//
+//   | marker (2) | type (1) | reserved (1) | token length (2) | token |
+
+// processNetworkUDPPackets processes UDP datagrams arriving from network and are destined to the application
+func (d *Datapath) processNetworkUDPPackets(p *packet.Packet) (err error) {
+
+	if d.packetLogs {
+		zap.L().Debug("Processing network udp packet ",
+			zap.String("flow", p.L4FlowHash()),
+		)
+
+		defer zap.L().Debug("Finished Processing network udp packet ",
+			zap.String("flow", p.L4FlowHash()),
+			zap.Error(err),
+		)
+	}
+
+	conn, err := d.netUDPRetrieveState(p)
+	if err != nil {
+		if d.packetLogs {
+			zap.L().Debug("Packet rejected",
+				zap.String("flow", p.L4FlowHash()),
+				zap.Error(err),
+			)
+		}
+		return err
+	}
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	p.Print(packet.PacketStageIncoming)
+
+	p.Print(packet.PacketStageAuth)
+
+	if err = d.processNetworkUDPPacket(p, conn.Context, conn); err != nil {
+		p.Print(packet.PacketFailureAuth)
+		if d.packetLogs {
+			zap.L().Debug("Rejecting packet ",
+				zap.String("flow", p.L4FlowHash()),
+				zap.Error(err),
+			)
+		}
+		return fmt.Errorf("packet processing failed for network udp packet: %s", err)
+	}
+
+	// Accept the packet
+	p.UpdateUDPChecksum()
+	p.Print(packet.PacketStageOutgoing)
+
+	return nil
+}
+
+// processApplicationUDPPackets processes UDP datagrams arriving from an application and are destined to the network
+func (d *Datapath) processApplicationUDPPackets(p *packet.Packet) (err error) {
+
+	if d.packetLogs {
+		zap.L().Debug("Processing application udp packet ",
+			zap.String("flow", p.L4FlowHash()),
+		)
+
+		defer zap.L().Debug("Finished Processing application udp packet ",
+			zap.String("flow", p.L4FlowHash()),
+			zap.Error(err),
+		)
+	}
+
+	conn, err := d.appUDPRetrieveState(p)
+	if err != nil {
+		if d.packetLogs {
+			zap.L().Debug("Packet rejected",
+				zap.String("flow", p.L4FlowHash()),
+				zap.Error(err),
+			)
+		}
+		return err
+	}
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	p.Print(packet.PacketStageIncoming)
+
+	p.Print(packet.PacketStageAuth)
+
+	if err = d.processApplicationUDPPacket(p, conn.Context, conn); err != nil {
+		p.Print(packet.PacketFailureAuth)
+		if d.packetLogs {
+			zap.L().Debug("Dropping packet  ",
+				zap.String("flow", p.L4FlowHash()),
+				zap.Error(err),
+			)
+		}
+		return fmt.Errorf("processing failed for application udp packet: %s", err)
+	}
+
+	// Accept the packet
+	p.UpdateUDPChecksum()
+	p.Print(packet.PacketStageOutgoing)
+
+	return nil
+}
+
+// processApplicationUDPPacket attaches our token to the datagram if the flow
+// is not authorized yet
+func (d *Datapath) processApplicationUDPPacket(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) error {
+
+	switch conn.GetState() {
+
+	case connection.UDPData:
+		return nil
+
+	case connection.UDPSynSend:
+		// This is a new flow initiated by the application
+		if conn.Token == nil {
+			// Destinations that are explicitly allowed by the ACLs are
+			// external services and they don't expect a token.
+			report, policy, perr := context.UDPApplicationACLPolicy(udpPacket)
+			if perr == nil {
+				if !policy.Action.Rejected() && !context.ConnectionAllowed(policy) {
+					d.reportExternalServiceFlow(context, collector.RateLimitDrop, rateLimitedFlowPolicy(report), rateLimitedFlowPolicy(policy), true, udpPacket)
+					return fmt.Errorf("udp flow dropped by rate limit of policy: %s", policy.PolicyID)
+				}
+
+				d.reportExternalServiceFlow(context, collector.PolicyDrop, report, policy, true, udpPacket)
+				if policy.Action.Rejected() {
+					return errors.New("udp flow rejected by application acls")
+				}
+
+				conn.SetState(connection.UDPData)
+				d.udpAppOrigConnectionTracker.AddOrUpdate(udpPacket.L4FlowHash(), conn)
+				d.releaseUDPFlow(udpPacket, false)
+				return nil
+			}
+
+			token, err := d.tokenAccessor.CreateSynPacketToken(context, &conn.Auth)
+			if err != nil {
+				return err
+			}
+
+			conn.Token = createUDPAuthenticationHeader(enforcerconstants.UDPSynToken, token)
+			d.udpAppOrigConnectionTracker.AddOrUpdate(udpPacket.L4FlowHash(), conn)
+		}
+
+	case connection.UDPSynReceived, connection.UDPSynAckSend:
+		// We are replying to an authorized flow initiated by the network
+		if conn.Token == nil {
+			token, err := d.tokenAccessor.CreateSynAckPacketToken(context, &conn.Auth)
+			if err != nil {
+				return err
+			}
+
+			conn.Token = createUDPAuthenticationHeader(enforcerconstants.UDPSynAckToken, token)
+		}
+
+		conn.SetState(connection.UDPSynAckSend)
+	}
+
+	return udpPacket.UDPDataAttach(conn.Token)
+}
+
+// processNetworkUDPPacket validates the tokens carried by a datagram arriving from the network
+func (d *Datapath) processNetworkUDPPacket(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) error {
+
+	tokenType, token, err := parseUDPAuthenticationHeader(udpPacket.ReadUDPData())
+	if err != nil {
+		return d.processNetworkUDPPacketWithoutToken(udpPacket, context, conn)
+	}
+
+	switch tokenType {
+	case enforcerconstants.UDPSynToken:
+		if err := d.processNetworkUDPSynPacket(udpPacket, context, conn, token); err != nil {
+			return err
+		}
+	case enforcerconstants.UDPSynAckToken:
+		if err := d.processNetworkUDPSynAckPacket(udpPacket, context, conn, token); err != nil {
+			return err
+		}
+	default:
+		d.reportUDPRejectedFlow(udpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidFormat, nil, nil)
+		return fmt.Errorf("unknown udp token type: %d", tokenType)
+	}
+
+	// Remove our data from the datagram before it reaches the application
+	if err := udpPacket.UDPDataDetach(uint16(enforcerconstants.UDPAuthHeaderLen + len(token))); err != nil {
+		d.reportUDPRejectedFlow(udpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidFormat, nil, nil)
+		return fmt.Errorf("udp packet dropped because of invalid format: %s", err)
+	}
+
+	return nil
+}
+
+// processNetworkUDPSynPacket validates the token of the initiator of a flow
+// against the receive rules
+func (d *Datapath) processNetworkUDPSynPacket(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection, token []byte) error {
+
+	// Retransmissions carry the same token and have already been authorized
+	if conn.RemoteToken != nil && bytes.Equal(conn.RemoteToken, token) {
+		return nil
+	}
+
+	claims, err := d.tokenAccessor.ParsePacketToken(&conn.Auth, token)
+	if err != nil {
+		d.reportUDPRejectedFlow(udpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, tokenErrorReason(err, collector.InvalidToken), nil, nil)
+		return fmt.Errorf("udp packet dropped because of invalid token: %s", err)
+	}
+
+	if claims == nil {
+		d.reportUDPRejectedFlow(udpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, nil, nil)
+		return errors.New("udp packet dropped because of no claims")
+	}
+
+	txLabel, _ := claims.T.Get(enforcerconstants.TransmitterLabel)
+
+	// Add the port as a label with an @ prefix. These labels are invalid otherwise
+	// If all policies are restricted by port numbers this will allow port-specific policies
+	claims.T.AppendKeyValue(enforcerconstants.PortNumberLabelString, strconv.Itoa(int(udpPacket.DestinationPort)))
+
+	report, packet := context.SearchRcvRules(claims.T)
+	if packet.Action.Rejected() {
+		d.reportUDPRejectedFlow(udpPacket, conn, txLabel, context.ManagementID(), context, collector.PolicyDrop, report, packet)
+		return fmt.Errorf("udp flow rejected because of policy: %s", claims.T.String())
+	}
+
+	// Only the first token of a flow counts as a new connection
+	if conn.GetState() == connection.UDPSynSend && !context.ConnectionAllowed(packet) {
+		d.reportUDPRejectedFlow(udpPacket, conn, txLabel, context.ManagementID(), context, collector.RateLimitDrop, rateLimitedFlowPolicy(report), rateLimitedFlowPolicy(packet))
+		return fmt.Errorf("udp flow dropped by rate limit of policy: %s", packet.PolicyID)
+	}
+
+	// The nonce is remembered only once the flow is accepted. The initiator
+	// retransmits the same token after a rejection.
+	if err := d.tokenAccessor.RecordPacketToken(&conn.Auth); err != nil {
+		d.reportUDPRejectedFlow(udpPacket, conn, txLabel, context.ManagementID(), context, tokenErrorReason(err, collector.InvalidToken), nil, nil)
+		return fmt.Errorf("udp packet dropped because of invalid token: %s", err)
+	}
+
+	// A new token from the initiator carries a new nonce and our reply token
+	// must be regenerated.
+	conn.RemoteToken = append([]byte{}, token...)
+	conn.Token = nil
+	conn.ReportFlowPolicy = report
+	conn.PacketFlowPolicy = packet
+	conn.SetState(connection.UDPSynReceived)
+
+	d.udpNetOrigConnectionTracker.AddOrUpdate(udpPacket.L4FlowHash(), conn)
+
+	d.reportUDPAcceptedFlow(udpPacket, conn, txLabel, context.ManagementID(), context, report, packet)
+
+	return nil
+}
+
+// processNetworkUDPSynAckPacket validates the token of the responder of a flow
+// initiated by our application
+func (d *Datapath) processNetworkUDPSynAckPacket(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection, token []byte) error {
+
+	// Replies that were queued before the flow was released
+	if conn.GetState() == connection.UDPData && bytes.Equal(conn.RemoteToken, token) {
+		return nil
+	}
+
+	if conn.GetState() != connection.UDPSynSend || conn.Token == nil {
+		d.reportUDPRejectedFlow(udpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidState, nil, nil)
+		return fmt.Errorf("udp reply token received in the wrong state: %d", conn.GetState())
+	}
+
+	claims, err := d.tokenAccessor.ParsePacketToken(&conn.Auth, token)
+	if err != nil {
+		d.reportUDPRejectedFlow(udpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, tokenErrorReason(err, collector.InvalidToken), nil, nil)
+		return fmt.Errorf("udp reply dropped because of bad claims: %s", err)
+	}
+
+	if claims == nil {
+		d.reportUDPRejectedFlow(udpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, nil, nil)
+		return errors.New("udp reply dropped because of no claims")
+	}
+
+	// The reply must be bound to the nonce we sent in our token
+	if !bytes.Equal(claims.RMT, conn.Auth.LocalContext) {
+		d.reportUDPRejectedFlow(udpPacket, conn, context.ManagementID(), conn.Auth.RemoteContextID, context, collector.InvalidToken, nil, nil)
+		return errors.New("failed to match context in udp reply")
+	}
+
+	if d.mutualAuthorization {
+		report, packet := context.SearchTxtRules(claims.T, !d.mutualAuthorization)
+		if packet.Action.Rejected() {
+			d.reportUDPRejectedFlow(udpPacket, conn, context.ManagementID(), conn.Auth.RemoteContextID, context, collector.PolicyDrop, report, packet)
+			return fmt.Errorf("dropping udp flow because of reject rule on transmitter: %s", claims.T.String())
+		}
+
+		if !context.ConnectionAllowed(packet) {
+			d.reportUDPRejectedFlow(udpPacket, conn, context.ManagementID(), conn.Auth.RemoteContextID, context, collector.RateLimitDrop, rateLimitedFlowPolicy(report), rateLimitedFlowPolicy(packet))
+			return fmt.Errorf("dropping udp flow because of rate limit on transmitter: %s", packet.PolicyID)
+		}
+
+		conn.ReportFlowPolicy = report
+		conn.PacketFlowPolicy = packet
+	}
+
+	if err := d.tokenAccessor.RecordPacketToken(&conn.Auth); err != nil {
+		d.reportUDPRejectedFlow(udpPacket, conn, context.ManagementID(), conn.Auth.RemoteContextID, context, tokenErrorReason(err, collector.InvalidToken), nil, nil)
+		return fmt.Errorf("udp reply dropped because of bad claims: %s", err)
+	}
+
+	conn.RemoteToken = append([]byte{}, token...)
+	conn.SetState(connection.UDPData)
+
+	// The flow is authorized in both directions. Release it to the kernel.
+	d.releaseUDPFlow(udpPacket, true)
+
+	return nil
+}
+
+// processNetworkUDPPacketWithoutToken processes datagrams that don't carry a token
+func (d *Datapath) processNetworkUDPPacketWithoutToken(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) error {
+
+	switch conn.GetState() {
+
+	case connection.UDPData:
+		return nil
+
+	case connection.UDPSynReceived, connection.UDPSynAckSend:
+		// The initiator stopped sending tokens, which means that it has
+		// authorized our replies. Release the flow to the kernel.
+		conn.SetState(connection.UDPData)
+		d.releaseUDPFlow(udpPacket, false)
+		return nil
+	}
+
+	// Our application initiated the flow and the peer didn't reply with a token
+	if conn.Token != nil {
+		d.reportUDPRejectedFlow(udpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.MissingToken, nil, nil)
+		return errors.New("udp reply dropped because of missing token")
+	}
+
+	// New flows without a token are candidates to be processed as external services
+	report, packet, perr := context.UDPNetworkACLPolicy(udpPacket)
+	if perr == nil && !packet.Action.Rejected() && !context.ConnectionAllowed(packet) {
+		d.reportExternalServiceFlow(context, collector.RateLimitDrop, rateLimitedFlowPolicy(report), rateLimitedFlowPolicy(packet), false, udpPacket)
+		return fmt.Errorf("udp flow dropped by rate limit of policy: %s", packet.PolicyID)
+	}
+
+	d.reportExternalServiceFlow(context, collector.PolicyDrop, report, packet, false, udpPacket)
+	if perr != nil || packet.Action.Rejected() {
+		return fmt.Errorf("no auth or acls: udp packet dropped: %s", perr)
+	}
+
+	conn.SetState(connection.UDPData)
+	d.udpNetOrigConnectionTracker.AddOrUpdate(udpPacket.L4FlowHash(), conn)
+	d.releaseUDPFlow(udpPacket, false)
+
+	return nil
+}
+
+// appUDPRetrieveState retrieves the state of an application datagram. It
+// creates a new flow if none is found
+func (d *Datapath) appUDPRetrieveState(p *packet.Packet) (*connection.UDPConnection, error) {
+
+	if conn, err := d.udpNetOrigConnectionTracker.GetReset(p.L4ReverseFlowHash(), 0); err == nil {
+		return conn.(*connection.UDPConnection), nil
+	}
+
+	if conn, err := d.udpAppOrigConnectionTracker.GetReset(p.L4FlowHash(), 0); err == nil {
+		return conn.(*connection.UDPConnection), nil
+	}
+
+	context, err := d.contextFromIP(true, p.SourceAddress.String(), p.Mark, p.SourcePort)
+	if err != nil {
+		return nil, errors.New("no context in app udp processing")
+	}
+
+	return connection.NewUDPConnection(context), nil
+}
+
+// netUDPRetrieveState retrieves the state of a network datagram. It
+// creates a new flow if none is found
+func (d *Datapath) netUDPRetrieveState(p *packet.Packet) (*connection.UDPConnection, error) {
+
+	if conn, err := d.udpAppOrigConnectionTracker.GetReset(p.L4ReverseFlowHash(), 0); err == nil {
+		return conn.(*connection.UDPConnection), nil
+	}
+
+	if conn, err := d.udpNetOrigConnectionTracker.GetReset(p.L4FlowHash(), 0); err == nil {
+		return conn.(*connection.UDPConnection), nil
+	}
+
+	context, err := d.contextFromIP(false, p.DestinationAddress.String(), p.Mark, p.DestinationPort)
+	if err != nil {
+		return nil, errors.New("no context in net udp processing")
+	}
+
+	return connection.NewUDPConnection(context), nil
+}
+
+// releaseUDPFlow updates the conntrack mark of the flow so that subsequent
+// datagrams bypass the datapath. reply indicates that the packet travels in
+// the opposite direction of the flow originator.
+func (d *Datapath) releaseUDPFlow(udpPacket *packet.Packet, reply bool) {
+
+	srcIP, dstIP := udpPacket.SourceAddress.String(), udpPacket.DestinationAddress.String()
+	srcPort, dstPort := udpPacket.SourcePort, udpPacket.DestinationPort
+
+	if reply {
+		srcIP, dstIP = dstIP, srcIP
+		srcPort, dstPort = dstPort, srcPort
+	}
+
+	if err := d.conntrackHdl.ConntrackTableUpdateMark(
+		srcIP,
+		dstIP,
+		udpPacket.IPProto,
+		srcPort,
+		dstPort,
+		constants.DefaultConnMark,
+	); err != nil {
+		zap.L().Error("Failed to update conntrack table for udp flow",
+			zap.String("flow", udpPacket.L4FlowHash()),
+			zap.Error(err),
+		)
+	}
+}
+
+// createUDPAuthenticationHeader creates the header carrying a token in a UDP datagram
+func createUDPAuthenticationHeader(tokenType uint8, token []byte) []byte {
+
+	header := make([]byte, enforcerconstants.UDPAuthHeaderLen, enforcerconstants.UDPAuthHeaderLen+len(token))
+	binary.BigEndian.PutUint16(header[0:2], enforcerconstants.UDPAuthMarker)
+	header[2] = tokenType
+	binary.BigEndian.PutUint16(header[4:6], uint16(len(token)))
+
+	return append(header, token...)
+}
+
+// parseUDPAuthenticationHeader returns the type and the token carried in a
+// UDP payload. It returns an error if the payload doesn't carry a token
+func parseUDPAuthenticationHeader(data []byte) (uint8, []byte, error) {
+
+	if len(data) < enforcerconstants.UDPAuthHeaderLen {
+		return 0, nil, errors.New("udp payload too small for a token")
+	}
+
+	if binary.BigEndian.Uint16(data[0:2]) != enforcerconstants.UDPAuthMarker {
+		return 0, nil, errors.New("udp authentication marker not found")
+	}
+
+	tokenLen := int(binary.BigEndian.Uint16(data[4:6]))
+	if tokenLen == 0 || len(data) < enforcerconstants.UDPAuthHeaderLen+tokenLen {
+		return 0, nil, fmt.Errorf("invalid udp token length: %d", tokenLen)
+	}
+
+	return data[2], data[enforcerconstants.UDPAuthHeaderLen : enforcerconstants.UDPAuthHeaderLen+tokenLen], nil
+}
@@ -0,0 +1,129 @@
+package nfqdatapath
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/connection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/encryption"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+)
+
+// Connections whose policy requires encryption are not released to the kernel
+// after the handshake. The payload of their segments is encrypted in place by
+// the application path and decrypted by the network path. The authentication
+// tag of a segment is carried by a TCP option appended to its header, so that
+// the payload length and the sequence numbers are left untouched. The MSS
+// announced by the handshake is decreased by the length of the option and
+// SACK is disabled to keep room for it in the header.
+//
+// The client announces its ephemeral key in every syn packet. The server
+// answers with its own key only when its policy requires encryption and both
+// sides derive the keys of the connection from them and from the nonces of
+// the handshake.
+
+// startEncryption prepares the server side of a connection for encryption
+// when its policy requires it. The syn packet is updated so that the server
+// does not send segments too large for the encryption option.
+func (d *Datapath) startEncryption(tcpPacket *packet.Packet, conn *connection.TCPConnection) error {
+
+	if len(conn.Auth.RemoteServiceContext) == 0 {
+		return errors.New("encryption required by policy but not supported by the client")
+	}
+
+	key, err := encryption.NewEphemeralKey()
+	if err != nil {
+		return err
+	}
+
+	conn.EphemeralKey = key
+	conn.Auth.LocalServiceContext = key.Public
+
+	tcpPacket.DecreaseTCPMss(enforcerconstants.TCPEncryptionOptionLen)
+	tcpPacket.DisableTCPSack()
+
+	return nil
+}
+
+// createSession derives the keys of an encrypted connection once both nonces
+// of the handshake are known. Encrypted connections are kept in the caches
+// as long as they are used since the datapath processes all their packets.
+func (d *Datapath) createSession(conn *connection.TCPConnection, initiator bool) (err error) {
+
+	if initiator {
+		conn.Session, err = encryption.NewSession(conn.EphemeralKey, conn.Auth.RemoteServiceContext, conn.Auth.LocalContext, conn.Auth.RemoteContext, true)
+	} else {
+		conn.Session, err = encryption.NewSession(conn.EphemeralKey, conn.Auth.RemoteServiceContext, conn.Auth.RemoteContext, conn.Auth.LocalContext, false)
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to create encryption session: %s", err)
+	}
+
+	conn.TimeOut = enforcerconstants.EncryptedConnectionTimeout
+
+	return nil
+}
+
+// encryptTCPPayload encrypts the payload of an application packet of an
+// encrypted connection and attaches the encryption option.
+func (d *Datapath) encryptTCPPayload(tcpPacket *packet.Packet, conn *connection.TCPConnection) error {
+
+	if conn.Session == nil || tcpPacket.IsEmptyTCPPayload() {
+		return nil
+	}
+
+	if tcpPacket.TCPOptionSpace() < enforcerconstants.TCPEncryptionOptionLen {
+		return fmt.Errorf("no space for the encryption option: space=%d", tcpPacket.TCPOptionSpace())
+	}
+
+	payload := append([]byte{}, tcpPacket.ReadTCPData()...)
+
+	if err := tcpPacket.TCPDataDetach(0); err != nil {
+		return fmt.Errorf("unable to detach payload: %s", err)
+	}
+
+	tcpPacket.DropDetachedBytes()
+
+	tag := conn.Session.Seal(tcpPacket.TCPSeq, payload)
+
+	return tcpPacket.TCPDataAttach(d.createTCPEncryptionOption(tag), payload)
+}
+
+// decryptTCPPayload authenticates and decrypts the payload of a network
+// packet of an encrypted connection and removes the encryption option.
+func (d *Datapath) decryptTCPPayload(tcpPacket *packet.Packet, conn *connection.TCPConnection) error {
+
+	if conn.Session == nil || tcpPacket.IsEmptyTCPPayload() {
+		return nil
+	}
+
+	if err := tcpPacket.CheckTCPEncryptionOption(enforcerconstants.TCPEncryptionOptionLen); err != nil {
+		return fmt.Errorf("clear text segment on encrypted connection: %s", err)
+	}
+
+	if err := tcpPacket.TCPDataDetach(enforcerconstants.TCPEncryptionOptionLen); err != nil {
+		return fmt.Errorf("unable to detach payload: %s", err)
+	}
+
+	options := tcpPacket.GetTCPOptions()
+	tag := append([]byte{}, options[len(options)-encryption.TagSize:]...)
+	payload := append([]byte{}, tcpPacket.GetTCPData()...)
+
+	tcpPacket.DropDetachedBytes()
+
+	if err := conn.Session.Open(tcpPacket.TCPSeq, payload, tag); err != nil {
+		return err
+	}
+
+	return tcpPacket.TCPDataAttach([]byte{}, payload)
+}
+
+// createTCPEncryptionOption creates the TCP option carrying the tag of a segment
+func (d *Datapath) createTCPEncryptionOption(tag []byte) []byte {
+
+	options := []byte{packet.TCPEncryptionOption, enforcerconstants.TCPEncryptionOptionLen, 0, 0}
+
+	return append(options, tag...)
+}
@@ -0,0 +1,214 @@
+package nfqdatapath
+
+import (
+	"net"
+	"sort"
+	"strconv"
+
+	enforcerconstants "github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/connection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/cache"
+)
+
+// ListPUs returns the state of the PUs enforced by the datapath with the
+// counters of the detection of the replayed tokens.
+func (d *Datapath) ListPUs() ([]*introspection.PU, error) {
+
+	pus := []*introspection.PU{}
+
+	stats := d.ReplayStatistics()
+	replays := &introspection.Replays{
+		Checked:  stats.Checked,
+		Replayed: stats.Replayed,
+		Evicted:  stats.Evicted,
+		Size:     stats.Size,
+	}
+
+	for _, contextID := range d.puFromContextID.KeyList() {
+		// The PU may have been removed since the keys were listed
+		item, err := d.puFromContextID.Get(contextID)
+		if err != nil {
+			continue
+		}
+
+		pu, ok := item.(*pucontext.PUContext)
+		if !ok {
+			continue
+		}
+
+		state := pu.Introspect()
+		state.Replays = replays
+		pus = append(pus, state)
+	}
+
+	sort.Slice(pus, func(i, j int) bool {
+		return pus[i].ContextID < pus[j].ContextID
+	})
+
+	return pus, nil
+}
+
+// ListFlows returns the flows tracked by the datapath for the PU with the
+// given context ID or for all the PUs if it is empty. Only the trackers of
+// the originating direction are listed since the reply trackers hold the
+// same connections.
+func (d *Datapath) ListFlows(contextID string) ([]*introspection.Flow, error) {
+
+	flows := []*introspection.Flow{}
+	flows = append(flows, tcpFlows(d.appOrigConnectionTracker, introspection.ApplicationOriginated, contextID)...)
+	flows = append(flows, tcpFlows(d.netOrigConnectionTracker, introspection.NetworkOriginated, contextID)...)
+	flows = append(flows, udpFlows(d.udpAppOrigConnectionTracker, introspection.ApplicationOriginated, contextID)...)
+	flows = append(flows, udpFlows(d.udpNetOrigConnectionTracker, introspection.NetworkOriginated, contextID)...)
+
+	sort.Slice(flows, func(i, j int) bool {
+		if flows[i].ContextID != flows[j].ContextID {
+			return flows[i].ContextID < flows[j].ContextID
+		}
+		return flows[i].Flow < flows[j].Flow
+	})
+
+	return flows, nil
+}
+
+// Simulate evaluates a flow against the policy of a PU with the same
+// lookups as the datapath. Rate limits are not evaluated since they depend
+// on the traffic. It returns nil if the PU is not enforced by the datapath.
+func (d *Datapath) Simulate(query *introspection.Query) (*introspection.Decision, error) {
+
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	item, err := d.puFromContextID.Get(query.ContextID)
+	if err != nil {
+		return nil, nil
+	}
+
+	context, ok := item.(*pucontext.PUContext)
+	if !ok {
+		return nil, nil
+	}
+
+	networkOriginated := query.Direction == introspection.NetworkOriginated
+
+	// External endpoints are only matched by the ACLs
+	if len(query.Tags) == 0 {
+		protocol := uint8(packet.IPProtocolTCP)
+		if query.Protocol == introspection.UDP {
+			protocol = packet.IPProtocolUDP
+		}
+
+		report, packet, _ := context.LookupACLPolicy(protocol, networkOriginated, net.ParseIP(query.Address), query.Port)
+
+		decision := introspection.NewDecision(query.ContextID, report, packet)
+		decision.ACL = true
+
+		return decision, nil
+	}
+
+	tags := policy.NewTagStoreFromSlice(query.Tags).Copy()
+
+	if networkOriginated {
+		// The datapath adds the port to the claims of the peer
+		tags.AppendKeyValue(enforcerconstants.PortNumberLabelString, strconv.Itoa(int(query.Port)))
+		report, packet := context.SearchRcvRules(tags)
+
+		return introspection.NewDecision(query.ContextID, report, packet), nil
+	}
+
+	// The transmitter rules are only evaluated with mutual authorization.
+	// Without it the flow is accepted by the PU and only the receiver
+	// decides.
+	if !d.mutualAuthorization {
+		accept := &policy.FlowPolicy{Action: policy.Accept}
+		return introspection.NewDecision(query.ContextID, accept, accept), nil
+	}
+
+	report, packet := context.SearchTxtRules(tags, !d.mutualAuthorization)
+
+	return introspection.NewDecision(query.ContextID, report, packet), nil
+}
+
+// tcpFlows returns the TCP connections of a tracker that belong to the PU
+// with the given context ID.
+func tcpFlows(tracker cache.DataStore, direction string, contextID string) []*introspection.Flow {
+
+	flows := []*introspection.Flow{}
+
+	for _, hash := range tracker.KeyList() {
+		item, err := tracker.Get(hash)
+		if err != nil {
+			continue
+		}
+
+		conn, ok := item.(*connection.TCPConnection)
+		if !ok {
+			continue
+		}
+
+		conn.Lock()
+		flow := &introspection.Flow{
+			Protocol:          introspection.TCP,
+			Direction:         direction,
+			Flow:              hash.(string),
+			State:             conn.GetState().String(),
+			ServiceConnection: conn.ServiceConnection,
+			Encrypted:         conn.Session != nil,
+		}
+		if conn.Context != nil {
+			flow.ContextID = conn.Context.ID()
+		}
+		conn.Unlock()
+
+		if contextID != "" && flow.ContextID != contextID {
+			continue
+		}
+
+		flows = append(flows, flow)
+	}
+
+	return flows
+}
+
+// udpFlows returns the UDP flows of a tracker that belong to the PU with the
+// given context ID.
+func udpFlows(tracker cache.DataStore, direction string, contextID string) []*introspection.Flow {
+
+	flows := []*introspection.Flow{}
+
+	for _, hash := range tracker.KeyList() {
+		item, err := tracker.Get(hash)
+		if err != nil {
+			continue
+		}
+
+		conn, ok := item.(*connection.UDPConnection)
+		if !ok {
+			continue
+		}
+
+		conn.Lock()
+		flow := &introspection.Flow{
+			Protocol:  introspection.UDP,
+			Direction: direction,
+			Flow:      hash.(string),
+			State:     conn.GetState().String(),
+		}
+		if conn.Context != nil {
+			flow.ContextID = conn.Context.ID()
+		}
+		conn.Unlock()
+
+		if contextID != "" && flow.ContextID != contextID {
+			continue
+		}
+
+		flows = append(flows, flow)
+	}
+
+	return flows
+}
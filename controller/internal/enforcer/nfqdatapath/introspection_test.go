@@ -0,0 +1,194 @@
+package nfqdatapath
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIntrospection(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and two processing units that accept each other", t, func() {
+
+		enforcer, err := setupUDPProcessingUnits(&collector.DefaultCollector{}, &policy.FlowPolicy{Action: policy.Accept, PolicyID: "introspected"})
+		So(err, ShouldBeNil)
+
+		Convey("When I list the processing units", func() {
+
+			pus, err := enforcer.ListPUs()
+
+			Convey("Then I should get their identity and compiled rules", func() {
+				So(err, ShouldBeNil)
+				So(len(pus), ShouldEqual, 2)
+				So(pus[0].ContextID, ShouldEqual, "udpPU1")
+				So(pus[1].ContextID, ShouldEqual, "udpPU2")
+				So(pus[0].Identity, ShouldContain, "AporetoContextID=value")
+				So(len(pus[0].Receiver.Accept), ShouldEqual, 1)
+				So(pus[0].Receiver.Accept[0].Policy.PolicyID, ShouldEqual, "introspected")
+				So(pus[0].Receiver.Reject, ShouldBeEmpty)
+				So(pus[0].Transmitter.Accept, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a flow is processed on both paths", func() {
+
+			query, err := generateUDPDatagram("10.1.10.76", "10.1.10.77", 5353, 53, []byte("query"))
+			So(err, ShouldBeNil)
+
+			appPacket, err := packet.New(0, query, "0")
+			So(err, ShouldBeNil)
+			So(enforcer.processApplicationUDPPackets(appPacket), ShouldBeNil)
+
+			netPacket, err := packet.New(0, appPacket.GetBytes(), "0")
+			So(err, ShouldBeNil)
+			So(enforcer.processNetworkUDPPackets(netPacket), ShouldBeNil)
+
+			flows, err := enforcer.ListFlows("")
+			So(err, ShouldBeNil)
+
+			Convey("Then I should get the flow as tracked by each path", func() {
+				So(len(flows), ShouldEqual, 2)
+
+				directions := []string{}
+				for _, f := range flows {
+					So(f.Protocol, ShouldEqual, "udp")
+					So(f.Flow, ShouldEqual, "10.1.10.76:10.1.10.77:5353:53")
+					directions = append(directions, f.Direction)
+				}
+				So(directions, ShouldContain, introspection.ApplicationOriginated)
+				So(directions, ShouldContain, introspection.NetworkOriginated)
+			})
+
+			Convey("Then the processing units should report the replay counters of the enforcer", func() {
+				pus, err := enforcer.ListPUs()
+				So(err, ShouldBeNil)
+				So(len(pus), ShouldEqual, 2)
+				for _, pu := range pus {
+					So(pu.Replays, ShouldResemble, &introspection.Replays{Checked: 1, Size: 1})
+				}
+			})
+
+			Convey("Then I should get no flows for an unknown processing unit", func() {
+				list, err := enforcer.ListFlows("unknown")
+				So(err, ShouldBeNil)
+				So(list, ShouldBeEmpty)
+			})
+
+			Convey("Then I should get the flows of a processing unit", func() {
+				list, err := enforcer.ListFlows(flows[0].ContextID)
+				So(err, ShouldBeNil)
+				So(len(list), ShouldBeGreaterThan, 0)
+				for _, f := range list {
+					So(f.ContextID, ShouldEqual, flows[0].ContextID)
+				}
+			})
+		})
+
+		Convey("When I simulate a flow from a processing unit that is accepted by the receiver rules", func() {
+
+			decision, err := enforcer.Simulate(&introspection.Query{
+				ContextID: "udpPU2",
+				Direction: introspection.NetworkOriginated,
+				Tags:      []string{"AporetoContextID=value"},
+				Port:      53,
+			})
+
+			Convey("Then the flow should be allowed by the matching policy", func() {
+				So(err, ShouldBeNil)
+				So(decision.ContextID, ShouldEqual, "udpPU2")
+				So(decision.Allowed, ShouldBeTrue)
+				So(decision.PolicyID, ShouldEqual, "introspected")
+				So(decision.Report.PolicyID, ShouldEqual, "introspected")
+				So(decision.Observed, ShouldBeFalse)
+				So(decision.ACL, ShouldBeFalse)
+			})
+		})
+
+		Convey("When I simulate a flow from a processing unit that matches no receiver rule", func() {
+
+			decision, err := enforcer.Simulate(&introspection.Query{
+				ContextID: "udpPU2",
+				Direction: introspection.NetworkOriginated,
+				Tags:      []string{"AporetoContextID=other"},
+				Port:      53,
+			})
+
+			Convey("Then the flow should be rejected by default", func() {
+				So(err, ShouldBeNil)
+				So(decision.Allowed, ShouldBeFalse)
+				So(decision.PolicyID, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I simulate a flow to a processing unit", func() {
+
+			query := &introspection.Query{
+				ContextID: "udpPU1",
+				Direction: introspection.ApplicationOriginated,
+				Tags:      []string{"AporetoContextID=value"},
+				Port:      53,
+			}
+
+			Convey("Then the flow should be allowed without mutual authorization", func() {
+				decision, err := enforcer.Simulate(query)
+				So(err, ShouldBeNil)
+				So(decision.Allowed, ShouldBeTrue)
+			})
+
+			Convey("Then the flow should be rejected by the transmitter rules with mutual authorization", func() {
+				enforcer.mutualAuthorization = true
+				decision, err := enforcer.Simulate(query)
+				So(err, ShouldBeNil)
+				So(decision.Allowed, ShouldBeFalse)
+			})
+		})
+
+		Convey("When I simulate a flow from an external address", func() {
+
+			decision, err := enforcer.Simulate(&introspection.Query{
+				ContextID: "udpPU1",
+				Direction: introspection.NetworkOriginated,
+				Protocol:  introspection.UDP,
+				Address:   "192.168.1.1",
+				Port:      53,
+			})
+
+			Convey("Then the flow should be rejected by the default ACL", func() {
+				So(err, ShouldBeNil)
+				So(decision.ACL, ShouldBeTrue)
+				So(decision.Allowed, ShouldBeFalse)
+				So(decision.PolicyID, ShouldEqual, "default")
+			})
+		})
+
+		Convey("When I simulate a flow of an unknown processing unit", func() {
+
+			decision, err := enforcer.Simulate(&introspection.Query{
+				ContextID: "unknown",
+				Direction: introspection.NetworkOriginated,
+				Tags:      []string{"AporetoContextID=value"},
+			})
+
+			Convey("Then I should get no decision", func() {
+				So(err, ShouldBeNil)
+				So(decision, ShouldBeNil)
+			})
+		})
+
+		Convey("When I simulate an invalid flow", func() {
+
+			_, err := enforcer.Simulate(&introspection.Query{
+				ContextID: "udpPU1",
+				Direction: introspection.NetworkOriginated,
+			})
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -5,7 +5,6 @@ package nfqdatapath
 // Go libraries
 import (
 	"context"
-	"fmt"
 	"strconv"
 	"time"
 
@@ -78,10 +77,8 @@ func (d *Datapath) processNetworkPacketsFromNFQ(p *nfqueue.NFPacket) {
 
 	if err != nil {
 		netPacket.Print(packet.PacketFailureCreate)
-	} else if netPacket.IPProto == packet.IPProtocolTCP {
-		err = d.processNetworkTCPPackets(netPacket)
 	} else {
-		err = fmt.Errorf("invalid ip protocol: %d", netPacket.IPProto)
+		err = d.ProcessNetworkPacket(netPacket)
 	}
 	if err != nil {
 		length := uint32(len(p.Buffer))
@@ -112,10 +109,8 @@ func (d *Datapath) processApplicationPacketsFromNFQ(p *nfqueue.NFPacket) {
 
 	if err != nil {
 		appPacket.Print(packet.PacketFailureCreate)
-	} else if appPacket.IPProto == packet.IPProtocolTCP {
-		err = d.processApplicationTCPPackets(appPacket)
 	} else {
-		err = fmt.Errorf("invalid ip protocol: %d", appPacket.IPProto)
+		err = d.ProcessApplicationPacket(appPacket)
 	}
 
 	if err != nil {
@@ -0,0 +1,86 @@
+package replay
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+)
+
+// WriteResults writes the results of a replay in a readable form, one block per
+// packet.
+func WriteResults(w io.Writer, results []*Result) error {
+
+	for _, result := range results {
+		if _, err := fmt.Fprintf(w, "#%d %s %s %s\n", result.Index, result.Timestamp.Format(time.RFC3339Nano), result.Flow, result.Flags); err != nil {
+			return err
+		}
+
+		lines := []string{}
+
+		if len(result.Verdicts) == 0 {
+			lines = append(lines, "skipped: no processing unit")
+		}
+
+		for _, v := range result.Verdicts {
+			if v.Accept {
+				lines = append(lines, fmt.Sprintf("%s: accept (%d bytes)", v.Path, len(v.Buffer)))
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%s: drop: %s", v.Path, v.Error))
+		}
+
+		for _, t := range result.Transitions {
+			lines = append(lines, fmt.Sprintf("state %s %s: %s -> %s", t.Direction, t.Flow, stateString(t.From), stateString(t.To)))
+		}
+
+		for _, r := range result.Records {
+			lines = append(lines, "record "+recordString(r))
+		}
+
+		for _, f := range result.Released {
+			lines = append(lines, "released "+f)
+		}
+
+		for _, line := range lines {
+			if _, err := fmt.Fprintf(w, "  %s\n", line); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// stateString returns the state of a flow or none if it is not tracked.
+func stateString(state string) string {
+
+	if state == "" {
+		return "none"
+	}
+
+	return state
+}
+
+// recordString returns a one line summary of a flow record.
+func recordString(r *collector.FlowRecord) string {
+
+	s := fmt.Sprintf("%s %s:%d -> %s:%d action %s policy %s",
+		r.ContextID,
+		r.Source.IP, r.Source.Port,
+		r.Destination.IP, r.Destination.Port,
+		r.Action.ActionString(),
+		r.PolicyID,
+	)
+
+	if r.DropReason != "" {
+		s += " reason " + r.DropReason
+	}
+
+	if r.Termination != nil {
+		s += " terminated " + r.Termination.Reason
+	}
+
+	return s
+}
@@ -0,0 +1,78 @@
+package replay
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aporeto-inc/netlink-go/conntrack"
+	"github.com/aporeto-inc/trireme-lib/collector"
+)
+
+// recorder is the collector of the replayed datapath. It keeps the flow
+// records until they are added to the result of a packet.
+type recorder struct {
+	records []*collector.FlowRecord
+
+	sync.Mutex
+}
+
+// CollectFlowEvent is part of the EventCollector interface.
+func (r *recorder) CollectFlowEvent(record *collector.FlowRecord) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.records = append(r.records, record)
+}
+
+// CollectContainerEvent is part of the EventCollector interface.
+func (r *recorder) CollectContainerEvent(record *collector.ContainerRecord) {}
+
+// CollectUserEvent is part of the EventCollector interface.
+func (r *recorder) CollectUserEvent(record *collector.UserRecord) {}
+
+// flush returns and forgets the records collected so far.
+func (r *recorder) flush() []*collector.FlowRecord {
+
+	r.Lock()
+	defer r.Unlock()
+
+	records := r.records
+	r.records = nil
+
+	return records
+}
+
+// releaseRecorder replaces the conntrack handle of the replayed datapath.
+// The connmark updates releasing flows to the kernel are recorded instead of
+// being applied. The datapath issues no other conntrack request.
+type releaseRecorder struct {
+	conntrack.Conntrack
+
+	released []string
+
+	sync.Mutex
+}
+
+// ConntrackTableUpdateMark records the update of the connmark of a flow.
+func (r *releaseRecorder) ConntrackTableUpdateMark(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, newmark uint32) error {
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.released = append(r.released, fmt.Sprintf("%s:%s:%d:%d mark %d", ipSrc, ipDst, srcport, dstport, newmark))
+
+	return nil
+}
+
+// flush returns and forgets the flows released so far.
+func (r *releaseRecorder) flush() []string {
+
+	r.Lock()
+	defer r.Unlock()
+
+	released := r.released
+	r.released = nil
+
+	return released
+}
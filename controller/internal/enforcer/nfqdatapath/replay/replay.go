@@ -0,0 +1,313 @@
+// Package replay feeds the packets of a capture through the nfq datapath
+// without a kernel. The verdicts the datapath would give to NFQUEUE, the
+// state transitions of the flows and the records sent to the collector are
+// reported for every packet. It is meant to debug the handshake offline.
+package replay
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/nfqdatapath"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// Verdict is the verdict given to a packet by one path of the datapath.
+type Verdict struct {
+	// Path is the path that processed the packet, either
+	// introspection.ApplicationOriginated or introspection.NetworkOriginated
+	Path   string
+	Accept bool
+	// Error is the reason of a drop
+	Error string
+	// Buffer is the packet as it is handed back to the queue
+	Buffer []byte
+}
+
+// Transition is the change of state of a flow tracked by the datapath.
+// From is empty for new flows and To is empty for flows that are no longer
+// tracked.
+type Transition struct {
+	Direction string
+	Flow      string
+	From      string
+	To        string
+}
+
+// Result is the outcome of the replay of one packet.
+type Result struct {
+	Index     int
+	Timestamp time.Time
+	// Flow is the 4-tuple of the packet in the source:destination:sport:dport format
+	Flow  string
+	Flags string
+	// Verdicts are empty when the packet does not belong to a processing unit
+	Verdicts    []*Verdict
+	Transitions []*Transition
+	Records     []*collector.FlowRecord
+	// Released are the flows released to the kernel through a connmark update
+	Released []string
+}
+
+// Replayer feeds packets through a datapath enforcing a set of processing
+// units. The processing units are enforced as in a remote enforcer and
+// their packets are selected by their IP addresses. The host is not
+// configured.
+type Replayer struct {
+	datapath  *nfqdatapath.Datapath
+	collector *recorder
+	conntrack *releaseRecorder
+	local     []net.IP
+	// now is the time of the packet being replayed. The tokens are issued
+	// and validated at this time, or at the current time when it is zero.
+	now time.Time
+}
+
+// New creates a datapath enforcing the processing units and a replayer
+// feeding it. The secrets must be the ones of the enforcers that captured
+// the packets for their tokens to be valid.
+func New(serverID string, s secrets.Secrets, pus ...*policy.PUInfo) (*Replayer, error) {
+
+	r := &Replayer{
+		collector: &recorder{},
+		conntrack: &releaseRecorder{},
+		local:     []net.IP{},
+	}
+
+	datapath, err := nfqdatapath.NewOffline(serverID, r.collector, s, r.clock)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create datapath: %s", err)
+	}
+	r.datapath = datapath
+	r.datapath.SetConntrackHandle(r.conntrack)
+
+	for _, pu := range pus {
+		for _, addr := range pu.Runtime.IPAddresses() {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip address %s for %s", addr, pu.ContextID)
+			}
+			r.local = append(r.local, ip)
+		}
+
+		if err := r.datapath.Enforce(pu.ContextID, pu); err != nil {
+			return nil, fmt.Errorf("unable to enforce %s: %s", pu.ContextID, err)
+		}
+	}
+
+	return r, nil
+}
+
+// clock returns the time at which the tokens are issued and validated.
+func (r *Replayer) clock() time.Time {
+
+	if r.now.IsZero() {
+		return time.Now()
+	}
+
+	return r.now
+}
+
+// ReplayPcap replays all the packets of a pcap capture. The packets are
+// replayed at the time they were captured so that the tokens they carry are
+// validated as they were by the enforcers.
+func (r *Replayer) ReplayPcap(capture io.Reader) ([]*Result, error) {
+
+	defer func() { r.now = time.Time{} }()
+
+	reader, err := pcapgo.NewReader(capture)
+	if err != nil {
+		return nil, fmt.Errorf("invalid capture: %s", err)
+	}
+
+	results := []*Result{}
+
+	for index := 0; ; index++ {
+		data, ci, err := reader.ReadPacketData()
+		if err == io.EOF {
+			return results, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read packet %d: %s", index, err)
+		}
+
+		// The datapath works on IP packets
+		decoded := gopacket.NewPacket(data, reader.LinkType(), gopacket.Default)
+		network := decoded.NetworkLayer()
+		if network == nil {
+			continue
+		}
+
+		buffer := append(append([]byte{}, network.LayerContents()...), network.LayerPayload()...)
+
+		r.now = ci.Timestamp
+
+		result, err := r.Replay(buffer)
+		if err != nil {
+			return nil, fmt.Errorf("unable to replay packet %d: %s", index, err)
+		}
+		result.Index = index
+		result.Timestamp = ci.Timestamp
+
+		results = append(results, result)
+	}
+}
+
+// Replay feeds one IP packet through the datapath. Packets sent by a
+// processing unit go through the application path. Packets received by a
+// processing unit go through the network path, after the application path
+// if they are also sent by one.
+func (r *Replayer) Replay(buffer []byte) (*Result, error) {
+
+	p, err := packet.New(0, append([]byte{}, buffer...), "0")
+	if err != nil {
+		return nil, fmt.Errorf("invalid packet: %s", err)
+	}
+
+	result := &Result{
+		Flow: p.L4FlowHash(),
+	}
+	if p.IPProto == packet.IPProtocolTCP {
+		result.Flags = p.TCPFlagsString()
+	}
+
+	before, err := r.flowStates()
+	if err != nil {
+		return nil, err
+	}
+
+	if r.isLocal(p.SourceAddress) {
+		verdict := r.process(introspection.ApplicationOriginated, buffer)
+		result.Verdicts = append(result.Verdicts, verdict)
+		if !verdict.Accept {
+			return r.complete(result, before)
+		}
+		buffer = verdict.Buffer
+	}
+
+	if r.isLocal(p.DestinationAddress) {
+		result.Verdicts = append(result.Verdicts, r.process(introspection.NetworkOriginated, buffer))
+	}
+
+	return r.complete(result, before)
+}
+
+// process runs a packet through one path and gives it the verdict
+// the NFQUEUE callbacks would give.
+func (r *Replayer) process(path string, buffer []byte) *Verdict {
+
+	verdict := &Verdict{
+		Path: path,
+	}
+
+	packetType := packet.PacketTypeNetwork
+	if path == introspection.ApplicationOriginated {
+		packetType = packet.PacketTypeApplication
+	}
+
+	p, err := packet.New(uint64(packetType), append([]byte{}, buffer...), "0")
+	if err == nil {
+		if path == introspection.ApplicationOriginated {
+			err = r.datapath.ProcessApplicationPacket(p)
+		} else {
+			err = r.datapath.ProcessNetworkPacket(p)
+		}
+	}
+
+	// Dropped packets are handed back unmodified
+	if err != nil {
+		verdict.Error = err.Error()
+		verdict.Buffer = buffer
+		return verdict
+	}
+
+	verdict.Accept = true
+	verdict.Buffer = p.GetBytes()
+
+	return verdict
+}
+
+// complete adds the side effects of the processing of a packet to its result.
+func (r *Replayer) complete(result *Result, before map[flowKey]string) (*Result, error) {
+
+	after, err := r.flowStates()
+	if err != nil {
+		return nil, err
+	}
+
+	result.Transitions = transitions(before, after)
+	result.Records = r.collector.flush()
+	result.Released = r.conntrack.flush()
+
+	return result, nil
+}
+
+// isLocal returns true if the ip belongs to a processing unit.
+func (r *Replayer) isLocal(ip net.IP) bool {
+
+	for _, local := range r.local {
+		if local.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// flowKey identifies a flow tracked by the datapath.
+type flowKey struct {
+	direction string
+	flow      string
+}
+
+// flowStates returns the state of all the flows tracked by the datapath.
+func (r *Replayer) flowStates() (map[flowKey]string, error) {
+
+	flows, err := r.datapath.ListFlows("")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list flows: %s", err)
+	}
+
+	states := map[flowKey]string{}
+	for _, f := range flows {
+		states[flowKey{direction: f.Direction, flow: f.Flow}] = f.State
+	}
+
+	return states, nil
+}
+
+// transitions returns the flows whose state changed between two snapshots.
+func transitions(before, after map[flowKey]string) []*Transition {
+
+	list := []*Transition{}
+
+	for key, to := range after {
+		if from, ok := before[key]; !ok || from != to {
+			list = append(list, &Transition{Direction: key.direction, Flow: key.flow, From: from, To: to})
+		}
+	}
+
+	for key, from := range before {
+		if _, ok := after[key]; !ok {
+			list = append(list, &Transition{Direction: key.direction, Flow: key.flow, From: from})
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Direction != list[j].Direction {
+			return list[i].Direction < list[j].Direction
+		}
+		return list[i].Flow < list[j].Flow
+	})
+
+	return list
+}
@@ -0,0 +1,346 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/common"
+	enforcerconstants "github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/utils/packetgen"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// ethernetHeader is prepended to the packets of the captures.
+var ethernetHeader = []byte{
+	0x02, 0x42, 0xac, 0x11, 0x00, 0x02,
+	0x02, 0x42, 0xac, 0x11, 0x00, 0x03,
+	0x08, 0x00,
+}
+
+func capture(packets [][]byte) (*bytes.Buffer, error) {
+
+	buffer := &bytes.Buffer{}
+
+	w := pcapgo.NewWriter(buffer)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		return nil, err
+	}
+
+	start := time.Unix(1500000000, 0)
+
+	for i, p := range packets {
+		frame := append(append([]byte{}, ethernetHeader...), p...)
+		ci := gopacket.CaptureInfo{
+			Timestamp:     start.Add(time.Duration(i) * time.Millisecond),
+			CaptureLength: len(frame),
+			Length:        len(frame),
+		}
+		if err := w.WritePacket(ci, frame); err != nil {
+			return nil, err
+		}
+	}
+
+	return buffer, nil
+}
+
+func processingUnit(contextID string, ip string, action policy.ActionType) *policy.PUInfo {
+
+	puInfo := policy.NewPUInfo(contextID, common.ContainerPU)
+	puInfo.Runtime.SetIPAddresses(policy.ExtendedMap{"bridge": ip})
+	puInfo.Policy.SetIPAddresses(policy.ExtendedMap{policy.DefaultNamespace: ip})
+	puInfo.Policy.AddIdentityTag(enforcerconstants.TransmitterLabel, "value")
+	puInfo.Policy.AddReceiverRules(policy.TagSelector{
+		Clause: []policy.KeyValueOperator{
+			{
+				Key:      enforcerconstants.TransmitterLabel,
+				Value:    []string{"value"},
+				Operator: policy.Equal,
+			},
+		},
+		Policy: &policy.FlowPolicy{Action: action, PolicyID: "replayed"},
+	})
+
+	return puInfo
+}
+
+func templatePackets() (packetgen.PacketFlowManipulator, [][]byte, error) {
+
+	flow := packetgen.NewTemplateFlow()
+	if _, err := flow.GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlowTemplate); err != nil {
+		return nil, nil, err
+	}
+
+	packets := [][]byte{}
+	for i := 0; i < flow.GetNumPackets(); i++ {
+		p, err := flow.GetNthPacket(i).ToBytes()
+		if err != nil {
+			return nil, nil, err
+		}
+		packets = append(packets, p)
+	}
+
+	return flow, packets, nil
+}
+
+func TestReplayPcap(t *testing.T) {
+
+	Convey("Given a capture of a good flow between two processing units", t, func() {
+
+		flow, packets, err := templatePackets()
+		So(err, ShouldBeNil)
+
+		pcap, err := capture(packets)
+		So(err, ShouldBeNil)
+
+		srcIP := flow.GetNthPacket(0).GetIPPacket().SrcIP.String()
+		dstIP := flow.GetNthPacket(0).GetIPPacket().DstIP.String()
+
+		r, err := New("SomeServerId", secrets.NewPSKSecrets([]byte("Dummy Test Password")),
+			processingUnit("pu1", srcIP, policy.Accept),
+			processingUnit("pu2", dstIP, policy.Accept),
+		)
+		So(err, ShouldBeNil)
+
+		Convey("When I replay the capture", func() {
+
+			results, err := r.ReplayPcap(pcap)
+			So(err, ShouldBeNil)
+
+			Convey("Then every packet should be accepted by both paths", func() {
+				So(len(results), ShouldEqual, len(packets))
+				for i, result := range results {
+					So(result.Index, ShouldEqual, i)
+					So(result.Timestamp.Equal(time.Unix(1500000000, 0).Add(time.Duration(i)*time.Millisecond)), ShouldBeTrue)
+					So(len(result.Verdicts), ShouldEqual, 2)
+					So(result.Verdicts[0].Path, ShouldEqual, introspection.ApplicationOriginated)
+					So(result.Verdicts[1].Path, ShouldEqual, introspection.NetworkOriginated)
+					for _, v := range result.Verdicts {
+						So(v.Error, ShouldBeEmpty)
+						So(v.Accept, ShouldBeTrue)
+					}
+				}
+			})
+
+			Convey("Then the syn should create the flow on both paths", func() {
+				So(results[0].Flags, ShouldEqual, "....S.")
+				So(results[0].Transitions, ShouldResemble, []*Transition{
+					{Direction: introspection.ApplicationOriginated, Flow: results[0].Flow, To: "synsend"},
+					{Direction: introspection.NetworkOriginated, Flow: results[0].Flow, To: "synreceived"},
+				})
+
+				// The syn carries the token of the application path and the
+				// network path removes it. The template packets are padded to
+				// the minimum ethernet frame size.
+				ipLength := int(binary.BigEndian.Uint16(packets[0][2:4]))
+				So(len(results[0].Verdicts[0].Buffer), ShouldBeGreaterThan, ipLength)
+				So(results[0].Verdicts[1].Buffer, ShouldResemble, packets[0][:ipLength])
+			})
+
+			Convey("Then the flow should be reported as accepted and released", func() {
+				records := []*collector.FlowRecord{}
+				released := []string{}
+				for _, result := range results {
+					records = append(records, result.Records...)
+					released = append(released, result.Released...)
+				}
+
+				accepted := 0
+				for _, record := range records {
+					if record.Action.Accepted() && record.Termination == nil {
+						accepted++
+						So(record.PolicyID, ShouldEqual, "replayed")
+					}
+				}
+				So(accepted, ShouldBeGreaterThan, 0)
+				So(released, ShouldNotBeEmpty)
+			})
+
+			Convey("Then the results should be written per packet", func() {
+				output := &bytes.Buffer{}
+				So(WriteResults(output, results), ShouldBeNil)
+				So(output.String(), ShouldStartWith, "#0 ")
+				So(output.String(), ShouldContainSubstring, "  application: accept")
+				So(output.String(), ShouldContainSubstring, "  state application "+results[0].Flow+": none -> synsend")
+				So(strings.Count(output.String(), "\n#"), ShouldEqual, len(packets)-1)
+			})
+		})
+
+		Convey("When I replay a packet that does not belong to a processing unit", func() {
+
+			r, err := New("SomeServerId", secrets.NewPSKSecrets([]byte("Dummy Test Password")), processingUnit("pu3", "10.1.1.1", policy.Accept))
+			So(err, ShouldBeNil)
+
+			result, err := r.Replay(packets[0])
+
+			Convey("Then it should be skipped", func() {
+				So(err, ShouldBeNil)
+				So(result.Verdicts, ShouldBeEmpty)
+				So(result.Transitions, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I replay an invalid capture", func() {
+
+			_, err := r.ReplayPcap(bytes.NewReader([]byte("not a capture")))
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestReplayCapturedTokens(t *testing.T) {
+
+	Convey("Given a syn captured with the token of its enforcer more than a year ago", t, func() {
+
+		flow, packets, err := templatePackets()
+		So(err, ShouldBeNil)
+
+		srcIP := flow.GetNthPacket(0).GetIPPacket().SrcIP.String()
+		dstIP := flow.GetNthPacket(0).GetIPPacket().DstIP.String()
+
+		sender, err := New("SomeServerId", secrets.NewPSKSecrets([]byte("Dummy Test Password")), processingUnit("pu1", srcIP, policy.Accept))
+		So(err, ShouldBeNil)
+
+		// The capture is taken at the time of its first packet
+		sender.now = time.Unix(1500000000, 0)
+		sent, err := sender.Replay(packets[0])
+		So(err, ShouldBeNil)
+		So(sent.Verdicts[0].Accept, ShouldBeTrue)
+
+		syn := sent.Verdicts[0].Buffer
+
+		Convey("When I replay the capture", func() {
+
+			r, err := New("SomeServerId", secrets.NewPSKSecrets([]byte("Dummy Test Password")), processingUnit("pu2", dstIP, policy.Accept))
+			So(err, ShouldBeNil)
+
+			pcap, err := capture([][]byte{syn})
+			So(err, ShouldBeNil)
+
+			results, err := r.ReplayPcap(pcap)
+			So(err, ShouldBeNil)
+
+			Convey("Then the token should be validated at the time of the capture", func() {
+				So(len(results), ShouldEqual, 1)
+				So(len(results[0].Verdicts), ShouldEqual, 1)
+				So(results[0].Verdicts[0].Error, ShouldBeEmpty)
+				So(results[0].Verdicts[0].Accept, ShouldBeTrue)
+			})
+		})
+
+		Convey("When I replay the packet at the current time", func() {
+
+			r, err := New("SomeServerId", secrets.NewPSKSecrets([]byte("Dummy Test Password")), processingUnit("pu2", dstIP, policy.Accept))
+			So(err, ShouldBeNil)
+
+			result, err := r.Replay(syn)
+			So(err, ShouldBeNil)
+
+			Convey("Then the expired token should be rejected", func() {
+				So(len(result.Verdicts), ShouldEqual, 1)
+				So(result.Verdicts[0].Accept, ShouldBeFalse)
+				So(result.Verdicts[0].Error, ShouldContainSubstring, "expired")
+			})
+		})
+	})
+}
+
+func TestReplayEncryptedFlow(t *testing.T) {
+
+	Convey("Given a flow whose policy requires encryption", t, func() {
+
+		flow, packets, err := templatePackets()
+		So(err, ShouldBeNil)
+
+		srcIP := flow.GetNthPacket(0).GetIPPacket().SrcIP.String()
+		dstIP := flow.GetNthPacket(0).GetIPPacket().DstIP.String()
+
+		r, err := New("SomeServerId", secrets.NewPSKSecrets([]byte("Dummy Test Password")),
+			processingUnit("pu1", srcIP, policy.Accept|policy.Encrypt),
+			processingUnit("pu2", dstIP, policy.Accept|policy.Encrypt),
+		)
+		So(err, ShouldBeNil)
+
+		// The data segment follows the ack of the handshake
+		So(flow.GetNthPacket(2).NewTCPPayload("encrypted payload"), ShouldBeNil)
+		data, err := flow.GetNthPacket(2).ToBytes()
+		So(err, ShouldBeNil)
+
+		Convey("When I replay the handshake and a data segment", func() {
+
+			handshake := []*Result{}
+			for _, p := range packets[:3] {
+				result, err := r.Replay(p)
+				So(err, ShouldBeNil)
+				handshake = append(handshake, result)
+			}
+
+			result, err := r.Replay(data)
+			So(err, ShouldBeNil)
+
+			Convey("Then every packet should be accepted by both paths", func() {
+				for _, result := range append(handshake, result) {
+					So(len(result.Verdicts), ShouldEqual, 2)
+					for _, v := range result.Verdicts {
+						So(v.Error, ShouldBeEmpty)
+						So(v.Accept, ShouldBeTrue)
+					}
+				}
+			})
+
+			Convey("Then the payload should be encrypted on the network only", func() {
+				wire, err := packet.New(0, result.Verdicts[0].Buffer, "0")
+				So(err, ShouldBeNil)
+				So(wire.CheckTCPEncryptionOption(enforcerconstants.TCPEncryptionOptionLen), ShouldBeNil)
+				So(len(wire.ReadTCPData()), ShouldEqual, len("encrypted payload"))
+				So(string(wire.ReadTCPData()), ShouldNotEqual, "encrypted payload")
+
+				delivered, err := packet.New(0, result.Verdicts[1].Buffer, "0")
+				So(err, ShouldBeNil)
+				So(delivered.TCPSeq, ShouldEqual, wire.TCPSeq)
+				So(string(delivered.ReadTCPData()), ShouldEqual, "encrypted payload")
+				So(delivered.VerifyTCPChecksum(), ShouldBeTrue)
+				So(result.Verdicts[1].Buffer, ShouldResemble, data)
+			})
+
+			Convey("Then the flow should not be released to the kernel", func() {
+				for _, result := range append(handshake, result) {
+					So(result.Released, ShouldBeEmpty)
+				}
+			})
+		})
+
+		Convey("When a data segment is modified on the network", func() {
+
+			for _, p := range packets[:3] {
+				_, err := r.Replay(p)
+				So(err, ShouldBeNil)
+			}
+
+			app := r.process(introspection.ApplicationOriginated, data)
+			So(app.Accept, ShouldBeTrue)
+
+			tampered := append([]byte{}, app.Buffer...)
+			tampered[len(tampered)-1] ^= 0xff
+			net := r.process(introspection.NetworkOriginated, tampered)
+
+			Convey("Then it should be dropped", func() {
+				So(net.Accept, ShouldBeFalse)
+				So(net.Error, ShouldContainSubstring, "authentication failed")
+			})
+		})
+	})
+}
@@ -14,10 +14,20 @@ type TokenAccessor interface {
 	SetToken(serverID string, validity time.Duration, secret secrets.Secrets) error
 	GetTokenValidity() time.Duration
 	GetTokenServerID() string
+	// AckSize returns the size of the Ack tokens signed with the current secrets.
+	AckSize() uint32
+	// ReplayStatistics returns the counters of the detection of the replayed tokens.
+	ReplayStatistics() ReplayStatistics
+	// CheckTokenSize returns an error if the tokens of the context do not fit
+	// in the handshake packets.
+	CheckTokenSize(context *pucontext.PUContext) error
 
 	CreateAckPacketToken(context *pucontext.PUContext, auth *connection.AuthInfo) ([]byte, error)
 	CreateSynPacketToken(context *pucontext.PUContext, auth *connection.AuthInfo) (token []byte, err error)
 	CreateSynAckPacketToken(context *pucontext.PUContext, auth *connection.AuthInfo) (token []byte, err error)
 	ParsePacketToken(auth *connection.AuthInfo, data []byte) (*tokens.ConnectionClaims, error)
+	// RecordPacketToken records the nonce of a token parsed by ParsePacketToken
+	// once the connection is accepted.
+	RecordPacketToken(auth *connection.AuthInfo) error
 	ParseAckToken(auth *connection.AuthInfo, data []byte) (*tokens.ConnectionClaims, error)
 }
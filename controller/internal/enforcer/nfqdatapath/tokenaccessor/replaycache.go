@@ -0,0 +1,157 @@
+package tokenaccessor
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// replayCacheSize is the maximum number of nonces remembered by the
+	// replay cache
+	replayCacheSize = 16384
+)
+
+// ErrTokenReplayed is returned when the nonce of a token has already been
+// accepted from the same transmitter within the validity of the tokens.
+var ErrTokenReplayed = errors.New("token replayed")
+
+// ReplayStatistics are the counters of the replay cache.
+type ReplayStatistics struct {
+	// Checked is the number of tokens checked
+	Checked uint64
+	// Replayed is the number of tokens rejected as replays
+	Replayed uint64
+	// Evicted is the number of nonces evicted before their expiration
+	// because the cache was full
+	Evicted uint64
+	// Size is the current number of nonces in the cache
+	Size int
+}
+
+// replayEntry is a nonce received at a given time.
+type replayEntry struct {
+	key    string
+	expiry time.Time
+}
+
+// replayCache remembers the nonces of the accepted tokens for the validity of
+// the tokens. Tokens can not be replayed after their validity. The entries
+// are kept in a ring in the order they are received so that the oldest ones
+// expire or are evicted first when the cache is full.
+type replayCache struct {
+	nonces   map[string]struct{}
+	ring     []replayEntry
+	head     int
+	count    int
+	validity time.Duration
+
+	checked  uint64
+	replayed uint64
+	evicted  uint64
+
+	sync.Mutex
+}
+
+// newReplayCache returns a replay cache of the given size for tokens valid for
+// the given duration.
+func newReplayCache(size int, validity time.Duration) *replayCache {
+
+	return &replayCache{
+		nonces:   make(map[string]struct{}, size),
+		ring:     make([]replayEntry, size),
+		validity: validity,
+	}
+}
+
+// check returns ErrTokenReplayed if the nonce has already been accepted from
+// the transmitter. The nonce is not remembered until it is recorded.
+func (c *replayCache) check(transmitter string, nonce []byte, now time.Time) error {
+
+	key := replayKey(transmitter, nonce)
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.checked++
+	c.expire(now)
+
+	if _, ok := c.nonces[key]; ok {
+		c.replayed++
+		return ErrTokenReplayed
+	}
+
+	return nil
+}
+
+// record remembers the nonce of an accepted token. It returns ErrTokenReplayed
+// if the nonce has been accepted from the transmitter since it was checked.
+func (c *replayCache) record(transmitter string, nonce []byte, now time.Time) error {
+
+	key := replayKey(transmitter, nonce)
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.expire(now)
+
+	if _, ok := c.nonces[key]; ok {
+		c.replayed++
+		return ErrTokenReplayed
+	}
+
+	if c.count == len(c.ring) {
+		c.pop()
+		c.evicted++
+	}
+
+	c.ring[(c.head+c.count)%len(c.ring)] = replayEntry{key: key, expiry: now.Add(c.validity)}
+	c.count++
+	c.nonces[key] = struct{}{}
+
+	return nil
+}
+
+// setValidity updates the validity of the tokens. It applies to the nonces
+// received from now on.
+func (c *replayCache) setValidity(validity time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.validity = validity
+}
+
+// statistics returns the counters of the cache.
+func (c *replayCache) statistics() ReplayStatistics {
+	c.Lock()
+	defer c.Unlock()
+
+	return ReplayStatistics{
+		Checked:  c.checked,
+		Replayed: c.replayed,
+		Evicted:  c.evicted,
+		Size:     c.count,
+	}
+}
+
+// expire removes the expired nonces. It must be called with the lock held.
+func (c *replayCache) expire(now time.Time) {
+
+	for c.count > 0 && !now.Before(c.ring[c.head].expiry) {
+		c.pop()
+	}
+}
+
+// replayKey returns the key of the nonce of a transmitter.
+func replayKey(transmitter string, nonce []byte) string {
+	return transmitter + ":" + string(nonce)
+}
+
+// pop removes the oldest nonce. It must be called with the lock held.
+func (c *replayCache) pop() {
+
+	delete(c.nonces, c.ring[c.head].key)
+	c.ring[c.head] = replayEntry{}
+	c.head = (c.head + 1) % len(c.ring)
+	c.count--
+}
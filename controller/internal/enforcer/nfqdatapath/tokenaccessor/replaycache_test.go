@@ -0,0 +1,69 @@
+package tokenaccessor
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReplayCache(t *testing.T) {
+
+	Convey("Given a replay cache", t, func() {
+		now := time.Now()
+		c := newReplayCache(2, time.Minute)
+
+		Convey("When I check a new nonce without recording it", func() {
+			So(c.check("pu1", []byte("nonce1"), now), ShouldBeNil)
+
+			Convey("Then the same nonce should be accepted again", func() {
+				So(c.check("pu1", []byte("nonce1"), now.Add(time.Second)), ShouldBeNil)
+				So(c.statistics(), ShouldResemble, ReplayStatistics{Checked: 2})
+			})
+		})
+
+		Convey("When I record a new nonce", func() {
+			So(c.check("pu1", []byte("nonce1"), now), ShouldBeNil)
+			So(c.record("pu1", []byte("nonce1"), now), ShouldBeNil)
+
+			Convey("Then the same nonce from the same transmitter should be rejected", func() {
+				So(c.check("pu1", []byte("nonce1"), now.Add(time.Second)), ShouldEqual, ErrTokenReplayed)
+				So(c.statistics(), ShouldResemble, ReplayStatistics{Checked: 2, Replayed: 1, Size: 1})
+			})
+
+			Convey("Then the same nonce should not be recorded twice", func() {
+				So(c.record("pu1", []byte("nonce1"), now.Add(time.Second)), ShouldEqual, ErrTokenReplayed)
+			})
+
+			Convey("Then the same nonce from another transmitter should be accepted", func() {
+				So(c.check("pu2", []byte("nonce1"), now), ShouldBeNil)
+			})
+
+			Convey("Then the same nonce should be accepted once it expired", func() {
+				So(c.check("pu1", []byte("nonce1"), now.Add(time.Minute)), ShouldBeNil)
+				So(c.statistics(), ShouldResemble, ReplayStatistics{Checked: 2})
+			})
+		})
+
+		Convey("When I record more nonces than the size of the cache", func() {
+			So(c.record("pu1", []byte("nonce1"), now), ShouldBeNil)
+			So(c.record("pu1", []byte("nonce2"), now), ShouldBeNil)
+			So(c.record("pu1", []byte("nonce3"), now), ShouldBeNil)
+
+			Convey("Then the oldest nonce should be evicted", func() {
+				So(c.statistics(), ShouldResemble, ReplayStatistics{Evicted: 1, Size: 2})
+				So(c.check("pu1", []byte("nonce3"), now), ShouldEqual, ErrTokenReplayed)
+				So(c.check("pu1", []byte("nonce1"), now), ShouldBeNil)
+			})
+		})
+
+		Convey("When I update the validity", func() {
+			c.setValidity(time.Second)
+			So(c.record("pu1", []byte("nonce1"), now), ShouldBeNil)
+
+			Convey("Then the nonces should expire after the new validity", func() {
+				So(c.check("pu1", []byte("nonce1"), now.Add(time.Second)), ShouldBeNil)
+			})
+		})
+	})
+}
@@ -3,6 +3,7 @@ package tokenaccessor
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"sync"
 	"time"
 
@@ -11,31 +12,78 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"go.uber.org/zap"
 )
 
 // tokenAccessor is a wrapper around tokenEngine to provide locks for accessing
 type tokenAccessor struct {
 	sync.RWMutex
-	tokens   tokens.TokenEngine
-	serverID string
-	validity time.Duration
+	tokens      tokens.TokenEngine
+	engine      tokens.EngineType
+	dictionary  *tokens.TagDictionary
+	attestation *policy.Attestation
+	serverID    string
+	validity    time.Duration
+	replays     *replayCache
+	clock       func() time.Time
 }
 
-// New creates a new instance of TokenAccessor interface
-func New(serverID string, validity time.Duration, secret secrets.Secrets) (TokenAccessor, error) {
+// New creates a new instance of TokenAccessor interface. The tokens are
+// created by an engine of the given type and their tags are encoded with the
+// dictionary if it is not nil. The Syn and SynAck tokens carry the attestation
+// of the node if it is not nil.
+func New(serverID string, validity time.Duration, secret secrets.Secrets, engine tokens.EngineType, dictionary *tokens.TagDictionary, attestation *policy.Attestation) (TokenAccessor, error) {
+	return NewWithClock(serverID, validity, secret, engine, dictionary, attestation, time.Now)
+}
+
+// NewWithClock creates a new instance of TokenAccessor interface whose tokens
+// are issued and validated at the time returned by the clock instead of the
+// current time.
+func NewWithClock(serverID string, validity time.Duration, secret secrets.Secrets, engine tokens.EngineType, dictionary *tokens.TagDictionary, attestation *policy.Attestation, clock func() time.Time) (TokenAccessor, error) {
 
-	tokenEngine, err := tokens.NewJWT(validity, serverID, secret)
+	tokenEngine, err := newTokenEngine(serverID, validity, secret, engine, dictionary, clock)
 	if err != nil {
 		return nil, err
 	}
 
 	return &tokenAccessor{
-		tokens:   tokenEngine,
-		serverID: serverID,
-		validity: validity,
+		tokens:      tokenEngine,
+		engine:      engine,
+		dictionary:  dictionary,
+		attestation: attestation,
+		serverID:    serverID,
+		validity:    validity,
+		replays:     newReplayCache(replayCacheSize, validity),
+		clock:       clock,
 	}, nil
 }
 
+// newTokenEngine creates the token engine of the accessor.
+func newTokenEngine(serverID string, validity time.Duration, secret secrets.Secrets, engine tokens.EngineType, dictionary *tokens.TagDictionary, clock func() time.Time) (tokens.TokenEngine, error) {
+
+	switch engine {
+	case tokens.JWTEngine:
+		tokenEngine, err := tokens.NewJWT(validity, serverID, secret)
+		if err != nil {
+			return nil, err
+		}
+		tokenEngine.Dictionary = dictionary
+		tokenEngine.Clock = clock
+		return tokenEngine, nil
+	case tokens.CustomEngine:
+		tokenEngine, err := tokens.NewCustomToken(validity, serverID, secret)
+		if err != nil {
+			return nil, err
+		}
+		tokenEngine.Dictionary = dictionary
+		tokenEngine.Clock = clock
+		return tokenEngine, nil
+	default:
+		return nil, fmt.Errorf("unknown token engine: %d", engine)
+	}
+}
+
 func (t *tokenAccessor) getToken() tokens.TokenEngine {
 
 	t.Lock()
@@ -49,11 +97,12 @@ func (t *tokenAccessor) SetToken(serverID string, validity time.Duration, secret
 
 	t.Lock()
 	defer t.Unlock()
-	tokenEngine, err := tokens.NewJWT(validity, serverID, secret)
+	tokenEngine, err := newTokenEngine(serverID, validity, secret, t.engine, t.dictionary, t.clock)
 	if err != nil {
 		return err
 	}
 	t.tokens = tokenEngine
+	t.replays.setValidity(validity)
 	return nil
 }
 
@@ -67,6 +116,46 @@ func (t *tokenAccessor) GetTokenServerID() string {
 	return t.serverID
 }
 
+// AckSize returns the size of the Ack tokens signed with the current secrets.
+// It depends on the token engine and on the type of the key.
+func (t *tokenAccessor) AckSize() uint32 {
+	return t.getToken().AckSize()
+}
+
+// ReplayStatistics returns the counters of the detection of the replayed tokens.
+func (t *tokenAccessor) ReplayStatistics() ReplayStatistics {
+	return t.replays.statistics()
+}
+
+// CheckTokenSize returns an error if the tokens of the context do not fit
+// in the handshake packets. The SynAck tokens are the largest ones since they
+// carry the nonce of the remote too.
+func (t *tokenAccessor) CheckTokenSize(context *pucontext.PUContext) error {
+
+	key, err := context.EphemeralKey()
+	if err != nil {
+		return err
+	}
+
+	claims := &tokens.ConnectionClaims{
+		T:   context.Identity(),
+		RMT: make([]byte, tokens.NonceLength),
+		EK:  key.Public,
+		A:   t.attestation,
+	}
+
+	token, _, err := t.getToken().CreateAndSign(false, claims)
+	if err != nil {
+		return fmt.Errorf("unable to create token: %s", err)
+	}
+
+	if len(token) > enforcerconstants.MaxTokenLength {
+		return fmt.Errorf("token of %d bytes exceeds the maximum of %d bytes: reduce the tags of the identity or use a tag dictionary", len(token), enforcerconstants.MaxTokenLength)
+	}
+
+	return nil
+}
+
 // CreateAckPacketToken creates the authentication token
 func (t *tokenAccessor) CreateAckPacketToken(context *pucontext.PUContext, auth *connection.AuthInfo) ([]byte, error) {
 
@@ -83,31 +172,20 @@ func (t *tokenAccessor) CreateAckPacketToken(context *pucontext.PUContext, auth
 	return token, nil
 }
 
-// createSynPacketToken creates the authentication token
+// createSynPacketToken creates the authentication token. The nonce is signed
+// and the token can not be cached
 func (t *tokenAccessor) CreateSynPacketToken(context *pucontext.PUContext, auth *connection.AuthInfo) (token []byte, err error) {
 
-	token, serviceContext, err := context.GetCachedTokenAndServiceContext()
-
-	if err == nil && bytes.Equal(auth.LocalServiceContext, serviceContext) {
-		// Randomize the nonce and send it
-		auth.LocalContext, err = t.getToken().Randomize(token)
-		if err == nil {
-			return token, nil
-		}
-		// If there is an error, let's try to create a new one
-	}
-
 	claims := &tokens.ConnectionClaims{
 		T:  context.Identity(),
 		EK: auth.LocalServiceContext,
+		A:  t.attestation,
 	}
 
 	if token, auth.LocalContext, err = t.getToken().CreateAndSign(false, claims); err != nil {
 		return []byte{}, nil
 	}
 
-	context.UpdateCachedTokenAndServiceContext(token, auth.LocalServiceContext)
-
 	return token, nil
 }
 
@@ -119,6 +197,7 @@ func (t *tokenAccessor) CreateSynAckPacketToken(context *pucontext.PUContext, au
 		T:   context.Identity(),
 		RMT: auth.RemoteContext,
 		EK:  auth.LocalServiceContext,
+		A:   t.attestation,
 	}
 
 	if token, auth.LocalContext, err = t.getToken().CreateAndSign(false, claims); err != nil {
@@ -144,6 +223,13 @@ func (t *tokenAccessor) ParsePacketToken(auth *connection.AuthInfo, data []byte)
 		return nil, errors.New("no transmitter label")
 	}
 
+	// A token captured within its validity must not be accepted again. The
+	// nonce is recorded by RecordPacketToken once the connection is accepted
+	if err := t.replays.check(remoteContextID, nonce, t.clock()); err != nil {
+		zap.L().Debug("Rejecting replayed token", zap.String("transmitter", remoteContextID))
+		return nil, err
+	}
+
 	auth.RemotePublicKey = cert
 	auth.RemoteContext = nonce
 	auth.RemoteContextID = remoteContextID
@@ -152,6 +238,20 @@ func (t *tokenAccessor) ParsePacketToken(auth *connection.AuthInfo, data []byte)
 	return claims, nil
 }
 
+// RecordPacketToken records the nonce of the token parsed by ParsePacketToken
+// once the connection is accepted. Tokens rejected by the policies can be
+// retransmitted. Returns ErrTokenReplayed if the token has been accepted
+// meanwhile.
+func (t *tokenAccessor) RecordPacketToken(auth *connection.AuthInfo) error {
+
+	if err := t.replays.record(auth.RemoteContextID, auth.RemoteContext, t.clock()); err != nil {
+		zap.L().Debug("Rejecting replayed token", zap.String("transmitter", auth.RemoteContextID))
+		return err
+	}
+
+	return nil
+}
+
 // parseAckToken parses the tokens in Ack packets. They don't carry all the state context
 // and it needs to be recovered
 func (t *tokenAccessor) ParseAckToken(auth *connection.AuthInfo, data []byte) (*tokens.ConnectionClaims, error) {
@@ -0,0 +1,70 @@
+package tokenaccessor
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/common"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newPU(tags int) (*pucontext.PUContext, []string, error) {
+
+	identity := policy.NewTagStore()
+	for i := 0; i < tags; i++ {
+		identity.AppendKeyValue(fmt.Sprintf("app.kubernetes.io/label-%d", i), fmt.Sprintf("value-%d", i))
+	}
+
+	puInfo := policy.NewPUInfo("pu", common.ContainerPU)
+	puInfo.Policy = policy.NewPUPolicy("pu", policy.AllowAll, nil, nil, nil, nil, identity, nil, nil, []string{}, []string{}, &policy.ProxiedServicesInfo{}, nil, nil, []string{})
+
+	pu, err := pucontext.NewPU("pu", puInfo, time.Second)
+
+	return pu, identity.GetSlice(), err
+}
+
+func TestCheckTokenSize(t *testing.T) {
+
+	Convey("Given a token accessor without dictionary", t, func() {
+		s := secrets.NewPSKSecrets([]byte("psk"))
+		ta, err := New("server", time.Minute, s, tokens.JWTEngine, nil, nil)
+		So(err, ShouldBeNil)
+
+		Convey("Then the tokens of a small identity should fit", func() {
+			pu, _, err := newPU(5)
+			So(err, ShouldBeNil)
+			So(ta.CheckTokenSize(pu), ShouldBeNil)
+		})
+
+		Convey("Then the tokens of a large identity should not fit", func() {
+			pu, _, err := newPU(50)
+			So(err, ShouldBeNil)
+			So(ta.CheckTokenSize(pu), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a token accessor with a dictionary of the tags of a large identity", t, func() {
+		pu, identity, err := newPU(50)
+		So(err, ShouldBeNil)
+		d, err := tokens.NewTagDictionary(identity)
+		So(err, ShouldBeNil)
+
+		s := secrets.NewPSKSecrets([]byte("psk"))
+		ta, err := New("server", time.Minute, s, tokens.JWTEngine, d, nil)
+		So(err, ShouldBeNil)
+
+		Convey("Then the tokens should fit", func() {
+			So(ta.CheckTokenSize(pu), ShouldBeNil)
+		})
+
+		Convey("Then the dictionary should be kept when the secrets are updated", func() {
+			So(ta.SetToken("server", time.Minute, s), ShouldBeNil)
+			So(ta.CheckTokenSize(pu), ShouldBeNil)
+		})
+	})
+}
@@ -9,10 +9,26 @@ import (
 )
 
 func (d *Datapath) reportAcceptedFlow(p *packet.Packet, conn *connection.TCPConnection, sourceID string, destID string, context *pucontext.PUContext, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
+	record := d.reportFlow(p, conn, sourceID, destID, context, "", report, packet)
 	if conn != nil {
 		conn.SetReported(connection.AcceptReported)
+		conn.AcceptedRecord = record
 	}
-	d.reportFlow(p, conn, sourceID, destID, context, "", report, packet)
+}
+
+// reportFlowTermination reports the end of an accepted flow. It is reported
+// only once per connection. The connection must be locked.
+func (d *Datapath) reportFlowTermination(conn *connection.TCPConnection, reason string) {
+
+	if conn.AcceptedRecord == nil || !conn.Terminate() {
+		return
+	}
+
+	d.collector.CollectFlowEvent(collector.NewTerminationRecord(conn.AcceptedRecord, &collector.FlowTermination{
+		Reason:   reason,
+		Duration: conn.Duration(),
+		State:    conn.GetState().String(),
+	}))
 }
 
 func (d *Datapath) reportRejectedFlow(p *packet.Packet, conn *connection.TCPConnection, sourceID string, destID string, context *pucontext.PUContext, mode string, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
@@ -32,7 +48,31 @@ func (d *Datapath) reportRejectedFlow(p *packet.Packet, conn *connection.TCPConn
 	d.reportFlow(p, conn, sourceID, destID, context, mode, report, packet)
 }
 
-func (d *Datapath) reportExternalServiceFlowCommon(context *pucontext.PUContext, report *policy.FlowPolicy, packet *policy.FlowPolicy, app bool, p *packet.Packet, src, dst *collector.EndPoint) {
+func (d *Datapath) reportUDPAcceptedFlow(p *packet.Packet, conn *connection.UDPConnection, sourceID string, destID string, context *pucontext.PUContext, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
+	if conn != nil {
+		conn.SetReported(connection.AcceptReported)
+	}
+	d.reportFlow(p, nil, sourceID, destID, context, "", report, packet)
+}
+
+func (d *Datapath) reportUDPRejectedFlow(p *packet.Packet, conn *connection.UDPConnection, sourceID string, destID string, context *pucontext.PUContext, mode string, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
+	if conn != nil && mode == collector.PolicyDrop {
+		conn.SetReported(connection.RejectReported)
+	}
+
+	if report == nil {
+		report = &policy.FlowPolicy{
+			Action:   policy.Reject,
+			PolicyID: "",
+		}
+	}
+	if packet == nil {
+		packet = report
+	}
+	d.reportFlow(p, nil, sourceID, destID, context, mode, report, packet)
+}
+
+func (d *Datapath) reportExternalServiceFlowCommon(context *pucontext.PUContext, mode string, report *policy.FlowPolicy, packet *policy.FlowPolicy, app bool, p *packet.Packet, src, dst *collector.EndPoint) {
 
 	if app {
 		src.ID = context.ManagementID()
@@ -50,7 +90,7 @@ func (d *Datapath) reportExternalServiceFlowCommon(context *pucontext.PUContext,
 		ContextID:   context.ID(),
 		Source:      src,
 		Destination: dst,
-		DropReason:  collector.PolicyDrop,
+		DropReason:  mode,
 		Action:      report.Action,
 		Tags:        context.Annotations(),
 		PolicyID:    report.PolicyID,
@@ -63,9 +103,13 @@ func (d *Datapath) reportExternalServiceFlowCommon(context *pucontext.PUContext,
 	}
 
 	d.collector.CollectFlowEvent(record)
+
+	if packet != nil && packet.Action.Accepted() {
+		d.accounting.track(record, nil)
+	}
 }
 
-func (d *Datapath) reportExternalServiceFlow(context *pucontext.PUContext, report *policy.FlowPolicy, packet *policy.FlowPolicy, app bool, p *packet.Packet) {
+func (d *Datapath) reportExternalServiceFlow(context *pucontext.PUContext, mode string, report *policy.FlowPolicy, packet *policy.FlowPolicy, app bool, p *packet.Packet) {
 
 	src := &collector.EndPoint{
 		IP:   p.SourceAddress.String(),
@@ -77,10 +121,10 @@ func (d *Datapath) reportExternalServiceFlow(context *pucontext.PUContext, repor
 		Port: p.DestinationPort,
 	}
 
-	d.reportExternalServiceFlowCommon(context, report, packet, app, p, src, dst)
+	d.reportExternalServiceFlowCommon(context, mode, report, packet, app, p, src, dst)
 }
 
-func (d *Datapath) reportReverseExternalServiceFlow(context *pucontext.PUContext, report *policy.FlowPolicy, packet *policy.FlowPolicy, app bool, p *packet.Packet) {
+func (d *Datapath) reportReverseExternalServiceFlow(context *pucontext.PUContext, mode string, report *policy.FlowPolicy, packet *policy.FlowPolicy, app bool, p *packet.Packet) {
 
 	src := &collector.EndPoint{
 		IP:   p.DestinationAddress.String(),
@@ -92,5 +136,40 @@ func (d *Datapath) reportReverseExternalServiceFlow(context *pucontext.PUContext
 		Port: p.SourcePort,
 	}
 
-	d.reportExternalServiceFlowCommon(context, report, packet, app, p, src, dst)
+	d.reportExternalServiceFlowCommon(context, mode, report, packet, app, p, src, dst)
+}
+
+// tcpConnectionAllowed returns false if the connection exceeds the connection
+// rate of the flow policy. A connection is only counted once so that
+// retransmitted handshake packets do not consume the rate of the policy.
+func tcpConnectionAllowed(context *pucontext.PUContext, conn *connection.TCPConnection, p *policy.FlowPolicy) bool {
+
+	if conn.RateCounted {
+		return true
+	}
+
+	if !context.ConnectionAllowed(p) {
+		return false
+	}
+
+	conn.RateCounted = true
+
+	return true
+}
+
+// rateLimitedFlowPolicy returns the policy reported for a connection that was
+// dropped by the rate limit of the given policy.
+func rateLimitedFlowPolicy(p *policy.FlowPolicy) *policy.FlowPolicy {
+
+	if p == nil {
+		return nil
+	}
+
+	return &policy.FlowPolicy{
+		ObserveAction: p.ObserveAction,
+		Action:        policy.Reject | policy.RateLimit,
+		ServiceID:     p.ServiceID,
+		PolicyID:      p.PolicyID,
+		RateLimit:     p.RateLimit,
+	}
 }
@@ -7,6 +7,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -20,9 +21,11 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/internal/portset"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/processmon"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packetprocessor"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/remoteenforcer"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/crypto"
 )
@@ -44,6 +47,9 @@ type ProxyInfo struct {
 	ExternalIPCacheTimeout time.Duration
 	portSetInstance        portset.PortSet
 	collector              collector.EventCollector
+	tokenEngine            tokens.EngineType
+	tagDictionary          *tokens.TagDictionary
+	attestation            *policy.Attestation
 	sync.RWMutex
 }
 
@@ -52,16 +58,24 @@ func (s *ProxyInfo) InitRemoteEnforcer(contextID string) error {
 
 	resp := &rpcwrapper.Response{}
 
+	payload := &rpcwrapper.InitRequestPayload{
+		FqConfig:               s.filterQueue,
+		MutualAuth:             s.MutualAuth,
+		Validity:               s.validity,
+		ServerID:               s.serverID,
+		ExternalIPCacheTimeout: s.ExternalIPCacheTimeout,
+		PacketLogs:             s.PacketLogs,
+		Secrets:                s.Secrets.PublicSecrets(),
+		TokenEngine:            s.tokenEngine,
+		Attestation:            s.attestation,
+	}
+
+	if s.tagDictionary != nil {
+		payload.TagDictionary = s.tagDictionary.Tags()
+	}
+
 	request := &rpcwrapper.Request{
-		Payload: &rpcwrapper.InitRequestPayload{
-			FqConfig:               s.filterQueue,
-			MutualAuth:             s.MutualAuth,
-			Validity:               s.validity,
-			ServerID:               s.serverID,
-			ExternalIPCacheTimeout: s.ExternalIPCacheTimeout,
-			PacketLogs:             s.PacketLogs,
-			Secrets:                s.Secrets.PublicSecrets(),
-		},
+		Payload: payload,
 	}
 
 	if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.InitEnforcer, request, resp); err != nil {
@@ -156,6 +170,128 @@ func (s *ProxyInfo) Unenforce(contextID string) error {
 	return nil
 }
 
+// ListPUs asks every remote enforcer for the state of its PUs. Remote
+// enforcers that cannot be reached are skipped.
+func (s *ProxyInfo) ListPUs() ([]*introspection.PU, error) {
+
+	pus := []*introspection.PU{}
+
+	for _, contextID := range s.initializedContexts() {
+		payload, err := s.introspect(contextID, remoteenforcer.ListPUs, nil)
+		if err != nil {
+			zap.L().Warn("Unable to list the pus of a remote enforcer",
+				zap.String("contextID", contextID),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		for _, pu := range payload.PUs {
+			pu.Remote = true
+			pus = append(pus, pu)
+		}
+	}
+
+	return pus, nil
+}
+
+// ListFlows asks the remote enforcer of the given contextID, or every remote
+// enforcer if it is empty, for the flows it tracks.
+func (s *ProxyInfo) ListFlows(contextID string) ([]*introspection.Flow, error) {
+
+	if contextID != "" {
+		s.RLock()
+		_, ok := s.initDone[contextID]
+		s.RUnlock()
+
+		// The PU is not enforced by a remote enforcer
+		if !ok {
+			return []*introspection.Flow{}, nil
+		}
+
+		payload, err := s.introspect(contextID, remoteenforcer.ListFlows, nil)
+		if err != nil {
+			return nil, err
+		}
+		return payload.Flows, nil
+	}
+
+	flows := []*introspection.Flow{}
+
+	for _, contextID := range s.initializedContexts() {
+		payload, err := s.introspect(contextID, remoteenforcer.ListFlows, nil)
+		if err != nil {
+			zap.L().Warn("Unable to list the flows of a remote enforcer",
+				zap.String("contextID", contextID),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		flows = append(flows, payload.Flows...)
+	}
+
+	return flows, nil
+}
+
+// Simulate asks the remote enforcer of the PU of the query to evaluate the
+// flow. It returns nil if the PU is not enforced by a remote enforcer.
+func (s *ProxyInfo) Simulate(query *introspection.Query) (*introspection.Decision, error) {
+
+	s.RLock()
+	_, ok := s.initDone[query.ContextID]
+	s.RUnlock()
+
+	if !ok {
+		return nil, nil
+	}
+
+	payload, err := s.introspect(query.ContextID, remoteenforcer.Simulate, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return payload.Decision, nil
+}
+
+// initializedContexts returns the sorted contextIDs of the initialized remote enforcers.
+func (s *ProxyInfo) initializedContexts() []string {
+
+	s.RLock()
+	defer s.RUnlock()
+
+	contexts := make([]string, 0, len(s.initDone))
+	for contextID := range s.initDone {
+		contexts = append(contexts, contextID)
+	}
+	sort.Strings(contexts)
+
+	return contexts
+}
+
+// introspect makes an introspection RPC call to the remote enforcer of contextID.
+func (s *ProxyInfo) introspect(contextID string, method string, query *introspection.Query) (*rpcwrapper.IntrospectionResponsePayload, error) {
+
+	resp := &rpcwrapper.Response{}
+	request := &rpcwrapper.Request{
+		Payload: &rpcwrapper.IntrospectionPayload{
+			ContextID: contextID,
+			Query:     query,
+		},
+	}
+
+	if err := s.rpchdl.RemoteCall(contextID, method, request, resp); err != nil {
+		return nil, fmt.Errorf("failed to introspect remote enforcer %s: status: %s: %s", contextID, resp.Status, err)
+	}
+
+	payload, ok := resp.Payload.(rpcwrapper.IntrospectionResponsePayload)
+	if !ok {
+		return nil, fmt.Errorf("invalid introspection response from remote enforcer %s", contextID)
+	}
+
+	return &payload, nil
+}
+
 // GetFilterQueue returns the current FilterQueueConfig.
 func (s *ProxyInfo) GetFilterQueue() *fqconfig.FilterQueue {
 	return s.filterQueue
@@ -191,6 +327,9 @@ func NewProxyEnforcer(mutualAuth bool,
 	procMountPoint string,
 	ExternalIPCacheTimeout time.Duration,
 	packetLogs bool,
+	engine tokens.EngineType,
+	dictionary *tokens.TagDictionary,
+	attestation *policy.Attestation,
 ) enforcer.Enforcer {
 	return newProxyEnforcer(
 		mutualAuth,
@@ -207,6 +346,9 @@ func NewProxyEnforcer(mutualAuth bool,
 		ExternalIPCacheTimeout,
 		nil,
 		packetLogs,
+		engine,
+		dictionary,
+		attestation,
 	)
 }
 
@@ -225,6 +367,9 @@ func newProxyEnforcer(mutualAuth bool,
 	ExternalIPCacheTimeout time.Duration,
 	portSetInstance portset.PortSet,
 	packetLogs bool,
+	engine tokens.EngineType,
+	dictionary *tokens.TagDictionary,
+	attestation *policy.Attestation,
 ) enforcer.Enforcer {
 
 	statsServersecret, err := crypto.GenerateRandomString(32)
@@ -251,6 +396,9 @@ func newProxyEnforcer(mutualAuth bool,
 		PacketLogs:             packetLogs,
 		portSetInstance:        portSetInstance,
 		collector:              collector,
+		tokenEngine:            engine,
+		tagDictionary:          dictionary,
+		attestation:            attestation,
 	}
 
 	return proxydata
@@ -285,6 +433,9 @@ func NewDefaultProxyEnforcer(serverID string,
 		procMountPoint,
 		defaultExternalIPCacheTimeout,
 		defaultPacketLogs,
+		tokens.JWTEngine,
+		nil,
+		nil,
 	)
 }
 
@@ -312,5 +463,15 @@ func (r *StatsServer) GetStats(req rpcwrapper.Request, resp *rpcwrapper.Response
 		r.collector.CollectUserEvent(record)
 	}
 
+	// The HTTP records are only delivered to the collectors that want them.
+	if httpCollector, ok := r.collector.(collector.HTTPCollector); ok {
+		for _, record := range payload.HTTPRecords {
+			httpCollector.CollectHTTPEvent(record)
+		}
+		for _, record := range payload.HTTPMetrics {
+			httpCollector.CollectHTTPMetrics(record)
+		}
+	}
+
 	return nil
 }
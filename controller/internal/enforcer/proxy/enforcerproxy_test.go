@@ -2,6 +2,7 @@ package enforcerproxy
 
 import (
 	"crypto/ecdsa"
+	"errors"
 	"testing"
 	"time"
 
@@ -11,8 +12,10 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/utils/rpcwrapper"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/processmon"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/remoteenforcer"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
 	"github.com/aporeto-inc/trireme-lib/policy"
 
 	mockrpcwrapper "github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/utils/rpcwrapper/mock"
@@ -141,6 +144,9 @@ func setupProxyEnforcer(rpchdl rpcwrapper.RPCClient, prochdl processmon.ProcessM
 		defaultExternalIPCacheTimeout,
 		nil,
 		false,
+		tokens.JWTEngine,
+		nil,
+		nil,
 	)
 	return policyEnf
 }
@@ -301,3 +307,96 @@ func TestUnenforce(t *testing.T) {
 		})
 	})
 }
+
+func TestIntrospection(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I try to start a proxy enforcer with two remote enforcers", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		prochdl := mockprocessmon.NewMockProcessManager(ctrl)
+		policyEnf := setupProxyEnforcer(rpchdl, prochdl).(*ProxyInfo)
+
+		policyEnf.initDone["pu1"] = true
+		policyEnf.initDone["pu2"] = true
+
+		respond := func(payload rpcwrapper.IntrospectionResponsePayload) func(string, string, *rpcwrapper.Request, *rpcwrapper.Response) {
+			return func(contextID string, method string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+				resp.Payload = payload
+			}
+		}
+
+		Convey("When I list the pus", func() {
+			rpchdl.EXPECT().RemoteCall("pu1", remoteenforcer.ListPUs, gomock.Any(), gomock.Any()).Times(1).
+				Do(respond(rpcwrapper.IntrospectionResponsePayload{PUs: []*introspection.PU{{ContextID: "pu1"}}})).Return(nil)
+			rpchdl.EXPECT().RemoteCall("pu2", remoteenforcer.ListPUs, gomock.Any(), gomock.Any()).Times(1).Return(errors.New("connection lost"))
+
+			pus, err := policyEnf.ListPUs()
+
+			Convey("Then I should get the pus of the remote enforcers that can be reached", func() {
+				So(err, ShouldBeNil)
+				So(len(pus), ShouldEqual, 1)
+				So(pus[0].ContextID, ShouldEqual, "pu1")
+				So(pus[0].Remote, ShouldBeTrue)
+			})
+		})
+
+		Convey("When I list the flows of one pu", func() {
+			flows := []*introspection.Flow{{ContextID: "pu2", Protocol: "tcp", Flow: "10.1.1.1:10.1.1.2:4000:80", State: "data"}}
+			rpchdl.EXPECT().RemoteCall("pu2", remoteenforcer.ListFlows, gomock.Any(), gomock.Any()).Times(1).
+				Do(respond(rpcwrapper.IntrospectionResponsePayload{Flows: flows})).Return(nil)
+
+			list, err := policyEnf.ListFlows("pu2")
+
+			Convey("Then I should only get the flows of its remote enforcer", func() {
+				So(err, ShouldBeNil)
+				So(list, ShouldResemble, flows)
+			})
+		})
+
+		Convey("When I list the flows of a pu without a remote enforcer", func() {
+			list, err := policyEnf.ListFlows("local")
+
+			Convey("Then I should get no flows", func() {
+				So(err, ShouldBeNil)
+				So(list, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the remote enforcer of a pu cannot be reached", func() {
+			rpchdl.EXPECT().RemoteCall("pu1", remoteenforcer.ListFlows, gomock.Any(), gomock.Any()).Times(1).Return(errors.New("connection lost"))
+
+			_, err := policyEnf.ListFlows("pu1")
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I simulate a flow of a pu", func() {
+			query := &introspection.Query{ContextID: "pu2", Direction: introspection.NetworkOriginated, Tags: []string{"app=web"}, Port: 80}
+			decision := &introspection.Decision{ContextID: "pu2", PolicyID: "web", Allowed: true}
+			rpchdl.EXPECT().RemoteCall("pu2", remoteenforcer.Simulate, gomock.Any(), gomock.Any()).Times(1).
+				Do(func(contextID string, method string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+					So(req.Payload.(*rpcwrapper.IntrospectionPayload).Query, ShouldEqual, query)
+					resp.Payload = rpcwrapper.IntrospectionResponsePayload{Decision: decision}
+				}).Return(nil)
+
+			d, err := policyEnf.Simulate(query)
+
+			Convey("Then I should get the decision of its remote enforcer", func() {
+				So(err, ShouldBeNil)
+				So(d, ShouldResemble, decision)
+			})
+		})
+
+		Convey("When I simulate a flow of a pu without a remote enforcer", func() {
+			d, err := policyEnf.Simulate(&introspection.Query{ContextID: "local", Direction: introspection.NetworkOriginated, Tags: []string{"app=web"}})
+
+			Convey("Then I should get no decision", func() {
+				So(err, ShouldBeNil)
+				So(d, ShouldBeNil)
+			})
+		})
+	})
+}
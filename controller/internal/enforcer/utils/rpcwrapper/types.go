@@ -5,7 +5,9 @@ import (
 
 	"github.com/aporeto-inc/trireme-lib/collector"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
 	"github.com/aporeto-inc/trireme-lib/policy"
 )
 
@@ -32,9 +34,10 @@ const (
 )
 
 //Response is the response for every RPC call. This is used to carry the status of the actual function call
-//made on the remote end
+//made on the remote end. Calls returning data carry it in the payload.
 type Response struct {
-	Status string
+	Status  string
+	Payload interface{}
 }
 
 //InitRequestPayload Payload for enforcer init request
@@ -46,6 +49,9 @@ type InitRequestPayload struct {
 	ServerID               string                `json:",omitempty"`
 	ExternalIPCacheTimeout time.Duration         `json:",omitempty"`
 	Secrets                secrets.PublicSecrets `json:",omitempty"`
+	TokenEngine            tokens.EngineType     `json:",omitempty"`
+	TagDictionary          []string              `json:",omitempty"`
+	Attestation            *policy.Attestation   `json:",omitempty"`
 }
 
 // UpdateSecretsPayload payload for the update secrets to remote enforcers
@@ -104,11 +110,26 @@ type UnEnforceResponsePayload struct {
 
 //StatsPayload is the payload carries by the stats reporting form the remote enforcer
 type StatsPayload struct {
-	Flows map[string]*collector.FlowRecord `json:",omitempty"`
-	Users map[string]*collector.UserRecord `json:",omitempty"`
+	Flows       map[string]*collector.FlowRecord        `json:",omitempty"`
+	Users       map[string]*collector.UserRecord        `json:",omitempty"`
+	HTTPRecords []*collector.HTTPRecord                 `json:",omitempty"`
+	HTTPMetrics map[string]*collector.HTTPMetricsRecord `json:",omitempty"`
 }
 
 //ExcludeIPRequestPayload carries the list of excluded ips
 type ExcludeIPRequestPayload struct {
 	IPs []string `json:",omitempty"`
 }
+
+//IntrospectionPayload selects the state returned by the introspection requests
+type IntrospectionPayload struct {
+	ContextID string               `json:",omitempty"`
+	Query     *introspection.Query `json:",omitempty"`
+}
+
+//IntrospectionResponsePayload carries the state held by the remote enforcer
+type IntrospectionResponsePayload struct {
+	PUs   []*introspection.PU   `json:",omitempty"`
+	Flows    []*introspection.Flow   `json:",omitempty"`
+	Decision *introspection.Decision `json:",omitempty"`
+}
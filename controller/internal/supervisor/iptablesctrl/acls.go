@@ -105,6 +105,12 @@ func (i *Instance) chainRules(appChain string, netChain string, port string, pro
 
 // proxyRules creates all the proxy specific rules.
 func (i *Instance) proxyRules(appChain string, netChain string, port string, proxyPort string, proxyPortSetName string) [][]string {
+
+	// The application proxy is only reachable over ipv4.
+	if i.ipv6 {
+		return [][]string{}
+	}
+
 	destSetName, srcSetName, srvSetName := i.getSetNames(proxyPortSetName)
 	return [][]string{
 		{
@@ -234,7 +240,7 @@ func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 	// Application Packets - SYN
 	rules = append(rules, []string{
 		i.appPacketIPTableContext, appChain,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetSetName, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueSynStr(),
 	})
@@ -242,14 +248,14 @@ func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 	// Application Packets - Evertyhing but SYN and SYN,ACK (first 4 packets). SYN,ACK is captured by global rule
 	rules = append(rules, []string{
 		i.appPacketIPTableContext, appChain,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetSetName, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "ACK",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr(),
 	})
 
 	rules = append(rules, []string{
 		i.appPacketIPTableContext, appChain,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetSetName, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr(),
 	})
@@ -257,18 +263,35 @@ func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 	// Network Packets - SYN
 	rules = append(rules, []string{
 		i.netPacketIPTableContext, netChain,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", i.targetSetName, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueSynStr(),
 	})
 	// Network Packets - Evertyhing but SYN and SYN,ACK (first 4 packets). SYN,ACK is captured by global rule
 	rules = append(rules, []string{
 		i.netPacketIPTableContext, netChain,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", i.targetSetName, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "ACK",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr(),
 	})
 
+	// Application Packets - UDP. Datagrams are captured until the flow is
+	// authorized and released by the connmark rule.
+	rules = append(rules, []string{
+		i.appPacketIPTableContext, appChain,
+		"-m", "set", "--match-set", i.targetSetName, "dst",
+		"-p", "udp",
+		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueSynStr(),
+	})
+
+	// Network Packets - UDP
+	rules = append(rules, []string{
+		i.netPacketIPTableContext, netChain,
+		"-m", "set", "--match-set", i.targetSetName, "src",
+		"-p", "udp",
+		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueSynStr(),
+	})
+
 	return rules
 }
 
@@ -548,7 +571,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 	// Accept established connections
 	if err := i.ipt.Append(
 		i.appPacketIPTableContext, chain,
-		"-d", "0.0.0.0/0",
+		"-d", i.allNetworks,
 		"-p", "udp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT"); err != nil {
 
@@ -557,7 +580,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 
 	if err := i.ipt.Append(
 		i.appPacketIPTableContext, chain,
-		"-d", "0.0.0.0/0",
+		"-d", i.allNetworks,
 		"-p", "tcp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT"); err != nil {
 
@@ -568,7 +591,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 	if err := i.ipt.Append(
 		i.appPacketIPTableContext,
 		chain,
-		"-d", "0.0.0.0/0",
+		"-d", i.allNetworks,
 		"-m", "state", "--state", "NEW",
 		"-j", "NFLOG", "--nflog-group", "10",
 		"--nflog-prefix", policy.DefaultLogPrefix(contextID),
@@ -579,7 +602,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 	// Drop everything else
 	if err := i.ipt.Append(
 		i.appPacketIPTableContext, chain,
-		"-d", "0.0.0.0/0",
+		"-d", i.allNetworks,
 		"-j", "DROP"); err != nil {
 
 		return fmt.Errorf("unable to add default drop acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
@@ -790,7 +813,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 	// Accept established connections
 	if err := i.ipt.Append(
 		i.netPacketIPTableContext, chain,
-		"-s", "0.0.0.0/0",
+		"-s", i.allNetworks,
 		"-p", "tcp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT",
 	); err != nil {
@@ -800,7 +823,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 
 	if err := i.ipt.Append(
 		i.netPacketIPTableContext, chain,
-		"-s", "0.0.0.0/0",
+		"-s", i.allNetworks,
 		"-p", "udp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT",
 	); err != nil {
@@ -812,7 +835,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 	if err := i.ipt.Append(
 		i.netPacketIPTableContext,
 		chain,
-		"-s", "0.0.0.0/0",
+		"-s", i.allNetworks,
 		"-m", "state", "--state", "NEW",
 		"-j", "NFLOG", "--nflog-group", "11",
 		"--nflog-prefix", policy.DefaultLogPrefix(contextID),
@@ -823,7 +846,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 	// Drop everything else
 	if err := i.ipt.Append(
 		i.netPacketIPTableContext, chain,
-		"-s", "0.0.0.0/0",
+		"-s", i.allNetworks,
 		"-j", "DROP",
 	); err != nil {
 
@@ -900,7 +923,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err = i.ipt.Insert(
 		i.appPacketIPTableContext,
 		appChain, 1,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetSetName, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetApplicationQueueSynAckStr())
 	if err != nil {
@@ -910,7 +933,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err = i.ipt.Insert(
 		i.appPacketIPTableContext,
 		appChain, 1,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetSetName, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "MARK", "--set-mark", strconv.Itoa(cgnetcls.Initialmarkval-1))
 	if err != nil {
@@ -940,7 +963,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err = i.ipt.Insert(
 		i.netPacketIPTableContext,
 		netChain, 1,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", i.targetSetName, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN", "--tcp-option",
 		"34", "-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetNetworkQueueSynStr())
 
@@ -951,7 +974,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err = i.ipt.Insert(
 		i.netPacketIPTableContext,
 		netChain, 1,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", i.targetSetName, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetNetworkQueueSynAckStr())
 
@@ -968,6 +991,10 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 		return fmt.Errorf("unable to add capture synack rule for table %s, chain %s: %s", i.appPacketIPTableContext, i.appPacketIPTableSection, err)
 	}
 
+	if i.ipv6 {
+		return nil
+	}
+
 	err = i.ipt.Insert(i.appProxyIPTableContext,
 		ipTableSectionPreRouting, 1,
 		"-j", natProxyInputChain)
@@ -1044,7 +1071,7 @@ func (i *Instance) CleanGlobalRules() error {
 	if err := i.ipt.Delete(
 		i.appPacketIPTableContext,
 		i.appPacketIPTableSection,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetSetName, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetApplicationQueueAckStr()); err != nil {
 		zap.L().Debug("Can not clear the SynAck packet capcture app chain", zap.Error(err))
@@ -1053,7 +1080,7 @@ func (i *Instance) CleanGlobalRules() error {
 	if err := i.ipt.Delete(
 		i.netPacketIPTableContext,
 		i.netPacketIPTableSection,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", i.targetSetName, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetNetworkQueueAckStr()); err != nil {
 		zap.L().Debug("Can not clear the SynAck packet capcture net chain", zap.Error(err))
@@ -1086,6 +1113,12 @@ func (i *Instance) CleanGlobalRules() error {
 // CleanAllSynAckPacketCaptures cleans the capture rules for SynAck packets irrespective of NFQUEUE
 func (i *Instance) CleanAllSynAckPacketCaptures() error {
 
+	if i.ipv6Instance != nil {
+		if err := i.ipv6Instance.CleanAllSynAckPacketCaptures(); err != nil {
+			zap.L().Debug("Can not clear the ipv6 SynAck packet captures", zap.Error(err))
+		}
+	}
+
 	if err := i.ipt.ClearChain(i.appPacketIPTableContext, i.appSynAckIPTableSection); err != nil {
 		zap.L().Debug("Can not clear the SynAck packet capcture app chain", zap.Error(err))
 	}
@@ -1182,6 +1215,10 @@ func (i *Instance) cleanACLs() error {
 	// Clean Application Rules/Chains
 	i.cleanACLSection(i.appPacketIPTableContext, i.netPacketIPTableSection, i.appPacketIPTableSection, ipTableSectionPreRouting, chainPrefix)
 
+	if i.ipv6 {
+		return nil
+	}
+
 	// Cannot clear chains in nat table there are masquerade rules in nat table which we don't want to touch
 	if err := i.removeProxyRules(i.appProxyIPTableContext,
 		i.appPacketIPTableContext,
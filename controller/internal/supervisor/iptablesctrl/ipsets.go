@@ -44,9 +44,14 @@ func (i *Instance) updateTargetNetworks(old, new []string) error {
 // createTargetSet creates a new target set
 func (i *Instance) createTargetSet(networks []string) error {
 
-	ips, err := i.ipset.NewIpset(targetNetworkSet, "hash:net", &ipset.Params{})
+	params := &ipset.Params{}
+	if i.ipv6 {
+		params.HashFamily = "inet6"
+	}
+
+	ips, err := i.ipset.NewIpset(i.targetSetName, "hash:net", params)
 	if err != nil {
-		return fmt.Errorf("unable to create ipset for %s: %s", targetNetworkSet, err)
+		return fmt.Errorf("unable to create ipset for %s: %s", i.targetSetName, err)
 	}
 
 	i.targetSet = ips
@@ -117,6 +122,10 @@ func (i *Instance) updateProxySet(policy *policy.PUPolicy, portSetName string) e
 		addresses := dependentService.NetworkInfo.Addresses
 		min, max := dependentService.NetworkInfo.Ports.Range()
 		for _, addr := range addresses {
+			// The proxy sets only hold ipv4 addresses.
+			if addr.IP.To4() == nil {
+				continue
+			}
 			for i := int(min); i <= int(max); i++ {
 				pair := addr.IP.To4().String() + "," + strconv.Itoa(i)
 				if err := vipTargetSet.Add(pair, 0); err != nil {
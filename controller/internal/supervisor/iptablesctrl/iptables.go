@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 
 	"github.com/aporeto-inc/trireme-lib/common"
 	"github.com/aporeto-inc/trireme-lib/controller/constants"
@@ -26,6 +27,8 @@ const (
 	appChainPrefix   = chainPrefix + "App-"
 	netChainPrefix   = chainPrefix + "Net-"
 	targetNetworkSet = "TargetNetSet"
+	// targetNetworkSetV6 is the target set for the IPv6 networks
+	targetNetworkSetV6 = "TargetNetSet6"
 	// PuPortSet The prefix for portset names
 	PuPortSet                = "PUPort-"
 	proxyPortSetPrefix       = "Proxy-"
@@ -56,6 +59,15 @@ type Instance struct {
 	appSynAckIPTableSection string
 	mode                    constants.ModeType
 	portSetInstance         portset.PortSet
+	targetSetName           string
+	allNetworks             string
+	// ipv6 is set for the instance programming ip6tables. It only handles
+	// the packet capture and ACLs. Sets and proxy rules are owned by the
+	// IPv4 instance.
+	ipv6 bool
+	// ipv6Instance is the companion instance for IPv6 traffic. It is nil
+	// if ip6tables is not available on the host.
+	ipv6Instance *Instance
 }
 
 // NewInstance creates a new iptables controller instance
@@ -71,10 +83,26 @@ func NewInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, portset por
 		return nil, fmt.Errorf("unable to initialize ipsets: %s", err)
 	}
 
+	i := newInstance(fqc, mode, portset, ipt, ips, false)
+
+	ip6t, err := provider.NewGoIP6TablesProvider()
+	if err != nil {
+		zap.L().Warn("Unable to initialize ip6tables provider, ipv6 traffic will not be enforced", zap.Error(err))
+		return i, nil
+	}
+
+	i.ipv6Instance = newInstance(fqc, mode, portset, ip6t, ips, true)
+
+	return i, nil
+}
+
+// newInstance creates an instance for a specific ip version.
+func newInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, portset portset.PortSet, ipt provider.IptablesProvider, ips provider.IpsetProvider, ipv6 bool) *Instance {
+
 	i := &Instance{
-		fqc:   fqc,
-		ipt:   ipt,
-		ipset: ips,
+		fqc:                     fqc,
+		ipt:                     ipt,
+		ipset:                   ips,
 		appPacketIPTableContext: "mangle",
 		netPacketIPTableContext: "mangle",
 		appProxyIPTableContext:  "nat",
@@ -84,10 +112,17 @@ func NewInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, portset por
 		appCgroupIPTableSection: ipTableSectionOutput,
 		netPacketIPTableSection: ipTableSectionInput,
 		appSynAckIPTableSection: ipTableSectionOutput,
+		targetSetName:           targetNetworkSet,
+		allNetworks:             "0.0.0.0/0",
+		ipv6:                    ipv6,
 	}
 
-	return i, nil
+	if ipv6 {
+		i.targetSetName = targetNetworkSetV6
+		i.allNetworks = "::/0"
+	}
 
+	return i
 }
 
 // chainPrefix returns the chain name for the specific PU.
@@ -142,18 +177,28 @@ func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *
 
 	proxySetName := puPortSetName(contextID, proxyPortSetPrefix)
 
-	// Create the proxy sets.
-	if err := i.createProxySets(proxySetName); err != nil {
-		return err
+	if !i.ipv6 {
+		// Create the proxy sets.
+		if err := i.createProxySets(proxySetName); err != nil {
+			return err
+		}
+
+		// Optionally create the UID set
+		if err := i.createUIDSets(contextID, containerInfo); err != nil {
+			return err
+		}
 	}
 
-	// Optionally create the UID set
-	if err := i.createUIDSets(contextID, containerInfo); err != nil {
+	// Install all the rules
+	if err := i.installRules(contextID, appChain, netChain, proxySetName, containerInfo); err != nil {
 		return err
 	}
 
-	// Install all the rules
-	return i.installRules(contextID, appChain, netChain, proxySetName, containerInfo)
+	if i.ipv6Instance != nil {
+		return i.ipv6Instance.ConfigureRules(version, contextID, containerInfo)
+	}
+
+	return nil
 }
 
 // DeleteRules implements the DeleteRules interface
@@ -174,6 +219,16 @@ func (i *Instance) DeleteRules(version int, contextID string, port string, mark
 		zap.L().Warn("Failed to clean container chains while deleting the rules", zap.Error(err))
 	}
 
+	if i.ipv6Instance != nil {
+		if err := i.ipv6Instance.DeleteRules(version, contextID, port, mark, uid, proxyPort); err != nil {
+			zap.L().Warn("Failed to clean ipv6 rules", zap.Error(err))
+		}
+	}
+
+	if i.ipv6 {
+		return nil
+	}
+
 	if uid != "" {
 		if err := i.deleteUIDSets(contextID, uid, mark); err != nil {
 			return err
@@ -226,7 +281,15 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 	}
 
 	// Delete the old chain to clean up
-	return i.deleteAllContainerChains(oldAppChain, oldNetChain)
+	if err := i.deleteAllContainerChains(oldAppChain, oldNetChain); err != nil {
+		return err
+	}
+
+	if i.ipv6Instance != nil {
+		return i.ipv6Instance.UpdateRules(version, contextID, containerInfo, oldContainerInfo)
+	}
+
+	return nil
 }
 
 // Run starts the iptables controller
@@ -241,6 +304,16 @@ func (i *Instance) Run(ctx context.Context) error {
 		return fmt.Errorf("Unable to initialize chains: %s", err)
 	}
 
+	if i.ipv6Instance != nil {
+		if err := i.ipv6Instance.cleanACLs(); err != nil {
+			zap.L().Warn("Unable to clean previous ipv6 acls while starting the supervisor", zap.Error(err))
+		}
+
+		if err := i.ipv6Instance.InitializeChains(); err != nil {
+			return fmt.Errorf("Unable to initialize ipv6 chains: %s", err)
+		}
+	}
+
 	go func() {
 		<-ctx.Done()
 		zap.L().Debug("Stop the supervisor")
@@ -256,6 +329,13 @@ func (i *Instance) Run(ctx context.Context) error {
 // CleanUp requires the implementor to clean up all ACLs
 func (i *Instance) CleanUp() error {
 
+	// The ipv6 rules must go first since they reference the sets destroyed below.
+	if i.ipv6Instance != nil {
+		if err := i.ipv6Instance.cleanACLs(); err != nil {
+			zap.L().Error("Failed to clean ipv6 acls while stopping the supervisor", zap.Error(err))
+		}
+	}
+
 	if err := i.cleanACLs(); err != nil {
 		zap.L().Error("Failed to clean acls while stopping the supervisor", zap.Error(err))
 	}
@@ -271,9 +351,24 @@ func (i *Instance) CleanUp() error {
 func (i *Instance) SetTargetNetworks(current, networks []string) error {
 
 	if len(networks) == 0 {
-		networks = []string{"0.0.0.0/1", "128.0.0.0/1"}
+		networks = []string{"0.0.0.0/1", "128.0.0.0/1", "::/1", "8000::/1"}
+	}
+
+	currentV4, currentV6 := splitNetworks(current)
+	networksV4, networksV6 := splitNetworks(networks)
+
+	if i.ipv6Instance != nil {
+		if err := i.ipv6Instance.setTargetNetworks(currentV6, networksV6); err != nil {
+			return err
+		}
 	}
 
+	return i.setTargetNetworks(currentV4, networksV4)
+}
+
+// setTargetNetworks updates the target networks of a single ip version.
+func (i *Instance) setTargetNetworks(current, networks []string) error {
+
 	// Cleanup old ACLs
 	if len(current) > 0 {
 		return i.updateTargetNetworks(current, networks)
@@ -301,6 +396,10 @@ func (i *Instance) InitializeChains() error {
 		}
 	}
 
+	if i.ipv6 {
+		return i.insertUIDChain()
+	}
+
 	if err := i.ipt.NewChain(i.appProxyIPTableContext, natProxyInputChain); err != nil {
 		return err
 	}
@@ -317,6 +416,12 @@ func (i *Instance) InitializeChains() error {
 		return err
 	}
 
+	return i.insertUIDChain()
+}
+
+// insertUIDChain jumps to the uid chain for Linux processes.
+func (i *Instance) insertUIDChain() error {
+
 	if i.mode == constants.LocalServer {
 		if err := i.ipt.Insert(i.appPacketIPTableContext, i.appPacketIPTableSection, 1, "-j", uidchain); err != nil {
 			return err
@@ -350,6 +455,10 @@ func (i *Instance) configureLinuxRules(contextID, appChain, netChain, proxyPortS
 	portSetName := ""
 	if uid != "" {
 		portSetName = puPortSetName(contextID, PuPortSet)
+		// The portset is shared with the ipv4 instance that already programs it.
+		if i.ipv6 {
+			return i.addChainRules(portSetName, appChain, netChain, port, mark, uid, proxyPort, proxyPortSetName)
+		}
 		// update the portset cache, so that it can program the portset
 		if i.portSetInstance == nil {
 			return errors.New("enforcer portset instance cannot be nil for host")
@@ -408,8 +517,10 @@ func (i *Instance) deleteProxySets(proxyPortSetName string) error {
 func (i *Instance) installRules(contextID, appChain, netChain, proxySetName string, containerInfo *policy.PUInfo) error {
 	policyrules := containerInfo.Policy
 
-	if err := i.updateProxySet(containerInfo.Policy, proxySetName); err != nil {
-		return err
+	if !i.ipv6 {
+		if err := i.updateProxySet(containerInfo.Policy, proxySetName); err != nil {
+			return err
+		}
 	}
 
 	// Install the PU specific chain first.
@@ -435,13 +546,58 @@ func (i *Instance) installRules(contextID, appChain, netChain, proxySetName stri
 		return err
 	}
 
-	if err := i.addAppACLs(contextID, appChain, policyrules.ApplicationACLs()); err != nil {
+	if err := i.addAppACLs(contextID, appChain, i.filterRules(policyrules.ApplicationACLs())); err != nil {
 		return err
 	}
 
-	if err := i.addNetACLs(contextID, netChain, policyrules.NetworkACLs()); err != nil {
+	if err := i.addNetACLs(contextID, netChain, i.filterRules(policyrules.NetworkACLs())); err != nil {
 		return err
 	}
 
-	return i.addExclusionACLs(appChain, netChain, policyrules.ExcludedNetworks())
+	return i.addExclusionACLs(appChain, netChain, i.filterNetworks(policyrules.ExcludedNetworks()))
+}
+
+// filterRules returns the rules matching the ip version of the instance.
+func (i *Instance) filterRules(rules policy.IPRuleList) policy.IPRuleList {
+
+	filtered := policy.IPRuleList{}
+	for _, rule := range rules {
+		if isIPv6Network(rule.Address) == i.ipv6 {
+			filtered = append(filtered, rule)
+		}
+	}
+
+	return filtered
+}
+
+// filterNetworks returns the networks matching the ip version of the instance.
+func (i *Instance) filterNetworks(networks []string) []string {
+
+	filtered := []string{}
+	for _, network := range networks {
+		if isIPv6Network(network) == i.ipv6 {
+			filtered = append(filtered, network)
+		}
+	}
+
+	return filtered
+}
+
+// splitNetworks splits a list of networks into ipv4 and ipv6 networks.
+func splitNetworks(networks []string) (v4 []string, v6 []string) {
+
+	for _, network := range networks {
+		if isIPv6Network(network) {
+			v6 = append(v6, network)
+		} else {
+			v4 = append(v4, network)
+		}
+	}
+
+	return v4, v6
+}
+
+// isIPv6Network returns true if the address or cidr is an ipv6 one.
+func isIPv6Network(network string) bool {
+	return strings.Contains(network, ":")
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/internal/supervisor/provider"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
 	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/bvandewalle/go-ipset/ipset"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -50,6 +51,7 @@ func TestConfigureRules(t *testing.T) {
 		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
+		i.ipv6Instance = nil
 
 		rules := policy.IPRuleList{
 			policy.IPRule{
@@ -220,6 +222,7 @@ func TestDeleteRules(t *testing.T) {
 		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
+		i.ipv6Instance = nil
 
 		Convey("I try to delete with a valid default IP address ", func() {
 			iptables.MockDelete(t, func(table string, chain string, rulespec ...string) error {
@@ -243,6 +246,7 @@ func TestUpdateRules(t *testing.T) {
 		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
+		i.ipv6Instance = nil
 
 		rules := policy.IPRuleList{
 			policy.IPRule{
@@ -348,6 +352,7 @@ func TestStart(t *testing.T) {
 		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
+		i.ipv6Instance = nil
 
 		Convey("When I start the controller and I can insert the right rules", func() {
 			iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
@@ -369,3 +374,130 @@ func TestStart(t *testing.T) {
 		})
 	})
 }
+
+func TestIPv6Instance(t *testing.T) {
+	Convey("Given an iptables controller with an ipv6 companion", t, func() {
+		iptables := provider.NewTestIptablesProvider()
+		ip6tables := provider.NewTestIptablesProvider()
+		ipsets := provider.NewTestIpsetProvider()
+
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), iptables, ipsets, false)
+		i.ipv6Instance = newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), ip6tables, ipsets, true)
+
+		So(i.ipv6Instance.targetSetName, ShouldEqual, targetNetworkSetV6)
+		So(i.ipv6Instance.allNetworks, ShouldEqual, "::/0")
+
+		Convey("When I set the default target networks, each ip version should get its own set", func() {
+			added := map[string][]string{}
+			ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+				if name == targetNetworkSetV6 && p.HashFamily != "inet6" {
+					return nil, errors.New("wrong family")
+				}
+				testset := provider.NewTestIpset()
+				testset.MockAdd(t, func(entry string, timeout int) error {
+					added[name] = append(added[name], entry)
+					return nil
+				})
+				return testset, nil
+			})
+			iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+				if matchSpec(targetNetworkSetV6, rulespec) == nil {
+					return errors.New("ipv6 target set used in iptables")
+				}
+				return nil
+			})
+			ip6tables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+				if table == "nat" {
+					return errors.New("nat rules are not supported for ipv6")
+				}
+				if matchSpec(targetNetworkSet, rulespec) == nil {
+					return errors.New("ipv4 target set used in ip6tables")
+				}
+				return nil
+			})
+
+			err := i.SetTargetNetworks([]string{}, []string{})
+			So(err, ShouldBeNil)
+			So(added[targetNetworkSet], ShouldResemble, []string{"0.0.0.0/1", "128.0.0.0/1"})
+			So(added[targetNetworkSetV6], ShouldResemble, []string{"::/1", "8000::/1"})
+		})
+
+		Convey("When I configure rules with mixed ACLs, each ip version should only get its own rules", func() {
+			rules := policy.IPRuleList{
+				policy.IPRule{
+					Address:  "192.30.253.0/24",
+					Port:     "443",
+					Protocol: "TCP",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept},
+				},
+				policy.IPRule{
+					Address:  "2001:db8::/32",
+					Port:     "443",
+					Protocol: "TCP",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept},
+				},
+			}
+
+			ipl := policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.1"}
+			policyrules := policy.NewPUPolicy("Context",
+				policy.Police,
+				rules,
+				rules,
+				nil,
+				nil,
+				nil,
+				nil,
+				ipl,
+				[]string{"172.17.0.0/24"},
+				[]string{"10.0.0.0/8", "fd00::/8"},
+				&policy.ProxiedServicesInfo{},
+				nil,
+				nil,
+				[]string{})
+
+			containerinfo := policy.NewPUInfo("Context", common.ContainerPU)
+			containerinfo.Policy = policyrules
+			containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+			ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+				return nil, errors.New("sets must not be created by the ipv6 instance")
+			})
+
+			ip6rules := [][]string{}
+			record := func(table string, chain string, rulespec ...string) error {
+				if table == "nat" {
+					return errors.New("nat rules are not supported for ipv6")
+				}
+				ip6rules = append(ip6rules, rulespec)
+				return nil
+			}
+			ip6tables.MockAppend(t, record)
+			ip6tables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+				return record(table, chain, rulespec...)
+			})
+			ip6tables.MockNewChain(t, func(table string, chain string) error {
+				return nil
+			})
+
+			err := i.ipv6Instance.ConfigureRules(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+
+			for _, rule := range ip6rules {
+				So(matchSpec("192.30.253.0/24", rule), ShouldNotBeNil)
+				So(matchSpec("10.0.0.0/8", rule), ShouldNotBeNil)
+				So(matchSpec("0.0.0.0/0", rule), ShouldNotBeNil)
+				So(matchSpec(targetNetworkSet, rule), ShouldNotBeNil)
+			}
+
+			found := map[string]bool{}
+			for _, rule := range ip6rules {
+				for _, term := range []string{"2001:db8::/32", "fd00::/8", "::/0", targetNetworkSetV6} {
+					if matchSpec(term, rule) == nil {
+						found[term] = true
+					}
+				}
+			}
+			So(found, ShouldResemble, map[string]bool{"2001:db8::/32": true, "fd00::/8": true, "::/0": true, targetNetworkSetV6: true})
+		})
+	})
+}
@@ -26,3 +26,9 @@ type IptablesProvider interface {
 func NewGoIPTablesProvider() (IptablesProvider, error) {
 	return iptables.New()
 }
+
+// NewGoIP6TablesProvider returns an IptablesProvider interface based on the go-iptables
+// external package that programs the ip6tables rules.
+func NewGoIP6TablesProvider() (IptablesProvider, error) {
+	return iptables.NewWithProtocol(iptables.ProtocolIPv6)
+}
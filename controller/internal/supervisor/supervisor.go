@@ -156,7 +156,7 @@ func (s *Config) SetTargetNetworks(networks []string) error {
 
 	// If there are no target networks, capture all traffic
 	if len(networks) == 0 {
-		networks = []string{"0.0.0.0/1", "128.0.0.0/1"}
+		networks = []string{"0.0.0.0/1", "128.0.0.0/1", "::/1", "8000::/1"}
 	}
 	s.triremeNetworks = networks
 
@@ -8,6 +8,8 @@ import (
 	context "context"
 	reflect "reflect"
 
+	introspection "github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
+	revocation "github.com/aporeto-inc/trireme-lib/controller/pkg/revocation"
 	secrets "github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
 	policy "github.com/aporeto-inc/trireme-lib/policy"
 	gomock "github.com/golang/mock/gomock"
@@ -124,6 +126,20 @@ func (mr *MockTriremeControllerMockRecorder) UpdateSecrets(secrets interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateSecrets", reflect.TypeOf((*MockTriremeController)(nil).UpdateSecrets), secrets)
 }
 
+// UpdateRevocationList mocks base method
+// nolint
+func (m *MockTriremeController) UpdateRevocationList(l *revocation.List) error {
+	ret := m.ctrl.Call(m, "UpdateRevocationList", l)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateRevocationList indicates an expected call of UpdateRevocationList
+// nolint
+func (mr *MockTriremeControllerMockRecorder) UpdateRevocationList(l interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateRevocationList", reflect.TypeOf((*MockTriremeController)(nil).UpdateRevocationList), l)
+}
+
 // UpdateConfiguration mocks base method
 // nolint
 func (m *MockTriremeController) UpdateConfiguration(networks []string) error {
@@ -137,3 +153,48 @@ func (m *MockTriremeController) UpdateConfiguration(networks []string) error {
 func (mr *MockTriremeControllerMockRecorder) UpdateConfiguration(networks interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateConfiguration", reflect.TypeOf((*MockTriremeController)(nil).UpdateConfiguration), networks)
 }
+
+// ListPUs mocks base method
+// nolint
+func (m *MockTriremeController) ListPUs() ([]*introspection.PU, error) {
+	ret := m.ctrl.Call(m, "ListPUs")
+	ret0, _ := ret[0].([]*introspection.PU)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListPUs indicates an expected call of ListPUs
+// nolint
+func (mr *MockTriremeControllerMockRecorder) ListPUs() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPUs", reflect.TypeOf((*MockTriremeController)(nil).ListPUs))
+}
+
+// ListFlows mocks base method
+// nolint
+func (m *MockTriremeController) ListFlows(contextID string) ([]*introspection.Flow, error) {
+	ret := m.ctrl.Call(m, "ListFlows", contextID)
+	ret0, _ := ret[0].([]*introspection.Flow)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListFlows indicates an expected call of ListFlows
+// nolint
+func (mr *MockTriremeControllerMockRecorder) ListFlows(contextID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListFlows", reflect.TypeOf((*MockTriremeController)(nil).ListFlows), contextID)
+}
+
+// Simulate mocks base method
+// nolint
+func (m *MockTriremeController) Simulate(query *introspection.Query) (*introspection.Decision, error) {
+	ret := m.ctrl.Call(m, "Simulate", query)
+	ret0, _ := ret[0].(*introspection.Decision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Simulate indicates an expected call of Simulate
+// nolint
+func (mr *MockTriremeControllerMockRecorder) Simulate(query interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Simulate", reflect.TypeOf((*MockTriremeController)(nil).Simulate), query)
+}
@@ -0,0 +1,78 @@
+package apiauth
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// Request holds the attributes of a request that are authorized by the rules.
+type Request struct {
+	// UserAttributes are the attributes of the user, as in email=user@example.com.
+	UserAttributes []string
+	// Profile is the list of tags of the source PU.
+	Profile []string
+	// Scopes is the list of scopes of the source PU.
+	Scopes []string
+	// Header is the header of the HTTP request.
+	Header http.Header
+}
+
+// Rule is the compiled authorization of an HTTP rule.
+type Rule struct {
+	// ID identifies the rule in the flow records. It is the ID of the HTTP
+	// rule or the text of its authorization if the rule has no ID.
+	ID string
+
+	authorization expression
+}
+
+// NewRule compiles the authorization of an HTTP rule. The scopes of the rule
+// are alternatives. When the rule also has an expression, both the scopes and
+// the expression must hold.
+func NewRule(rule *policy.HTTPRule) (*Rule, error) {
+
+	var authorization expression
+
+	if len(rule.Scopes) > 0 {
+		scopes := make([]expression, len(rule.Scopes))
+		for i, scope := range rule.Scopes {
+			scopes[i] = &termExpression{value: scope}
+		}
+		authorization = newOrExpression(scopes)
+	}
+
+	if rule.Expression != "" {
+		e, err := parse(rule.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression %q: %s", rule.Expression, err)
+		}
+		if authorization == nil {
+			authorization = e
+		} else {
+			authorization = &andExpression{operands: []expression{authorization, e}}
+		}
+	}
+
+	id := rule.ID
+	if id == "" && authorization != nil {
+		id = authorization.String()
+	}
+
+	return &Rule{
+		ID:            id,
+		authorization: authorization,
+	}, nil
+}
+
+// Authorize returns true if the rule authorizes the request. Rules without
+// scopes and expression do not authorize any request.
+func (r *Rule) Authorize(req *Request) bool {
+
+	if r.authorization == nil {
+		return false
+	}
+
+	return r.authorization.evaluate(req)
+}
@@ -0,0 +1,161 @@
+package apiauth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testRequest() *Request {
+	return &Request{
+		UserAttributes: []string{"email=alice@example.com", "group=eng-platform", "group=oncall"},
+		Profile:        []string{"app=frontend", "env=prod"},
+		Scopes:         []string{"data=read"},
+		Header: http.Header{
+			"X-Env":   []string{"prod"},
+			"X-Debug": []string{""},
+		},
+	}
+}
+
+func TestExpressions(t *testing.T) {
+
+	Convey("Given a request", t, func() {
+		req := testRequest()
+
+		tests := map[string]bool{
+			"group=eng-*":                    true,
+			"group=sales-*":                  false,
+			"app=frontend":                   true,
+			"data=read":                      true,
+			"user:email=*@example.com":       true,
+			"user:app=frontend":              false,
+			"source:app=front*":              true,
+			"source:group=eng-*":             false,
+			"scope:data=*":                   true,
+			"scope:app=frontend":             false,
+			"header:X-Env=prod":              true,
+			"header:x-env=dev":               false,
+			"header:X-Debug":                 true,
+			"header:X-Trace":                 false,
+			"group=eng-* and env=prod":       true,
+			"group=eng-* AND env=dev":        false,
+			"group=sales-* or env=prod":      true,
+			"not group=sales-*":              true,
+			"!group=eng-*":                   false,
+			"group=eng-*&&!header:X-Env=dev": true,
+			"group=sales || group=oncall":    true,
+			"(group=sales or group=eng-*) and not app=backend": true,
+			"group=sales or group=eng-* and app=backend":       false,
+			"not (group=eng-* and env=prod)":                   false,
+			"\"user:email=alice@example.com\"":                 true,
+			"*":                                                true,
+		}
+
+		for expression, expected := range tests {
+			e, err := parse(expression)
+			So(err, ShouldBeNil)
+			So(e.evaluate(req), ShouldEqual, expected)
+		}
+	})
+
+	Convey("Given invalid expressions", t, func() {
+		for _, expression := range []string{
+			"",
+			"group=eng and",
+			"(group=eng",
+			"group=eng)",
+			"and group=eng",
+			"not",
+			"\"group=eng",
+			"header:=prod",
+			"user:",
+		} {
+			_, err := parse(expression)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Given parsed expressions", t, func() {
+		Convey("Then their text should be normalized", func() {
+			e, err := parse("(a=1 || b=2) && !header:X-Env=dev")
+			So(err, ShouldBeNil)
+			So(e.String(), ShouldEqual, "(a=1 or b=2) and not header:X-Env=dev")
+
+			e, err = parse("\"user:name=Alice Smith\" or not (a=1 and b=2)")
+			So(err, ShouldBeNil)
+			So(e.String(), ShouldEqual, "\"user:name=Alice Smith\" or not (a=1 and b=2)")
+		})
+	})
+}
+
+func TestMatch(t *testing.T) {
+
+	Convey("Given patterns", t, func() {
+		So(match("abc", "abc"), ShouldBeTrue)
+		So(match("abc", "abcd"), ShouldBeFalse)
+		So(match("a*", "a"), ShouldBeTrue)
+		So(match("a*c", "abbbc"), ShouldBeTrue)
+		So(match("a*c", "abbbd"), ShouldBeFalse)
+		So(match("*@example.com", "alice@example.com"), ShouldBeTrue)
+		So(match("*a*b*", "xxaxxbxx"), ShouldBeTrue)
+		So(match("*a*b", "xxbxxa"), ShouldBeFalse)
+		So(match("**", ""), ShouldBeTrue)
+		So(match("", "a"), ShouldBeFalse)
+	})
+}
+
+func TestNewRule(t *testing.T) {
+
+	Convey("Given a request", t, func() {
+		req := testRequest()
+
+		Convey("Then the scopes of a rule should be alternatives matched literally", func() {
+			rule, err := NewRule(&policy.HTTPRule{Scopes: []string{"app=backend", "env=prod"}})
+			So(err, ShouldBeNil)
+			So(rule.Authorize(req), ShouldBeTrue)
+			So(rule.ID, ShouldEqual, "app=backend or env=prod")
+
+			rule, err = NewRule(&policy.HTTPRule{Scopes: []string{"env=*"}})
+			So(err, ShouldBeNil)
+			So(rule.Authorize(req), ShouldBeFalse)
+		})
+
+		Convey("Then both the scopes and the expression of a rule should hold", func() {
+			rule, err := NewRule(&policy.HTTPRule{
+				Scopes:     []string{"env=prod"},
+				Expression: "group=eng-* and not header:X-Env=dev",
+			})
+			So(err, ShouldBeNil)
+			So(rule.Authorize(req), ShouldBeTrue)
+			So(rule.ID, ShouldEqual, "env=prod and (group=eng-* and not header:X-Env=dev)")
+
+			rule, err = NewRule(&policy.HTTPRule{
+				Scopes:     []string{"env=prod"},
+				Expression: "group=sales-*",
+			})
+			So(err, ShouldBeNil)
+			So(rule.Authorize(req), ShouldBeFalse)
+		})
+
+		Convey("Then a rule with only an expression should be authorized by the expression", func() {
+			rule, err := NewRule(&policy.HTTPRule{ID: "eng", Expression: "group=eng-*"})
+			So(err, ShouldBeNil)
+			So(rule.Authorize(req), ShouldBeTrue)
+			So(rule.ID, ShouldEqual, "eng")
+		})
+
+		Convey("Then a rule without scopes and expression should not authorize anything", func() {
+			rule, err := NewRule(&policy.HTTPRule{})
+			So(err, ShouldBeNil)
+			So(rule.Authorize(req), ShouldBeFalse)
+		})
+
+		Convey("Then a rule with an invalid expression should be rejected", func() {
+			_, err := NewRule(&policy.HTTPRule{Expression: "group=eng-* or"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,434 @@
+package apiauth
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The expressions combine terms with and, or, not and parentheses. The
+// operators can also be written &&, || and !. A term matches an attribute
+// with a pattern where * matches any sequence of characters:
+//
+//   group=eng-*                  any user attribute, PU tag or scope
+//   user:email=*@example.com     the user attributes only
+//   source:app=frontend          the tags of the source PU only
+//   scope:data=read              the scopes of the source PU only
+//   header:X-Env=prod            the values of a request header
+//   header:X-Debug               the presence of a request header
+//
+// Terms with spaces or parentheses are written between double quotes.
+
+// Sources of the attributes of the terms.
+const (
+	sourceAny       = ""
+	sourceUser      = "user"
+	sourcePU        = "source"
+	sourceScope     = "scope"
+	sourceHeader    = "header"
+	sourceSeparator = ":"
+)
+
+// expression is a compiled authorization expression.
+type expression interface {
+	evaluate(req *Request) bool
+	String() string
+}
+
+type andExpression struct {
+	operands []expression
+}
+
+func (e *andExpression) evaluate(req *Request) bool {
+	for _, o := range e.operands {
+		if !o.evaluate(req) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *andExpression) String() string {
+	return join(e.operands, " and ")
+}
+
+type orExpression struct {
+	operands []expression
+}
+
+// newOrExpression returns the alternative of the operands.
+func newOrExpression(operands []expression) expression {
+	if len(operands) == 1 {
+		return operands[0]
+	}
+	return &orExpression{operands: operands}
+}
+
+func (e *orExpression) evaluate(req *Request) bool {
+	for _, o := range e.operands {
+		if o.evaluate(req) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *orExpression) String() string {
+	return join(e.operands, " or ")
+}
+
+type notExpression struct {
+	operand expression
+}
+
+func (e *notExpression) evaluate(req *Request) bool {
+	return !e.operand.evaluate(req)
+}
+
+func (e *notExpression) String() string {
+	return "not " + group(e.operand)
+}
+
+// termExpression matches the attributes of a source with a value. The value
+// is a pattern unless the term is a literal scope of a rule.
+type termExpression struct {
+	source  string
+	value   string
+	pattern bool
+}
+
+func (e *termExpression) evaluate(req *Request) bool {
+
+	switch e.source {
+	case sourceUser:
+		return e.matchAny(req.UserAttributes)
+	case sourcePU:
+		return e.matchAny(req.Profile)
+	case sourceScope:
+		return e.matchAny(req.Scopes)
+	case sourceHeader:
+		name, value, hasValue := splitAttribute(e.value)
+		values, ok := req.Header[http.CanonicalHeaderKey(name)]
+		if !hasValue {
+			return ok
+		}
+		for _, v := range values {
+			if match(value, v) {
+				return true
+			}
+		}
+		return false
+	default:
+		return e.matchAny(req.UserAttributes) || e.matchAny(req.Profile) || e.matchAny(req.Scopes)
+	}
+}
+
+func (e *termExpression) matchAny(attributes []string) bool {
+	for _, a := range attributes {
+		if e.pattern && match(e.value, a) || !e.pattern && e.value == a {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *termExpression) String() string {
+	s := e.value
+	if e.source != sourceAny {
+		s = e.source + sourceSeparator + s
+	}
+	if strings.ContainsAny(s, " \t()\"!&|") || isOperator(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// parse compiles an expression.
+func parse(s string) (expression, error) {
+
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s", p.tokens[p.pos].text)
+	}
+
+	return e, nil
+}
+
+type tokenKind int
+
+const (
+	tokenTerm tokenKind = iota
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// tokenize splits an expression in tokens.
+func tokenize(s string) ([]token, error) {
+
+	tokens := []token{}
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenOpen, text: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenClose, text: ")"})
+			i++
+		case c == '!':
+			tokens = append(tokens, token{kind: tokenNot, text: "!"})
+			i++
+		case strings.HasPrefix(s[i:], "&&"):
+			tokens = append(tokens, token{kind: tokenAnd, text: "&&"})
+			i += 2
+		case strings.HasPrefix(s[i:], "||"):
+			tokens = append(tokens, token{kind: tokenOr, text: "||"})
+			i += 2
+		case c == '"':
+			end := i + 1
+			for ; end < len(s) && s[end] != '"'; end++ {
+				if s[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated quoted term")
+			}
+			text, err := strconv.Unquote(s[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted term: %s", err)
+			}
+			tokens = append(tokens, token{kind: tokenTerm, text: text})
+			i = end + 1
+		default:
+			end := i
+			for end < len(s) && !isDelimiter(s[end:]) {
+				end++
+			}
+			word := s[i:end]
+			switch strings.ToLower(word) {
+			case "and":
+				tokens = append(tokens, token{kind: tokenAnd, text: word})
+			case "or":
+				tokens = append(tokens, token{kind: tokenOr, text: word})
+			case "not":
+				tokens = append(tokens, token{kind: tokenNot, text: word})
+			default:
+				tokens = append(tokens, token{kind: tokenTerm, text: word})
+			}
+			i = end
+		}
+	}
+
+	return tokens, nil
+}
+
+// parser is a recursive descent parser of the expressions. The not operator
+// binds tighter than and, which binds tighter than or.
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) next(kind tokenKind) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == kind {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (expression, error) {
+
+	operands := []expression{}
+	for {
+		e, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, e)
+		if !p.next(tokenOr) {
+			break
+		}
+	}
+
+	return newOrExpression(operands), nil
+}
+
+func (p *parser) parseAnd() (expression, error) {
+
+	operands := []expression{}
+	for {
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, e)
+		if !p.next(tokenAnd) {
+			break
+		}
+	}
+
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+
+	return &andExpression{operands: operands}, nil
+}
+
+func (p *parser) parseNot() (expression, error) {
+
+	if p.next(tokenNot) {
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpression{operand: e}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (expression, error) {
+
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	t := p.tokens[p.pos]
+	switch t.kind {
+	case tokenOpen:
+		p.pos++
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.next(tokenClose) {
+			return nil, fmt.Errorf("missing )")
+		}
+		return e, nil
+	case tokenTerm:
+		p.pos++
+		return newTermExpression(t.text)
+	default:
+		return nil, fmt.Errorf("unexpected %s", t.text)
+	}
+}
+
+// newTermExpression compiles a term.
+func newTermExpression(s string) (expression, error) {
+
+	term := &termExpression{value: s, pattern: true}
+
+	if i := strings.Index(s, sourceSeparator); i > 0 {
+		switch source := s[:i]; source {
+		case sourceUser, sourcePU, sourceScope, sourceHeader:
+			term.source = source
+			term.value = s[i+1:]
+		}
+	}
+
+	if term.value == "" {
+		return nil, fmt.Errorf("empty term %q", s)
+	}
+
+	if term.source == sourceHeader {
+		if name, _, _ := splitAttribute(term.value); name == "" {
+			return nil, fmt.Errorf("missing header name in %q", s)
+		}
+	}
+
+	return term, nil
+}
+
+// splitAttribute splits an attribute in its key and value.
+func splitAttribute(s string) (string, string, bool) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+1:], true
+}
+
+// match returns true if the value matches the pattern. A * in the pattern
+// matches any sequence of characters.
+func match(pattern, value string) bool {
+
+	// Positions to resume from after the last * when a match fails.
+	star, next := -1, 0
+
+	p, v := 0, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, v
+			p++
+		case p < len(pattern) && pattern[p] == value[v]:
+			p++
+			v++
+		case star >= 0:
+			next++
+			p, v = star+1, next
+		default:
+			return false
+		}
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
+
+// isDelimiter returns true if a word ends at the start of s.
+func isDelimiter(s string) bool {
+	return strings.ContainsRune(" \t\n()", rune(s[0])) || strings.HasPrefix(s, "&&") || strings.HasPrefix(s, "||")
+}
+
+// isOperator returns true if a word is an operator.
+func isOperator(s string) bool {
+	switch strings.ToLower(s) {
+	case "and", "or", "not":
+		return true
+	}
+	return false
+}
+
+// group returns the text of an expression in parentheses if it has operators.
+func group(e expression) string {
+	switch e.(type) {
+	case *andExpression, *orExpression:
+		return "(" + e.String() + ")"
+	default:
+		return e.String()
+	}
+}
+
+func join(operands []expression, operator string) string {
+	parts := make([]string, len(operands))
+	for i, o := range operands {
+		parts[i] = group(o)
+	}
+	return strings.Join(parts, operator)
+}
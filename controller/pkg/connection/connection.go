@@ -2,11 +2,14 @@ package connection
 
 import (
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/encryption"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
@@ -15,6 +18,9 @@ import (
 // TCPFlowState identifies the constants of the state of a TCP connectioncon
 type TCPFlowState int
 
+// UDPFlowState identifies the constants of the state of a UDP flow
+type UDPFlowState int
+
 // ProxyConnState identifies the constants of the state of a proxied connection
 type ProxyConnState int
 
@@ -45,6 +51,63 @@ const (
 	UnknownState
 )
 
+// String returns the name of the state
+func (s TCPFlowState) String() string {
+
+	switch s {
+	case TCPSynSend:
+		return "synsend"
+	case TCPSynReceived:
+		return "synreceived"
+	case TCPSynAckSend:
+		return "synacksend"
+	case TCPSynAckReceived:
+		return "synackreceived"
+	case TCPAckSend:
+		return "acksend"
+	case TCPAckProcessed:
+		return "ackprocessed"
+	case TCPData:
+		return "data"
+	case UnknownState:
+		return "unknown"
+	}
+
+	return strconv.Itoa(int(s))
+}
+
+const (
+
+	// UDPSynSend is the state where datagrams are sent with our token, but no reply token has been received
+	UDPSynSend UDPFlowState = iota
+
+	// UDPSynReceived indicates that a datagram with a valid token has been received
+	UDPSynReceived
+
+	// UDPSynAckSend indicates that reply datagrams are sent with our reply token
+	UDPSynAckSend
+
+	// UDPData indicates that the flow is authorized and datagrams carry no tokens
+	UDPData
+)
+
+// String returns the name of the state
+func (s UDPFlowState) String() string {
+
+	switch s {
+	case UDPSynSend:
+		return "synsend"
+	case UDPSynReceived:
+		return "synreceived"
+	case UDPSynAckSend:
+		return "synacksend"
+	case UDPData:
+		return "data"
+	}
+
+	return strconv.Itoa(int(s))
+}
+
 const (
 	// ClientTokenSend Init token send for client
 	ClientTokenSend ProxyConnState = iota
@@ -116,6 +179,28 @@ type TCPConnection struct {
 
 	// PacketFlowPolicy holds the last matched actual policy
 	PacketFlowPolicy *policy.FlowPolicy
+
+	// EphemeralKey is the local key of the key agreement of the connection
+	EphemeralKey *encryption.EphemeralKey
+
+	// Session encrypts the payload of the connection when its policy
+	// requires encryption. It is nil for connections in clear text.
+	Session *encryption.Session
+
+	// AcceptedRecord is the flow record reported when the connection was
+	// accepted. It is used to report the termination of the flow.
+	AcceptedRecord *collector.FlowRecord
+
+	// RateCounted indicates that the connection was already counted by the
+	// connection rate limit of its policy. Retransmitted handshake packets
+	// are not counted again.
+	RateCounted bool
+
+	// startTime is the time the connection was created
+	startTime time.Time
+
+	// terminated indicates that the termination of the flow was reported
+	terminated bool
 }
 
 // TCPConnectionExpirationNotifier handles processing the expiration of an element
@@ -160,6 +245,25 @@ func (c *TCPConnection) SetReported(flowState bool) {
 	c.flowLastReporting = flowState
 }
 
+// Duration returns the time since the connection was created
+func (c *TCPConnection) Duration() time.Duration {
+
+	return time.Since(c.startTime)
+}
+
+// Terminate marks the flow as terminated. It returns false if the flow was
+// already terminated.
+func (c *TCPConnection) Terminate() bool {
+
+	if c.terminated {
+		return false
+	}
+
+	c.terminated = true
+
+	return true
+}
+
 // Cleanup will provide information when a connection is removed by a timer.
 func (c *TCPConnection) Cleanup(expiration bool) {
 	// Logging information
@@ -173,11 +277,106 @@ func (c *TCPConnection) Cleanup(expiration bool) {
 func NewTCPConnection(context *pucontext.PUContext) *TCPConnection {
 
 	return &TCPConnection{
-		state:   TCPSynSend,
+		state:     TCPSynSend,
+		Context:   context,
+		startTime: time.Now(),
+	}
+}
+
+// UDPConnection is information regarding UDP flows. Since UDP has no
+// handshake, the tokens are carried in the first datagrams of each
+// direction until the peer has been authorized.
+type UDPConnection struct {
+	sync.RWMutex
+
+	state UDPFlowState
+	Auth  AuthInfo
+
+	// Debugging Information
+	flowReported int
+
+	// Context is the pucontext.PUContext that is associated with this flow
+	Context *pucontext.PUContext
+
+	// Token is the token attached to outgoing datagrams while authorization
+	// is in progress. It is created once per flow so that retransmissions
+	// carry the same nonce.
+	Token []byte
+
+	// RemoteToken is the last token validated from the peer. Retransmitted
+	// datagrams carrying the same token do not need to be validated again.
+	RemoteToken []byte
+
+	// Debugging information - pushed to the end for compact structure
+	flowLastReporting bool
+
+	// ReportFlowPolicy holds the last matched observed policy
+	ReportFlowPolicy *policy.FlowPolicy
+
+	// PacketFlowPolicy holds the last matched actual policy
+	PacketFlowPolicy *policy.FlowPolicy
+}
+
+// UDPConnectionExpirationNotifier handles processing the expiration of an element
+func UDPConnectionExpirationNotifier(c cache.DataStore, id interface{}, item interface{}) {
+
+	if conn, ok := item.(*UDPConnection); ok {
+		conn.Cleanup(true)
+	}
+}
+
+// NewUDPConnection returns a UDPConnection information struct
+func NewUDPConnection(context *pucontext.PUContext) *UDPConnection {
+
+	return &UDPConnection{
+		state:   UDPSynSend,
 		Context: context,
 	}
 }
 
+// String returns a printable version of connection
+func (c *UDPConnection) String() string {
+
+	return fmt.Sprintf("udp state:%d auth: %+v", c.state, c.Auth)
+}
+
+// GetState is used to return the state
+func (c *UDPConnection) GetState() UDPFlowState {
+
+	return c.state
+}
+
+// SetState is used to setup the state for the UDP flow
+func (c *UDPConnection) SetState(state UDPFlowState) {
+
+	c.state = state
+}
+
+// SetReported is used to track if a flow is reported
+func (c *UDPConnection) SetReported(flowState bool) {
+
+	c.flowReported++
+
+	if c.flowReported > 1 && c.flowLastReporting != flowState {
+		zap.L().Info("UDP flow reported multiple times",
+			zap.Int("report count", c.flowReported),
+			zap.Bool("previous", c.flowLastReporting),
+			zap.Bool("next", flowState),
+		)
+	}
+
+	c.flowLastReporting = flowState
+}
+
+// Cleanup will provide information when a flow is removed by a timer.
+func (c *UDPConnection) Cleanup(expiration bool) {
+	// Flows that never completed authorization are not necessarily reported
+	if c.flowReported == 0 && c.state == UDPData {
+		zap.L().Error("UDP flow not reported",
+			zap.String("connection", c.String()))
+	}
+}
+
 // ProxyConnection is a record to keep state of proxy auth
 type ProxyConnection struct {
 	sync.Mutex
@@ -0,0 +1,120 @@
+// Package ebpf defines the maps and verdicts of a flow offload program. Only
+// an in-memory emulation of the program is provided; there is no BPF object
+// or loader in this package.
+package ebpf
+
+import (
+	"fmt"
+	"net"
+)
+
+// Verdict is the decision of the program for a packet.
+type Verdict uint8
+
+const (
+	// VerdictUserspace hands the packet to the regular stack so that the
+	// user space datapath can process it (handshakes, unknown flows).
+	VerdictUserspace Verdict = iota
+	// VerdictPass accepts the packet without any user space processing.
+	VerdictPass
+	// VerdictDrop drops the packet in the kernel.
+	VerdictDrop
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case VerdictUserspace:
+		return "userspace"
+	case VerdictPass:
+		return "pass"
+	case VerdictDrop:
+		return "drop"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(v))
+}
+
+// Hook identifies the attach point of the program.
+type Hook uint8
+
+const (
+	// HookIngress is the XDP hook that sees packets arriving from the network.
+	HookIngress Hook = iota
+	// HookEgress is the TC hook that sees packets sent by local applications.
+	HookEgress
+)
+
+// FlowKey is the key of the flow map. It matches the layout of the
+// corresponding BPF map key, with IPv4 addresses stored as IPv4-mapped
+// IPv6 addresses.
+type FlowKey struct {
+	SrcIP   [16]byte
+	DstIP   [16]byte
+	SrcPort uint16
+	DstPort uint16
+	Proto   uint8
+}
+
+// NewFlowKey creates a flow key from the five tuple of a packet.
+func NewFlowKey(srcIP, dstIP net.IP, proto uint8, srcPort, dstPort uint16) FlowKey {
+
+	k := FlowKey{
+		SrcPort: srcPort,
+		DstPort: dstPort,
+		Proto:   proto,
+	}
+	copy(k.SrcIP[:], srcIP.To16())
+	copy(k.DstIP[:], dstIP.To16())
+
+	return k
+}
+
+// Reverse returns the key of the opposite direction of the flow.
+func (k FlowKey) Reverse() FlowKey {
+	return FlowKey{
+		SrcIP:   k.DstIP,
+		DstIP:   k.SrcIP,
+		SrcPort: k.DstPort,
+		DstPort: k.SrcPort,
+		Proto:   k.Proto,
+	}
+}
+
+func (k FlowKey) String() string {
+	return fmt.Sprintf("%s:%d->%s:%d/%d", net.IP(k.SrcIP[:]), k.SrcPort, net.IP(k.DstIP[:]), k.DstPort, k.Proto)
+}
+
+// ACLRule is a reject rule evaluated by the program. The remote network is
+// the source of ingress packets and the destination of egress packets. A
+// zero protocol matches both TCP and UDP.
+type ACLRule struct {
+	Network   *net.IPNet
+	Protocol  uint8
+	PortStart uint16
+	PortEnd   uint16
+}
+
+// Program is the interface to the loaded BPF programs and their maps.
+type Program interface {
+
+	// Load loads the programs and creates the maps.
+	Load() error
+
+	// Close detaches the programs and releases the maps.
+	Close() error
+
+	// UpdateFlow installs a verdict for an established flow.
+	UpdateFlow(key FlowKey, verdict Verdict) error
+
+	// DeleteFlow removes a flow from the flow map.
+	DeleteFlow(key FlowKey) error
+
+	// UpdateACLs replaces the reject rules of a local IP address.
+	UpdateACLs(ip net.IP, ingress []ACLRule, egress []ACLRule) error
+
+	// DeleteACLs removes the reject rules of a local IP address.
+	DeleteACLs(ip net.IP) error
+
+	// Process runs the program attached at the given hook on a packet
+	// and returns its verdict. This is the equivalent of BPF_PROG_TEST_RUN.
+	Process(hook Hook, buf []byte) (Verdict, error)
+}
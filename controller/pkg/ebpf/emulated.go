@@ -0,0 +1,195 @@
+package ebpf
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+)
+
+// emulatedProgram implements the Program interface in user space. It keeps
+// the maps in memory and runs the same lookups as the BPF programs, which
+// allows the datapath to be exercised without a kernel.
+type emulatedProgram struct {
+	flows   map[FlowKey]Verdict
+	ingress map[[16]byte][]ACLRule
+	egress  map[[16]byte][]ACLRule
+	loaded  bool
+
+	sync.RWMutex
+}
+
+// NewEmulatedProgram returns a Program that emulates the BPF programs and
+// maps in memory. It does not attach to any kernel hook and offloads nothing.
+func NewEmulatedProgram() Program {
+
+	return &emulatedProgram{
+		flows:   map[FlowKey]Verdict{},
+		ingress: map[[16]byte][]ACLRule{},
+		egress:  map[[16]byte][]ACLRule{},
+	}
+}
+
+// Load implements the Program interface.
+func (e *emulatedProgram) Load() error {
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.loaded = true
+
+	return nil
+}
+
+// Close implements the Program interface.
+func (e *emulatedProgram) Close() error {
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.loaded = false
+	e.flows = map[FlowKey]Verdict{}
+	e.ingress = map[[16]byte][]ACLRule{}
+	e.egress = map[[16]byte][]ACLRule{}
+
+	return nil
+}
+
+// UpdateFlow implements the Program interface.
+func (e *emulatedProgram) UpdateFlow(key FlowKey, verdict Verdict) error {
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.flows[key] = verdict
+
+	return nil
+}
+
+// DeleteFlow implements the Program interface.
+func (e *emulatedProgram) DeleteFlow(key FlowKey) error {
+
+	e.Lock()
+	defer e.Unlock()
+
+	if _, ok := e.flows[key]; !ok {
+		return fmt.Errorf("flow not found: %s", key)
+	}
+	delete(e.flows, key)
+
+	return nil
+}
+
+// UpdateACLs implements the Program interface.
+func (e *emulatedProgram) UpdateACLs(ip net.IP, ingress []ACLRule, egress []ACLRule) error {
+
+	key, err := ipKey(ip)
+	if err != nil {
+		return err
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.ingress[key] = ingress
+	e.egress[key] = egress
+
+	return nil
+}
+
+// DeleteACLs implements the Program interface.
+func (e *emulatedProgram) DeleteACLs(ip net.IP) error {
+
+	key, err := ipKey(ip)
+	if err != nil {
+		return err
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	delete(e.ingress, key)
+	delete(e.egress, key)
+
+	return nil
+}
+
+// Process implements the Program interface.
+func (e *emulatedProgram) Process(hook Hook, buf []byte) (Verdict, error) {
+
+	e.RLock()
+	defer e.RUnlock()
+
+	if !e.loaded {
+		return VerdictUserspace, fmt.Errorf("program not loaded")
+	}
+
+	packetType := uint64(packet.PacketTypeNetwork)
+	if hook == HookEgress {
+		packetType = packet.PacketTypeApplication
+	}
+
+	// Packets we cannot parse are left to the regular datapath.
+	p, err := packet.New(packetType, buf, "")
+	if err != nil {
+		return VerdictUserspace, nil
+	}
+
+	if p.IPProto != packet.IPProtocolTCP && p.IPProto != packet.IPProtocolUDP {
+		return VerdictUserspace, nil
+	}
+
+	key := NewFlowKey(p.SourceAddress, p.DestinationAddress, p.IPProto, p.SourcePort, p.DestinationPort)
+	if verdict, ok := e.flows[key]; ok {
+		return verdict, nil
+	}
+
+	// Reject rules are always evaluated before accept rules by the
+	// user space datapath, so any match can be dropped here. Accepted
+	// flows still need the handshake and are installed once released.
+	local, remote, rules := p.DestinationAddress, p.SourceAddress, e.ingress
+	if hook == HookEgress {
+		local, remote, rules = p.SourceAddress, p.DestinationAddress, e.egress
+	}
+
+	localKey, err := ipKey(local)
+	if err != nil {
+		return VerdictUserspace, nil
+	}
+
+	for _, rule := range rules[localKey] {
+		if rule.matches(remote, p.IPProto, p.DestinationPort) {
+			return VerdictDrop, nil
+		}
+	}
+
+	return VerdictUserspace, nil
+}
+
+// matches returns true if the rule covers the remote address, protocol and port.
+func (r ACLRule) matches(remote net.IP, proto uint8, port uint16) bool {
+
+	if r.Network == nil || !r.Network.Contains(remote) {
+		return false
+	}
+
+	if r.Protocol != 0 && r.Protocol != proto {
+		return false
+	}
+
+	return port >= r.PortStart && port <= r.PortEnd
+}
+
+func ipKey(ip net.IP) ([16]byte, error) {
+
+	var key [16]byte
+
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return key, fmt.Errorf("invalid ip address: %s", ip)
+	}
+	copy(key[:], ip16)
+
+	return key, nil
+}
@@ -0,0 +1,139 @@
+package ebpf
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func tcpSyn(src, dst string, sport, dport layers.TCPPort) ([]byte, error) {
+
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.ParseIP(src), DstIP: net.ParseIP(dst)}
+	tcp := &layers.TCP{SrcPort: sport, DstPort: dport, SYN: true, Window: 1024}
+
+	if err := tcp.SetNetworkLayerForChecksum(ip); err != nil {
+		return nil, err
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, tcp); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func TestFlowKey(t *testing.T) {
+
+	Convey("Given I create a flow key", t, func() {
+
+		key := NewFlowKey(net.ParseIP("10.1.1.1"), net.ParseIP("10.1.1.2"), 6, 4000, 80)
+
+		Convey("Then the reverse key should swap addresses and ports", func() {
+			reverse := key.Reverse()
+			So(net.IP(reverse.SrcIP[:]).String(), ShouldEqual, "10.1.1.2")
+			So(reverse.SrcPort, ShouldEqual, 80)
+			So(reverse.DstPort, ShouldEqual, 4000)
+			So(reverse.Reverse(), ShouldResemble, key)
+			So(key.String(), ShouldEqual, "10.1.1.1:4000->10.1.1.2:80/6")
+		})
+	})
+}
+
+func TestEmulatedProgram(t *testing.T) {
+
+	Convey("Given I create an emulated program", t, func() {
+
+		p := NewEmulatedProgram()
+		syn, err := tcpSyn("10.1.1.1", "10.1.1.2", 4000, 80)
+		So(err, ShouldBeNil)
+
+		Convey("When the program is not loaded", func() {
+			_, err := p.Process(HookIngress, syn)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the program is loaded", func() {
+			So(p.Load(), ShouldBeNil)
+
+			Convey("Then unknown flows should go to user space", func() {
+				verdict, err := p.Process(HookIngress, syn)
+				So(err, ShouldBeNil)
+				So(verdict, ShouldEqual, VerdictUserspace)
+			})
+
+			Convey("Then unparsable packets should go to user space", func() {
+				verdict, err := p.Process(HookIngress, []byte{0x45, 0x00})
+				So(err, ShouldBeNil)
+				So(verdict, ShouldEqual, VerdictUserspace)
+			})
+
+			Convey("Then installed flows should get their verdict until they are deleted", func() {
+				key := NewFlowKey(net.ParseIP("10.1.1.1"), net.ParseIP("10.1.1.2"), 6, 4000, 80)
+				So(p.UpdateFlow(key, VerdictPass), ShouldBeNil)
+
+				verdict, err := p.Process(HookIngress, syn)
+				So(err, ShouldBeNil)
+				So(verdict, ShouldEqual, VerdictPass)
+
+				So(p.DeleteFlow(key), ShouldBeNil)
+				So(p.DeleteFlow(key), ShouldNotBeNil)
+
+				verdict, err = p.Process(HookIngress, syn)
+				So(err, ShouldBeNil)
+				So(verdict, ShouldEqual, VerdictUserspace)
+			})
+
+			Convey("Then packets matching a reject acl of the local address should be dropped", func() {
+				_, network, _ := net.ParseCIDR("10.1.1.0/24")
+				rule := ACLRule{Network: network, Protocol: 6, PortStart: 80, PortEnd: 90}
+				So(p.UpdateACLs(net.ParseIP("10.1.1.2"), []ACLRule{rule}, nil), ShouldBeNil)
+
+				verdict, err := p.Process(HookIngress, syn)
+				So(err, ShouldBeNil)
+				So(verdict, ShouldEqual, VerdictDrop)
+
+				// The egress rules of the sender are empty
+				verdict, err = p.Process(HookEgress, syn)
+				So(err, ShouldBeNil)
+				So(verdict, ShouldEqual, VerdictUserspace)
+
+				So(p.DeleteACLs(net.ParseIP("10.1.1.2")), ShouldBeNil)
+				verdict, err = p.Process(HookIngress, syn)
+				So(err, ShouldBeNil)
+				So(verdict, ShouldEqual, VerdictUserspace)
+			})
+
+			Convey("Then packets not matching the port or protocol of a rule should go to user space", func() {
+				_, network, _ := net.ParseCIDR("10.1.1.0/24")
+				rules := []ACLRule{
+					{Network: network, Protocol: 6, PortStart: 443, PortEnd: 443},
+					{Network: network, Protocol: 17, PortStart: 80, PortEnd: 80},
+				}
+				So(p.UpdateACLs(net.ParseIP("10.1.1.2"), rules, nil), ShouldBeNil)
+
+				verdict, err := p.Process(HookIngress, syn)
+				So(err, ShouldBeNil)
+				So(verdict, ShouldEqual, VerdictUserspace)
+			})
+
+			Convey("Then closing the program should clear the maps", func() {
+				key := NewFlowKey(net.ParseIP("10.1.1.1"), net.ParseIP("10.1.1.2"), 6, 4000, 80)
+				So(p.UpdateFlow(key, VerdictPass), ShouldBeNil)
+				So(p.Close(), ShouldBeNil)
+				So(p.Load(), ShouldBeNil)
+
+				verdict, err := p.Process(HookIngress, syn)
+				So(err, ShouldBeNil)
+				So(verdict, ShouldEqual, VerdictUserspace)
+			})
+		})
+	})
+}
@@ -0,0 +1,219 @@
+// Package encryption provides the key agreement and the authenticated
+// encryption of the payload of the connections whose policy requires
+// encryption. The key agreement is an ECDH exchange of ephemeral P-256 keys
+// carried in the handshake tokens. Every segment is sealed with AES-GCM in
+// place, so that its length and the sequence numbers are not modified.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const (
+	// TagSize is the size of the authentication tag of a segment
+	TagSize = 16
+
+	// initiatorLabel and responderLabel derive the keys of both directions
+	initiatorLabel = "trireme initiator"
+	responderLabel = "trireme responder"
+)
+
+// EphemeralKey is an ephemeral P-256 key pair used for the key agreement.
+type EphemeralKey struct {
+	private []byte
+	// Public is the public key in the uncompressed form announced in the tokens
+	Public []byte
+}
+
+// NewEphemeralKey generates a new ephemeral key pair.
+func NewEphemeralKey() (*EphemeralKey, error) {
+
+	private, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate ephemeral key: %s", err)
+	}
+
+	return &EphemeralKey{
+		private: private,
+		Public:  elliptic.Marshal(elliptic.P256(), x, y),
+	}, nil
+}
+
+// sharedSecret returns the ECDH shared secret with the remote public key.
+func (k *EphemeralKey) sharedSecret(remote []byte) ([]byte, error) {
+
+	curve := elliptic.P256()
+
+	x, y := elliptic.Unmarshal(curve, remote)
+	if x == nil {
+		return nil, errors.New("invalid remote ephemeral key")
+	}
+
+	secret, _ := curve.ScalarMult(x, y, k.private)
+	if secret.Sign() == 0 {
+		return nil, errors.New("invalid shared secret")
+	}
+
+	return padded(secret, (curve.Params().BitSize+7)/8), nil
+}
+
+// Session encrypts the segments of the two directions of a connection with
+// their own keys.
+type Session struct {
+	send    cipher.AEAD
+	receive cipher.AEAD
+
+	sent     sequenceSpace
+	received sequenceSpace
+}
+
+// NewSession derives the keys of a connection from the local ephemeral key,
+// the ephemeral key of the remote and the nonces exchanged by the handshake.
+// The initiator is the side that sent the syn packet.
+func NewSession(key *EphemeralKey, remote []byte, initiatorNonce []byte, responderNonce []byte, initiator bool) (*Session, error) {
+
+	if key == nil {
+		return nil, errors.New("no local ephemeral key")
+	}
+
+	secret, err := key.sharedSecret(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	// HKDF-SHA256 with the nonces as salt
+	salt := append(append([]byte{}, initiatorNonce...), responderNonce...)
+	prk := hmacSHA256(salt, secret)
+
+	initiatorCipher, err := newCipher(hmacSHA256(prk, append([]byte(initiatorLabel), 1)))
+	if err != nil {
+		return nil, err
+	}
+
+	responderCipher, err := newCipher(hmacSHA256(prk, append([]byte(responderLabel), 1)))
+	if err != nil {
+		return nil, err
+	}
+
+	if initiator {
+		return &Session{send: initiatorCipher, receive: responderCipher}, nil
+	}
+
+	return &Session{send: responderCipher, receive: initiatorCipher}, nil
+}
+
+// Seal encrypts in place the payload of a segment sent with the sequence
+// number seq and returns its authentication tag.
+func (s *Session) Seal(seq uint32, payload []byte) []byte {
+
+	index := s.sent.index(seq)
+	s.sent.update(index)
+
+	sealed := s.send.Seal(nil, nonce(index, len(payload)), payload, nil)
+	copy(payload, sealed)
+
+	return sealed[len(payload):]
+}
+
+// Open authenticates and decrypts in place the payload of a segment received
+// with the sequence number seq. The payload is not modified if the segment
+// cannot be authenticated.
+func (s *Session) Open(seq uint32, payload []byte, tag []byte) error {
+
+	if len(tag) != TagSize {
+		return fmt.Errorf("invalid tag length: %d", len(tag))
+	}
+
+	index := s.received.index(seq)
+
+	sealed := append(append([]byte{}, payload...), tag...)
+	plain, err := s.receive.Open(sealed[:0], nonce(index, len(payload)), sealed, nil)
+	if err != nil {
+		return errors.New("segment authentication failed")
+	}
+
+	copy(payload, plain)
+	s.received.update(index)
+
+	return nil
+}
+
+// sequenceSpace extends the sequence numbers of a direction to 64 bits by
+// counting their wraps. Segments are placed relatively to the highest
+// sequence number seen, within half of the sequence space.
+type sequenceSpace struct {
+	initialized bool
+	highest     uint64
+}
+
+// index returns the extended sequence number of seq.
+func (s *sequenceSpace) index(seq uint32) uint64 {
+
+	if !s.initialized {
+		return uint64(seq)
+	}
+
+	delta := int32(seq - uint32(s.highest))
+
+	return uint64(int64(s.highest) + int64(delta))
+}
+
+// update records the extended sequence number of a valid segment.
+func (s *sequenceSpace) update(index uint64) {
+
+	if !s.initialized || index > s.highest {
+		s.highest = index
+		s.initialized = true
+	}
+}
+
+// nonce returns the GCM nonce of a segment. The same nonce is only used for
+// the retransmission of the same bytes of the stream.
+func nonce(index uint64, length int) []byte {
+
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n[:8], index)
+	binary.BigEndian.PutUint32(n[8:], uint32(length))
+
+	return n
+}
+
+// newCipher returns the AES-GCM cipher of a key.
+func newCipher(key []byte) (cipher.AEAD, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cipher: %s", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data.
+func hmacSHA256(key []byte, data []byte) []byte {
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data) // nolint
+
+	return mac.Sum(nil)
+}
+
+// padded returns the big endian representation of n on size bytes.
+func padded(n *big.Int, size int) []byte {
+
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+
+	return append(make([]byte, size-len(b)), b...)
+}
@@ -0,0 +1,127 @@
+package encryption
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func sessions() (*Session, *Session, error) {
+
+	clientKey, err := NewEphemeralKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serverKey, err := NewEphemeralKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clientNonce := []byte("0123456789abcdef")
+	serverNonce := []byte("fedcba9876543210")
+
+	client, err := NewSession(clientKey, serverKey.Public, clientNonce, serverNonce, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	server, err := NewSession(serverKey, clientKey.Public, clientNonce, serverNonce, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return client, server, nil
+}
+
+func TestSession(t *testing.T) {
+
+	Convey("Given the sessions of a client and a server", t, func() {
+
+		client, server, err := sessions()
+		So(err, ShouldBeNil)
+
+		Convey("When the client seals a segment", func() {
+			payload := []byte("GET / HTTP/1.1")
+			tag := client.Seal(1000, payload)
+
+			Convey("Then the payload should be encrypted in place", func() {
+				So(len(tag), ShouldEqual, TagSize)
+				So(string(payload), ShouldNotEqual, "GET / HTTP/1.1")
+			})
+
+			Convey("Then the server should open it", func() {
+				So(server.Open(1000, payload, tag), ShouldBeNil)
+				So(string(payload), ShouldEqual, "GET / HTTP/1.1")
+			})
+
+			Convey("Then the client should not open it with the key of the other direction", func() {
+				So(client.Open(1000, payload, tag), ShouldNotBeNil)
+			})
+
+			Convey("Then the server should reject it with another sequence number", func() {
+				sealed := append([]byte{}, payload...)
+				So(server.Open(1001, payload, tag), ShouldNotBeNil)
+				So(payload, ShouldResemble, sealed)
+			})
+
+			Convey("Then the server should reject a modified segment", func() {
+				payload[0] ^= 0xff
+				So(server.Open(1000, payload, tag), ShouldNotBeNil)
+			})
+
+			Convey("Then the server should reject an invalid tag", func() {
+				So(server.Open(1000, payload, tag[:8]), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the client retransmits a segment", func() {
+			first := []byte("retransmitted")
+			second := []byte("retransmitted")
+			tag1 := client.Seal(2000, first)
+			tag2 := client.Seal(2000, second)
+
+			Convey("Then it should be sealed identically", func() {
+				So(first, ShouldResemble, second)
+				So(tag1, ShouldResemble, tag2)
+			})
+		})
+
+		Convey("When the sequence numbers wrap", func() {
+			first := []byte("first")
+			before := []byte("before")
+			after := []byte("after")
+			tagFirst := client.Seal(0xffffff00, first)
+			tagBefore := client.Seal(0xfffffff0, before)
+			tagAfter := client.Seal(0x10, after)
+
+			Convey("Then the server should open the segments reordered around the wrap", func() {
+				So(server.Open(0xffffff00, first, tagFirst), ShouldBeNil)
+				So(server.Open(0x10, after, tagAfter), ShouldBeNil)
+				So(server.Open(0xfffffff0, before, tagBefore), ShouldBeNil)
+				So(string(after), ShouldEqual, "after")
+				So(string(before), ShouldEqual, "before")
+			})
+
+			Convey("Then the segments should not be sealed as the ones before the wrap", func() {
+				space := sequenceSpace{}
+				space.update(space.index(0xfffffff0))
+				So(space.index(0x10), ShouldEqual, uint64(1)<<32|0x10)
+			})
+		})
+	})
+
+	Convey("Given an invalid remote ephemeral key", t, func() {
+
+		key, err := NewEphemeralKey()
+		So(err, ShouldBeNil)
+
+		Convey("When I create a session", func() {
+			_, err := NewSession(key, []byte("invalid"), nil, nil, true)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
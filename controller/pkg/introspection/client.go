@@ -0,0 +1,117 @@
+package introspection
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Client is a client of the introspection API. It implements the
+// Introspector interface.
+type Client struct {
+	httpc http.Client
+}
+
+// NewClient creates a new client of the server listening on path.
+func NewClient(path string) (*Client, error) {
+
+	addr, err := net.ResolveUnixAddr("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address: %s", err)
+	}
+
+	return &Client{
+		httpc: http.Client{
+			Transport: &http.Transport{
+				DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+					return net.DialUnix("unix", nil, addr)
+				},
+			},
+		},
+	}, nil
+}
+
+// ListPUs returns the processing units that are enforced.
+func (c *Client) ListPUs() ([]*PU, error) {
+
+	pus := []*PU{}
+	if err := c.get(PUsPath, &pus); err != nil {
+		return nil, err
+	}
+
+	return pus, nil
+}
+
+// ListFlows returns the active flows of the processing unit with the given
+// context ID or the flows of all the processing units if it is empty.
+func (c *Client) ListFlows(contextID string) ([]*Flow, error) {
+
+	path := FlowsPath
+	if contextID != "" {
+		path = path + "?contextid=" + url.QueryEscape(contextID)
+	}
+
+	flows := []*Flow{}
+	if err := c.get(path, &flows); err != nil {
+		return nil, err
+	}
+
+	return flows, nil
+}
+
+// Simulate evaluates a flow against the live policy of a processing unit.
+func (c *Client) Simulate(query *Query) (*Decision, error) {
+
+	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode query: %s", err)
+	}
+
+	resp, err := c.httpc.Post("http://unix"+SimulatePath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	decision := &Decision{}
+	if err := decode(resp, decision); err != nil {
+		return nil, err
+	}
+
+	return decision, nil
+}
+
+// get decodes the response of a request to path in v.
+func (c *Client) get(path string, v interface{}) error {
+
+	resp, err := c.httpc.Get("http://unix" + path)
+	if err != nil {
+		return err
+	}
+
+	return decode(resp, v)
+}
+
+// decode decodes the body of a response in v.
+func decode(resp *http.Response, v interface{}) error {
+
+	defer resp.Body.Close() // nolint errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		errorBuffer, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("invalid request: %s", err)
+		}
+		return fmt.Errorf("invalid request: %s", string(errorBuffer))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("unable to decode response: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package introspection
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// PUsPath is the path of the request listing the processing units
+	PUsPath = "/pus"
+	// FlowsPath is the path of the request listing the flows. The
+	// processing unit is selected with the contextid query parameter.
+	FlowsPath = "/flows"
+	// SimulatePath is the path of the request evaluating a Query. The
+	// query is the JSON body of a POST request.
+	SimulatePath = "/simulate"
+)
+
+// Server serves the introspection API over a unix socket.
+type Server struct {
+	socketPath   string
+	server       *http.Server
+	introspector Introspector
+}
+
+// NewServer creates a new introspection server.
+func NewServer(address string, introspector Introspector) (*Server, error) {
+
+	// Cleanup the socket first.
+	if _, err := os.Stat(address); err == nil {
+		if err := os.Remove(address); err != nil {
+			return nil, fmt.Errorf("unable to clean up socket: %s", err)
+		}
+	}
+
+	return &Server{
+		socketPath:   address,
+		introspector: introspector,
+	}, nil
+}
+
+// Run runs the server in the background until the context is cancelled.
+func (s *Server) Run(ctx context.Context) error {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(PUsPath, s.listPUs)
+	mux.HandleFunc(FlowsPath, s.listFlows)
+	mux.HandleFunc(SimulatePath, s.simulate)
+
+	s.server = &http.Server{
+		Handler: mux,
+	}
+
+	nl, err := listenPrivate(s.socketPath)
+	if err != nil {
+		return fmt.Errorf("unable to start introspection server: %s", err)
+	}
+
+	go s.server.Serve(nl) // nolint
+
+	go func() {
+		<-ctx.Done()
+		s.server.Close()        // nolint
+		os.Remove(s.socketPath) // nolint
+	}()
+
+	return nil
+}
+
+// listenPrivate listens on a unix socket at address that only root can
+// access. The policies of all processing units are exposed, so the socket
+// is created in a private directory, restricted and only then moved to its
+// address. It is never reachable with the default permissions.
+func listenPrivate(address string) (*net.UnixListener, error) {
+
+	dir, err := ioutil.TempDir(filepath.Dir(address), ".introspection")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create private directory: %s", err)
+	}
+	defer os.RemoveAll(dir) // nolint
+
+	path := filepath.Join(dir, "socket")
+	nl, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+
+	// The socket is removed by the server once moved.
+	nl.SetUnlinkOnClose(false)
+
+	if err := os.Chmod(path, 0600); err != nil {
+		nl.Close() // nolint
+		return nil, fmt.Errorf("unable to restrict access to the socket: %s", err)
+	}
+
+	if err := os.Rename(path, address); err != nil {
+		nl.Close() // nolint
+		return nil, fmt.Errorf("unable to move the socket: %s", err)
+	}
+
+	return nl, nil
+}
+
+// listPUs handles the requests for the processing units.
+func (s *Server) listPUs(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pus, err := s.introspector.ListPUs()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Cannot list processing units: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, pus)
+}
+
+// listFlows handles the requests for the flows.
+func (s *Server) listFlows(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flows, err := s.introspector.ListFlows(r.URL.Query().Get("contextid"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Cannot list flows: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, flows)
+}
+
+// simulate handles the requests evaluating a query. The query does not
+// change any state but it is carried in the body of a POST request.
+func (s *Server) simulate(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := &Query{}
+	if err := json.NewDecoder(r.Body).Decode(query); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid query: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := query.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid query: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	decision, err := s.introspector.Simulate(query)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Cannot simulate flow: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, decision)
+}
+
+// writeJSON writes v as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, fmt.Sprintf("Unable to encode response: %s", err), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,159 @@
+package introspection
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testIntrospector struct {
+	pus      []*PU
+	flows    []*Flow
+	decision *Decision
+	query    *Query
+	err      error
+}
+
+func (i *testIntrospector) ListPUs() ([]*PU, error) {
+	return i.pus, i.err
+}
+
+func (i *testIntrospector) ListFlows(contextID string) ([]*Flow, error) {
+
+	flows := []*Flow{}
+	for _, f := range i.flows {
+		if contextID == "" || f.ContextID == contextID {
+			flows = append(flows, f)
+		}
+	}
+
+	return flows, i.err
+}
+
+func (i *testIntrospector) Simulate(query *Query) (*Decision, error) {
+	i.query = query
+	return i.decision, i.err
+}
+
+func TestServer(t *testing.T) {
+
+	Convey("Given an introspection server listening on a unix socket", t, func() {
+
+		dir, err := ioutil.TempDir("", "introspection")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		introspector := &testIntrospector{
+			pus: []*PU{
+				{
+					ContextID: "pu1",
+					Identity:  []string{"app=web"},
+					Mark:      "100",
+					Ports:     []string{"80"},
+					ProxyPort: "5000",
+					ACLs: ACLs{
+						Network: policy.IPRuleList{
+							{Address: "10.0.0.0/8", Port: "80", Protocol: "tcp", Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "acl"}},
+						},
+					},
+				},
+			},
+			flows: []*Flow{
+				{ContextID: "pu1", Protocol: "tcp", Direction: NetworkOriginated, Flow: "10.1.1.1:10.1.1.2:4000:80", State: "data"},
+				{ContextID: "pu2", Protocol: "udp", Direction: ApplicationOriginated, Flow: "10.1.1.2:10.1.1.3:5353:53", State: "data"},
+			},
+			decision: &Decision{
+				ContextID: "pu1",
+				Report:    &policy.FlowPolicy{Action: policy.Accept, ObserveAction: policy.ObserveContinue, PolicyID: "observed"},
+				Packet:    &policy.FlowPolicy{Action: policy.Reject, PolicyID: "reject"},
+				PolicyID:  "reject",
+				Observed:  true,
+			},
+		}
+
+		socket := filepath.Join(dir, "introspection.sock")
+		s, err := NewServer(socket, introspector)
+		So(err, ShouldBeNil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		So(s.Run(ctx), ShouldBeNil)
+
+		info, err := os.Stat(socket)
+		So(err, ShouldBeNil)
+		So(info.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+
+		// The private directory the socket was created in is removed
+		entries, err := ioutil.ReadDir(dir)
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldEqual, 1)
+
+		client, err := NewClient(socket)
+		So(err, ShouldBeNil)
+
+		Convey("When I list the processing units", func() {
+			pus, err := client.ListPUs()
+
+			Convey("Then I should get the processing units of the introspector", func() {
+				So(err, ShouldBeNil)
+				So(pus, ShouldResemble, introspector.pus)
+			})
+		})
+
+		Convey("When I list the flows of a processing unit", func() {
+			flows, err := client.ListFlows("pu1")
+
+			Convey("Then I should get only its flows", func() {
+				So(err, ShouldBeNil)
+				So(len(flows), ShouldEqual, 1)
+				So(flows[0], ShouldResemble, introspector.flows[0])
+			})
+		})
+
+		Convey("When I list all the flows", func() {
+			flows, err := client.ListFlows("")
+
+			Convey("Then I should get the flows of all the processing units", func() {
+				So(err, ShouldBeNil)
+				So(len(flows), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When I simulate a flow", func() {
+			query := &Query{ContextID: "pu1", Direction: NetworkOriginated, Tags: []string{"app=db"}, Port: 80}
+			decision, err := client.Simulate(query)
+
+			Convey("Then I should get the decision of the introspector", func() {
+				So(err, ShouldBeNil)
+				So(introspector.query, ShouldResemble, query)
+				So(decision, ShouldResemble, introspector.decision)
+			})
+		})
+
+		Convey("When I simulate an invalid flow", func() {
+			_, err := client.Simulate(&Query{ContextID: "pu1", Direction: "sideways", Port: 80})
+
+			Convey("Then I should get an error without calling the introspector", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "invalid direction")
+				So(introspector.query, ShouldBeNil)
+			})
+		})
+
+		Convey("When the introspector fails", func() {
+			introspector.err = errors.New("enforcer unavailable")
+			_, err := client.ListPUs()
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "enforcer unavailable")
+			})
+		})
+	})
+}
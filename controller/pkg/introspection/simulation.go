@@ -0,0 +1,93 @@
+package introspection
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+const (
+	// TCP is the protocol of TCP flows
+	TCP = "tcp"
+	// UDP is the protocol of UDP flows
+	UDP = "udp"
+)
+
+// Query is a flow that is evaluated against the live policy of a processing
+// unit without generating any traffic.
+type Query struct {
+	// ContextID is the processing unit whose policy is evaluated
+	ContextID string `json:"contextid"`
+	// Direction is the side that originates the flow. The transmitter
+	// rules of the processing unit are evaluated for application
+	// originated flows and its receiver rules for network originated flows.
+	Direction string `json:"direction"`
+	// Protocol is either tcp or udp. It defaults to tcp.
+	Protocol string `json:"protocol,omitempty"`
+	// Tags is the identity of the remote processing unit. When it is
+	// empty the remote endpoint is external and the ACLs of the processing
+	// unit are evaluated for Address instead.
+	Tags []string `json:"tags,omitempty"`
+	// Address is the IP address of the external endpoint
+	Address string `json:"address,omitempty"`
+	// Port is the destination port of the flow
+	Port uint16 `json:"port"`
+}
+
+// Validate checks that the query can be evaluated.
+func (q *Query) Validate() error {
+
+	if q.ContextID == "" {
+		return fmt.Errorf("contextid is required")
+	}
+
+	if q.Direction != ApplicationOriginated && q.Direction != NetworkOriginated {
+		return fmt.Errorf("invalid direction %s", q.Direction)
+	}
+
+	if q.Protocol != "" && q.Protocol != TCP && q.Protocol != UDP {
+		return fmt.Errorf("invalid protocol %s", q.Protocol)
+	}
+
+	if len(q.Tags) == 0 && net.ParseIP(q.Address) == nil {
+		return fmt.Errorf("tags or a valid address are required")
+	}
+
+	return nil
+}
+
+// Decision is the result of the evaluation of a query.
+type Decision struct {
+	ContextID string `json:"contextid"`
+	// Report is the policy that is reported for the flow
+	Report *policy.FlowPolicy `json:"report"`
+	// Packet is the policy that is applied to the packets of the flow
+	Packet *policy.FlowPolicy `json:"packet"`
+	// PolicyID is the ID of the policy that matched the flow
+	PolicyID string `json:"policyid"`
+	// Allowed is set when the packets of the flow are accepted
+	Allowed bool `json:"allowed"`
+	// Observed is set when an observe rule matched the flow
+	Observed bool `json:"observed"`
+	// ACL is set when the flow was evaluated against the ACLs
+	ACL bool `json:"acl,omitempty"`
+}
+
+// NewDecision creates the decision of the processing unit with the given
+// context ID from the report and packet policies of a flow. The policies
+// are copied since they belong to the live policy of the enforcer.
+func NewDecision(contextID string, report *policy.FlowPolicy, packet *policy.FlowPolicy) *Decision {
+
+	r := *report
+	p := *packet
+
+	return &Decision{
+		ContextID: contextID,
+		Report:    &r,
+		Packet:    &p,
+		PolicyID:  p.PolicyID,
+		Allowed:   p.Action.Accepted() && !p.Action.Rejected(),
+		Observed:  r.ObserveAction.Observed() || p.ObserveAction.Observed(),
+	}
+}
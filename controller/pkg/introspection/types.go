@@ -0,0 +1,93 @@
+package introspection
+
+import (
+	"github.com/aporeto-inc/trireme-lib/common"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+const (
+	// ApplicationOriginated identifies flows initiated by the processing unit
+	ApplicationOriginated = "application"
+	// NetworkOriginated identifies flows initiated by a peer on the network
+	NetworkOriginated = "network"
+)
+
+// Introspector is the read-only interface to the state held by the enforcers.
+type Introspector interface {
+	// ListPUs returns the processing units that are enforced.
+	ListPUs() ([]*PU, error)
+
+	// ListFlows returns the active flows of the processing unit with the given
+	// context ID or the flows of all the processing units if it is empty.
+	ListFlows(contextID string) ([]*Flow, error)
+
+	// Simulate evaluates a flow against the live policy of a processing unit.
+	Simulate(query *Query) (*Decision, error)
+}
+
+// Rules are the tag rules of one direction of a processing unit as they are
+// compiled in the policy databases of the enforcer. Each list holds the
+// rules of one database in the order they are added.
+type Rules struct {
+	Reject        policy.TagSelectorList `json:"reject,omitempty"`
+	ObserveReject policy.TagSelectorList `json:"observereject,omitempty"`
+	Accept        policy.TagSelectorList `json:"accept,omitempty"`
+	ObserveAccept policy.TagSelectorList `json:"observeaccept,omitempty"`
+	ObserveApply  policy.TagSelectorList `json:"observeapply,omitempty"`
+	Encrypt       policy.TagSelectorList `json:"encrypt,omitempty"`
+}
+
+// ACLs are the contents of the ACL caches of a processing unit.
+type ACLs struct {
+	Application    policy.IPRuleList `json:"application,omitempty"`
+	Network        policy.IPRuleList `json:"network,omitempty"`
+	UDPApplication policy.IPRuleList `json:"udpapplication,omitempty"`
+	UDPNetwork     policy.IPRuleList `json:"udpnetwork,omitempty"`
+}
+
+// Replays are the counters of the detection of the replayed tokens by an
+// enforcer. They are shared by all the processing units of the enforcer.
+type Replays struct {
+	// Checked is the number of tokens checked
+	Checked uint64 `json:"checked"`
+	// Replayed is the number of tokens rejected as replays
+	Replayed uint64 `json:"replayed"`
+	// Evicted is the number of nonces evicted before their expiration
+	Evicted uint64 `json:"evicted"`
+	// Size is the current number of nonces remembered
+	Size int `json:"size"`
+}
+
+// PU is the state of an enforced processing unit.
+type PU struct {
+	ContextID    string        `json:"contextid"`
+	ManagementID string        `json:"managementid,omitempty"`
+	Type         common.PUType `json:"type"`
+	Identity     []string      `json:"identity,omitempty"`
+	Annotations  []string      `json:"annotations,omitempty"`
+	Mark         string        `json:"mark,omitempty"`
+	Ports        []string      `json:"ports,omitempty"`
+	ProxyPort    string        `json:"proxyport,omitempty"`
+	Transmitter  Rules         `json:"transmitter"`
+	Receiver     Rules         `json:"receiver"`
+	ACLs         ACLs          `json:"acls"`
+	// Replays are the counters of the enforcer of the processing unit
+	Replays *Replays `json:"replays,omitempty"`
+	// Remote is set for processing units enforced by a remote enforcer
+	Remote bool `json:"remote,omitempty"`
+}
+
+// Flow is an active flow tracked by the datapath.
+type Flow struct {
+	ContextID string `json:"contextid"`
+	Protocol  string `json:"protocol"`
+	// Direction is the side that originated the flow
+	Direction string `json:"direction"`
+	// Flow is the 4-tuple of the original direction of the flow in
+	// the source:destination:sport:dport format
+	Flow              string `json:"flow"`
+	State             string `json:"state"`
+	ServiceConnection bool   `json:"serviceconnection,omitempty"`
+	// Encrypted indicates that the payload of the flow is encrypted by the datapath
+	Encrypted bool `json:"encrypted,omitempty"`
+}
@@ -1,13 +1,28 @@
 package packet
 
 const (
-	// minIPPacketLen is the min ip packet size
-	minIPPacketLen = 40
-
 	// minIPHdrSize
 	minIPHdrSize = 20
 
 	minIPHdrWords = (minIPHdrSize / 4)
+
+	// minTCPHdrSize is the size of a TCP header without options
+	minTCPHdrSize = 20
+
+	// minUDPHdrSize is the size of a UDP header
+	minUDPHdrSize = 8
+
+	// ipv6HdrSize is the size of the fixed IPv6 header
+	ipv6HdrSize = 40
+)
+
+// IP versions
+const (
+	// IPVersion4 is the version field of an IPv4 header
+	IPVersion4 = 4
+
+	// IPVersion6 is the version field of an IPv6 header
+	IPVersion6 = 6
 )
 
 // IP Header field position constants
@@ -34,6 +49,33 @@ const (
 	ipDestAddrPos = 16
 )
 
+// IPv6 Header field position constants
+const (
+	// ipv6PayloadLenPos is the location of the payload length
+	ipv6PayloadLenPos = 4
+
+	// ipv6NextHeaderPos is the location of the next header
+	ipv6NextHeaderPos = 6
+
+	// ipv6SourceAddrPos is location of source IP address
+	ipv6SourceAddrPos = 8
+
+	// ipv6DestAddrPos is location of destination IP address
+	ipv6DestAddrPos = 24
+)
+
+// IPv6 extension header numbers
+const (
+	ipv6HopByHopHdr        = 0
+	ipv6RoutingHdr         = 43
+	ipv6FragmentHdr        = 44
+	ipv6AuthenticationHdr  = 51
+	ipv6DestinationOptsHdr = 60
+
+	// ipv6FragmentHdrLen is the fixed size of the fragment header
+	ipv6FragmentHdrLen = 8
+)
+
 // IP Protocol numbers
 const (
 	// IPProtocolTCP defines the constant for UDP protocol number
@@ -46,30 +88,51 @@ const (
 // IP Header masks
 const (
 	ipHdrLenMask = 0xF
+
+	// ipVersionMask is a mask for the version nibble
+	ipVersionMask = 0xF0
 )
 
-// TCP Header field position constants
+// TCP Header field position constants. They are relative to the
+// beginning of the TCP header since IPv6 extension headers make the
+// absolute position variable.
 const (
 	// tcpSourcePortPos is the location of source port
-	tcpSourcePortPos = 20
+	tcpSourcePortPos = 0
 
 	// tcpDestPortPos is the location of destination port
-	tcpDestPortPos = 22
+	tcpDestPortPos = 2
 
 	// tcpSeqPos is the location of seq
-	tcpSeqPos = 24
+	tcpSeqPos = 4
 
 	// tcpAckPos is the location of seq
-	tcpAckPos = 28
+	tcpAckPos = 8
 
 	// tcpDataOffsetPos is the location of the TCP data offset
-	tcpDataOffsetPos = 32
+	tcpDataOffsetPos = 12
 
 	//tcpFlagsOfsetPos is the location of the TCP flags
-	tcpFlagsOffsetPos = 33
+	tcpFlagsOffsetPos = 13
 
 	// TCPChecksumPos is the location of TCP checksum
-	TCPChecksumPos = 36
+	TCPChecksumPos = 16
+)
+
+// UDP Header field position constants. They are relative to the
+// beginning of the UDP header.
+const (
+	// udpSourcePortPos is the location of source port
+	udpSourcePortPos = 0
+
+	// udpDestPortPos is the location of destination port
+	udpDestPortPos = 2
+
+	// udpLengthPos is the location of the UDP length
+	udpLengthPos = 4
+
+	// UDPChecksumPos is the location of UDP checksum
+	UDPChecksumPos = 6
 )
 
 // TCP Header masks
@@ -106,4 +169,20 @@ const (
 
 	// TCPMssOptionLen is the type for MSS option
 	TCPMssOptionLen = uint8(4)
+
+	// TCPEncryptionOption is the option carrying the authentication tag of
+	// the segments of encrypted connections
+	TCPEncryptionOption = uint8(253)
+
+	// tcpEndOption is the type of the end of option list option
+	tcpEndOption = uint8(0)
+
+	// tcpNopOption is the type of the no-operation option
+	tcpNopOption = uint8(1)
+
+	// tcpSackPermittedOption is the type of the SACK permitted option
+	tcpSackPermittedOption = uint8(4)
+
+	// tcpMaxHeaderLen is the maximum length of the TCP header with its options
+	tcpMaxHeaderLen = 60
 )
@@ -8,6 +8,7 @@ import (
 	"strconv"
 
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 // Helpher functions for the package, mainly for debugging and validation
@@ -18,6 +19,11 @@ import (
 // modified.
 func (p *Packet) VerifyIPChecksum() bool {
 
+	// IPv6 headers are not protected by a checksum
+	if p.IsIPv6() {
+		return true
+	}
+
 	sum := p.computeIPChecksum()
 
 	return sum == p.ipChecksum
@@ -27,6 +33,10 @@ func (p *Packet) VerifyIPChecksum() bool {
 // packet with the value.
 func (p *Packet) UpdateIPChecksum() {
 
+	if p.IsIPv6() {
+		return
+	}
+
 	p.ipChecksum = p.computeIPChecksum()
 
 	binary.BigEndian.PutUint16(p.Buffer[ipChecksumPos:ipChecksumPos+2], p.ipChecksum)
@@ -48,12 +58,40 @@ func (p *Packet) UpdateTCPChecksum() {
 
 	p.TCPChecksum = p.computeTCPChecksum()
 
-	binary.BigEndian.PutUint16(p.Buffer[TCPChecksumPos:TCPChecksumPos+2], p.TCPChecksum)
+	binary.BigEndian.PutUint16(p.Buffer[p.l4BeginPos+TCPChecksumPos:p.l4BeginPos+TCPChecksumPos+2], p.TCPChecksum)
+}
+
+// VerifyUDPChecksum returns true if the UDP checksum is correct for this
+// packet, false otherwise. IPv4 datagrams without a checksum are always
+// valid. Note that the checksum is not modified.
+func (p *Packet) VerifyUDPChecksum() bool {
+
+	if p.UDPChecksum == 0 && !p.IsIPv6() {
+		return true
+	}
+
+	sum := p.computeUDPChecksum()
+
+	return sum == p.UDPChecksum
+}
+
+// UpdateUDPChecksum computes the UDP checksum and updates the packet with
+// the value. IPv4 datagrams that were sent without a checksum are left
+// without one.
+func (p *Packet) UpdateUDPChecksum() {
+
+	if p.UDPChecksum == 0 && !p.IsIPv6() {
+		return
+	}
+
+	p.UDPChecksum = p.computeUDPChecksum()
+
+	binary.BigEndian.PutUint16(p.Buffer[p.l4BeginPos+UDPChecksumPos:p.l4BeginPos+UDPChecksumPos+2], p.UDPChecksum)
 }
 
 // UpdateTCPFlags
 func (p *Packet) updateTCPFlags(tcpFlags uint8) {
-	p.Buffer[tcpFlagsOffsetPos] = tcpFlags
+	p.Buffer[p.l4BeginPos+tcpFlagsOffsetPos] = tcpFlags
 }
 
 // ConvertAcktoFinAck function removes the data from the packet
@@ -80,7 +118,14 @@ func (p *Packet) String() string {
 	var buf bytes.Buffer
 	buf.WriteString("(error)")
 
-	header, err := ipv4.ParseHeader(p.Buffer)
+	var header fmt.Stringer
+	var err error
+
+	if p.IsIPv6() {
+		header, err = ipv6.ParseHeader(p.Buffer)
+	} else {
+		header, err = ipv4.ParseHeader(p.Buffer)
+	}
 
 	if err == nil {
 		buf.Reset()
@@ -114,35 +159,74 @@ func (p *Packet) computeIPChecksum() uint16 {
 
 // Computes the TCP header checksum. The packet is not modified.
 func (p *Packet) computeTCPChecksum() uint16 {
+	return p.computeL4Checksum(IPProtocolTCP, TCPChecksumPos)
+}
+
+// Computes the UDP checksum. The packet is not modified. A computed
+// value of zero is transmitted as all ones (RFC 768).
+func (p *Packet) computeUDPChecksum() uint16 {
+
+	sum := p.computeL4Checksum(IPProtocolUDP, UDPChecksumPos)
+	if sum == 0 {
+		return 0xffff
+	}
+
+	return sum
+}
+
+// Computes the L4 checksum over the pseudo-header, the L4 header and
+// payload of the given protocol. The packet is not modified.
+func (p *Packet) computeL4Checksum(proto uint8, checksumPos uint16) uint16 {
 
-	var pseudoHeaderLen uint16 = 12
 	tcpSize := uint16(len(p.Buffer)) - p.l4BeginPos
-	bufLen := pseudoHeaderLen + tcpSize
-	buf := make([]byte, bufLen)
+	tcpLength := tcpSize + uint16(len(p.tcpData)+len(p.tcpOptions))
+
+	var buf []byte
+	var pseudoHeaderLen uint16
+
+	if p.IsIPv6() {
+		// Construct the IPv6 pseudo-header (RFC 2460 section 8.1):
+		pseudoHeaderLen = 40
+		buf = make([]byte, pseudoHeaderLen+tcpSize)
 
-	// Construct the pseudo-header for TCP checksum computation:
+		// bytes 0-15: Source IP address
+		copy(buf[0:16], p.Buffer[ipv6SourceAddrPos:ipv6SourceAddrPos+16])
 
-	// bytes 0-3: Source IP address
-	copy(buf[0:4], p.Buffer[ipSourceAddrPos:ipSourceAddrPos+4])
+		// bytes 16-31: Destination IP address
+		copy(buf[16:32], p.Buffer[ipv6DestAddrPos:ipv6DestAddrPos+16])
 
-	// bytes 4-7: Destination IP address
-	copy(buf[4:8], p.Buffer[ipDestAddrPos:ipDestAddrPos+4])
+		// bytes 32-35: TCP buffer size (real header + payload)
+		binary.BigEndian.PutUint32(buf[32:36], uint32(tcpLength))
 
-	// byte 8: Constant zero
-	buf[8] = 0
+		// bytes 36-38: Constant zero, byte 39: Next header (6==TCP, 17==UDP)
+		buf[39] = proto
+	} else {
+		// Construct the pseudo-header for TCP checksum computation:
+		pseudoHeaderLen = 12
+		buf = make([]byte, pseudoHeaderLen+tcpSize)
 
-	// byte 9: Protocol (6==TCP)
-	buf[9] = 6
+		// bytes 0-3: Source IP address
+		copy(buf[0:4], p.Buffer[ipSourceAddrPos:ipSourceAddrPos+4])
 
-	// bytes 10,11: TCP buffer size (real header + payload)
-	binary.BigEndian.PutUint16(buf[10:12], tcpSize+uint16(len(p.tcpData)+len(p.tcpOptions)))
+		// bytes 4-7: Destination IP address
+		copy(buf[4:8], p.Buffer[ipDestAddrPos:ipDestAddrPos+4])
+
+		// byte 8: Constant zero
+		buf[8] = 0
+
+		// byte 9: Protocol (6==TCP, 17==UDP)
+		buf[9] = proto
+
+		// bytes 10,11: TCP buffer size (real header + payload)
+		binary.BigEndian.PutUint16(buf[10:12], tcpLength)
+	}
 
-	// bytes 12+: The TCP buffer (real header + payload)
-	copy(buf[12:], p.Buffer[p.l4BeginPos:])
+	// The TCP buffer (real header + payload)
+	copy(buf[pseudoHeaderLen:], p.Buffer[p.l4BeginPos:])
 
 	// Set current checksum to zero (in buf, not changing packet)
-	buf[pseudoHeaderLen+16] = 0
-	buf[pseudoHeaderLen+17] = 0
+	buf[pseudoHeaderLen+checksumPos] = 0
+	buf[pseudoHeaderLen+checksumPos+1] = 0
 
 	buf = append(buf, p.tcpOptions...)
 	buf = append(buf, p.tcpData...)
@@ -7,6 +7,7 @@ import (
 	"encoding/hex"
 	"flag"
 	"fmt"
+	"math"
 	"net"
 	"strconv"
 
@@ -64,45 +65,188 @@ func New(context uint64, bytes []byte, mark string) (packet *Packet, err error)
 	p.tcpOptions = []byte{}
 	p.tcpData = []byte{}
 
+	if len(bytes) == 0 {
+		return nil, fmt.Errorf("empty ip packet")
+	}
+
 	// IP Header Processing
-	p.ipHeaderLen = bytes[ipHdrLenPos] & ipHdrLenMask
-	p.IPProto = bytes[ipProtoPos]
-	p.IPTotalLength = binary.BigEndian.Uint16(bytes[ipLengthPos : ipLengthPos+2])
-	p.ipID = binary.BigEndian.Uint16(bytes[IPIDPos : IPIDPos+2])
-	p.ipChecksum = binary.BigEndian.Uint16(bytes[ipChecksumPos : ipChecksumPos+2])
-	p.SourceAddress = net.IP(bytes[ipSourceAddrPos : ipSourceAddrPos+4])
-	p.DestinationAddress = net.IP(bytes[ipDestAddrPos : ipDestAddrPos+4])
+	p.ipVersion = (bytes[ipHdrLenPos] & ipVersionMask) >> 4
+	switch p.ipVersion {
+	case IPVersion4:
+		err = p.parseIPv4Header()
+	case IPVersion6:
+		err = p.parseIPv6Header()
+	default:
+		err = fmt.Errorf("unsupported ip version: %d", p.ipVersion)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if p.IPProto == IPProtocolUDP {
+		// UDP Header Processing
+		udp := p.Buffer[p.l4BeginPos:]
+		p.SourcePort = binary.BigEndian.Uint16(udp[udpSourcePortPos : udpSourcePortPos+2])
+		p.DestinationPort = binary.BigEndian.Uint16(udp[udpDestPortPos : udpDestPortPos+2])
+		p.udpLength = binary.BigEndian.Uint16(udp[udpLengthPos : udpLengthPos+2])
+		p.UDPChecksum = binary.BigEndian.Uint16(udp[UDPChecksumPos : UDPChecksumPos+2])
+
+		if p.udpLength != p.IPTotalLength-p.l4BeginPos {
+			return nil, fmt.Errorf("udp length %d differs from bytes available %d", p.udpLength, p.IPTotalLength-p.l4BeginPos)
+		}
+
+		p.context = context
+
+		return &p, nil
+	}
+
+	// TCP Header Processing
+	tcp := p.Buffer[p.l4BeginPos:]
+	p.TCPChecksum = binary.BigEndian.Uint16(tcp[TCPChecksumPos : TCPChecksumPos+2])
+	p.SourcePort = binary.BigEndian.Uint16(tcp[tcpSourcePortPos : tcpSourcePortPos+2])
+	p.DestinationPort = binary.BigEndian.Uint16(tcp[tcpDestPortPos : tcpDestPortPos+2])
+	p.TCPAck = binary.BigEndian.Uint32(tcp[tcpAckPos : tcpAckPos+4])
+	p.TCPSeq = binary.BigEndian.Uint32(tcp[tcpSeqPos : tcpSeqPos+4])
+	p.tcpDataOffset = (tcp[tcpDataOffsetPos] & tcpDataOffsetMask) >> 4
+	p.TCPFlags = tcp[tcpFlagsOffsetPos]
+
+	p.context = context
+
+	return &p, nil
+}
+
+// parseIPv4Header validates and extracts the fields of an IPv4 header.
+func (p *Packet) parseIPv4Header() error {
+
+	if len(p.Buffer) < minIPHdrSize {
+		return fmt.Errorf("ip packet too small: length=%d", len(p.Buffer))
+	}
+
+	p.ipHeaderLen = p.Buffer[ipHdrLenPos] & ipHdrLenMask
+	p.IPProto = p.Buffer[ipProtoPos]
+	p.IPTotalLength = binary.BigEndian.Uint16(p.Buffer[ipLengthPos : ipLengthPos+2])
+	p.ipID = binary.BigEndian.Uint16(p.Buffer[IPIDPos : IPIDPos+2])
+	p.ipChecksum = binary.BigEndian.Uint16(p.Buffer[ipChecksumPos : ipChecksumPos+2])
+	p.SourceAddress = net.IP(p.Buffer[ipSourceAddrPos : ipSourceAddrPos+4])
+	p.DestinationAddress = net.IP(p.Buffer[ipDestAddrPos : ipDestAddrPos+4])
 
 	// Some sanity checking...
-	if p.IPTotalLength < minIPPacketLen {
-		return nil, fmt.Errorf("ip packet too small: hdrlen=%d", p.ipHeaderLen)
+	if p.IPTotalLength < minIPHdrSize+minL4HdrSize(p.IPProto) {
+		return fmt.Errorf("ip packet too small: hdrlen=%d", p.ipHeaderLen)
 	}
 
 	if p.ipHeaderLen != minIPHdrWords {
-		return nil, fmt.Errorf("packets with ip options not supported: hdrlen=%d", p.ipHeaderLen)
+		return fmt.Errorf("packets with ip options not supported: hdrlen=%d", p.ipHeaderLen)
+	}
+
+	if err := p.trimToTotalLength(); err != nil {
+		return err
+	}
+
+	p.l4BeginPos = minIPHdrSize
+
+	return nil
+}
+
+// parseIPv6Header validates and extracts the fields of an IPv6 header. The
+// extension headers are skipped so that l4BeginPos points to the TCP/UDP
+// header. Fragmented packets are not supported since the L4 header may not
+// be present.
+func (p *Packet) parseIPv6Header() error {
+
+	if len(p.Buffer) < ipv6HdrSize {
+		return fmt.Errorf("ipv6 packet too small: length=%d", len(p.Buffer))
+	}
+
+	// The length arithmetic below is done on ints so that crafted extension
+	// header chains can not wrap the uint16 offsets.
+	totalLength := ipv6HdrSize + int(binary.BigEndian.Uint16(p.Buffer[ipv6PayloadLenPos:ipv6PayloadLenPos+2]))
+	if totalLength > math.MaxUint16 {
+		return fmt.Errorf("ipv6 packet too large: length=%d", totalLength)
+	}
+
+	p.IPTotalLength = uint16(totalLength)
+	p.SourceAddress = net.IP(p.Buffer[ipv6SourceAddrPos : ipv6SourceAddrPos+net.IPv6len])
+	p.DestinationAddress = net.IP(p.Buffer[ipv6DestAddrPos : ipv6DestAddrPos+net.IPv6len])
+
+	if err := p.trimToTotalLength(); err != nil {
+		return err
 	}
 
+	nextHeader := p.Buffer[ipv6NextHeaderPos]
+	offset := ipv6HdrSize
+
+	for {
+		var extLen int
+
+		switch nextHeader {
+		case ipv6HopByHopHdr, ipv6RoutingHdr, ipv6DestinationOptsHdr:
+			if offset+2 > totalLength {
+				return fmt.Errorf("truncated ipv6 extension header: type=%d", nextHeader)
+			}
+			extLen = (int(p.Buffer[offset+1]) + 1) * 8
+		case ipv6AuthenticationHdr:
+			if offset+2 > totalLength {
+				return fmt.Errorf("truncated ipv6 extension header: type=%d", nextHeader)
+			}
+			extLen = (int(p.Buffer[offset+1]) + 2) * 4
+		case ipv6FragmentHdr:
+			if offset+ipv6FragmentHdrLen > totalLength {
+				return fmt.Errorf("truncated ipv6 extension header: type=%d", nextHeader)
+			}
+			// Only atomic fragments (offset 0, no more fragments) carry a full L4 header.
+			if binary.BigEndian.Uint16(p.Buffer[offset+2:offset+4]) != 0 {
+				return fmt.Errorf("fragmented ipv6 packets not supported")
+			}
+			extLen = ipv6FragmentHdrLen
+		default:
+			if offset > totalLength || totalLength-offset < int(minL4HdrSize(nextHeader)) {
+				return fmt.Errorf("ipv6 packet too small: l4offset=%d length=%d", offset, totalLength)
+			}
+
+			p.IPProto = nextHeader
+			p.l4BeginPos = uint16(offset)
+
+			return nil
+		}
+
+		if offset+extLen > totalLength {
+			return fmt.Errorf("ipv6 extension header exceeds packet: type=%d length=%d", nextHeader, extLen)
+		}
+
+		nextHeader = p.Buffer[offset]
+		offset += extLen
+	}
+}
+
+// minL4HdrSize returns the minimum size of the L4 header for the given protocol
+func minL4HdrSize(proto uint8) uint16 {
+
+	if proto == IPProtocolUDP {
+		return minUDPHdrSize
+	}
+
+	return minTCPHdrSize
+}
+
+// trimToTotalLength ensures that the buffer holds exactly the number of
+// bytes stated in the ip header.
+func (p *Packet) trimToTotalLength() error {
+
 	if p.IPTotalLength != uint16(len(p.Buffer)) {
 		if p.IPTotalLength < uint16(len(p.Buffer)) {
 			p.Buffer = p.Buffer[:p.IPTotalLength]
 		} else {
-			return nil, fmt.Errorf("stated ip packet length %d differs from bytes available %d", p.IPTotalLength, len(p.Buffer))
+			return fmt.Errorf("stated ip packet length %d differs from bytes available %d", p.IPTotalLength, len(p.Buffer))
 		}
 	}
 
-	// TCP Header Processing
-	p.l4BeginPos = minIPHdrSize
-	p.TCPChecksum = binary.BigEndian.Uint16(bytes[TCPChecksumPos : TCPChecksumPos+2])
-	p.SourcePort = binary.BigEndian.Uint16(bytes[tcpSourcePortPos : tcpSourcePortPos+2])
-	p.DestinationPort = binary.BigEndian.Uint16(bytes[tcpDestPortPos : tcpDestPortPos+2])
-	p.TCPAck = binary.BigEndian.Uint32(bytes[tcpAckPos : tcpAckPos+4])
-	p.TCPSeq = binary.BigEndian.Uint32(bytes[tcpSeqPos : tcpSeqPos+4])
-	p.tcpDataOffset = (bytes[tcpDataOffsetPos] & tcpDataOffsetMask) >> 4
-	p.TCPFlags = bytes[tcpFlagsOffsetPos]
-
-	p.context = context
+	return nil
+}
 
-	return &p, nil
+// IsIPv6 returns true if this is an IPv6 packet
+func (p *Packet) IsIPv6() bool {
+	return p.ipVersion == IPVersion6
 }
 
 // IsEmptyTCPPayload returns the TCP data offset
@@ -147,6 +291,11 @@ func (p *Packet) GetIPLength() uint16 {
 	return p.IPTotalLength
 }
 
+// TCPFlagsString returns the TCP flags in the format used by the packet logs
+func (p *Packet) TCPFlagsString() string {
+	return tcpFlagsToStr(p.TCPFlags)
+}
+
 // Print is a print helper function
 func (p *Packet) Print(context uint64) {
 
@@ -188,8 +337,8 @@ func (p *Packet) Print(context uint64) {
 			p.ipID,
 			flagsToDir(p.context|context),
 			flagsToStr(p.context|context),
-			p.SourceAddress.To4().String(), p.SourcePort,
-			p.DestinationAddress.To4().String(), p.DestinationPort,
+			p.SourceAddress.String(), p.SourcePort,
+			p.DestinationAddress.String(), p.DestinationPort,
 			tcpFlagsToStr(p.TCPFlags),
 			p.TCPSeq, p.TCPAck, p.IPTotalLength-p.TCPDataStartBytes(),
 			expAck, expAck, p.tcpDataOffset,
@@ -257,6 +406,13 @@ func (p *Packet) CheckTCPAuthenticationOption(iOptionLength int) (err error) {
 // FixupIPHdrOnDataModify modifies the IP header fields and checksum
 func (p *Packet) FixupIPHdrOnDataModify(old, new uint16) {
 
+	// IPv6 has no header checksum, only the payload length needs an update.
+	if p.IsIPv6() {
+		p.IPTotalLength = p.IPTotalLength + new - old
+		binary.BigEndian.PutUint16(p.Buffer[ipv6PayloadLenPos:ipv6PayloadLenPos+2], p.IPTotalLength-ipv6HdrSize)
+		return
+	}
+
 	// IP Header Processing
 	// IP chekcsum fixup.
 	p.ipChecksum = incCsum16(p.ipChecksum, old, new)
@@ -271,28 +427,28 @@ func (p *Packet) FixupIPHdrOnDataModify(old, new uint16) {
 func (p *Packet) IncreaseTCPSeq(incr uint32) {
 
 	p.TCPSeq = p.TCPSeq + incr
-	binary.BigEndian.PutUint32(p.Buffer[tcpSeqPos:tcpSeqPos+4], p.TCPSeq)
+	binary.BigEndian.PutUint32(p.Buffer[p.l4BeginPos+tcpSeqPos:p.l4BeginPos+tcpSeqPos+4], p.TCPSeq)
 }
 
 // DecreaseTCPSeq decreases TCP seq number by decr
 func (p *Packet) DecreaseTCPSeq(decr uint32) {
 
 	p.TCPSeq = p.TCPSeq - decr
-	binary.BigEndian.PutUint32(p.Buffer[tcpSeqPos:tcpSeqPos+4], p.TCPSeq)
+	binary.BigEndian.PutUint32(p.Buffer[p.l4BeginPos+tcpSeqPos:p.l4BeginPos+tcpSeqPos+4], p.TCPSeq)
 }
 
 // IncreaseTCPAck increases TCP ack number by incr
 func (p *Packet) IncreaseTCPAck(incr uint32) {
 
 	p.TCPAck = p.TCPAck + incr
-	binary.BigEndian.PutUint32(p.Buffer[tcpAckPos:tcpAckPos+4], p.TCPAck)
+	binary.BigEndian.PutUint32(p.Buffer[p.l4BeginPos+tcpAckPos:p.l4BeginPos+tcpAckPos+4], p.TCPAck)
 }
 
 // DecreaseTCPAck decreases TCP ack number by decr
 func (p *Packet) DecreaseTCPAck(decr uint32) {
 
 	p.TCPAck = p.TCPAck - decr
-	binary.BigEndian.PutUint32(p.Buffer[tcpAckPos:tcpAckPos+4], p.TCPAck)
+	binary.BigEndian.PutUint32(p.Buffer[p.l4BeginPos+tcpAckPos:p.l4BeginPos+tcpAckPos+4], p.TCPAck)
 }
 
 // FixupTCPHdrOnTCPDataDetach modifies the TCP header fields and checksum
@@ -300,7 +456,7 @@ func (p *Packet) FixupTCPHdrOnTCPDataDetach(dataLength uint16, optionLength uint
 
 	// Update DataOffset
 	p.tcpDataOffset = p.tcpDataOffset - uint8(optionLength/4)
-	p.Buffer[tcpDataOffsetPos] = p.tcpDataOffset << 4
+	p.Buffer[p.l4BeginPos+tcpDataOffsetPos] = p.tcpDataOffset << 4
 }
 
 // tcpDataDetach splits the p.Buffer into p.Buffer (header + some options), p.tcpOptions (optionLength) and p.TCPData (dataLength)
@@ -360,8 +516,8 @@ func (p *Packet) FixupTCPHdrOnTCPDataAttach(tcpOptions []byte, tcpData []byte) {
 
 	// Modify the fields
 	p.tcpDataOffset = p.tcpDataOffset + uint8(numberOfOptions)
-	binary.BigEndian.PutUint16(p.Buffer[TCPChecksumPos:TCPChecksumPos+2], p.TCPChecksum)
-	p.Buffer[tcpDataOffsetPos] = p.tcpDataOffset << 4
+	binary.BigEndian.PutUint16(p.Buffer[p.l4BeginPos+TCPChecksumPos:p.l4BeginPos+TCPChecksumPos+2], p.TCPChecksum)
+	p.Buffer[p.l4BeginPos+tcpDataOffsetPos] = p.tcpDataOffset << 4
 }
 
 // tcpDataAttach splits the p.Buffer into p.Buffer (header + some options), p.tcpOptions (optionLength) and p.TCPData (dataLength)
@@ -397,6 +553,91 @@ func (p *Packet) TCPDataAttach(tcpOptions []byte, tcpData []byte) (err error) {
 	return
 }
 
+// CheckTCPEncryptionOption ensures the encryption option of length
+// optionLength is the last option of the packet
+func (p *Packet) CheckTCPEncryptionOption(optionLength int) error {
+
+	start := int(p.TCPDataStartBytes()) - optionLength
+	if optionLength < 2 || start < int(p.l4BeginPos)+minTCPHdrSize || len(p.Buffer) < start+2 {
+		return fmt.Errorf("tcp encryption option not found: optionlength=%d", optionLength)
+	}
+
+	if p.Buffer[start] != TCPEncryptionOption || int(p.Buffer[start+1]) != optionLength {
+		return fmt.Errorf("tcp encryption option not found: optionlength=%d", optionLength)
+	}
+
+	return nil
+}
+
+// TCPOptionSpace returns the number of bytes of options that can still be
+// added to the TCP header
+func (p *Packet) TCPOptionSpace() int {
+	return tcpMaxHeaderLen - int(p.tcpDataOffset)*4
+}
+
+// DecreaseTCPMss decreases the maximum segment size announced by the packet
+// by decr. Packets without the MSS option are not modified.
+func (p *Packet) DecreaseTCPMss(decr uint16) {
+
+	p.walkTCPOptions(func(pos int, kind uint8, length uint8) {
+		if kind != TCPMssOption || length != TCPMssOptionLen {
+			return
+		}
+
+		mss := binary.BigEndian.Uint16(p.Buffer[pos+2 : pos+4])
+		if mss > decr {
+			binary.BigEndian.PutUint16(p.Buffer[pos+2:pos+4], mss-decr)
+		}
+	})
+}
+
+// DisableTCPSack replaces the SACK permitted option of the packet by
+// no-operation options
+func (p *Packet) DisableTCPSack() {
+
+	p.walkTCPOptions(func(pos int, kind uint8, length uint8) {
+		if kind == tcpSackPermittedOption {
+			for i := pos; i < pos+int(length); i++ {
+				p.Buffer[i] = tcpNopOption
+			}
+		}
+	})
+}
+
+// walkTCPOptions calls fn with the position, type and length of every
+// option of the TCP header held in the buffer
+func (p *Packet) walkTCPOptions(fn func(pos int, kind uint8, length uint8)) {
+
+	end := int(p.TCPDataStartBytes())
+	if end > len(p.Buffer) {
+		end = len(p.Buffer)
+	}
+
+	for pos := int(p.l4BeginPos) + minTCPHdrSize; pos < end; {
+		kind := p.Buffer[pos]
+
+		switch kind {
+		case tcpEndOption:
+			return
+		case tcpNopOption:
+			pos++
+			continue
+		}
+
+		if pos+1 >= end {
+			return
+		}
+
+		length := p.Buffer[pos+1]
+		if length < 2 || pos+int(length) > end {
+			return
+		}
+
+		fn(pos, kind, length)
+		pos += int(length)
+	}
+}
+
 // L4FlowHash calculate a hash string based on the 4-tuple
 func (p *Packet) L4FlowHash() string {
 	return p.SourceAddress.String() + ":" + p.DestinationAddress.String() + ":" + strconv.Itoa(int(p.SourcePort)) + ":" + strconv.Itoa(int(p.DestinationPort))
@@ -415,17 +656,80 @@ func (p *Packet) SourcePortHash(stage uint64) string {
 	return p.SourceAddress.String() + ":" + strconv.Itoa(int(p.SourcePort))
 }
 
-// ID returns the IP ID of the packet
+// ID returns the IP ID of the packet. IPv6 packets have no IP ID and
+// always return "0".
 func (p *Packet) ID() string {
 	return strconv.Itoa(int(p.ipID))
 }
 
-//TCPOptionLength returns the length of tcpoptions
+// TCPOptionLength returns the length of tcpoptions
 func (p *Packet) TCPOptionLength() int {
 	return len(p.tcpOptions)
 }
 
-//TCPDataLength -- returns the length of tcp options
+// TCPDataLength -- returns the length of tcp options
 func (p *Packet) TCPDataLength() int {
 	return len(p.tcpData)
 }
+
+// UDPDataStartBytes provides the udp payload start offset in bytes
+func (p *Packet) UDPDataStartBytes() uint16 {
+	return p.l4BeginPos + minUDPHdrSize
+}
+
+// ReadUDPData returns the payload of a UDP packet
+func (p *Packet) ReadUDPData() []byte {
+	return p.Buffer[p.UDPDataStartBytes():p.IPTotalLength]
+}
+
+// UDPDataAttach inserts data at the beginning of the UDP payload and
+// updates the UDP length and the IP header. The UDP checksum must be
+// updated by the caller once all modifications are done.
+func (p *Packet) UDPDataAttach(data []byte) (err error) {
+
+	if int(p.IPTotalLength)+len(data) > 0xffff {
+		return fmt.Errorf("udp data attachment failed: packet too large: length=%d", int(p.IPTotalLength)+len(data))
+	}
+
+	start := p.UDPDataStartBytes()
+
+	buffer := make([]byte, 0, int(p.IPTotalLength)+len(data))
+	buffer = append(buffer, p.Buffer[:start]...)
+	buffer = append(buffer, data...)
+	buffer = append(buffer, p.Buffer[start:p.IPTotalLength]...)
+	p.Buffer = buffer
+
+	p.fixupUDPHdrOnDataModify(p.IPTotalLength, p.IPTotalLength+uint16(len(data)))
+
+	return nil
+}
+
+// UDPDataDetach removes length bytes from the beginning of the UDP payload
+// and updates the UDP length and the IP header. The UDP checksum must be
+// updated by the caller once all modifications are done.
+func (p *Packet) UDPDataDetach(length uint16) (err error) {
+
+	start := p.UDPDataStartBytes()
+
+	if start+length > p.IPTotalLength {
+		return fmt.Errorf("udp data detach failed: length=%d payload=%d", length, p.IPTotalLength-start)
+	}
+
+	buffer := make([]byte, 0, p.IPTotalLength-length)
+	buffer = append(buffer, p.Buffer[:start]...)
+	buffer = append(buffer, p.Buffer[start+length:p.IPTotalLength]...)
+	p.Buffer = buffer
+
+	p.fixupUDPHdrOnDataModify(p.IPTotalLength, p.IPTotalLength-length)
+
+	return nil
+}
+
+// fixupUDPHdrOnDataModify modifies the UDP length and the IP header fields
+func (p *Packet) fixupUDPHdrOnDataModify(old, new uint16) {
+
+	p.udpLength = p.udpLength + new - old
+	binary.BigEndian.PutUint16(p.Buffer[p.l4BeginPos+udpLengthPos:p.l4BeginPos+udpLengthPos+2], p.udpLength)
+
+	p.FixupIPHdrOnDataModify(old, new)
+}
@@ -11,6 +11,11 @@ const (
 	synIPLenTooSmall
 	synMissingBytes
 	synBadIPChecksum
+	synIPv6GoodTCPChecksum
+	synIPv6HopByHop
+	synIPv6Fragment
+	udpIPv4GoodChecksum
+	udpIPv6GoodChecksum
 )
 
 var testPackets = [][]byte{
@@ -57,7 +62,46 @@ var testPackets = [][]byte{
 		0x00, 0x7f, 0x00, 0x00, 0x01, 0x7f, 0x00, 0x00, 0x01, 0xb2, 0x64, 0x00, 0x63, 0x58, 0xd1,
 		0x24, 0xd9, 0x00, 0x00, 0x00, 0x00, 0xa0, 0x02, 0xaa, 0xaa, 0xfe, 0x30, 0x00, 0x00, 0x02,
 		0x04, 0xff, 0xd7, 0x04, 0x02, 0x08, 0x0a, 0x00, 0xc5, 0x8e, 0xf7, 0x00, 0x00, 0x00, 0x00,
-		0x01, 0x03, 0x03, 0x07}}
+		0x01, 0x03, 0x03, 0x07},
+
+	// IPv6 SYN packet from [::1]:40000 to [fd00::2]:99 with an MSS option.
+	// Everything is correct.
+	[]byte{0x60, 0x00, 0x00, 0x00, 0x00, 0x18, 0x06, 0x40, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x02, 0x9c, 0x40, 0x00, 0x63, 0x2c, 0x32, 0xa8, 0xd6,
+		0x00, 0x00, 0x00, 0x00, 0x60, 0x02, 0xaa, 0xaa, 0x84, 0xbb, 0x00, 0x00,
+		0x02, 0x04, 0xff, 0xc4},
+
+	// Same IPv6 SYN packet with a hop-by-hop extension header (PadN).
+	[]byte{0x60, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00, 0x40, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x02, 0x06, 0x00, 0x01, 0x04, 0x00, 0x00, 0x00, 0x00,
+		0x9c, 0x40, 0x00, 0x63, 0x2c, 0x32, 0xa8, 0xd6, 0x00, 0x00, 0x00, 0x00,
+		0x60, 0x02, 0xaa, 0xaa, 0x84, 0xbb, 0x00, 0x00, 0x02, 0x04, 0xff, 0xc4},
+
+	// Same IPv6 SYN packet with a fragment header (offset 8, more fragments).
+	[]byte{0x60, 0x00, 0x00, 0x00, 0x00, 0x20, 0x2c, 0x40, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x02, 0x06, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x01,
+		0x9c, 0x40, 0x00, 0x63, 0x2c, 0x32, 0xa8, 0xd6, 0x00, 0x00, 0x00, 0x00,
+		0x60, 0x02, 0xaa, 0xaa, 0x84, 0xbb, 0x00, 0x00, 0x02, 0x04, 0xff, 0xc4},
+
+	// UDP datagram from 127.0.0.1:5353 to 127.0.0.1:53 with payload "hello".
+	// Everything is correct.
+	[]byte{0x45, 0x00, 0x00, 0x21, 0x12, 0x34, 0x40, 0x00, 0x40, 0x11, 0x2a, 0x96,
+		0x7f, 0x00, 0x00, 0x01, 0x7f, 0x00, 0x00, 0x01, 0x14, 0xe9, 0x00, 0x35,
+		0x00, 0x0d, 0xa8, 0xe1, 0x68, 0x65, 0x6c, 0x6c, 0x6f},
+
+	// UDP datagram from [::1]:5353 to [fd00::2]:53 with payload "hello".
+	// Everything is correct.
+	[]byte{0x60, 0x00, 0x00, 0x00, 0x00, 0x0d, 0x11, 0x40, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x02, 0x14, 0xe9, 0x00, 0x35, 0x00, 0x0d, 0xa9, 0xe0,
+		0x68, 0x65, 0x6c, 0x6c, 0x6f}}
 
 func TestGoodPacket(t *testing.T) {
 
@@ -332,6 +376,239 @@ func TestAddTags(t *testing.T) {
 	*/
 }
 
+func TestIPv6Packet(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestPacket(t, synIPv6GoodTCPChecksum)
+	t.Log(pkt.String())
+
+	if !pkt.IsIPv6() {
+		t.Error("Expected an IPv6 packet")
+	}
+
+	if pkt.IPProto != IPProtocolTCP {
+		t.Errorf("Unexpected protocol %d", pkt.IPProto)
+	}
+
+	if src := pkt.SourceAddress.String(); src != "::1" {
+		t.Errorf("Unexpected source address %s", src)
+	}
+
+	if dest := pkt.DestinationAddress.String(); dest != "fd00::2" {
+		t.Errorf("Unexpected destination address %s", dest)
+	}
+
+	if pkt.SourcePort != 40000 || pkt.DestinationPort != 99 {
+		t.Errorf("Unexpected ports %d %d", pkt.SourcePort, pkt.DestinationPort)
+	}
+
+	if pkt.TCPFlags&TCPSynAckMask != TCPSynMask {
+		t.Error("Expected a SYN packet")
+	}
+
+	if !pkt.VerifyTCPChecksum() {
+		t.Error("TCP checksum failed")
+	}
+
+	if !pkt.IsEmptyTCPPayload() {
+		t.Error("Test SYN packet should have no TCP payload")
+	}
+}
+
+func TestIPv6ExtensionHeaders(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestPacket(t, synIPv6HopByHop)
+
+	if pkt.IPProto != IPProtocolTCP {
+		t.Errorf("Unexpected protocol %d", pkt.IPProto)
+	}
+
+	if pkt.SourcePort != 40000 || pkt.DestinationPort != 99 {
+		t.Errorf("Unexpected ports %d %d", pkt.SourcePort, pkt.DestinationPort)
+	}
+
+	if pkt.TCPSeq != 0x2c32a8d6 {
+		t.Errorf("Unexpected sequence number %d", pkt.TCPSeq)
+	}
+
+	if !pkt.VerifyTCPChecksum() {
+		t.Error("TCP checksum failed")
+	}
+}
+
+func TestIPv6Fragment(t *testing.T) {
+
+	t.Parallel()
+	err := getTestPacketWithError(t, synIPv6Fragment)
+	t.Log(err)
+	if err == nil {
+		t.Error("Expected failure given fragmented packet")
+	}
+}
+
+func TestIPv6MalformedExtensionHeaders(t *testing.T) {
+
+	t.Parallel()
+
+	tests := map[string]func(b []byte){
+		"extension length beyond packet": func(b []byte) {
+			b[41] = 0xff
+		},
+		"extension chain consumes l4 header": func(b []byte) {
+			b[40] = ipv6DestinationOptsHdr
+			b[41] = 0x03
+		},
+		"payload shorter than extension header": func(b []byte) {
+			b[5] = 0x01
+		},
+	}
+
+	for name, mutate := range tests {
+		tmp := make([]byte, len(testPackets[synIPv6HopByHop]))
+		copy(tmp, testPackets[synIPv6HopByHop])
+		mutate(tmp)
+
+		if _, err := New(0, tmp, "0"); err == nil {
+			t.Errorf("%s: expected failure given malformed ipv6 packet", name)
+		}
+	}
+}
+
+func TestIPv6DataAttachDetach(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestPacket(t, synIPv6HopByHop)
+	length := pkt.IPTotalLength
+
+	options := []byte{TCPAuthenticationOption, 4, 0, 0}
+	data := []byte("token")
+	if err := pkt.TCPDataAttach(options, data); err != nil {
+		t.Fatal(err)
+	}
+	pkt.UpdateTCPChecksum()
+
+	pkt2, err := New(0, pkt.GetBytes(), "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pkt2.IPTotalLength != length+uint16(len(options)+len(data)) {
+		t.Errorf("Unexpected length %d", pkt2.IPTotalLength)
+	}
+
+	if !pkt2.VerifyTCPChecksum() {
+		t.Error("TCP checksum is wrong after attach")
+	}
+
+	if err := pkt2.CheckTCPAuthenticationOption(len(options)); err != nil {
+		t.Error(err)
+	}
+
+	if string(pkt2.ReadTCPData()) != "token" {
+		t.Errorf("Unexpected payload %s", pkt2.ReadTCPDataString())
+	}
+
+	if err := pkt2.TCPDataDetach(uint16(len(options))); err != nil {
+		t.Fatal(err)
+	}
+
+	if pkt2.IPTotalLength != length {
+		t.Errorf("Unexpected length after detach %d", pkt2.IPTotalLength)
+	}
+}
+
+func TestUDPPacket(t *testing.T) {
+
+	t.Parallel()
+	for _, id := range []SamplePacketName{udpIPv4GoodChecksum, udpIPv6GoodChecksum} {
+		pkt := getTestPacket(t, id)
+
+		if pkt.IPProto != IPProtocolUDP {
+			t.Errorf("Unexpected protocol %d", pkt.IPProto)
+		}
+
+		if pkt.SourcePort != 5353 || pkt.DestinationPort != 53 {
+			t.Errorf("Unexpected ports %d %d", pkt.SourcePort, pkt.DestinationPort)
+		}
+
+		if string(pkt.ReadUDPData()) != "hello" {
+			t.Errorf("Unexpected payload %s", string(pkt.ReadUDPData()))
+		}
+
+		if !pkt.VerifyUDPChecksum() {
+			t.Error("UDP checksum failed")
+		}
+	}
+}
+
+func TestUDPShortLength(t *testing.T) {
+
+	t.Parallel()
+	buf := append([]byte{}, testPackets[udpIPv4GoodChecksum]...)
+	// Claim a UDP length larger than the datagram
+	buf[25] = 0x10
+	if _, err := New(0, buf, "0"); err == nil {
+		t.Error("Expected failure given wrong udp length")
+	}
+}
+
+func TestUDPDataAttachDetach(t *testing.T) {
+
+	t.Parallel()
+	for _, id := range []SamplePacketName{udpIPv4GoodChecksum, udpIPv6GoodChecksum} {
+		pkt := getTestPacket(t, id)
+		length := pkt.IPTotalLength
+
+		if err := pkt.UDPDataAttach([]byte("token")); err != nil {
+			t.Fatal(err)
+		}
+		pkt.UpdateUDPChecksum()
+
+		pkt2, err := New(0, pkt.GetBytes(), "0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if pkt2.IPTotalLength != length+5 {
+			t.Errorf("Unexpected length %d", pkt2.IPTotalLength)
+		}
+
+		if !pkt2.VerifyIPChecksum() {
+			t.Error("IP checksum is wrong after attach")
+		}
+
+		if !pkt2.VerifyUDPChecksum() {
+			t.Error("UDP checksum is wrong after attach")
+		}
+
+		if string(pkt2.ReadUDPData()) != "tokenhello" {
+			t.Errorf("Unexpected payload %s", string(pkt2.ReadUDPData()))
+		}
+
+		if err := pkt2.UDPDataDetach(5); err != nil {
+			t.Fatal(err)
+		}
+		pkt2.UpdateUDPChecksum()
+
+		if pkt2.IPTotalLength != length {
+			t.Errorf("Unexpected length after detach %d", pkt2.IPTotalLength)
+		}
+
+		if !pkt2.VerifyUDPChecksum() {
+			t.Error("UDP checksum is wrong after detach")
+		}
+
+		if string(pkt2.ReadUDPData()) != "hello" {
+			t.Errorf("Unexpected payload after detach %s", string(pkt2.ReadUDPData()))
+		}
+
+		if err := pkt2.UDPDataDetach(10); err == nil {
+			t.Error("Expected failure detaching more than the payload")
+		}
+	}
+}
+
 func TestRawChecksums(t *testing.T) {
 
 	t.Parallel()
@@ -375,3 +652,32 @@ func getTestPacketWithError(t *testing.T, id SamplePacketName) error {
 	_, err := New(0, tmp, "0")
 	return err
 }
+
+func TestTCPOptions(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestPacket(t, synGoodTCPChecksum)
+
+	if pkt.TCPOptionSpace() != 20 {
+		t.Errorf("Expected 20 bytes of option space, got %d", pkt.TCPOptionSpace())
+	}
+
+	pkt.DecreaseTCPMss(20)
+	if pkt.Buffer[42] != 0xff || pkt.Buffer[43] != 0xc3 {
+		t.Errorf("MSS not decreased: %x", pkt.Buffer[40:44])
+	}
+
+	pkt.DisableTCPSack()
+	if pkt.Buffer[44] != tcpNopOption || pkt.Buffer[45] != tcpNopOption {
+		t.Errorf("SACK permitted option not removed: %x", pkt.Buffer[44:46])
+	}
+
+	// The other options are not modified
+	if pkt.Buffer[46] != 0x08 || pkt.Buffer[57] != 0x03 || pkt.Buffer[59] != 0x07 {
+		t.Errorf("Unexpected options: %x", pkt.Buffer[40:60])
+	}
+
+	if err := pkt.CheckTCPEncryptionOption(20); err == nil {
+		t.Error("Expected no encryption option")
+	}
+}
@@ -1,15 +1,21 @@
 package pkiverifier
 
 import (
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/x509"
 	"errors"
+	"fmt"
 	"math/big"
+	"sync"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/revocation"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/signer"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
 )
 
@@ -25,34 +31,72 @@ type PKITokenIssuer interface {
 
 // PKITokenVerifier is the interface of an object that can verify a PKI token.
 type PKITokenVerifier interface {
-	Verify([]byte) (*ecdsa.PublicKey, error)
+	Verify([]byte) (crypto.PublicKey, error)
+	// SetRevocationList sets the list of the revoked keys that are rejected.
+	SetRevocationList(*revocation.List)
 }
 
+// verifierClaims carry the public key of a certificate. P-256 keys are carried
+// in X and Y only, P-384 keys with their curve and Ed25519 keys in K.
 type verifierClaims struct {
-	X *big.Int
-	Y *big.Int
+	X     *big.Int `json:",omitempty"`
+	Y     *big.Int `json:",omitempty"`
+	Curve string   `json:"C,omitempty"`
+	K     []byte   `json:",omitempty"`
 	jwt.StandardClaims
 }
 
+// VerifierKey is a public key of a token authority with the period during
+// which the tokens it signed are accepted. Zero times are not enforced.
+type VerifierKey struct {
+	Key       crypto.PublicKey
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// valid returns true if the key can be used at the given time.
+func (k *VerifierKey) valid(now time.Time) bool {
+
+	if !k.NotBefore.IsZero() && now.Before(k.NotBefore) {
+		return false
+	}
+
+	if !k.NotAfter.IsZero() && now.After(k.NotAfter) {
+		return false
+	}
+
+	return true
+}
+
+// cachedKey is a verified key with the time after which it must be verified
+// again.
+type cachedKey struct {
+	key    crypto.PublicKey
+	expiry time.Time
+}
+
 type tokenManager struct {
-	publicKeys []*ecdsa.PublicKey
-	privateKey *ecdsa.PrivateKey
-	signMethod jwt.SigningMethod
+	publicKeys []crypto.PublicKey
+	windows    []*VerifierKey
+	privateKey crypto.Signer
 	keycache   cache.DataStore
 	validity   time.Duration
+	revoked    *revocation.List
+	sync.RWMutex
 }
 
-// NewPKIIssuer initializes a new signer structure
-func NewPKIIssuer(privateKey *ecdsa.PrivateKey) PKITokenIssuer {
+// NewPKIIssuer initializes a new signer structure. The tokens are signed with
+// the algorithm of the key: ES256, ES384 or EdDSA.
+func NewPKIIssuer(privateKey crypto.Signer) PKITokenIssuer {
 
 	return &tokenManager{
 		privateKey: privateKey,
-		signMethod: jwt.SigningMethodES256,
 	}
 }
 
-// NewPKIVerifier returns a new PKIConfiguration.
-func NewPKIVerifier(publicKeys []*ecdsa.PublicKey, cacheValidity time.Duration) PKITokenVerifier {
+// NewPKIVerifier returns a new PKIConfiguration. The public keys are P-256,
+// P-384 or Ed25519 keys.
+func NewPKIVerifier(publicKeys []crypto.PublicKey, cacheValidity time.Duration) PKITokenVerifier {
 
 	validity := defaultValidity * time.Second
 	if cacheValidity > 0 {
@@ -61,56 +105,135 @@ func NewPKIVerifier(publicKeys []*ecdsa.PublicKey, cacheValidity time.Duration)
 
 	return &tokenManager{
 		publicKeys: publicKeys,
-		signMethod: jwt.SigningMethodES256,
 		keycache:   cache.NewCacheWithExpiration("PKIVerifierKey", validity),
 		validity:   validity,
 	}
 }
 
+// NewPKIVerifierWithValidity returns a new PKIConfiguration that only accepts
+// the tokens signed by keys within their validity period. It allows several
+// token authorities to overlap while they are rotated.
+func NewPKIVerifierWithValidity(keys []*VerifierKey, cacheValidity time.Duration) PKITokenVerifier {
+
+	publicKeys := make([]crypto.PublicKey, len(keys))
+	for i, k := range keys {
+		publicKeys[i] = k.Key
+	}
+
+	t := NewPKIVerifier(publicKeys, cacheValidity).(*tokenManager)
+	t.windows = keys
+
+	return t
+}
+
 // Verify verifies a token and returns the public key
-func (p *tokenManager) Verify(token []byte) (*ecdsa.PublicKey, error) {
+func (p *tokenManager) Verify(token []byte) (crypto.PublicKey, error) {
 
 	tokenString := string(token)
-	if pk, err := p.keycache.Get(tokenString); err == nil {
-		return pk.(*ecdsa.PublicKey), nil
+	now := time.Now()
+
+	if entry, err := p.keycache.Get(tokenString); err == nil {
+		if cached := entry.(*cachedKey); now.Before(cached.expiry) {
+			return p.checkRevocation(cached.key)
+		}
+		p.keycache.Remove(tokenString) // nolint
 	}
 
 	claims := &verifierClaims{}
 	var JWTToken *jwt.Token
 	var err error
-	for _, pk := range p.publicKeys {
+
+	for i, pk := range p.publicKeys {
+		if p.windows != nil && !p.windows[i].valid(now) {
+			continue
+		}
 
 		JWTToken, err = jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+			// The algorithm of the token must be the one of the key
+			method, err := signer.SigningMethod(pk)
+			if err != nil {
+				return nil, err
+			}
+			if token.Method.Alg() != method.Alg() {
+				return nil, fmt.Errorf("unexpected signing method: %s", token.Method.Alg())
+			}
 			return pk, nil
 		})
 		if err != nil || !JWTToken.Valid {
 			continue
 		}
 
-		pk := KeyFromClaims(claims)
+		key, err := KeyFromClaims(claims)
+		if err != nil {
+			return nil, err
+		}
 
-		if time.Now().Add(p.validity).Unix() <= claims.ExpiresAt {
-			p.keycache.AddOrUpdate(tokenString, pk)
+		// The key is not cached beyond the validity of the token authority
+		expiry := now.Add(p.validity)
+		if p.windows != nil && !p.windows[i].NotAfter.IsZero() && p.windows[i].NotAfter.Before(expiry) {
+			expiry = p.windows[i].NotAfter
 		}
 
-		return pk, nil
+		if expiry.Unix() <= claims.ExpiresAt {
+			p.keycache.AddOrUpdate(tokenString, &cachedKey{key: key, expiry: expiry})
+		}
+
+		return p.checkRevocation(key)
 	}
 
 	return nil, errors.New("unable to verify token against any available public key")
 }
 
+// SetRevocationList sets the list of the revoked keys that are rejected.
+func (p *tokenManager) SetRevocationList(l *revocation.List) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.revoked = l
+}
+
+// checkRevocation returns the key unless it has been revoked.
+func (p *tokenManager) checkRevocation(pk crypto.PublicKey) (crypto.PublicKey, error) {
+	p.RLock()
+	defer p.RUnlock()
+
+	if p.revoked == nil {
+		return pk, nil
+	}
+
+	if err := p.revoked.CheckPublicKey(pk); err != nil {
+		return nil, err
+	}
+
+	return pk, nil
+}
+
 // CreateTokenFromCertificate creates and signs a token
 func (p *tokenManager) CreateTokenFromCertificate(cert *x509.Certificate) ([]byte, error) {
 
 	// Combine the application claims with the standard claims
-	claims := &verifierClaims{
-		X: cert.PublicKey.(*ecdsa.PublicKey).X,
-		Y: cert.PublicKey.(*ecdsa.PublicKey).Y,
+	claims := &verifierClaims{}
+	switch pk := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		claims.X = pk.X
+		claims.Y = pk.Y
+		if pk.Curve != elliptic.P256() {
+			claims.Curve = pk.Curve.Params().Name
+		}
+	case ed25519.PublicKey:
+		claims.K = pk
+	default:
+		return []byte{}, fmt.Errorf("unsupported certificate key: %T", cert.PublicKey)
 	}
 	claims.ExpiresAt = cert.NotAfter.Unix()
 
+	method, err := signer.SigningMethod(p.privateKey)
+	if err != nil {
+		return []byte{}, fmt.Errorf("unsupported token authority key: %s", err)
+	}
+
 	// Create the token and sign with our key
-	strtoken, err := jwt.NewWithClaims(p.signMethod, claims).SignedString(p.privateKey)
+	strtoken, err := jwt.NewWithClaims(method, claims).SignedString(p.privateKey)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -119,10 +242,35 @@ func (p *tokenManager) CreateTokenFromCertificate(cert *x509.Certificate) ([]byt
 }
 
 // KeyFromClaims creates the public key structure from the claims
-func KeyFromClaims(claims *verifierClaims) *ecdsa.PublicKey {
+func KeyFromClaims(claims *verifierClaims) (crypto.PublicKey, error) {
+
+	if claims.K != nil {
+		if len(claims.K) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(claims.K), nil
+	}
+
+	if claims.X == nil || claims.Y == nil {
+		return nil, errors.New("no key in token")
+	}
+
+	curve := elliptic.P256()
+	switch claims.Curve {
+	case "":
+	case elliptic.P384().Params().Name:
+		curve = elliptic.P384()
+	default:
+		return nil, fmt.Errorf("unsupported curve: %s", claims.Curve)
+	}
+
+	if !curve.IsOnCurve(claims.X, claims.Y) {
+		return nil, errors.New("invalid ecdsa key")
+	}
+
 	return &ecdsa.PublicKey{
-		Curve: elliptic.P256(),
+		Curve: curve,
 		X:     claims.X,
 		Y:     claims.Y,
-	}
+	}, nil
 }
@@ -1,10 +1,16 @@
 package pkiverifier
 
 import (
+	gocrypto "crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
 	"testing"
 	"time"
 
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/revocation"
 	"github.com/aporeto-inc/trireme-lib/utils/crypto"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -67,18 +73,18 @@ func TestNewConfig(t *testing.T) {
 		})
 
 		Convey("When I use NewPKIVerifier valid keys, it should succeed ", func() {
-			p := NewPKIVerifier([]*ecdsa.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)}, -1).(*tokenManager)
+			p := NewPKIVerifier([]gocrypto.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)}, -1).(*tokenManager)
 			So(p, ShouldNotBeNil)
 			So(p.validity, ShouldEqual, defaultValidity*time.Second)
 			So(p.privateKey, ShouldBeNil)
-			So(p.publicKeys, ShouldResemble, []*ecdsa.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)})
+			So(p.publicKeys, ShouldResemble, []gocrypto.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)})
 		})
 		Convey("When I use NewPKIVerifier valid keys with a custom validity, it should succeed ", func() {
-			p := NewPKIVerifier([]*ecdsa.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)}, 10*time.Second).(*tokenManager)
+			p := NewPKIVerifier([]gocrypto.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)}, 10*time.Second).(*tokenManager)
 			So(p, ShouldNotBeNil)
 			So(p.validity, ShouldEqual, 10*time.Second)
 			So(p.privateKey, ShouldBeNil)
-			So(p.publicKeys, ShouldResemble, []*ecdsa.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)})
+			So(p.publicKeys, ShouldResemble, []gocrypto.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)})
 		})
 	})
 }
@@ -88,13 +94,14 @@ func TestCreateAndVerify(t *testing.T) {
 		key, cert, _, err := crypto.LoadAndVerifyECSecrets([]byte(keyPEM), []byte(certPEM), []byte(caPool))
 		So(err, ShouldBeNil)
 		p := NewPKIIssuer(key)
-		v := NewPKIVerifier([]*ecdsa.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)}, -1)
+		v := NewPKIVerifier([]gocrypto.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)}, -1)
 		So(p, ShouldNotBeNil)
 		Convey("When I create a token", func() {
 			token, err1 := p.CreateTokenFromCertificate(cert)
 			So(err1, ShouldBeNil)
-			rxtoken, err2 := v.Verify(token)
+			rxkey, err2 := v.Verify(token)
 			So(err2, ShouldBeNil)
+			rxtoken := rxkey.(*ecdsa.PublicKey)
 			So(*rxtoken.X, ShouldResemble, *cert.PublicKey.(*ecdsa.PublicKey).X)
 			So(*rxtoken.Y, ShouldResemble, *cert.PublicKey.(*ecdsa.PublicKey).Y)
 			So(rxtoken.Curve, ShouldResemble, cert.PublicKey.(*ecdsa.PublicKey).Curve)
@@ -105,7 +112,7 @@ func TestCreateAndVerify(t *testing.T) {
 		key, cert, _, err := crypto.LoadAndVerifyECSecrets([]byte(keyPEM), []byte(certPEM), []byte(caPool))
 		So(err, ShouldBeNil)
 		p := NewPKIIssuer(key)
-		v := NewPKIVerifier([]*ecdsa.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)}, -1)
+		v := NewPKIVerifier([]gocrypto.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)}, -1)
 		So(p, ShouldNotBeNil)
 		Convey("When I a receive a bad token, I should get an error", func() {
 			token, err1 := p.CreateTokenFromCertificate(cert)
@@ -117,12 +124,88 @@ func TestCreateAndVerify(t *testing.T) {
 	})
 }
 
+func TestVerifierValidity(t *testing.T) {
+	Convey("Given a token signed by a token authority", t, func() {
+		key, err := crypto.LoadEllipticCurveKey([]byte(keyPEM))
+		So(err, ShouldBeNil)
+		cert := &x509.Certificate{PublicKey: &key.PublicKey, NotAfter: time.Now().Add(time.Hour)}
+		token, err := NewPKIIssuer(key).CreateTokenFromCertificate(cert)
+		So(err, ShouldBeNil)
+
+		Convey("When the authority is within its validity period, it should verify the token", func() {
+			v := NewPKIVerifierWithValidity([]*VerifierKey{
+				{Key: &key.PublicKey, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)},
+			}, -1)
+			_, err := v.Verify(token)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("When the authority has expired, it should reject the token", func() {
+			v := NewPKIVerifierWithValidity([]*VerifierKey{
+				{Key: &key.PublicKey, NotAfter: time.Now().Add(-time.Minute)},
+			}, -1)
+			_, err := v.Verify(token)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the authority expires while the key is cached, it should reject the token", func() {
+			v := NewPKIVerifierWithValidity([]*VerifierKey{
+				{Key: &key.PublicKey, NotAfter: time.Now().Add(500 * time.Millisecond)},
+			}, 10*time.Second)
+			_, err := v.Verify(token)
+			So(err, ShouldBeNil)
+
+			time.Sleep(time.Second)
+
+			_, err = v.(*tokenManager).keycache.Get(string(token))
+			So(err, ShouldBeNil)
+			_, err = v.Verify(token)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the authority is not valid yet, it should reject the token", func() {
+			v := NewPKIVerifierWithValidity([]*VerifierKey{
+				{Key: &key.PublicKey, NotBefore: time.Now().Add(time.Minute)},
+			}, -1)
+			_, err := v.Verify(token)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestVerifierRevocation(t *testing.T) {
+	Convey("Given a verifier with a revocation list", t, func() {
+		key, err := crypto.LoadEllipticCurveKey([]byte(keyPEM))
+		So(err, ShouldBeNil)
+		cert := &x509.Certificate{PublicKey: &key.PublicKey, NotAfter: time.Now().Add(time.Hour)}
+		token, err := NewPKIIssuer(key).CreateTokenFromCertificate(cert)
+		So(err, ShouldBeNil)
+
+		l := revocation.NewList()
+		v := NewPKIVerifier([]gocrypto.PublicKey{&key.PublicKey}, 10*time.Second)
+		v.SetRevocationList(l)
+
+		Convey("When the key of a verified token is denied, it should reject the token", func() {
+			_, err := v.Verify(token)
+			So(err, ShouldBeNil)
+
+			fingerprint, err := revocation.Fingerprint(&key.PublicKey)
+			So(err, ShouldBeNil)
+			l.AddFingerprint(fingerprint)
+
+			_, err = v.Verify(token)
+			So(err, ShouldNotBeNil)
+			So(revocation.IsRevoked(err), ShouldBeTrue)
+		})
+	})
+}
+
 func TestCaching(t *testing.T) {
 	Convey("Given a valid verifier with a zero timer for the cache", t, func() {
 		key, cert, _, err := crypto.LoadAndVerifyECSecrets([]byte(keyPEM), []byte(certPEM), []byte(caPool))
 		So(err, ShouldBeNil)
 		p := NewPKIIssuer(key)
-		v := NewPKIVerifier([]*ecdsa.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)}, 1*time.Second)
+		v := NewPKIVerifier([]gocrypto.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)}, 1*time.Second)
 
 		So(p, ShouldNotBeNil)
 
@@ -147,3 +230,75 @@ func TestCaching(t *testing.T) {
 		})
 	})
 }
+
+func TestKeyTypes(t *testing.T) {
+	Convey("Given a verifier and certificates with different key types", t, func() {
+		issuerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+		p := NewPKIIssuer(issuerKey)
+		v := NewPKIVerifier([]gocrypto.PublicKey{&issuerKey.PublicKey}, -1)
+
+		p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		edPublic, _, _ := ed25519.GenerateKey(rand.Reader)
+
+		for _, key := range []gocrypto.PublicKey{&p256.PublicKey, &p384.PublicKey, edPublic} {
+			cert := &x509.Certificate{PublicKey: key, NotAfter: time.Now().Add(time.Hour)}
+
+			token, err := p.CreateTokenFromCertificate(cert)
+			So(err, ShouldBeNil)
+
+			rxkey, err := v.Verify(token)
+			So(err, ShouldBeNil)
+			So(rxkey, ShouldResemble, key)
+		}
+
+		Convey("When I create a token for an unsupported key, it should fail", func() {
+			p224, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+			cert := &x509.Certificate{PublicKey: &p224.PublicKey, NotAfter: time.Now().Add(time.Hour)}
+
+			token, err := p.CreateTokenFromCertificate(cert)
+			So(err, ShouldBeNil)
+			_, err = v.Verify(token)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestAuthorityKeyTypes(t *testing.T) {
+	Convey("Given token authorities with different key types", t, func() {
+		p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		edPublic, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+
+		authorities := []struct {
+			signer gocrypto.Signer
+			public gocrypto.PublicKey
+		}{
+			{signer: p384, public: &p384.PublicKey},
+			{signer: edPrivate, public: edPublic},
+		}
+
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		cert := &x509.Certificate{PublicKey: &key.PublicKey, NotAfter: time.Now().Add(time.Hour)}
+
+		Convey("When I create a token, it should be verified with the key of the authority only", func() {
+			for _, a := range authorities {
+				token, err := NewPKIIssuer(a.signer).CreateTokenFromCertificate(cert)
+				So(err, ShouldBeNil)
+
+				rxkey, err := NewPKIVerifier([]gocrypto.PublicKey{a.public}, -1).Verify(token)
+				So(err, ShouldBeNil)
+				So(rxkey, ShouldResemble, &key.PublicKey)
+
+				_, err = NewPKIVerifier([]gocrypto.PublicKey{&key.PublicKey}, -1).Verify(token)
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("When the authority key is not supported, it should fail", func() {
+			p224, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+			_, err := NewPKIIssuer(p224).CreateTokenFromCertificate(cert)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
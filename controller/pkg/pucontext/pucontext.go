@@ -2,6 +2,7 @@ package pucontext
 
 import (
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 	"sync"
@@ -10,11 +11,18 @@ import (
 	"github.com/aporeto-inc/trireme-lib/common"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/acls"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/lookup"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/encryption"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
+	"github.com/aporeto-inc/trireme-lib/utils/tokenbucket"
 )
 
+// ephemeralKeyValidity is the time after which the ephemeral key of a
+// processing unit is renewed
+const ephemeralKeyValidity = 10 * time.Minute
+
 type policies struct {
 	observeRejectRules *lookup.PolicyDB // Packet: Continue       Report:    Drop
 	rejectRules        *lookup.PolicyDB // Packet:     Drop       Report:    Drop
@@ -24,6 +32,12 @@ type policies struct {
 	encryptRules       *lookup.PolicyDB // Packet: Encrypt       Report: Encrypt
 }
 
+// rateLimiter is the token bucket enforcing a rate limit of a policy.
+type rateLimiter struct {
+	limit  policy.RateLimitParameters
+	bucket *tokenbucket.TokenBucket
+}
+
 // PUContext holds data indexed by the PU ID
 type PUContext struct {
 	id                string
@@ -34,6 +48,8 @@ type PUContext struct {
 	rcv               *policies
 	applicationACLs   *acls.ACLCache
 	networkACLs       *acls.ACLCache
+	udpAppACLs        *acls.ACLCache
+	udpNetACLs        *acls.ACLCache
 	externalIPCache   cache.DataStore
 	mark              string
 	ProxyPort         string
@@ -42,9 +58,14 @@ type PUContext struct {
 	synToken          []byte
 	synServiceContext []byte
 	synExpiration     time.Time
+	ephemeralKey      *encryption.EphemeralKey
+	ephemeralExpiry   time.Time
 	jwt               string
 	jwtExpiration     time.Time
 	scopes            []string
+	rateLimits        map[string]policy.RateLimitParameters
+	connLimiters      map[string]*rateLimiter
+	byteLimiters      map[string]*rateLimiter
 	Extension         interface{}
 	sync.RWMutex
 }
@@ -61,10 +82,18 @@ func NewPU(contextID string, puInfo *policy.PUInfo, timeout time.Duration) (*PUC
 		externalIPCache: cache.NewCacheWithExpiration("External IP Cache", timeout),
 		applicationACLs: acls.NewACLCache(),
 		networkACLs:     acls.NewACLCache(),
+		udpAppACLs:      acls.NewACLCacheForProtocol("udp"),
+		udpNetACLs:      acls.NewACLCacheForProtocol("udp"),
 		mark:            puInfo.Runtime.Options().CgroupMark,
+		ProxyPort:       puInfo.Runtime.Options().ProxyPort,
 		scopes:          puInfo.Policy.Scopes(),
+		rateLimits:      map[string]policy.RateLimitParameters{},
+		connLimiters:    map[string]*rateLimiter{},
+		byteLimiters:    map[string]*rateLimiter{},
 	}
 
+	pu.addRateLimits(puInfo.Policy)
+
 	pu.CreateRcvRules(puInfo.Policy.ReceiverRules())
 
 	pu.CreateTxtRules(puInfo.Policy.TransmitterRules())
@@ -80,6 +109,14 @@ func NewPU(contextID string, puInfo *policy.PUInfo, timeout time.Duration) (*PUC
 		return nil, err
 	}
 
+	if err := pu.udpAppACLs.AddRuleList(puInfo.Policy.ApplicationACLs()); err != nil {
+		return nil, err
+	}
+
+	if err := pu.udpNetACLs.AddRuleList(puInfo.Policy.NetworkACLs()); err != nil {
+		return nil, err
+	}
+
 	return pu, nil
 
 }
@@ -126,12 +163,41 @@ func (p *PUContext) RetrieveCachedExternalFlowPolicy(id string) (interface{}, er
 
 // NetworkACLPolicy retrieves the policy based on ACLs
 func (p *PUContext) NetworkACLPolicy(packet *packet.Packet) (report *policy.FlowPolicy, action *policy.FlowPolicy, err error) {
-	return p.networkACLs.GetMatchingAction(packet.SourceAddress.To4(), packet.DestinationPort)
+	return p.networkACLs.GetMatchingAction(packet.SourceAddress, packet.DestinationPort)
 }
 
 // ApplicationACLPolicy retrieves the policy based on ACLs
 func (p *PUContext) ApplicationACLPolicy(packet *packet.Packet) (report *policy.FlowPolicy, action *policy.FlowPolicy, err error) {
-	return p.applicationACLs.GetMatchingAction(packet.SourceAddress.To4(), packet.SourcePort)
+	return p.applicationACLs.GetMatchingAction(packet.SourceAddress, packet.SourcePort)
+}
+
+// UDPNetworkACLPolicy retrieves the policy based on UDP ACLs for a datagram
+// arriving from the network
+func (p *PUContext) UDPNetworkACLPolicy(packet *packet.Packet) (report *policy.FlowPolicy, action *policy.FlowPolicy, err error) {
+	return p.udpNetACLs.GetMatchingAction(packet.SourceAddress, packet.DestinationPort)
+}
+
+// UDPApplicationACLPolicy retrieves the policy based on UDP ACLs for a
+// datagram sent by the application
+func (p *PUContext) UDPApplicationACLPolicy(packet *packet.Packet) (report *policy.FlowPolicy, action *policy.FlowPolicy, err error) {
+	return p.udpAppACLs.GetMatchingAction(packet.DestinationAddress, packet.DestinationPort)
+}
+
+// LookupACLPolicy retrieves the policy based on ACLs for a flow between the
+// PU and the external ip without a packet. The port is the destination port
+// of the flow.
+func (p *PUContext) LookupACLPolicy(protocol uint8, networkOriginated bool, ip net.IP, port uint16) (report *policy.FlowPolicy, action *policy.FlowPolicy, err error) {
+
+	switch {
+	case protocol == packet.IPProtocolUDP && networkOriginated:
+		return p.udpNetACLs.GetMatchingAction(ip, port)
+	case protocol == packet.IPProtocolUDP:
+		return p.udpAppACLs.GetMatchingAction(ip, port)
+	case networkOriginated:
+		return p.networkACLs.GetMatchingAction(ip, port)
+	default:
+		return p.applicationACLs.GetMatchingAction(ip, port)
+	}
 }
 
 // CacheExternalFlowPolicy will cache an external flow
@@ -185,6 +251,28 @@ func (p *PUContext) UpdateCachedTokenAndServiceContext(token []byte, serviceCont
 
 }
 
+// EphemeralKey returns the ephemeral key announced in the syn packets of the
+// processing unit. A new key is generated when it expires.
+func (p *PUContext) EphemeralKey() (*encryption.EphemeralKey, error) {
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.ephemeralKey != nil && p.ephemeralExpiry.After(time.Now()) {
+		return p.ephemeralKey, nil
+	}
+
+	key, err := encryption.NewEphemeralKey()
+	if err != nil {
+		return nil, err
+	}
+
+	p.ephemeralKey = key
+	p.ephemeralExpiry = time.Now().Add(ephemeralKeyValidity)
+
+	return key, nil
+}
+
 // Scopes returns the scopes.
 func (p *PUContext) Scopes() []string {
 	p.RLock()
@@ -214,6 +302,112 @@ func (p *PUContext) UpdateJWT(jwt string, expiration time.Time) {
 	p.jwtExpiration = expiration
 }
 
+// addRateLimits records the rate limits of the rate limited policies of the
+// PU policy.
+func (p *PUContext) addRateLimits(plc *policy.PUPolicy) {
+
+	add := func(f *policy.FlowPolicy) {
+		if f != nil && f.Action.RateLimited() && f.RateLimit != nil {
+			p.rateLimits[f.PolicyID] = *f.RateLimit
+		}
+	}
+
+	for _, rule := range plc.ReceiverRules() {
+		add(rule.Policy)
+	}
+	for _, rule := range plc.TransmitterRules() {
+		add(rule.Policy)
+	}
+	for _, rule := range plc.ApplicationACLs() {
+		add(rule.Policy)
+	}
+	for _, rule := range plc.NetworkACLs() {
+		add(rule.Policy)
+	}
+}
+
+// InheritRateLimiters keeps the rate state of the previous context of the PU
+// across a policy update. Only the limiters of the policies that are still
+// present with the same rate limit are kept. The others are dropped and are
+// created again when a flow matches their new policy.
+func (p *PUContext) InheritRateLimiters(previous *PUContext) {
+
+	if previous == nil || previous == p {
+		return
+	}
+
+	inherit := func(from map[string]*rateLimiter, to map[string]*rateLimiter) {
+		for id, limiter := range from {
+			if limit, ok := p.rateLimits[id]; ok && limit == limiter.limit {
+				to[id] = limiter
+			}
+		}
+	}
+
+	previous.Lock()
+	defer previous.Unlock()
+
+	p.Lock()
+	defer p.Unlock()
+
+	inherit(previous.connLimiters, p.connLimiters)
+	inherit(previous.byteLimiters, p.byteLimiters)
+}
+
+// limiter returns the limiter of the flow policy from limiters. The limiter
+// is created when the policy is first seen or when its rate limit changed.
+// It must be called with the lock held.
+func limiter(limiters map[string]*rateLimiter, flowPolicy *policy.FlowPolicy, rate float64, burst int) *tokenbucket.TokenBucket {
+
+	l, ok := limiters[flowPolicy.PolicyID]
+	if !ok || l.limit != *flowPolicy.RateLimit {
+		l = &rateLimiter{
+			limit:  *flowPolicy.RateLimit,
+			bucket: tokenbucket.New(rate, burst),
+		}
+		limiters[flowPolicy.PolicyID] = l
+	}
+
+	return l.bucket
+}
+
+// ConnectionAllowed returns false if a new connection matching the flow policy
+// exceeds the connection rate of the policy. Policies without a connection
+// rate limit always allow new connections. The limiters are shared by all the
+// flow policies with the same policy ID.
+func (p *PUContext) ConnectionAllowed(flowPolicy *policy.FlowPolicy) bool {
+
+	if flowPolicy == nil || !flowPolicy.Action.RateLimited() || flowPolicy.RateLimit == nil || flowPolicy.RateLimit.ConnectionsPerSecond <= 0 {
+		return true
+	}
+
+	p.Lock()
+	bucket := limiter(p.connLimiters, flowPolicy, flowPolicy.RateLimit.ConnectionsPerSecond, flowPolicy.RateLimit.ConnectionBurst)
+	p.Unlock()
+
+	return bucket.Allow()
+}
+
+// BandwidthLimiter returns the limiter shared by all the connections of the
+// flow policy, or nil if the policy has no bandwidth limit. The burst is one
+// second of bandwidth when the policy does not set it.
+func (p *PUContext) BandwidthLimiter(flowPolicy *policy.FlowPolicy) *tokenbucket.TokenBucket {
+
+	if flowPolicy == nil || !flowPolicy.Action.RateLimited() || flowPolicy.RateLimit == nil || flowPolicy.RateLimit.BytesPerSecond <= 0 {
+		return nil
+	}
+
+	burst := flowPolicy.RateLimit.ByteBurst
+	if burst <= 0 {
+		burst = int(flowPolicy.RateLimit.BytesPerSecond)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	return limiter(p.byteLimiters, flowPolicy, flowPolicy.RateLimit.BytesPerSecond, burst)
+}
+
 // createRuleDBs creates the database of rules from the policy
 func (p *PUContext) createRuleDBs(policyRules policy.TagSelectorList) *policies {
 
@@ -251,6 +445,44 @@ func (p *PUContext) createRuleDBs(policyRules policy.TagSelectorList) *policies
 	return policyDB
 }
 
+// rules returns the rules compiled in the policy databases.
+func (p *policies) rules() introspection.Rules {
+	return introspection.Rules{
+		Reject:        p.rejectRules.Selectors(),
+		ObserveReject: p.observeRejectRules.Selectors(),
+		Accept:        p.acceptRules.Selectors(),
+		ObserveAccept: p.observeAcceptRules.Selectors(),
+		ObserveApply:  p.observeApplyRules.Selectors(),
+		Encrypt:       p.encryptRules.Selectors(),
+	}
+}
+
+// Introspect returns the state of the PU as it is held by the enforcer.
+func (p *PUContext) Introspect() *introspection.PU {
+
+	p.RLock()
+	defer p.RUnlock()
+
+	return &introspection.PU{
+		ContextID:    p.id,
+		ManagementID: p.managementID,
+		Type:         p.puType,
+		Identity:     p.identity.Copy().GetSlice(),
+		Annotations:  p.annotations.Copy().GetSlice(),
+		Mark:         p.mark,
+		Ports:        p.ports,
+		ProxyPort:    p.ProxyPort,
+		Transmitter:  p.txt.rules(),
+		Receiver:     p.rcv.rules(),
+		ACLs: introspection.ACLs{
+			Application:    p.applicationACLs.Rules(),
+			Network:        p.networkACLs.Rules(),
+			UDPApplication: p.udpAppACLs.Rules(),
+			UDPNetwork:     p.udpNetACLs.Rules(),
+		},
+	}
+}
+
 // CreateRcvRules create receive rules for this PU based on the update of the policy.
 func (p *PUContext) CreateRcvRules(policyRules policy.TagSelectorList) {
 	p.rcv = p.createRuleDBs(policyRules)
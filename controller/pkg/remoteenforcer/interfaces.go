@@ -21,6 +21,12 @@ const (
 	EnforcerExit = "RemoteEnforcer.EnforcerExit"
 	// UpdateSecrets is string for invoking updatesecrets RPC
 	UpdateSecrets = "RemoteEnforcer.UpdateSecrets"
+	// ListPUs is string for invoking the introspection of the PUs
+	ListPUs = "RemoteEnforcer.ListPUs"
+	// ListFlows is string for invoking the introspection of the flows
+	ListFlows = "RemoteEnforcer.ListFlows"
+	// Simulate is string for invoking the policy simulation of a flow
+	Simulate = "RemoteEnforcer.Simulate"
 )
 
 // RemoteIntf is the interface implemented by the remote enforcer
@@ -47,4 +53,13 @@ type RemoteIntf interface {
 	// EnforcerExit this method is called when  we received a killrpocess message from the controller
 	// This allows a graceful exit of the enforcer
 	EnforcerExit(req rpcwrapper.Request, resp *rpcwrapper.Response) error
+
+	// ListPUs returns the state of the PUs enforced by the remote enforcer
+	ListPUs(req rpcwrapper.Request, resp *rpcwrapper.Response) error
+
+	// ListFlows returns the flows tracked by the remote enforcer
+	ListFlows(req rpcwrapper.Request, resp *rpcwrapper.Response) error
+
+	// Simulate evaluates a flow against the policy of the remote enforcer
+	Simulate(req rpcwrapper.Request, resp *rpcwrapper.Response) error
 }
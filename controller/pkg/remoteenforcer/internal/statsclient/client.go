@@ -68,14 +68,18 @@ func (s *statsClient) sendStats(ctx context.Context) {
 
 			flows := s.collector.GetAllRecords()
 			users := s.collector.GetUserRecords()
-			if flows == nil && users == nil {
+			httpRecords := s.collector.GetHTTPRecords()
+			httpMetrics := s.collector.GetHTTPMetrics()
+			if flows == nil && users == nil && httpRecords == nil && httpMetrics == nil {
 				continue
 			}
 
 			request := rpcwrapper.Request{
 				Payload: &rpcwrapper.StatsPayload{
-					Flows: flows,
-					Users: users,
+					Flows:       flows,
+					Users:       users,
+					HTTPRecords: httpRecords,
+					HTTPMetrics: httpMetrics,
 				},
 			}
 
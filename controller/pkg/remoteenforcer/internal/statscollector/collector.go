@@ -12,12 +12,14 @@ func NewCollector() Collector {
 		Flows:          map[string]*collector.FlowRecord{},
 		Users:          map[string]*collector.UserRecord{},
 		ProcessedUsers: map[string]bool{},
+		HTTPMetrics:    collector.NewHTTPMetrics(),
 	}
 }
 
 // collectorImpl : This object is a stash implements two interfaces.
 //
 //  collector.EventCollector - so datapath can report flow events
+//  collector.HTTPCollector - so the HTTP proxy can report its requests
 //  CollectorReader - so components can extract information out of this stash
 //
 // It has a flow entries cache which contains unique flows that are reported
@@ -26,5 +28,7 @@ type collectorImpl struct {
 	Flows          map[string]*collector.FlowRecord
 	ProcessedUsers map[string]bool
 	Users          map[string]*collector.UserRecord
+	HTTPRecords    []*collector.HTTPRecord
+	HTTPMetrics    *collector.HTTPMetrics
 	sync.Mutex
 }
@@ -45,3 +45,22 @@ func (c *collectorImpl) FlushUserCache() {
 
 	c.ProcessedUsers = map[string]bool{}
 }
+
+// GetHTTPRecords retrieves all the access log records of HTTP requests.
+func (c *collectorImpl) GetHTTPRecords() []*collector.HTTPRecord {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.HTTPRecords) == 0 {
+		return nil
+	}
+
+	retval := c.HTTPRecords
+	c.HTTPRecords = nil
+	return retval
+}
+
+// GetHTTPMetrics retrieves the metrics of the APIs.
+func (c *collectorImpl) GetHTTPMetrics() map[string]*collector.HTTPMetricsRecord {
+	return c.HTTPMetrics.Flush()
+}
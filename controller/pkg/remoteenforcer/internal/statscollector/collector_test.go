@@ -2,6 +2,7 @@ package statscollector
 
 import (
 	"testing"
+	"time"
 
 	"github.com/aporeto-inc/trireme-lib/collector"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
@@ -102,6 +103,57 @@ func TestCollectFlowEvent(t *testing.T) {
 					So(c.Flows[collector.StatsFlowHash(r)].Count, ShouldEqual, 33)
 				})
 			})
+
+			Convey("When I add two accounting records of the flow", func() {
+				a := collector.NewAccountingRecord(r, collector.FlowCounters{SourceBytes: 100, SourcePackets: 2, DestinationBytes: 1000, DestinationPackets: 3})
+				b := collector.NewAccountingRecord(r, collector.FlowCounters{SourceBytes: 50, SourcePackets: 1})
+				c.CollectFlowEvent(a)
+				c.CollectFlowEvent(b)
+
+				Convey("The traffic should be aggregated in a separate record that does not count as a flow", func() {
+					So(len(c.Flows), ShouldEqual, 2)
+					So(c.Flows[collector.StatsFlowHash(r)].Count, ShouldEqual, 1)
+					So(c.Flows[collector.StatsFlowHash(a)].Count, ShouldEqual, 0)
+					So(c.Flows[collector.StatsFlowHash(a)].FlowCounters, ShouldResemble, collector.FlowCounters{
+						SourceBytes:        150,
+						SourcePackets:      3,
+						DestinationBytes:   1000,
+						DestinationPackets: 3,
+					})
+				})
+			})
+		})
+	})
+}
+
+func TestCollectHTTPEvent(t *testing.T) {
+	Convey("Given a stats collector", t, func() {
+		c := NewCollector()
+
+		Convey("When I add access log records", func() {
+			for i := 0; i < maxHTTPRecords+1; i++ {
+				c.CollectHTTPEvent(&collector.HTTPRecord{ContextID: "1", Method: "GET", StatusCode: 200})
+			}
+
+			Convey("The records should be capped and reset when read", func() {
+				So(len(c.GetHTTPRecords()), ShouldEqual, maxHTTPRecords)
+				So(c.GetHTTPRecords(), ShouldBeNil)
+			})
+		})
+
+		Convey("When I add the metrics of an API twice", func() {
+			r := &collector.HTTPMetricsRecord{ContextID: "1", Port: 80, Method: "GET", StatusCodes: map[int]uint64{200: 1}}
+			r.Latency.Observe(time.Millisecond)
+			c.CollectHTTPMetrics(r)
+			c.CollectHTTPMetrics(r)
+
+			Convey("The metrics should be merged and reset when read", func() {
+				metrics := c.GetHTTPMetrics()
+				So(len(metrics), ShouldEqual, 1)
+				So(metrics[collector.StatsHTTPMetricsHash(r)].Latency.Count, ShouldEqual, 2)
+				So(metrics[collector.StatsHTTPMetricsHash(r)].StatusCodes[200], ShouldEqual, 2)
+				So(c.GetHTTPMetrics(), ShouldBeNil)
+			})
 		})
 	})
 }
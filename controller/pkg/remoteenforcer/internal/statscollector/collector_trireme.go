@@ -10,8 +10,9 @@ func (c *collectorImpl) CollectFlowEvent(record *collector.FlowRecord) {
 
 	hash := collector.StatsFlowHash(record)
 
-	// If flow event doesn't have a count make it equal to 1. At least one flow is collected
-	if record.Count == 0 {
+	// If flow event doesn't have a count make it equal to 1. At least one flow is collected.
+	// Accounting and termination records report existing flows and never count as a new flow.
+	if record.Count == 0 && !record.Accounting && record.Termination == nil {
 		record.Count = 1
 	}
 
@@ -20,6 +21,7 @@ func (c *collectorImpl) CollectFlowEvent(record *collector.FlowRecord) {
 
 	if r, ok := c.Flows[hash]; ok {
 		r.Count = r.Count + record.Count
+		r.FlowCounters = r.FlowCounters.Add(record.FlowCounters)
 		return
 	}
 
@@ -48,3 +50,25 @@ func (c *collectorImpl) CollectUserEvent(record *collector.UserRecord) {
 		c.ProcessedUsers[record.ID] = true
 	}
 }
+
+// maxHTTPRecords is the maximum number of access log records kept between two
+// reports. The records above are dropped.
+const maxHTTPRecords = 10000
+
+// CollectHTTPEvent collects the access log record of an HTTP request.
+func (c *collectorImpl) CollectHTTPEvent(record *collector.HTTPRecord) {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.HTTPRecords) >= maxHTTPRecords {
+		zap.L().Debug("Dropping HTTP access log record", zap.Stringer("record", record))
+		return
+	}
+
+	c.HTTPRecords = append(c.HTTPRecords, record)
+}
+
+// CollectHTTPMetrics collects the metrics of an API.
+func (c *collectorImpl) CollectHTTPMetrics(record *collector.HTTPMetricsRecord) {
+	c.HTTPMetrics.Merge(record)
+}
@@ -9,6 +9,8 @@ type CollectorReader interface {
 	Count() int
 	GetAllRecords() map[string]*collector.FlowRecord
 	GetUserRecords() map[string]*collector.UserRecord
+	GetHTTPRecords() []*collector.HTTPRecord
+	GetHTTPMetrics() map[string]*collector.HTTPMetricsRecord
 	FlushUserCache()
 }
 
@@ -16,4 +18,5 @@ type CollectorReader interface {
 type Collector interface {
 	CollectorReader
 	collector.EventCollector
+	collector.HTTPCollector
 }
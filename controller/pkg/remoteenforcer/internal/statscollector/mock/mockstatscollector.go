@@ -80,6 +80,34 @@ func (mr *MockCollectorReaderMockRecorder) GetUserRecords() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserRecords", reflect.TypeOf((*MockCollectorReader)(nil).GetUserRecords))
 }
 
+// GetHTTPRecords mocks base method
+// nolint
+func (m *MockCollectorReader) GetHTTPRecords() []*collector.HTTPRecord {
+	ret := m.ctrl.Call(m, "GetHTTPRecords")
+	ret0, _ := ret[0].([]*collector.HTTPRecord)
+	return ret0
+}
+
+// GetHTTPRecords indicates an expected call of GetHTTPRecords
+// nolint
+func (mr *MockCollectorReaderMockRecorder) GetHTTPRecords() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHTTPRecords", reflect.TypeOf((*MockCollectorReader)(nil).GetHTTPRecords))
+}
+
+// GetHTTPMetrics mocks base method
+// nolint
+func (m *MockCollectorReader) GetHTTPMetrics() map[string]*collector.HTTPMetricsRecord {
+	ret := m.ctrl.Call(m, "GetHTTPMetrics")
+	ret0, _ := ret[0].(map[string]*collector.HTTPMetricsRecord)
+	return ret0
+}
+
+// GetHTTPMetrics indicates an expected call of GetHTTPMetrics
+// nolint
+func (mr *MockCollectorReaderMockRecorder) GetHTTPMetrics() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHTTPMetrics", reflect.TypeOf((*MockCollectorReader)(nil).GetHTTPMetrics))
+}
+
 // FlushUserCache mocks base method
 // nolint
 func (m *MockCollectorReader) FlushUserCache() {
@@ -161,6 +189,34 @@ func (mr *MockCollectorMockRecorder) GetUserRecords() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserRecords", reflect.TypeOf((*MockCollector)(nil).GetUserRecords))
 }
 
+// GetHTTPRecords mocks base method
+// nolint
+func (m *MockCollector) GetHTTPRecords() []*collector.HTTPRecord {
+	ret := m.ctrl.Call(m, "GetHTTPRecords")
+	ret0, _ := ret[0].([]*collector.HTTPRecord)
+	return ret0
+}
+
+// GetHTTPRecords indicates an expected call of GetHTTPRecords
+// nolint
+func (mr *MockCollectorMockRecorder) GetHTTPRecords() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHTTPRecords", reflect.TypeOf((*MockCollector)(nil).GetHTTPRecords))
+}
+
+// GetHTTPMetrics mocks base method
+// nolint
+func (m *MockCollector) GetHTTPMetrics() map[string]*collector.HTTPMetricsRecord {
+	ret := m.ctrl.Call(m, "GetHTTPMetrics")
+	ret0, _ := ret[0].(map[string]*collector.HTTPMetricsRecord)
+	return ret0
+}
+
+// GetHTTPMetrics indicates an expected call of GetHTTPMetrics
+// nolint
+func (mr *MockCollectorMockRecorder) GetHTTPMetrics() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHTTPMetrics", reflect.TypeOf((*MockCollector)(nil).GetHTTPMetrics))
+}
+
 // FlushUserCache mocks base method
 // nolint
 func (m *MockCollector) FlushUserCache() {
@@ -208,3 +264,27 @@ func (m *MockCollector) CollectUserEvent(record *collector.UserRecord) {
 func (mr *MockCollectorMockRecorder) CollectUserEvent(record interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectUserEvent", reflect.TypeOf((*MockCollector)(nil).CollectUserEvent), record)
 }
+
+// CollectHTTPEvent mocks base method
+// nolint
+func (m *MockCollector) CollectHTTPEvent(record *collector.HTTPRecord) {
+	m.ctrl.Call(m, "CollectHTTPEvent", record)
+}
+
+// CollectHTTPEvent indicates an expected call of CollectHTTPEvent
+// nolint
+func (mr *MockCollectorMockRecorder) CollectHTTPEvent(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectHTTPEvent", reflect.TypeOf((*MockCollector)(nil).CollectHTTPEvent), record)
+}
+
+// CollectHTTPMetrics mocks base method
+// nolint
+func (m *MockCollector) CollectHTTPMetrics(record *collector.HTTPMetricsRecord) {
+	m.ctrl.Call(m, "CollectHTTPMetrics", record)
+}
+
+// CollectHTTPMetrics indicates an expected call of CollectHTTPMetrics
+// nolint
+func (mr *MockCollectorMockRecorder) CollectHTTPMetrics(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectHTTPMetrics", reflect.TypeOf((*MockCollector)(nil).CollectHTTPMetrics), record)
+}
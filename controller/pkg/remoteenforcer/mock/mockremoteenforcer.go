@@ -135,3 +135,45 @@ func (m *MockRemoteIntf) EnforcerExit(req rpcwrapper.Request, resp *rpcwrapper.R
 func (mr *MockRemoteIntfMockRecorder) EnforcerExit(req, resp interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnforcerExit", reflect.TypeOf((*MockRemoteIntf)(nil).EnforcerExit), req, resp)
 }
+
+// ListPUs mocks base method
+// nolint
+func (m *MockRemoteIntf) ListPUs(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	ret := m.ctrl.Call(m, "ListPUs", req, resp)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ListPUs indicates an expected call of ListPUs
+// nolint
+func (mr *MockRemoteIntfMockRecorder) ListPUs(req, resp interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPUs", reflect.TypeOf((*MockRemoteIntf)(nil).ListPUs), req, resp)
+}
+
+// ListFlows mocks base method
+// nolint
+func (m *MockRemoteIntf) ListFlows(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	ret := m.ctrl.Call(m, "ListFlows", req, resp)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ListFlows indicates an expected call of ListFlows
+// nolint
+func (mr *MockRemoteIntfMockRecorder) ListFlows(req, resp interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListFlows", reflect.TypeOf((*MockRemoteIntf)(nil).ListFlows), req, resp)
+}
+
+// Simulate mocks base method
+// nolint
+func (m *MockRemoteIntf) Simulate(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	ret := m.ctrl.Call(m, "Simulate", req, resp)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Simulate indicates an expected call of Simulate
+// nolint
+func (mr *MockRemoteIntfMockRecorder) Simulate(req, resp interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Simulate", reflect.TypeOf((*MockRemoteIntf)(nil).Simulate), req, resp)
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/remoteenforcer/internal/statsclient"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/remoteenforcer/internal/statscollector"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
 	"github.com/aporeto-inc/trireme-lib/policy"
 
 	"go.uber.org/zap"
@@ -102,6 +103,13 @@ func (s *RemoteEnforcer) setupEnforcer(req rpcwrapper.Request) error {
 		return err
 	}
 
+	var dictionary *tokens.TagDictionary
+	if len(payload.TagDictionary) > 0 {
+		if dictionary, err = tokens.NewTagDictionary(payload.TagDictionary); err != nil {
+			return err
+		}
+	}
+
 	if s.enforcer, err = enforcer.New(
 		payload.MutualAuth,
 		payload.FqConfig,
@@ -114,6 +122,10 @@ func (s *RemoteEnforcer) setupEnforcer(req rpcwrapper.Request) error {
 		s.procMountPoint,
 		payload.ExternalIPCacheTimeout,
 		payload.PacketLogs,
+		payload.TokenEngine,
+		dictionary,
+		payload.Attestation,
+		nil,
 	); err != nil || s.enforcer == nil {
 		return fmt.Errorf("Error while initializing remote enforcer, %s", err)
 	}
@@ -368,16 +380,116 @@ func (s *RemoteEnforcer) UpdateSecrets(req rpcwrapper.Request, resp *rpcwrapper.
 
 	cmdLock.Lock()
 	defer cmdLock.Unlock()
-	if s.enforcer != nil {
-		return nil
-	}
 
 	payload := req.Payload.(rpcwrapper.UpdateSecretsPayload)
 	s.secrets, err = secrets.NewSecrets(payload.Secrets)
 	if err != nil {
+		resp.Status = fmt.Sprintf("invalid secrets: %s", err)
+		return err
+	}
+
+	// The enforcer gets the secrets of its initialization if it is not running yet.
+	if s.enforcer == nil {
+		return nil
+	}
+
+	if err = s.enforcer.UpdateSecrets(s.secrets); err != nil {
+		resp.Status = fmt.Sprintf("unable to update secrets: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+// ListPUs returns the state of the PUs enforced by the remote enforcer
+func (s *RemoteEnforcer) ListPUs(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = "list pus message auth failed"
+		return fmt.Errorf(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	if s.enforcer == nil {
+		resp.Status = "enforcer not initialized - cannot list pus"
+		return fmt.Errorf(resp.Status)
+	}
+
+	pus, err := s.enforcer.ListPUs()
+	if err != nil {
+		resp.Status = err.Error()
 		return err
 	}
 
+	resp.Payload = rpcwrapper.IntrospectionResponsePayload{PUs: pus}
+	resp.Status = ""
+
+	return nil
+}
+
+// ListFlows returns the flows tracked by the remote enforcer
+func (s *RemoteEnforcer) ListFlows(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = "list flows message auth failed"
+		return fmt.Errorf(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	if s.enforcer == nil {
+		resp.Status = "enforcer not initialized - cannot list flows"
+		return fmt.Errorf(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.IntrospectionPayload)
+
+	flows, err := s.enforcer.ListFlows(payload.ContextID)
+	if err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	resp.Payload = rpcwrapper.IntrospectionResponsePayload{Flows: flows}
+	resp.Status = ""
+
+	return nil
+}
+
+// Simulate evaluates a flow against the policy of the remote enforcer
+func (s *RemoteEnforcer) Simulate(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = "simulate message auth failed"
+		return fmt.Errorf(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	if s.enforcer == nil {
+		resp.Status = "enforcer not initialized - cannot simulate"
+		return fmt.Errorf(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.IntrospectionPayload)
+	if payload.Query == nil {
+		resp.Status = "simulate message without query"
+		return fmt.Errorf(resp.Status)
+	}
+
+	decision, err := s.enforcer.Simulate(payload.Query)
+	if err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	resp.Payload = rpcwrapper.IntrospectionResponsePayload{Decision: decision}
+	resp.Status = ""
+
 	return nil
 }
 
@@ -60,3 +60,18 @@ func (s *RemoteEnforcer) Enforce(req rpcwrapper.Request, resp *rpcwrapper.Respon
 func (s *RemoteEnforcer) EnforcerExit(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
 	return nil
 }
+
+// ListPUs returns the state of the PUs enforced by the remote enforcer
+func (s *RemoteEnforcer) ListPUs(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	return nil
+}
+
+// ListFlows returns the flows tracked by the remote enforcer
+func (s *RemoteEnforcer) ListFlows(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	return nil
+}
+
+// Simulate evaluates a flow against the policy of the remote enforcer
+func (s *RemoteEnforcer) Simulate(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	return nil
+}
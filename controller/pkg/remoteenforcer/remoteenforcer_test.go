@@ -21,6 +21,7 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/internal/supervisor"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/supervisor/mock"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packetprocessor"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/remoteenforcer/internal/statsclient/mock"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
@@ -993,3 +994,111 @@ func TestUnSupervise(t *testing.T) {
 		})
 	})
 }
+
+func TestListFlows(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a remote enforcer server", t, func() {
+		rpcHdl := rpcwrapper.NewRPCServer()
+		mockEnf := mockenforcer.NewMockEnforcer(ctrl)
+
+		secret := "KMvm4a6kgLLma5NitOMGx2f9k21G3nrAaLbgA5zNNHM="
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server := &RemoteEnforcer{
+			rpcHandle: rpcHdl,
+			rpcSecret: secret,
+			ctx:       ctx,
+			cancel:    cancel,
+		}
+
+		var rpcwrperreq rpcwrapper.Request
+		var rpcwrperres rpcwrapper.Response
+
+		rpcwrperreq.Payload = rpcwrapper.IntrospectionPayload{ContextID: "b06f47830f64"}
+
+		Convey("When I list the flows with an invalid secret", func() {
+			digest := hmac.New(sha256.New, []byte("InvalidSecret"))
+			if _, err := digest.Write(getHash(rpcwrperreq.Payload)); err != nil {
+				So(err, ShouldBeNil)
+			}
+			rpcwrperreq.HashAuth = digest.Sum(nil)
+
+			server.enforcer = mockEnf
+			err := server.ListFlows(rpcwrperreq, &rpcwrperres)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldResemble, errors.New("list flows message auth failed"))
+			})
+		})
+
+		Convey("When I list the flows and pus before the enforcer is initialized", func() {
+			digest := hmac.New(sha256.New, []byte(secret))
+			if _, err := digest.Write(getHash(rpcwrperreq.Payload)); err != nil {
+				So(err, ShouldBeNil)
+			}
+			rpcwrperreq.HashAuth = digest.Sum(nil)
+
+			ferr := server.ListFlows(rpcwrperreq, &rpcwrperres)
+			perr := server.ListPUs(rpcwrperreq, &rpcwrperres)
+
+			Convey("Then I should get errors", func() {
+				So(ferr, ShouldNotBeNil)
+				So(perr, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I list the flows and pus", func() {
+			flows := []*introspection.Flow{
+				{ContextID: "b06f47830f64", Protocol: "tcp", Direction: introspection.NetworkOriginated, Flow: "10.1.1.1:172.17.0.2:4000:80", State: "data"},
+			}
+			pus := []*introspection.PU{
+				{ContextID: "b06f47830f64", Mark: "100"},
+			}
+			mockEnf.EXPECT().ListFlows("b06f47830f64").Times(1).Return(flows, nil)
+			mockEnf.EXPECT().ListPUs().Times(1).Return(pus, nil)
+
+			digest := hmac.New(sha256.New, []byte(secret))
+			if _, err := digest.Write(getHash(rpcwrperreq.Payload)); err != nil {
+				So(err, ShouldBeNil)
+			}
+			rpcwrperreq.HashAuth = digest.Sum(nil)
+
+			server.enforcer = mockEnf
+
+			var puResponse rpcwrapper.Response
+			ferr := server.ListFlows(rpcwrperreq, &rpcwrperres)
+			perr := server.ListPUs(rpcwrperreq, &puResponse)
+
+			Convey("Then the state of the enforcer should be in the responses", func() {
+				So(ferr, ShouldBeNil)
+				So(perr, ShouldBeNil)
+				So(rpcwrperres.Payload, ShouldResemble, rpcwrapper.IntrospectionResponsePayload{Flows: flows})
+				So(puResponse.Payload, ShouldResemble, rpcwrapper.IntrospectionResponsePayload{PUs: pus})
+			})
+		})
+
+		Convey("When I simulate a flow", func() {
+			query := &introspection.Query{ContextID: "b06f47830f64", Direction: introspection.NetworkOriginated, Tags: []string{"app=web"}, Port: 80}
+			decision := &introspection.Decision{ContextID: "b06f47830f64", PolicyID: "web", Allowed: true}
+			mockEnf.EXPECT().Simulate(query).Times(1).Return(decision, nil)
+
+			rpcwrperreq.Payload = rpcwrapper.IntrospectionPayload{ContextID: "b06f47830f64", Query: query}
+			digest := hmac.New(sha256.New, []byte(secret))
+			if _, err := digest.Write(getHash(rpcwrperreq.Payload)); err != nil {
+				So(err, ShouldBeNil)
+			}
+			rpcwrperreq.HashAuth = digest.Sum(nil)
+
+			server.enforcer = mockEnf
+			err := server.Simulate(rpcwrperreq, &rpcwrperres)
+
+			Convey("Then the decision of the enforcer should be in the response", func() {
+				So(err, ShouldBeNil)
+				So(rpcwrperres.Payload, ShouldResemble, rpcwrapper.IntrospectionResponsePayload{Decision: decision})
+			})
+		})
+	})
+}
@@ -0,0 +1,239 @@
+// Package revocation maintains the certificates and keys that must not be
+// trusted anymore even though they were signed by a trusted authority. Entries
+// are added from the CRLs of the authorities and from a deny-list of serial
+// numbers and public key fingerprints that can be updated at runtime.
+package revocation
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Error is the error returned for revoked certificates and keys.
+type Error struct {
+	reason string
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return "revoked: " + e.reason
+}
+
+// IsRevoked returns true if the error was returned for a revoked certificate or key.
+func IsRevoked(err error) bool {
+	_, ok := err.(*Error)
+	return ok
+}
+
+// List holds the revoked certificates and keys. It is safe for concurrent use.
+type List struct {
+	// crls holds the serials revoked by the CRL of each authority, indexed
+	// by the raw subject of the authority
+	crls map[string]map[string]struct{}
+	// serials is the deny-list of serial numbers
+	serials map[string]struct{}
+	// fingerprints is the deny-list of public key fingerprints
+	fingerprints map[string]struct{}
+
+	sync.RWMutex
+}
+
+// NewList returns an empty revocation list.
+func NewList() *List {
+	return &List{
+		crls:         map[string]map[string]struct{}{},
+		serials:      map[string]struct{}{},
+		fingerprints: map[string]struct{}{},
+	}
+}
+
+// Entries are the entries of a list in a form that can be transmitted over the
+// RPC interface.
+type Entries struct {
+	// CRLs holds the serials revoked by the CRL of each authority, indexed
+	// by the raw subject of the authority
+	CRLs         map[string][]string
+	Serials      []string
+	Fingerprints []string
+}
+
+// NewListFromEntries returns a revocation list holding the entries.
+func NewListFromEntries(e *Entries) *List {
+
+	l := NewList()
+
+	for authority, serials := range e.CRLs {
+		revoked := map[string]struct{}{}
+		for _, serial := range serials {
+			revoked[serial] = struct{}{}
+		}
+		l.crls[authority] = revoked
+	}
+
+	for _, serial := range e.Serials {
+		l.serials[serial] = struct{}{}
+	}
+
+	for _, fingerprint := range e.Fingerprints {
+		l.fingerprints[fingerprint] = struct{}{}
+	}
+
+	return l
+}
+
+// Entries returns a copy of the entries of the list.
+func (l *List) Entries() *Entries {
+	l.RLock()
+	defer l.RUnlock()
+
+	e := &Entries{
+		CRLs:         map[string][]string{},
+		Serials:      []string{},
+		Fingerprints: []string{},
+	}
+
+	for authority, revoked := range l.crls {
+		serials := []string{}
+		for serial := range revoked {
+			serials = append(serials, serial)
+		}
+		e.CRLs[authority] = serials
+	}
+
+	for serial := range l.serials {
+		e.Serials = append(e.Serials, serial)
+	}
+
+	for fingerprint := range l.fingerprints {
+		e.Fingerprints = append(e.Fingerprints, fingerprint)
+	}
+
+	return e
+}
+
+// UpdateCRL replaces the CRL of an authority. The CRL can be PEM or DER encoded
+// and it must be signed by the authority.
+func (l *List) UpdateCRL(crl []byte, authority *x509.Certificate) error {
+
+	list, err := x509.ParseCRL(crl)
+	if err != nil {
+		return fmt.Errorf("unable to parse crl: %s", err)
+	}
+
+	if err := authority.CheckCRLSignature(list); err != nil {
+		return fmt.Errorf("invalid crl signature: %s", err)
+	}
+
+	if list.HasExpired(time.Now()) {
+		zap.L().Warn("CRL is past its next update",
+			zap.String("authority", authority.Subject.String()),
+			zap.Time("nextUpdate", list.TBSCertList.NextUpdate),
+		)
+	}
+
+	revoked := map[string]struct{}{}
+	for _, entry := range list.TBSCertList.RevokedCertificates {
+		revoked[entry.SerialNumber.String()] = struct{}{}
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.crls[string(authority.RawSubject)] = revoked
+
+	return nil
+}
+
+// AddSerial adds a serial number to the deny-list.
+func (l *List) AddSerial(serial *big.Int) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.serials[serial.String()] = struct{}{}
+}
+
+// RemoveSerial removes a serial number from the deny-list.
+func (l *List) RemoveSerial(serial *big.Int) {
+	l.Lock()
+	defer l.Unlock()
+
+	delete(l.serials, serial.String())
+}
+
+// AddFingerprint adds a public key fingerprint to the deny-list.
+func (l *List) AddFingerprint(fingerprint string) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.fingerprints[fingerprint] = struct{}{}
+}
+
+// RemoveFingerprint removes a public key fingerprint from the deny-list.
+func (l *List) RemoveFingerprint(fingerprint string) {
+	l.Lock()
+	defer l.Unlock()
+
+	delete(l.fingerprints, fingerprint)
+}
+
+// CheckCertificate returns an error if the certificate or its public key
+// has been revoked.
+func (l *List) CheckCertificate(cert *x509.Certificate) error {
+
+	serial := cert.SerialNumber.String()
+
+	l.RLock()
+	_, denied := l.serials[serial]
+	_, revoked := l.crls[string(cert.RawIssuer)][serial]
+	l.RUnlock()
+
+	if denied {
+		return &Error{reason: "serial " + serial + " is denied"}
+	}
+
+	if revoked {
+		return &Error{reason: "serial " + serial + " is revoked by its authority"}
+	}
+
+	return l.CheckPublicKey(cert.PublicKey)
+}
+
+// CheckPublicKey returns an error if the public key has been revoked.
+func (l *List) CheckPublicKey(key interface{}) error {
+
+	fingerprint, err := Fingerprint(key)
+	if err != nil {
+		return err
+	}
+
+	l.RLock()
+	_, denied := l.fingerprints[fingerprint]
+	l.RUnlock()
+
+	if denied {
+		return &Error{reason: "public key " + fingerprint + " is denied"}
+	}
+
+	return nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the PKIX encoding of a
+// public key in hexadecimal.
+func Fingerprint(key interface{}) (string, error) {
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", fmt.Errorf("unable to encode public key: %s", err)
+	}
+
+	sum := sha256.Sum256(der)
+
+	return hex.EncodeToString(sum[:]), nil
+}
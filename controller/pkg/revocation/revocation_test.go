@@ -0,0 +1,201 @@
+package revocation
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/gob"
+	"math/big"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func authority() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "authority"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+
+	return cert, key, err
+}
+
+func certificate(serial int64, ca *x509.Certificate, caKey *ecdsa.PrivateKey) (*x509.Certificate, error) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "node"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+func TestList(t *testing.T) {
+
+	Convey("Given a revocation list and certificates signed by an authority", t, func() {
+		ca, caKey, err := authority()
+		So(err, ShouldBeNil)
+		revoked, err := certificate(10, ca, caKey)
+		So(err, ShouldBeNil)
+		valid, err := certificate(11, ca, caKey)
+		So(err, ShouldBeNil)
+
+		l := NewList()
+
+		Convey("Then no certificate should be revoked", func() {
+			So(l.CheckCertificate(revoked), ShouldBeNil)
+			So(l.CheckCertificate(valid), ShouldBeNil)
+		})
+
+		Convey("When I deny a serial number", func() {
+			l.AddSerial(big.NewInt(10))
+
+			Convey("Then only its certificate should be revoked", func() {
+				err := l.CheckCertificate(revoked)
+				So(err, ShouldNotBeNil)
+				So(IsRevoked(err), ShouldBeTrue)
+				So(l.CheckCertificate(valid), ShouldBeNil)
+			})
+
+			Convey("Then it should be accepted again once removed", func() {
+				l.RemoveSerial(big.NewInt(10))
+				So(l.CheckCertificate(revoked), ShouldBeNil)
+			})
+		})
+
+		Convey("When I deny a public key", func() {
+			fingerprint, err := Fingerprint(revoked.PublicKey)
+			So(err, ShouldBeNil)
+			l.AddFingerprint(fingerprint)
+
+			Convey("Then the key and its certificate should be revoked", func() {
+				So(IsRevoked(l.CheckPublicKey(revoked.PublicKey)), ShouldBeTrue)
+				So(IsRevoked(l.CheckCertificate(revoked)), ShouldBeTrue)
+				So(l.CheckPublicKey(valid.PublicKey), ShouldBeNil)
+			})
+
+			Convey("Then it should be accepted again once removed", func() {
+				l.RemoveFingerprint(fingerprint)
+				So(l.CheckPublicKey(revoked.PublicKey), ShouldBeNil)
+			})
+		})
+
+		Convey("When I load the CRL of the authority", func() {
+			crl, err := ca.CreateCRL(rand.Reader, caKey, []pkix.RevokedCertificate{
+				{SerialNumber: big.NewInt(10), RevocationTime: time.Now()},
+			}, time.Now(), time.Now().Add(time.Hour))
+			So(err, ShouldBeNil)
+			So(l.UpdateCRL(crl, ca), ShouldBeNil)
+
+			Convey("Then only the revoked certificates should be rejected", func() {
+				So(IsRevoked(l.CheckCertificate(revoked)), ShouldBeTrue)
+				So(l.CheckCertificate(valid), ShouldBeNil)
+			})
+
+			Convey("Then a new CRL should replace it", func() {
+				crl, err := ca.CreateCRL(rand.Reader, caKey, nil, time.Now(), time.Now().Add(time.Hour))
+				So(err, ShouldBeNil)
+				So(l.UpdateCRL(crl, ca), ShouldBeNil)
+				So(l.CheckCertificate(revoked), ShouldBeNil)
+			})
+		})
+
+		Convey("When I load a CRL that is not signed by the authority, it should fail", func() {
+			other, otherKey, err := authority()
+			So(err, ShouldBeNil)
+			crl, err := other.CreateCRL(rand.Reader, otherKey, []pkix.RevokedCertificate{
+				{SerialNumber: big.NewInt(11), RevocationTime: time.Now()},
+			}, time.Now(), time.Now().Add(time.Hour))
+			So(err, ShouldBeNil)
+
+			So(l.UpdateCRL(crl, ca), ShouldNotBeNil)
+			So(l.CheckCertificate(valid), ShouldBeNil)
+		})
+
+		Convey("When I load an invalid CRL, it should fail", func() {
+			So(l.UpdateCRL([]byte("invalid"), ca), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestEntries(t *testing.T) {
+
+	Convey("Given a revocation list with a CRL and a deny-list", t, func() {
+		ca, caKey, err := authority()
+		So(err, ShouldBeNil)
+		byCRL, err := certificate(10, ca, caKey)
+		So(err, ShouldBeNil)
+		bySerial, err := certificate(11, ca, caKey)
+		So(err, ShouldBeNil)
+		byKey, err := certificate(12, ca, caKey)
+		So(err, ShouldBeNil)
+		valid, err := certificate(13, ca, caKey)
+		So(err, ShouldBeNil)
+
+		l := NewList()
+		crl, err := ca.CreateCRL(rand.Reader, caKey, []pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(10), RevocationTime: time.Now()},
+		}, time.Now(), time.Now().Add(time.Hour))
+		So(err, ShouldBeNil)
+		So(l.UpdateCRL(crl, ca), ShouldBeNil)
+		l.AddSerial(big.NewInt(11))
+		fingerprint, err := Fingerprint(byKey.PublicKey)
+		So(err, ShouldBeNil)
+		l.AddFingerprint(fingerprint)
+
+		Convey("When I transmit its entries and create a list from them", func() {
+			buffer := &bytes.Buffer{}
+			So(gob.NewEncoder(buffer).Encode(l.Entries()), ShouldBeNil)
+			e := &Entries{}
+			So(gob.NewDecoder(buffer).Decode(e), ShouldBeNil)
+
+			r := NewListFromEntries(e)
+
+			Convey("Then it should revoke the same certificates", func() {
+				So(IsRevoked(r.CheckCertificate(byCRL)), ShouldBeTrue)
+				So(IsRevoked(r.CheckCertificate(bySerial)), ShouldBeTrue)
+				So(IsRevoked(r.CheckCertificate(byKey)), ShouldBeTrue)
+				So(r.CheckCertificate(valid), ShouldBeNil)
+			})
+
+			Convey("Then it should not change with the original list", func() {
+				l.RemoveSerial(big.NewInt(11))
+				So(IsRevoked(r.CheckCertificate(bySerial)), ShouldBeTrue)
+			})
+		})
+	})
+}
@@ -1,25 +1,69 @@
 package secrets
 
 import (
-	"crypto/ecdsa"
+	gocrypto "crypto"
 	"crypto/x509"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/pkiverifier"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/revocation"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/signer"
 	"github.com/aporeto-inc/trireme-lib/utils/crypto"
 	"go.uber.org/zap"
 )
 
-// CompactPKI holds all PKI information
+// CompactPKI holds all PKI information. It can hold several keypairs and
+// token authorities with overlapping validity periods, so that keys can be
+// rotated without interrupting the connections with the peers that have not
+// rotated yet. The tokens are signed with the newest valid keypair and verified
+// with all the token authorities that are still valid.
 type CompactPKI struct {
 	PrivateKeyPEM []byte
 	PublicKeyPEM  []byte
 	AuthorityPEM  []byte
 	TokenKeyPEMs  [][]byte
-	privateKey    *ecdsa.PrivateKey
-	publicKey     *x509.Certificate
-	txKey         []byte
+	KeyPairs      []*CompactPKIKeyPair
+	TokenCAs      []*CompactPKITokenCA
+	keyPairs      []*compactKeyPair
 	verifier      pkiverifier.PKITokenVerifier
+	revocationChecker
+}
+
+// CompactPKIKeyPair is a keypair of the enforcer with the token issued for its
+// certificate and the period during which it is used. Zero times are not enforced.
+// The private key can be held by a signing daemon instead of being provided in PEM.
+type CompactPKIKeyPair struct {
+	KeyPEM         []byte
+	CertificatePEM []byte
+	Token          []byte
+	NotBefore      time.Time
+	NotAfter       time.Time
+	// SignerSocket is the socket of the signing daemon holding the key
+	SignerSocket string
+	// signer is a signer holding the key provided in process
+	signer gocrypto.Signer
+}
+
+// CompactPKITokenCA is a token authority with the period during which the
+// tokens it signed are accepted. Zero times are not enforced.
+type CompactPKITokenCA struct {
+	CertificatePEM []byte
+	NotBefore      time.Time
+	NotAfter       time.Time
+}
+
+// compactKeyPair is a decoded keypair
+type compactKeyPair struct {
+	*CompactPKIKeyPair
+	privateKey gocrypto.Signer
+	publicKey  *x509.Certificate
+}
+
+// valid returns true if the keypair can be used at the given time.
+func (k *compactKeyPair) valid(now time.Time) bool {
+	return (k.NotBefore.IsZero() || !now.Before(k.NotBefore)) && (k.NotAfter.IsZero() || !now.After(k.NotAfter))
 }
 
 // NewCompactPKI creates new secrets for PKI implementation based on compact encoding
@@ -32,53 +76,215 @@ func NewCompactPKI(keyPEM []byte, certPEM []byte, caPEM []byte, txKey []byte) (*
 // NewCompactPKIWithTokenCA creates new secrets for PKI implementation based on compact encoding
 func NewCompactPKIWithTokenCA(keyPEM []byte, certPEM []byte, caPEM []byte, tokenKeyPEMs [][]byte, txKey []byte) (*CompactPKI, error) {
 
+	keyPairs := []*CompactPKIKeyPair{
+		{
+			KeyPEM:         keyPEM,
+			CertificatePEM: certPEM,
+			Token:          txKey,
+		},
+	}
+
+	tokenCAs := make([]*CompactPKITokenCA, len(tokenKeyPEMs))
+	for i, ca := range tokenKeyPEMs {
+		tokenCAs[i] = &CompactPKITokenCA{CertificatePEM: ca}
+	}
+
+	return NewCompactPKIWithRotation(caPEM, keyPairs, tokenCAs)
+}
+
+// NewCompactPKIWithSigner creates new secrets for PKI implementation based on
+// compact encoding whose private key is held by a signer. Only the keys held by
+// a DaemonSigner can be transmitted to the remote enforcers.
+func NewCompactPKIWithSigner(s gocrypto.Signer, certPEM []byte, caPEM []byte, tokenKeyPEMs [][]byte, txKey []byte) (*CompactPKI, error) {
+
+	keyPairs := []*CompactPKIKeyPair{
+		{
+			CertificatePEM: certPEM,
+			Token:          txKey,
+			SignerSocket:   signerSocket(s),
+			signer:         s,
+		},
+	}
+
+	tokenCAs := make([]*CompactPKITokenCA, len(tokenKeyPEMs))
+	for i, ca := range tokenKeyPEMs {
+		tokenCAs[i] = &CompactPKITokenCA{CertificatePEM: ca}
+	}
+
+	return NewCompactPKIWithRotation(caPEM, keyPairs, tokenCAs)
+}
+
+// NewCompactPKIWithRotation creates new secrets for PKI implementation based on
+// compact encoding with several keypairs and token authorities. The keypairs
+// whose period has already ended are ignored.
+func NewCompactPKIWithRotation(caPEM []byte, keyPairs []*CompactPKIKeyPair, tokenCAs []*CompactPKITokenCA) (*CompactPKI, error) {
+
 	zap.L().Debug("Initializing with Compact PKI")
 
-	key, cert, _, err := crypto.LoadAndVerifyECSecrets(keyPEM, certPEM, caPEM)
-	if err != nil {
-		return nil, err
+	now := time.Now()
+
+	p := &CompactPKI{
+		AuthorityPEM: caPEM,
+		KeyPairs:     keyPairs,
+		TokenCAs:     tokenCAs,
 	}
 
-	var tokenKeys []*ecdsa.PublicKey
-	for _, ca := range tokenKeyPEMs {
-		caCert, err := crypto.LoadCertificate(ca)
+	for _, kp := range keyPairs {
+
+		if !kp.NotAfter.IsZero() && now.After(kp.NotAfter) {
+			zap.L().Debug("Ignoring expired keypair", zap.Time("notAfter", kp.NotAfter))
+			continue
+		}
+
+		key, cert, err := loadKeyPair(kp, caPEM)
 		if err != nil {
 			return nil, err
 		}
-		tokenKeys = append(tokenKeys, caCert.PublicKey.(*ecdsa.PublicKey))
+
+		if len(kp.Token) == 0 {
+			return nil, errors.New("transmit token missing")
+		}
+
+		p.keyPairs = append(p.keyPairs, &compactKeyPair{
+			CompactPKIKeyPair: kp,
+			privateKey:        key,
+			publicKey:         cert,
+		})
 	}
 
-	if len(txKey) == 0 {
-		return nil, errors.New("transmit token missing")
+	if len(p.keyPairs) == 0 {
+		return nil, errors.New("no valid keypair")
 	}
 
-	p := &CompactPKI{
-		PrivateKeyPEM: keyPEM,
-		PublicKeyPEM:  certPEM,
-		AuthorityPEM:  caPEM,
-		TokenKeyPEMs:  tokenKeyPEMs,
-		privateKey:    key,
-		publicKey:     cert,
-		txKey:         txKey,
-		verifier:      pkiverifier.NewPKIVerifier(tokenKeys, -1),
+	var tokenKeys []*pkiverifier.VerifierKey
+	for _, ca := range tokenCAs {
+		caCert, err := crypto.LoadCertificate(ca.CertificatePEM)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := signer.SigningMethod(caCert.PublicKey); err != nil {
+			return nil, fmt.Errorf("unsupported token authority: %s", err)
+		}
+
+		tokenKeys = append(tokenKeys, &pkiverifier.VerifierKey{
+			Key:       caCert.PublicKey,
+			NotBefore: ca.NotBefore,
+			NotAfter:  ca.NotAfter,
+		})
+		p.TokenKeyPEMs = append(p.TokenKeyPEMs, ca.CertificatePEM)
 	}
 
+	p.verifier = pkiverifier.NewPKIVerifierWithValidity(tokenKeys, -1)
+
+	newest := p.newestKeyPair()
+	p.PrivateKeyPEM = newest.KeyPEM
+	p.PublicKeyPEM = newest.CertificatePEM
+
 	return p, nil
 }
 
+// loadKeyPair loads the private key or the signer of a keypair and verifies
+// its certificate.
+func loadKeyPair(kp *CompactPKIKeyPair, caPEM []byte) (gocrypto.Signer, *x509.Certificate, error) {
+
+	if kp.signer == nil && kp.SignerSocket == "" {
+		key, cert, _, err := crypto.LoadAndVerifySecrets(kp.KeyPEM, kp.CertificatePEM, caPEM)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, cert, nil
+	}
+
+	s := kp.signer
+	if s == nil {
+		ds, err := signer.NewDaemonSigner(kp.SignerSocket)
+		if err != nil {
+			return nil, nil, err
+		}
+		s = ds
+	}
+
+	caPool := crypto.LoadRootCertificates(caPEM)
+	if caPool == nil {
+		return nil, nil, errors.New("invalid ca")
+	}
+
+	cert, err := crypto.LoadAndVerifyCertificate(kp.CertificatePEM, caPool)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := signer.MatchesPublicKey(s, cert.PublicKey); err != nil {
+		return nil, nil, err
+	}
+
+	return s, cert, nil
+}
+
+// newestKeyPair returns the keypair that started last.
+func (p *CompactPKI) newestKeyPair() *compactKeyPair {
+
+	newest := p.keyPairs[0]
+	for _, kp := range p.keyPairs[1:] {
+		if kp.NotBefore.After(newest.NotBefore) {
+			newest = kp
+		}
+	}
+
+	return newest
+}
+
+// activeKeyPair returns the newest keypair valid now. If no keypair is valid,
+// the one that expires last is used, so that the peers still in their overlap
+// period can verify it.
+func (p *CompactPKI) activeKeyPair() *compactKeyPair {
+
+	now := time.Now()
+
+	var active *compactKeyPair
+	for _, kp := range p.keyPairs {
+		if !kp.valid(now) {
+			continue
+		}
+		if active == nil || kp.NotBefore.After(active.NotBefore) {
+			active = kp
+		}
+	}
+
+	if active != nil {
+		return active
+	}
+
+	active = p.keyPairs[0]
+	for _, kp := range p.keyPairs[1:] {
+		if kp.NotAfter.After(active.NotAfter) {
+			active = kp
+		}
+	}
+
+	return active
+}
+
+// SetRevocationList implements the RevocationListSetter interface
+func (p *CompactPKI) SetRevocationList(l *revocation.List) {
+	p.revocationChecker.SetRevocationList(l)
+	p.verifier.SetRevocationList(l)
+}
+
 // Type implements the interface Secrets
 func (p *CompactPKI) Type() PrivateSecretsType {
 	return PKICompactType
 }
 
-// EncodingKey returns the private key
+// EncodingKey returns the private key or the signer holding it
 func (p *CompactPKI) EncodingKey() interface{} {
-	return p.privateKey
+	return p.activeKeyPair().privateKey
 }
 
 // PublicKey returns the public key
 func (p *CompactPKI) PublicKey() interface{} {
-	return p.publicKey
+	return p.activeKeyPair().publicKey
 }
 
 // DecodingKey returns the public key
@@ -86,7 +292,7 @@ func (p *CompactPKI) DecodingKey(server string, ackKey interface{}, prevKey inte
 
 	// If we have an inband certificate, return this one
 	if ackKey != nil {
-		return ackKey.(*ecdsa.PublicKey), nil
+		return ackKey, nil
 	}
 
 	// Otherwise, return the prevCert
@@ -107,12 +313,12 @@ func (p *CompactPKI) VerifyPublicKey(pkey []byte) (interface{}, error) {
 // TransmittedKey returns the PEM of the public key in the case of PKI
 // if there is no certificate cache configured
 func (p *CompactPKI) TransmittedKey() []byte {
-	return p.txKey
+	return p.activeKeyPair().Token
 }
 
-// AckSize returns the default size of an ACK packet
+// AckSize returns the size of the ACK packets signed by the active keypair
 func (p *CompactPKI) AckSize() uint32 {
-	return uint32(322)
+	return ackSize(p.activeKeyPair().privateKey)
 }
 
 // AuthPEM returns the Certificate Authority PEM
@@ -132,23 +338,26 @@ func (p *CompactPKI) TokenPEMs() [][]byte {
 
 // TransmittedPEM returns the PEM certificate that is transmitted
 func (p *CompactPKI) TransmittedPEM() []byte {
-	return p.PublicKeyPEM
+	return p.activeKeyPair().CertificatePEM
 }
 
 // EncodingPEM returns the certificate PEM that is used for encoding
 func (p *CompactPKI) EncodingPEM() []byte {
-	return p.PrivateKeyPEM
+	return p.activeKeyPair().KeyPEM
 }
 
 // PublicSecrets returns the secrets that are marshallable over the RPC interface.
 func (p *CompactPKI) PublicSecrets() PublicSecrets {
 	return &CompactPKIPublicSecrets{
-		Type:        PKICompactType,
-		Key:         p.PrivateKeyPEM,
-		Certificate: p.PublicKeyPEM,
-		CA:          p.AuthorityPEM,
-		Token:       p.txKey,
-		TokenCAs:    p.TokenKeyPEMs,
+		Type:           PKICompactType,
+		Key:            p.PrivateKeyPEM,
+		Certificate:    p.PublicKeyPEM,
+		CA:             p.AuthorityPEM,
+		Token:          p.TransmittedKey(),
+		TokenCAs:       p.TokenKeyPEMs,
+		KeyPairs:       p.KeyPairs,
+		TokenCAPeriods: p.TokenCAs,
+		Revoked:        p.revocationEntries(),
 	}
 }
 
@@ -161,6 +370,11 @@ type CompactPKIPublicSecrets struct {
 	CA          []byte
 	TokenCAs    [][]byte
 	Token       []byte
+	// KeyPairs and TokenCAPeriods are only set when the secrets are rotated
+	KeyPairs       []*CompactPKIKeyPair
+	TokenCAPeriods []*CompactPKITokenCA
+	// Revoked are the entries of the revocation list of the secrets
+	Revoked *revocation.Entries
 }
 
 // SecretsType returns the type of secrets.
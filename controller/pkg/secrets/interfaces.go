@@ -1,8 +1,26 @@
 package secrets
 
+import "github.com/aporeto-inc/trireme-lib/controller/pkg/revocation"
+
 // PublicKeyAdder register a publicKey for a Node.
 type PublicKeyAdder interface {
 
 	// PublicKeyAdd adds the given cert for the given host.
 	PublicKeyAdd(host string, cert []byte) error
 }
+
+// RevocationListSetter sets the revocation list of the secrets. The
+// certificates and keys of the peers in the list are rejected.
+type RevocationListSetter interface {
+
+	// SetRevocationList sets the revocation list.
+	SetRevocationList(l *revocation.List)
+}
+
+// Updater updates the secrets of the enforcers. It is implemented by the
+// controller.
+type Updater interface {
+
+	// UpdateSecrets updates the secrets.
+	UpdateSecrets(s Secrets) error
+}
@@ -1,6 +1,7 @@
 package secrets
 
 import (
+	gocrypto "crypto"
 	"crypto/ecdsa"
 	"crypto/x509"
 	"errors"
@@ -8,6 +9,8 @@ import (
 
 	"go.uber.org/zap"
 
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/revocation"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/signer"
 	"github.com/aporeto-inc/trireme-lib/utils/crypto"
 )
 
@@ -17,14 +20,15 @@ type PKISecrets struct {
 	PublicKeyPEM     []byte
 	AuthorityPEM     []byte
 	CertificateCache map[string]*ecdsa.PublicKey
-	privateKey       *ecdsa.PrivateKey
+	privateKey       gocrypto.Signer
 	publicKey        *x509.Certificate
 	certPool         *x509.CertPool
+	revocationChecker
 }
 
 // NewPKISecrets creates new secrets for PKI implementations
 func NewPKISecrets(keyPEM, certPEM, caPEM []byte, certCache map[string]*ecdsa.PublicKey) (*PKISecrets, error) {
-	key, cert, caCertPool, err := crypto.LoadAndVerifyECSecrets(keyPEM, certPEM, caPEM)
+	key, cert, caCertPool, err := crypto.LoadAndVerifySecrets(keyPEM, certPEM, caPEM)
 	if err != nil {
 		return nil, fmt.Errorf("invalid certificates: %s", err)
 	}
@@ -42,12 +46,42 @@ func NewPKISecrets(keyPEM, certPEM, caPEM []byte, certCache map[string]*ecdsa.Pu
 	return p, nil
 }
 
+// NewPKISecretsWithSigner creates new secrets for PKI implementations whose
+// private key is held by a signer
+func NewPKISecretsWithSigner(s gocrypto.Signer, certPEM, caPEM []byte, certCache map[string]*ecdsa.PublicKey) (*PKISecrets, error) {
+
+	caCertPool := crypto.LoadRootCertificates(caPEM)
+	if caCertPool == nil {
+		return nil, errors.New("invalid ca")
+	}
+
+	cert, err := crypto.LoadAndVerifyCertificate(certPEM, caCertPool)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificates: %s", err)
+	}
+
+	if err := signer.MatchesPublicKey(s, cert.PublicKey); err != nil {
+		return nil, fmt.Errorf("invalid signer: %s", err)
+	}
+
+	p := &PKISecrets{
+		PublicKeyPEM:     certPEM,
+		AuthorityPEM:     caPEM,
+		CertificateCache: certCache,
+		privateKey:       s,
+		publicKey:        cert,
+		certPool:         caCertPool,
+	}
+
+	return p, nil
+}
+
 // Type implements the interface Secrets
 func (p *PKISecrets) Type() PrivateSecretsType {
 	return PKIType
 }
 
-// EncodingKey returns the private key
+// EncodingKey returns the private key or the signer holding it
 func (p *PKISecrets) EncodingKey() interface{} {
 	return p.privateKey
 }
@@ -68,12 +102,16 @@ func (p *PKISecrets) DecodingKey(server string, ackCert interface{}, prevCert in
 			return nil, fmt.Errorf("no certificate in cache for server %s", server)
 		}
 
+		if err := p.checkPublicKey(cert); err != nil {
+			return nil, err
+		}
+
 		return cert, nil
 	}
 
 	// If we have an inband certificate, return this one
 	if ackCert != nil {
-		return ackCert.(*x509.Certificate).PublicKey.(*ecdsa.PublicKey), nil
+		return ackCert.(*x509.Certificate).PublicKey, nil
 	}
 
 	// Otherwise, return the prevCert
@@ -92,6 +130,10 @@ func (p *PKISecrets) VerifyPublicKey(pkey []byte) (interface{}, error) {
 		return nil, err
 	}
 
+	if err := p.checkCertificate(decodedCert); err != nil {
+		return nil, err
+	}
+
 	return decodedCert, nil
 }
 
@@ -101,9 +143,9 @@ func (p *PKISecrets) TransmittedKey() []byte {
 	return p.PublicKeyPEM
 }
 
-// AckSize returns the default size of an ACK packet
+// AckSize returns the size of the ACK packets signed by the key
 func (p *PKISecrets) AckSize() uint32 {
-	return uint32(336)
+	return ackSize(p.privateKey)
 }
 
 // PublicKeyAdd validates the parameter certificate.
@@ -116,9 +158,18 @@ func (p *PKISecrets) PublicKeyAdd(host string, newCert []byte) error {
 		return fmt.Errorf("unable to load certificate: %s", err)
 	}
 
+	if err := p.checkCertificate(cert); err != nil {
+		return err
+	}
+
+	publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("only ecdsa certificates can be cached")
+	}
+
 	zap.L().Debug("Adding cert for host", zap.String("host", host))
 
-	p.CertificateCache[host] = cert.PublicKey.(*ecdsa.PublicKey)
+	p.CertificateCache[host] = publicKey
 	return nil
 }
 
@@ -140,10 +191,12 @@ func (p *PKISecrets) EncodingPEM() []byte {
 // PublicSecrets returns the secrets that are marshallable over the RPC interface.
 func (p *PKISecrets) PublicSecrets() PublicSecrets {
 	return &PKIPublicSecrets{
-		Type:        PKIType,
-		Key:         p.PrivateKeyPEM,
-		Certificate: p.PublicKeyPEM,
-		CA:          p.AuthorityPEM,
+		Type:         PKIType,
+		Key:          p.PrivateKeyPEM,
+		Certificate:  p.PublicKeyPEM,
+		CA:           p.AuthorityPEM,
+		SignerSocket: signerSocket(p.privateKey),
+		Revoked:      p.revocationEntries(),
 	}
 }
 
@@ -154,6 +207,10 @@ type PKIPublicSecrets struct {
 	Key         []byte
 	Certificate []byte
 	CA          []byte
+	// SignerSocket is the socket of the signing daemon holding the key
+	SignerSocket string
+	// Revoked are the entries of the revocation list of the secrets
+	Revoked *revocation.Entries
 }
 
 // SecretsType returns the type of secrets.
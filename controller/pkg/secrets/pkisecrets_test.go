@@ -5,6 +5,7 @@ import (
 	"crypto/x509"
 	"testing"
 
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/revocation"
 	"github.com/aporeto-inc/trireme-lib/utils/crypto"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -68,7 +69,7 @@ func TestPKIBasicInterfaceFunctions(t *testing.T) {
 		})
 
 		Convey("I should ge the righ ack size", func() {
-			So(p.AckSize(), ShouldEqual, 336)
+			So(p.AckSize(), ShouldEqual, 322)
 		})
 
 		Convey("I should get the right public key, ", func() {
@@ -135,3 +136,39 @@ func TestPKICache(t *testing.T) {
 
 	})
 }
+
+func TestPKIRevocation(t *testing.T) {
+	Convey("Given PKI secrets with a cache and a revocation list", t, func() {
+		p, err := NewPKISecrets([]byte(clientSVIDKeyPEM), []byte(clientSVIDPEM), []byte(spiffeBundlePEM), map[string]*ecdsa.PublicKey{})
+		So(err, ShouldBeNil)
+		So(p.PublicKeyAdd("server", []byte(serverSVIDPEM)), ShouldBeNil)
+
+		l := revocation.NewList()
+		p.SetRevocationList(l)
+
+		server, err := crypto.LoadCertificate([]byte(serverSVIDPEM))
+		So(err, ShouldBeNil)
+
+		Convey("When the key of a cached certificate is denied, the decoding key should be rejected", func() {
+			_, err := p.DecodingKey("server", nil, nil)
+			So(err, ShouldBeNil)
+
+			fingerprint, err := revocation.Fingerprint(server.PublicKey)
+			So(err, ShouldBeNil)
+			l.AddFingerprint(fingerprint)
+
+			_, err = p.DecodingKey("server", nil, nil)
+			So(revocation.IsRevoked(err), ShouldBeTrue)
+		})
+
+		Convey("When I create secrets from the public secrets, they should carry the revocation list", func() {
+			l.AddSerial(server.SerialNumber)
+
+			s, err := NewSecrets(p.PublicSecrets())
+			So(err, ShouldBeNil)
+
+			_, err = s.VerifyPublicKey([]byte(serverSVIDPEM))
+			So(revocation.IsRevoked(err), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,59 @@
+package secrets
+
+import (
+	"crypto/x509"
+	"sync"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/revocation"
+)
+
+// revocationChecker rejects the certificates of the peers that have been revoked.
+type revocationChecker struct {
+	revoked *revocation.List
+	sync.RWMutex
+}
+
+// SetRevocationList implements the RevocationListSetter interface
+func (r *revocationChecker) SetRevocationList(l *revocation.List) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.revoked = l
+}
+
+// checkCertificate returns an error if the certificate has been revoked.
+func (r *revocationChecker) checkCertificate(cert *x509.Certificate) error {
+	r.RLock()
+	defer r.RUnlock()
+
+	if r.revoked == nil {
+		return nil
+	}
+
+	return r.revoked.CheckCertificate(cert)
+}
+
+// checkPublicKey returns an error if the public key has been revoked.
+func (r *revocationChecker) checkPublicKey(key interface{}) error {
+	r.RLock()
+	defer r.RUnlock()
+
+	if r.revoked == nil {
+		return nil
+	}
+
+	return r.revoked.CheckPublicKey(key)
+}
+
+// revocationEntries returns the entries of the revocation list to transmit
+// them over the RPC interface, or nil if there is no list.
+func (r *revocationChecker) revocationEntries() *revocation.Entries {
+	r.RLock()
+	defer r.RUnlock()
+
+	if r.revoked == nil {
+		return nil
+	}
+
+	return r.revoked.Entries()
+}
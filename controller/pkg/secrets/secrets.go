@@ -1,6 +1,13 @@
 package secrets
 
-import "fmt"
+import (
+	gocrypto "crypto"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/revocation"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/signer"
+)
 
 // Secrets is an interface implementing secrets
 type Secrets interface {
@@ -43,21 +50,97 @@ const (
 	PKICompactType
 	// PKINull is for debugging
 	PKINull
+	// SPIFFEType is for asymetric signing using X.509-SVIDs
+	SPIFFEType
 )
 
-// NewSecrets creates a new set of secrets based on the type.
+// p256AckSize is the size of the ACK packets signed with P-256 keys
+const p256AckSize = 322
+
+// NewSecrets creates a new set of secrets based on the type. The revocation
+// list carried by the public secrets is set on the new secrets.
 func NewSecrets(s PublicSecrets) (Secrets, error) {
+
+	secrets, err := newSecrets(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked := revokedEntries(s); revoked != nil {
+		if setter, ok := secrets.(RevocationListSetter); ok {
+			setter.SetRevocationList(revocation.NewListFromEntries(revoked))
+		}
+	}
+
+	return secrets, nil
+}
+
+// newSecrets creates a new set of secrets based on the type.
+func newSecrets(s PublicSecrets) (Secrets, error) {
 	switch s.SecretsType() {
 	case PKIType:
 		t := s.(*PKIPublicSecrets)
+		if t.SignerSocket != "" {
+			ds, err := signer.NewDaemonSigner(t.SignerSocket)
+			if err != nil {
+				return nil, err
+			}
+			return NewPKISecretsWithSigner(ds, t.Certificate, t.CA, nil)
+		}
 		return NewPKISecrets(t.Key, t.Certificate, t.CA, nil)
 	case PKICompactType:
 		t := s.(*CompactPKIPublicSecrets)
+		if len(t.KeyPairs) > 0 {
+			return NewCompactPKIWithRotation(t.CA, t.KeyPairs, t.TokenCAPeriods)
+		}
 		return NewCompactPKIWithTokenCA(t.Key, t.Certificate, t.CA, t.TokenCAs, t.Token)
 	case PSKType:
 		t := s.(*PSKPublicSecrets)
 		return NewPSKSecrets(t.SharedKey), nil
+	case SPIFFEType:
+		t := s.(*SPIFFEPublicSecrets)
+		return NewSPIFFESecrets(t.Certificate, t.Key, t.Bundle)
 	default:
 		return nil, fmt.Errorf("Unsupported type")
 	}
 }
+
+// revokedEntries returns the entries of the revocation list carried by the
+// public secrets.
+func revokedEntries(s PublicSecrets) *revocation.Entries {
+
+	switch t := s.(type) {
+	case *PKIPublicSecrets:
+		return t.Revoked
+	case *CompactPKIPublicSecrets:
+		return t.Revoked
+	case *SPIFFEPublicSecrets:
+		return t.Revoked
+	default:
+		return nil
+	}
+}
+
+// signerSocket returns the socket of the signing daemon holding a key, so that
+// the remote enforcers use the same daemon. Other signers can not be
+// transmitted over the RPC interface.
+func signerSocket(s gocrypto.Signer) string {
+
+	if ds, ok := s.(*signer.DaemonSigner); ok {
+		return ds.Path()
+	}
+
+	return ""
+}
+
+// ackSize returns the size of the ACK packets signed by a key. The tokens only
+// differ from the ones signed by a P-256 key by the encoding of their signature.
+func ackSize(key interface{}) uint32 {
+
+	size, err := signer.SignatureSize(key)
+	if err != nil {
+		return p256AckSize
+	}
+
+	return p256AckSize - uint32(base64.RawURLEncoding.EncodedLen(64)) + uint32(base64.RawURLEncoding.EncodedLen(size))
+}
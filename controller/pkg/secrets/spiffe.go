@@ -0,0 +1,375 @@
+package secrets
+
+import (
+	gocrypto "crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/revocation"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/signer"
+	"github.com/aporeto-inc/trireme-lib/utils/crypto"
+)
+
+const (
+	// SPIFFEScheme is the URI scheme of the SPIFFE IDs
+	SPIFFEScheme = "spiffe"
+
+	// svidSocketTimeout is the timeout of the requests to an SVID socket
+	svidSocketTimeout = 5 * time.Second
+)
+
+// SVIDSource provides the X.509-SVID of the enforcer and the trust bundle
+// used to verify the SVIDs of the remotes.
+type SVIDSource interface {
+	// FetchSVID returns the PEM encoded certificate chain of the SVID, starting
+	// with the leaf, its private key and the trust bundle.
+	FetchSVID() (certPEM, keyPEM, bundlePEM []byte, err error)
+}
+
+// FileSVIDSource reads the SVID and the trust bundle from local files.
+type FileSVIDSource struct {
+	CertPath   string
+	KeyPath    string
+	BundlePath string
+}
+
+// FetchSVID implements the SVIDSource interface
+func (f *FileSVIDSource) FetchSVID() (certPEM, keyPEM, bundlePEM []byte, err error) {
+
+	if certPEM, err = ioutil.ReadFile(f.CertPath); err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to read svid: %s", err)
+	}
+
+	if keyPEM, err = ioutil.ReadFile(f.KeyPath); err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to read svid key: %s", err)
+	}
+
+	if bundlePEM, err = ioutil.ReadFile(f.BundlePath); err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to read trust bundle: %s", err)
+	}
+
+	return certPEM, keyPEM, bundlePEM, nil
+}
+
+// SocketSVIDSource reads the SVID and the trust bundle from a unix socket. It
+// is a stand-in for the SPIFFE workload API: the agent listening on the socket
+// writes an SVIDResponse in JSON for every connection and closes it.
+type SocketSVIDSource struct {
+	Path string
+}
+
+// SVIDResponse is the document served on an SVID socket.
+type SVIDResponse struct {
+	SVID   string `json:"svid"`
+	Key    string `json:"key"`
+	Bundle string `json:"bundle"`
+}
+
+// FetchSVID implements the SVIDSource interface
+func (s *SocketSVIDSource) FetchSVID() (certPEM, keyPEM, bundlePEM []byte, err error) {
+
+	conn, err := net.DialTimeout("unix", s.Path, svidSocketTimeout)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to connect to svid socket: %s", err)
+	}
+	defer conn.Close() // nolint
+
+	if err = conn.SetReadDeadline(time.Now().Add(svidSocketTimeout)); err != nil {
+		return nil, nil, nil, err
+	}
+
+	response := &SVIDResponse{}
+	if err = json.NewDecoder(conn).Decode(response); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid svid response: %s", err)
+	}
+
+	return []byte(response.SVID), []byte(response.Key), []byte(response.Bundle), nil
+}
+
+// SPIFFESecrets holds the X.509-SVID of the enforcer. The SVID is transmitted
+// in the tokens and the SVIDs of the remotes are verified with the trust bundle.
+type SPIFFESecrets struct {
+	CertificatePEM []byte
+	PrivateKeyPEM  []byte
+	BundlePEM      []byte
+	id             string
+	privateKey     gocrypto.Signer
+	certificate    *x509.Certificate
+	transmittedKey []byte
+	bundle         *x509.CertPool
+	tlsCertificate *tls.Certificate
+	revocationChecker
+}
+
+// NewSPIFFESecrets creates new secrets from an X.509-SVID, its private key and
+// the trust bundle.
+func NewSPIFFESecrets(certPEM, keyPEM, bundlePEM []byte) (*SPIFFESecrets, error) {
+
+	key, err := crypto.LoadPrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid svid key: %s", err)
+	}
+
+	chain, err := loadCertificateChain(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid svid: %s", err)
+	}
+
+	bundle := x509.NewCertPool()
+	if !bundle.AppendCertsFromPEM(bundlePEM) {
+		return nil, errors.New("invalid trust bundle")
+	}
+
+	id, err := verifySVID(chain[0], certificatePool(chain[1:]), bundle)
+	if err != nil {
+		return nil, fmt.Errorf("invalid svid: %s", err)
+	}
+
+	if err := signer.MatchesPublicKey(key, chain[0].PublicKey); err != nil {
+		return nil, errors.New("svid does not match its key")
+	}
+
+	tlsCertificate := &tls.Certificate{PrivateKey: key, Leaf: chain[0]}
+	transmittedKey := []byte{}
+	for _, cert := range chain {
+		tlsCertificate.Certificate = append(tlsCertificate.Certificate, cert.Raw)
+		transmittedKey = append(transmittedKey, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	return &SPIFFESecrets{
+		CertificatePEM: certPEM,
+		PrivateKeyPEM:  keyPEM,
+		BundlePEM:      bundlePEM,
+		id:             id,
+		privateKey:     key,
+		certificate:    chain[0],
+		transmittedKey: transmittedKey,
+		bundle:         bundle,
+		tlsCertificate: tlsCertificate,
+	}, nil
+}
+
+// NewSPIFFESecretsFromSource creates new secrets from the SVID provided by a source.
+func NewSPIFFESecretsFromSource(source SVIDSource) (*SPIFFESecrets, error) {
+
+	certPEM, keyPEM, bundlePEM, err := source.FetchSVID()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSPIFFESecrets(certPEM, keyPEM, bundlePEM)
+}
+
+// Type implements the interface Secrets
+func (s *SPIFFESecrets) Type() PrivateSecretsType {
+	return SPIFFEType
+}
+
+// EncodingKey returns the private key of the SVID
+func (s *SPIFFESecrets) EncodingKey() interface{} {
+	return s.privateKey
+}
+
+// PublicKey returns the SVID
+func (s *SPIFFESecrets) PublicKey() interface{} {
+	return s.certificate
+}
+
+// DecodingKey returns the public key of the SVID of the remote
+func (s *SPIFFESecrets) DecodingKey(server string, ackCert interface{}, prevCert interface{}) (interface{}, error) {
+
+	if ackCert != nil {
+		publicKey := ackCert.(*x509.Certificate).PublicKey
+		if _, err := signer.SigningMethod(publicKey); err != nil {
+			return nil, errors.New("unsupported svid key")
+		}
+		return publicKey, nil
+	}
+
+	if prevCert != nil {
+		return prevCert, nil
+	}
+
+	return nil, errors.New("no valid certificate")
+}
+
+// VerifyPublicKey verifies the inband SVID of a remote with the trust bundle
+// and the intermediates transmitted after it. The SVID must carry exactly one
+// SPIFFE ID.
+func (s *SPIFFESecrets) VerifyPublicKey(pkey []byte) (interface{}, error) {
+
+	chain, err := loadCertificateChain(pkey)
+	if err != nil {
+		return nil, err
+	}
+
+	cert := chain[0]
+
+	if _, err := verifySVID(cert, certificatePool(chain[1:]), s.bundle); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkCertificate(cert); err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// TransmittedKey returns the PEM of the chain of the SVID, starting with the
+// leaf, so that the remotes can verify it up to the trust bundle.
+func (s *SPIFFESecrets) TransmittedKey() []byte {
+	return s.transmittedKey
+}
+
+// AckSize returns the size of the ACK packets signed by the key of the SVID
+func (s *SPIFFESecrets) AckSize() uint32 {
+	return ackSize(s.privateKey)
+}
+
+// ID returns the SPIFFE ID of the SVID
+func (s *SPIFFESecrets) ID() string {
+	return s.id
+}
+
+// TLSCertificate returns the SVID as a TLS certificate
+func (s *SPIFFESecrets) TLSCertificate() *tls.Certificate {
+	return s.tlsCertificate
+}
+
+// VerifyPeerCertificate verifies the SVID presented by a TLS peer with the
+// trust bundle. It can be used as the VerifyPeerCertificate callback of a TLS
+// configuration, since SVIDs are not bound to host names.
+func (s *SPIFFESecrets) VerifyPeerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+
+	if len(rawCerts) == 0 {
+		return errors.New("no svid presented")
+	}
+
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("invalid certificate: %s", err)
+		}
+		certs[i] = cert
+	}
+
+	if _, err := verifySVID(certs[0], certificatePool(certs[1:]), s.bundle); err != nil {
+		return err
+	}
+
+	return s.checkCertificate(certs[0])
+}
+
+// PublicSecrets returns the secrets that are marshallable over the RPC interface.
+func (s *SPIFFESecrets) PublicSecrets() PublicSecrets {
+	return &SPIFFEPublicSecrets{
+		Type:        SPIFFEType,
+		Key:         s.PrivateKeyPEM,
+		Certificate: s.CertificatePEM,
+		Bundle:      s.BundlePEM,
+		Revoked:     s.revocationEntries(),
+	}
+}
+
+// SPIFFEPublicSecrets includes all the secrets that can be transmitted over
+// the RPC interface.
+type SPIFFEPublicSecrets struct {
+	Type        PrivateSecretsType
+	Key         []byte
+	Certificate []byte
+	Bundle      []byte
+	// Revoked are the entries of the revocation list of the secrets
+	Revoked *revocation.Entries
+}
+
+// SecretsType returns the type of secrets.
+func (p *SPIFFEPublicSecrets) SecretsType() PrivateSecretsType {
+	return p.Type
+}
+
+// CertAuthority returns the trust bundle
+func (p *SPIFFEPublicSecrets) CertAuthority() []byte {
+	return p.Bundle
+}
+
+// SPIFFEID returns the SPIFFE ID of an X.509-SVID. An SVID must have exactly
+// one URI SAN and it must be a SPIFFE ID.
+func SPIFFEID(cert *x509.Certificate) (string, error) {
+
+	if len(cert.URIs) != 1 {
+		return "", fmt.Errorf("svid must have exactly one uri san: found %d", len(cert.URIs))
+	}
+
+	uri := cert.URIs[0]
+	if uri.Scheme != SPIFFEScheme || uri.Host == "" {
+		return "", fmt.Errorf("invalid spiffe id: %s", uri.String())
+	}
+
+	return uri.String(), nil
+}
+
+// verifySVID verifies an SVID with a trust bundle and returns its SPIFFE ID.
+func verifySVID(cert *x509.Certificate, intermediates, bundle *x509.CertPool) (string, error) {
+
+	id, err := SPIFFEID(cert)
+	if err != nil {
+		return "", err
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         bundle,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+
+	if _, err := cert.Verify(opts); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// certificatePool returns a pool of the certificates.
+func certificatePool(certs []*x509.Certificate) *x509.CertPool {
+
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+
+	return pool
+}
+
+// loadCertificateChain parses all the certificates of a PEM encoded chain.
+func loadCertificateChain(certPEM []byte) ([]*x509.Certificate, error) {
+
+	chain := []*x509.Certificate{}
+
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		chain = append(chain, cert)
+	}
+
+	if len(chain) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+
+	return chain, nil
+}
@@ -0,0 +1,276 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/signer"
+	"github.com/aporeto-inc/trireme-lib/utils/crypto"
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+const (
+	// reloadDelay is the time waited after the last change of the files
+	// before reloading them. The files are often replaced one by one.
+	reloadDelay = 200 * time.Millisecond
+)
+
+// Files are the files on disk holding the secrets of the enforcer. Compact PKI
+// secrets are built when a token is provided and PKI secrets otherwise.
+type Files struct {
+	KeyPath  string
+	CertPath string
+	CAPath   string
+	// TokenPath is the path of the compact PKI token of the certificate
+	TokenPath string
+	// TokenCAPath is the path of the authority of the token. The CA is used
+	// when it is empty.
+	TokenCAPath string
+}
+
+// filesContent is the content of the files of the secrets.
+type filesContent struct {
+	keyPEM     []byte
+	certPEM    []byte
+	caPEM      []byte
+	token      []byte
+	tokenCAPEM []byte
+}
+
+// Load reads the files and returns the secrets they hold. The key, the
+// certificate and the CA are verified before building the secrets.
+func (f *Files) Load() (Secrets, error) {
+
+	c, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.secrets()
+}
+
+// read reads the content of the files.
+func (f *Files) read() (*filesContent, error) {
+
+	c := &filesContent{}
+	var err error
+
+	if c.keyPEM, err = ioutil.ReadFile(f.KeyPath); err != nil {
+		return nil, fmt.Errorf("unable to read key: %s", err)
+	}
+
+	if c.certPEM, err = ioutil.ReadFile(f.CertPath); err != nil {
+		return nil, fmt.Errorf("unable to read certificate: %s", err)
+	}
+
+	if c.caPEM, err = ioutil.ReadFile(f.CAPath); err != nil {
+		return nil, fmt.Errorf("unable to read ca: %s", err)
+	}
+
+	if f.TokenPath == "" {
+		return c, nil
+	}
+
+	if c.token, err = ioutil.ReadFile(f.TokenPath); err != nil {
+		return nil, fmt.Errorf("unable to read token: %s", err)
+	}
+
+	c.tokenCAPEM = c.caPEM
+	if f.TokenCAPath != "" {
+		if c.tokenCAPEM, err = ioutil.ReadFile(f.TokenCAPath); err != nil {
+			return nil, fmt.Errorf("unable to read token ca: %s", err)
+		}
+	}
+
+	return c, nil
+}
+
+// paths returns the paths of the files.
+func (f *Files) paths() []string {
+
+	paths := []string{f.KeyPath, f.CertPath, f.CAPath}
+	for _, p := range []string{f.TokenPath, f.TokenCAPath} {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+
+	return paths
+}
+
+// equal returns true if the content of the files is the same.
+func (c *filesContent) equal(o *filesContent) bool {
+	return o != nil &&
+		bytes.Equal(c.keyPEM, o.keyPEM) &&
+		bytes.Equal(c.certPEM, o.certPEM) &&
+		bytes.Equal(c.caPEM, o.caPEM) &&
+		bytes.Equal(c.token, o.token) &&
+		bytes.Equal(c.tokenCAPEM, o.tokenCAPEM)
+}
+
+// secrets verifies the content of the files and builds the secrets.
+func (c *filesContent) secrets() (Secrets, error) {
+
+	key, cert, _, err := crypto.LoadAndVerifySecrets(c.keyPEM, c.certPEM, c.caPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secrets: %s", err)
+	}
+
+	if err := signer.MatchesPublicKey(key, cert.PublicKey); err != nil {
+		return nil, fmt.Errorf("invalid secrets: %s", err)
+	}
+
+	if c.token == nil {
+		return NewPKISecrets(c.keyPEM, c.certPEM, c.caPEM, nil)
+	}
+
+	return NewCompactPKIWithTokenCA(c.keyPEM, c.certPEM, c.caPEM, [][]byte{c.tokenCAPEM}, c.token)
+}
+
+// Watcher watches the files of the secrets and pushes the new secrets to an
+// updater when they change. Invalid files are rejected and the current
+// secrets are kept.
+type Watcher struct {
+	files   Files
+	updater Updater
+	content *filesContent
+	secrets Secrets
+	sync.RWMutex
+}
+
+// NewWatcher loads the secrets from the files and returns a watcher pushing
+// their updates to the updater. The controller is usually the updater:
+//
+//	w, err := secrets.NewWatcher(files, ctrl)
+//	...
+//	w.Run(ctx)
+func NewWatcher(files Files, updater Updater) (*Watcher, error) {
+
+	if updater == nil {
+		return nil, errors.New("updater can not be nil")
+	}
+
+	c, err := files.read()
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := c.secrets()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Watcher{
+		files:   files,
+		updater: updater,
+		content: c,
+		secrets: s,
+	}, nil
+}
+
+// Secrets returns the current secrets.
+func (w *Watcher) Secrets() Secrets {
+
+	w.RLock()
+	defer w.RUnlock()
+
+	return w.secrets
+}
+
+// Run watches the files in the background until the context is cancelled.
+// The directories of the files are watched, so that files replaced by a
+// rename or through a symlink are detected.
+func (w *Watcher) Run(ctx context.Context) error {
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("unable to watch secrets: %s", err)
+	}
+
+	dirs := map[string]bool{}
+	for _, p := range w.files.paths() {
+		dir := filepath.Dir(p)
+		if dirs[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close() // nolint
+			return fmt.Errorf("unable to watch %s: %s", dir, err)
+		}
+		dirs[dir] = true
+	}
+
+	go w.watch(ctx, watcher)
+
+	return nil
+}
+
+// watch reloads the secrets after the events of the watcher.
+func (w *Watcher) watch(ctx context.Context, watcher *fsnotify.Watcher) {
+
+	defer watcher.Close() // nolint
+
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			timer.Reset(reloadDelay)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			zap.L().Warn("Error while watching secrets", zap.Error(err))
+		case <-timer.C:
+			w.reload()
+		}
+	}
+}
+
+// reload rebuilds the secrets from the files and pushes them to the updater
+// if they changed.
+func (w *Watcher) reload() {
+
+	c, err := w.files.read()
+	if err != nil {
+		zap.L().Error("Rejecting secrets update", zap.Error(err))
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	if c.equal(w.content) {
+		return
+	}
+
+	s, err := c.secrets()
+	if err != nil {
+		zap.L().Error("Rejecting secrets update", zap.Error(err))
+		return
+	}
+
+	if err := w.updater.UpdateSecrets(s); err != nil {
+		zap.L().Error("Unable to update secrets", zap.Error(err))
+		return
+	}
+
+	w.content = c
+	w.secrets = s
+
+	zap.L().Info("Secrets updated from files", zap.String("cert", w.files.CertPath))
+}
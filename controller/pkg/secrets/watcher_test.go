@@ -0,0 +1,217 @@
+package secrets
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testUpdater records the secrets pushed by a watcher.
+type testUpdater struct {
+	updates chan Secrets
+	err     error
+}
+
+func (u *testUpdater) UpdateSecrets(s Secrets) error {
+	if u.err != nil {
+		return u.err
+	}
+	u.updates <- s
+	return nil
+}
+
+// writeSecretsFiles writes the files of the secrets in dir.
+func writeSecretsFiles(dir string, keyPEM, certPEM string) Files {
+
+	files := Files{
+		KeyPath:  filepath.Join(dir, "key.pem"),
+		CertPath: filepath.Join(dir, "cert.pem"),
+		CAPath:   filepath.Join(dir, "ca.pem"),
+	}
+
+	ioutil.WriteFile(files.KeyPath, []byte(keyPEM), 0600)       // nolint
+	ioutil.WriteFile(files.CertPath, []byte(certPEM), 0600)     // nolint
+	ioutil.WriteFile(files.CAPath, []byte(rotationCAPEM), 0600) // nolint
+
+	return files
+}
+
+func certificateName(s Secrets) string {
+	return s.PublicKey().(*x509.Certificate).Subject.CommonName
+}
+
+func TestFiles(t *testing.T) {
+
+	Convey("Given the files of the secrets", t, func() {
+		dir, err := ioutil.TempDir("", "secrets")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		files := writeSecretsFiles(dir, oldKeyPEM, oldCertPEM)
+
+		Convey("Then PKI secrets should be loaded", func() {
+			s, err := files.Load()
+			So(err, ShouldBeNil)
+			So(s.Type(), ShouldEqual, PKIType)
+			So(certificateName(s), ShouldEqual, "old")
+		})
+
+		Convey("Then compact PKI secrets should be loaded with a token", func() {
+			files.TokenPath = filepath.Join(dir, "token")
+			files.TokenCAPath = filepath.Join(dir, "token-ca.pem")
+			So(ioutil.WriteFile(files.TokenPath, createRotationToken(oldTokenCAKeyPEM, oldCertPEM), 0600), ShouldBeNil)
+			So(ioutil.WriteFile(files.TokenCAPath, []byte(oldTokenCAPEM), 0600), ShouldBeNil)
+
+			s, err := files.Load()
+			So(err, ShouldBeNil)
+			So(s.Type(), ShouldEqual, PKICompactType)
+		})
+
+		Convey("Then PKI secrets with an Ed25519 key should be loaded", func() {
+			ca, caKey, err := issueSVID(1, "", nil, nil)
+			So(err, ShouldBeNil)
+			pub, key, err := ed25519.GenerateKey(rand.Reader)
+			So(err, ShouldBeNil)
+			template := &x509.Certificate{
+				SerialNumber: big.NewInt(2),
+				Subject:      pkix.Name{CommonName: "ed25519"},
+				NotBefore:    time.Now().Add(-time.Hour),
+				NotAfter:     time.Now().Add(time.Hour),
+				KeyUsage:     x509.KeyUsageDigitalSignature,
+			}
+			der, err := x509.CreateCertificate(rand.Reader, template, ca, pub, caKey)
+			So(err, ShouldBeNil)
+			keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+			So(err, ShouldBeNil)
+
+			So(ioutil.WriteFile(files.KeyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600), ShouldBeNil)
+			So(ioutil.WriteFile(files.CertPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600), ShouldBeNil)
+			So(ioutil.WriteFile(files.CAPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0600), ShouldBeNil)
+
+			s, err := files.Load()
+			So(err, ShouldBeNil)
+			So(s.Type(), ShouldEqual, PKIType)
+			So(certificateName(s), ShouldEqual, "ed25519")
+		})
+
+		Convey("Then a certificate that does not match the key should be rejected", func() {
+			So(ioutil.WriteFile(files.CertPath, []byte(newCertPEM), 0600), ShouldBeNil)
+			_, err := files.Load()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then a certificate that is not signed by the CA should be rejected", func() {
+			So(ioutil.WriteFile(files.CAPath, []byte(oldTokenCAPEM), 0600), ShouldBeNil)
+			_, err := files.Load()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then missing files should be rejected", func() {
+			files.TokenPath = filepath.Join(dir, "token")
+			_, err := files.Load()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestWatcher(t *testing.T) {
+
+	Convey("Given a watcher of the files of the secrets", t, func() {
+		dir, err := ioutil.TempDir("", "secrets")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		files := writeSecretsFiles(dir, oldKeyPEM, oldCertPEM)
+		updater := &testUpdater{updates: make(chan Secrets, 10)}
+
+		w, err := NewWatcher(files, updater)
+		So(err, ShouldBeNil)
+		So(certificateName(w.Secrets()), ShouldEqual, "old")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		So(w.Run(ctx), ShouldBeNil)
+
+		Convey("When the key and the certificate are replaced", func() {
+			writeSecretsFiles(dir, newKeyPEM, newCertPEM)
+
+			Convey("Then the new secrets should be pushed to the updater", func() {
+				select {
+				case s := <-updater.updates:
+					So(certificateName(s), ShouldEqual, "new")
+				case <-time.After(5 * time.Second):
+					So("no update", ShouldBeEmpty)
+				}
+				So(certificateName(w.Secrets()), ShouldEqual, "new")
+
+				Convey("Then the tokens of the peers should still be verified", func() {
+					cert, err := w.Secrets().VerifyPublicKey([]byte(oldCertPEM))
+					So(err, ShouldBeNil)
+					key, err := w.Secrets().DecodingKey("peer", cert, nil)
+					So(err, ShouldBeNil)
+					So(key, ShouldEqual, cert.(*x509.Certificate).PublicKey)
+				})
+			})
+		})
+
+		Convey("When only the certificate is replaced", func() {
+			So(ioutil.WriteFile(files.CertPath, []byte(newCertPEM), 0600), ShouldBeNil)
+
+			Convey("Then the secrets should be rejected and the current ones kept", func() {
+				select {
+				case <-updater.updates:
+					So("unexpected update", ShouldBeEmpty)
+				case <-time.After(4 * reloadDelay):
+				}
+				So(certificateName(w.Secrets()), ShouldEqual, "old")
+
+				Convey("Then the secrets should be updated once the key is replaced too", func() {
+					So(ioutil.WriteFile(files.KeyPath, []byte(newKeyPEM), 0600), ShouldBeNil)
+					select {
+					case s := <-updater.updates:
+						So(certificateName(s), ShouldEqual, "new")
+					case <-time.After(5 * time.Second):
+						So("no update", ShouldBeEmpty)
+					}
+				})
+			})
+		})
+
+		Convey("When the updater fails", func() {
+			failing, err := NewWatcher(files, &testUpdater{err: errors.New("update failed")})
+			So(err, ShouldBeNil)
+			So(failing.Run(ctx), ShouldBeNil)
+			writeSecretsFiles(dir, newKeyPEM, newCertPEM)
+
+			Convey("Then the current secrets should be kept", func() {
+				time.Sleep(4 * reloadDelay)
+				So(certificateName(failing.Secrets()), ShouldEqual, "old")
+			})
+		})
+	})
+
+	Convey("Given invalid files of the secrets", t, func() {
+		dir, err := ioutil.TempDir("", "secrets")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		files := writeSecretsFiles(dir, newKeyPEM, oldCertPEM)
+
+		Convey("Then the watcher should not be created", func() {
+			_, err := NewWatcher(files, &testUpdater{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,213 @@
+package signer
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// daemonTimeout is the timeout of the requests to the signing daemon
+	daemonTimeout = 2 * time.Second
+
+	methodPublicKey = "public"
+	methodSign      = "sign"
+)
+
+// Request is a request to the signing daemon. Every connection carries one
+// request and its response, encoded in JSON.
+type Request struct {
+	Method string `json:"method"`
+	// Digest is the digest to sign. Ed25519 keys sign the data itself.
+	Digest []byte `json:"digest,omitempty"`
+	// Hash is the hash of the digest, zero for Ed25519 keys
+	Hash crypto.Hash `json:"hash,omitempty"`
+}
+
+// Response is the response of the signing daemon.
+type Response struct {
+	// PublicKey is the PKIX encoding of the public key of the daemon
+	PublicKey []byte `json:"publicKey,omitempty"`
+	// Signature is the ASN.1 encoded ECDSA signature or the Ed25519
+	// signature of the digest
+	Signature []byte `json:"signature,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// DaemonSigner is a crypto.Signer whose private key is held by a local
+// signing daemon listening on a unix socket.
+type DaemonSigner struct {
+	path      string
+	publicKey crypto.PublicKey
+}
+
+// NewDaemonSigner returns a signer using the daemon listening on the unix
+// socket path. The public key is retrieved from the daemon.
+func NewDaemonSigner(path string) (*DaemonSigner, error) {
+
+	d := &DaemonSigner{path: path}
+
+	resp, err := d.call(&Request{Method: methodPublicKey})
+	if err != nil {
+		return nil, err
+	}
+
+	if d.publicKey, err = x509.ParsePKIXPublicKey(resp.PublicKey); err != nil {
+		return nil, fmt.Errorf("invalid public key from signing daemon: %s", err)
+	}
+
+	return d, nil
+}
+
+// Path returns the path of the socket of the daemon.
+func (d *DaemonSigner) Path() string {
+	return d.path
+}
+
+// Public implements the crypto.Signer interface
+func (d *DaemonSigner) Public() crypto.PublicKey {
+	return d.publicKey
+}
+
+// Sign implements the crypto.Signer interface. Only the SHA-256 and SHA-384
+// digests and the Ed25519 signatures are supported.
+func (d *DaemonSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+
+	if err := checkDigest(digest, opts.HashFunc()); err != nil {
+		return nil, err
+	}
+
+	resp, err := d.call(&Request{Method: methodSign, Digest: digest, Hash: opts.HashFunc()})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Signature, nil
+}
+
+// call sends a request to the daemon and returns its response.
+func (d *DaemonSigner) call(req *Request) (*Response, error) {
+
+	conn, err := net.DialTimeout("unix", d.path, daemonTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to signing daemon: %s", err)
+	}
+	defer conn.Close() // nolint
+
+	if err = conn.SetDeadline(time.Now().Add(daemonTimeout)); err != nil {
+		return nil, err
+	}
+
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("unable to send request to signing daemon: %s", err)
+	}
+
+	resp := &Response{}
+	if err = json.NewDecoder(conn).Decode(resp); err != nil {
+		return nil, fmt.Errorf("invalid response from signing daemon: %s", err)
+	}
+
+	if resp.Error != "" {
+		return nil, fmt.Errorf("signing daemon: %s", resp.Error)
+	}
+
+	return resp, nil
+}
+
+// Serve is a reference implementation of the signing daemon. It answers the
+// requests received on the listener with the key of the signer until the
+// context is cancelled.
+func Serve(ctx context.Context, l net.Listener, key crypto.Signer) error {
+
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return fmt.Errorf("unable to encode public key: %s", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		l.Close() // nolint
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+				return err
+			}
+		}
+
+		go serveRequest(conn, key, publicKey)
+	}
+}
+
+// serveRequest answers the request of a connection.
+func serveRequest(conn net.Conn, key crypto.Signer, publicKey []byte) {
+
+	defer conn.Close() // nolint
+
+	if err := conn.SetDeadline(time.Now().Add(daemonTimeout)); err != nil {
+		return
+	}
+
+	req := &Request{}
+	resp := &Response{}
+
+	if err := json.NewDecoder(conn).Decode(req); err != nil {
+		resp.Error = fmt.Sprintf("invalid request: %s", err)
+	} else {
+		switch req.Method {
+		case methodPublicKey:
+			resp.PublicKey = publicKey
+		case methodSign:
+			if resp.Signature, err = signDigest(key, req.Digest, req.Hash); err != nil {
+				resp.Error = err.Error()
+			}
+		default:
+			resp.Error = fmt.Sprintf("unknown method: %s", req.Method)
+		}
+	}
+
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		zap.L().Debug("Unable to answer signing request", zap.Error(err))
+	}
+}
+
+// signDigest signs a digest.
+func signDigest(key crypto.Signer, digest []byte, hash crypto.Hash) ([]byte, error) {
+
+	if err := checkDigest(digest, hash); err != nil {
+		return nil, err
+	}
+
+	return key.Sign(rand.Reader, digest, hash)
+}
+
+// checkDigest returns an error if the hash is not supported or if the digest
+// is not of the size of the hash.
+func checkDigest(digest []byte, hash crypto.Hash) error {
+
+	switch hash {
+	case 0:
+		return nil
+	case crypto.SHA256, crypto.SHA384:
+		if len(digest) != hash.Size() {
+			return errors.New("invalid digest size")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported hash: %d", hash)
+	}
+}
@@ -0,0 +1,252 @@
+// Package signer signs the tokens with private keys that are not held in
+// process memory, such as keys held by an HSM or by a local signing agent. Any
+// crypto.Signer can be used as the encoding key of the secrets.
+//
+// The signing algorithm is chosen from the type of the key: ES256 for P-256
+// keys, ES384 for P-384 keys and EdDSA for Ed25519 keys. The algorithm is
+// declared in the tokens so that peers using different keys interoperate.
+package signer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+
+	// Register the hashes of the ECDSA algorithms
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// SigningMethodES256 signs the JWTs with ES256 using a crypto.Signer. The
+	// signatures are verified as the ones of jwt.SigningMethodES256.
+	SigningMethodES256 jwt.SigningMethod = &signingMethod{alg: "ES256", hash: crypto.SHA256, size: 64}
+
+	// SigningMethodES384 signs the JWTs with ES384 using a crypto.Signer. The
+	// signatures are verified as the ones of jwt.SigningMethodES384.
+	SigningMethodES384 jwt.SigningMethod = &signingMethod{alg: "ES384", hash: crypto.SHA384, size: 96}
+
+	// SigningMethodEdDSA signs the JWTs with Ed25519 using a crypto.Signer.
+	SigningMethodEdDSA jwt.SigningMethod = &signingMethod{alg: "EdDSA", size: ed25519.SignatureSize}
+)
+
+func init() {
+	// jwt-go does not implement EdDSA
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+// signingMethod is a JWS algorithm.
+type signingMethod struct {
+	alg string
+	// hash is the hash of the signed data. Ed25519 signs the data itself.
+	hash crypto.Hash
+	// size is the size of the signatures. ECDSA signatures are r and s on
+	// half of the size each.
+	size int
+}
+
+// Alg implements the jwt.SigningMethod interface
+func (m *signingMethod) Alg() string {
+	return m.alg
+}
+
+// Verify implements the jwt.SigningMethod interface
+func (m *signingMethod) Verify(signingString, signature string, key interface{}) error {
+
+	if method, err := methodFor(key); err != nil || method != m {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !Verify(key, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+
+	return nil
+}
+
+// Sign implements the jwt.SigningMethod interface
+func (m *signingMethod) Sign(signingString string, key interface{}) (string, error) {
+
+	s, ok := key.(crypto.Signer)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	if method, err := methodFor(s); err != nil || method != m {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	signature, err := Sign(s, []byte(signingString))
+	if err != nil {
+		return "", err
+	}
+
+	return jwt.EncodeSegment(signature), nil
+}
+
+// SigningMethod returns the signing method of the JWTs signed by a key. The key
+// can be a private key, a signer or a public key.
+func SigningMethod(key interface{}) (jwt.SigningMethod, error) {
+
+	m, err := methodFor(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// SignatureSize returns the size of the signatures of a key returned by Sign.
+func SignatureSize(key interface{}) (int, error) {
+
+	m, err := methodFor(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return m.size, nil
+}
+
+// Sign signs data with a P-256, P-384 or Ed25519 signer. ECDSA signatures are
+// returned as r and s on half of the signature size each, as in JWS.
+func Sign(s crypto.Signer, data []byte) ([]byte, error) {
+
+	m, err := methodFor(s)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ed25519 signs the data itself
+	if m.hash == 0 {
+		signature, err := s.Sign(rand.Reader, data, crypto.Hash(0))
+		if err != nil {
+			return nil, fmt.Errorf("unable to sign: %s", err)
+		}
+		return signature, nil
+	}
+
+	h := m.hash.New()
+	h.Write(data) // nolint
+
+	der, err := s.Sign(rand.Reader, h.Sum(nil), m.hash)
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign: %s", err)
+	}
+
+	return rawSignature(der, m.size)
+}
+
+// Verify verifies a signature returned by Sign with the public key of the signer.
+func Verify(key interface{}, data []byte, signature []byte) bool {
+
+	m, err := methodFor(key)
+	if err != nil || len(signature) != m.size {
+		return false
+	}
+
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		h := m.hash.New()
+		h.Write(data) // nolint
+		r := new(big.Int).SetBytes(signature[:m.size/2])
+		s := new(big.Int).SetBytes(signature[m.size/2:])
+		return ecdsa.Verify(k, h.Sum(nil), r, s)
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, data, signature)
+	default:
+		return false
+	}
+}
+
+// MatchesPublicKey returns an error if the public key of the signer is not
+// the given public key.
+func MatchesPublicKey(s crypto.Signer, key interface{}) error {
+
+	switch signerKey := s.Public().(type) {
+	case *ecdsa.PublicKey:
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("public key is not an ecdsa key")
+		}
+		if signerKey.Curve != publicKey.Curve || signerKey.X.Cmp(publicKey.X) != 0 || signerKey.Y.Cmp(publicKey.Y) != 0 {
+			return errors.New("signer does not match the public key")
+		}
+	case ed25519.PublicKey:
+		publicKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("public key is not an ed25519 key")
+		}
+		if !bytes.Equal(signerKey, publicKey) {
+			return errors.New("signer does not match the public key")
+		}
+	default:
+		return fmt.Errorf("unsupported signer key: %T", signerKey)
+	}
+
+	return nil
+}
+
+// methodFor returns the signing method of a private key, a signer or a public key.
+func methodFor(key interface{}) (*signingMethod, error) {
+
+	if s, ok := key.(crypto.Signer); ok {
+		key = s.Public()
+	}
+
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return SigningMethodES256.(*signingMethod), nil
+		case elliptic.P384():
+			return SigningMethodES384.(*signingMethod), nil
+		}
+		return nil, fmt.Errorf("unsupported curve: %s", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return SigningMethodEdDSA.(*signingMethod), nil
+	default:
+		return nil, fmt.Errorf("unsupported key: %T", key)
+	}
+}
+
+// rawSignature converts an ASN.1 ECDSA signature to r and s on half of the
+// signature size each.
+func rawSignature(der []byte, size int) ([]byte, error) {
+
+	var sig struct {
+		R *big.Int
+		S *big.Int
+	}
+
+	if rest, err := asn1.Unmarshal(der, &sig); err != nil || len(rest) > 0 {
+		return nil, errors.New("invalid ecdsa signature")
+	}
+
+	rBytes := sig.R.Bytes()
+	sBytes := sig.S.Bytes()
+	if len(rBytes) > size/2 || len(sBytes) > size/2 {
+		return nil, errors.New("invalid ecdsa signature size")
+	}
+
+	signature := make([]byte, size)
+	copy(signature[size/2-len(rBytes):], rBytes)
+	copy(signature[size-len(sBytes):], sBytes)
+
+	return signature, nil
+}
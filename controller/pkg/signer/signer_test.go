@@ -0,0 +1,204 @@
+package signer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func startDaemon(key crypto.Signer) (string, func(), error) {
+
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		return "", nil, err
+	}
+
+	path := filepath.Join(dir, "signer.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		os.RemoveAll(dir) // nolint
+		return "", nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go Serve(ctx, l, key) // nolint
+
+	return path, func() {
+		cancel()
+		os.RemoveAll(dir) // nolint
+	}, nil
+}
+
+func TestDaemonSigner(t *testing.T) {
+
+	Convey("Given a signing daemon holding a key", t, func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+		path, stop, err := startDaemon(key)
+		So(err, ShouldBeNil)
+		defer stop()
+
+		Convey("When I create a daemon signer", func() {
+			s, err := NewDaemonSigner(path)
+			So(err, ShouldBeNil)
+
+			Convey("Then it should have the public key of the daemon", func() {
+				So(s.Path(), ShouldEqual, path)
+				So(MatchesPublicKey(s, &key.PublicKey), ShouldBeNil)
+			})
+
+			Convey("Then the signatures should be verified with the public key", func() {
+				signature, err := Sign(s, []byte("data"))
+				So(err, ShouldBeNil)
+				So(len(signature), ShouldEqual, 64)
+				So(Verify(&key.PublicKey, []byte("data"), signature), ShouldBeTrue)
+				So(Verify(&key.PublicKey, []byte("other"), signature), ShouldBeFalse)
+			})
+
+			Convey("Then it should sign JWTs verified as ES256", func() {
+				token, err := jwt.NewWithClaims(SigningMethodES256, jwt.StandardClaims{Issuer: "server"}).SignedString(s)
+				So(err, ShouldBeNil)
+
+				claims := &jwt.StandardClaims{}
+				_, err = jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+					So(token.Method.Alg(), ShouldEqual, "ES256")
+					return &key.PublicKey, nil
+				})
+				So(err, ShouldBeNil)
+				So(claims.Issuer, ShouldEqual, "server")
+			})
+
+			Convey("Then it should not match another key", func() {
+				other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+				So(err, ShouldBeNil)
+				So(MatchesPublicKey(s, &other.PublicKey), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I create a daemon signer on an invalid socket, it should fail", func() {
+			_, err := NewDaemonSigner(path + ".invalid")
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a key in memory", t, func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+
+		Convey("Then the JWTs should be signed as with jwt.SigningMethodES256", func() {
+			token, err := jwt.NewWithClaims(SigningMethodES256, jwt.StandardClaims{}).SignedString(key)
+			So(err, ShouldBeNil)
+			_, err = jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+				return &key.PublicKey, nil
+			})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Then it should not sign with an invalid key", func() {
+			_, err := jwt.NewWithClaims(SigningMethodES256, jwt.StandardClaims{}).SignedString([]byte("key"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestSigningMethods(t *testing.T) {
+
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, ed, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name string
+		key  crypto.Signer
+		alg  string
+		size int
+	}{
+		{name: "P-256", key: p256, alg: "ES256", size: 64},
+		{name: "P-384", key: p384, alg: "ES384", size: 96},
+		{name: "Ed25519", key: ed, alg: "EdDSA", size: 64},
+	}
+
+	for _, tt := range tests {
+		Convey("Given a signing daemon holding a "+tt.name+" key", t, func() {
+			path, stop, err := startDaemon(tt.key)
+			So(err, ShouldBeNil)
+			defer stop()
+
+			s, err := NewDaemonSigner(path)
+			So(err, ShouldBeNil)
+
+			Convey("Then the signing method should be chosen from the key", func() {
+				method, err := SigningMethod(s)
+				So(err, ShouldBeNil)
+				So(method.Alg(), ShouldEqual, tt.alg)
+
+				method, err = SigningMethod(tt.key.Public())
+				So(err, ShouldBeNil)
+				So(method.Alg(), ShouldEqual, tt.alg)
+
+				size, err := SignatureSize(s)
+				So(err, ShouldBeNil)
+				So(size, ShouldEqual, tt.size)
+			})
+
+			Convey("Then the signatures should be verified with the public key", func() {
+				signature, err := Sign(s, []byte("data"))
+				So(err, ShouldBeNil)
+				So(len(signature), ShouldEqual, tt.size)
+				So(Verify(tt.key.Public(), []byte("data"), signature), ShouldBeTrue)
+				So(Verify(tt.key.Public(), []byte("other"), signature), ShouldBeFalse)
+			})
+
+			Convey("Then the JWTs should declare and verify the algorithm", func() {
+				method, err := SigningMethod(s)
+				So(err, ShouldBeNil)
+				token, err := jwt.NewWithClaims(method, jwt.StandardClaims{}).SignedString(s)
+				So(err, ShouldBeNil)
+
+				_, err = jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+					So(token.Method.Alg(), ShouldEqual, tt.alg)
+					return tt.key.Public(), nil
+				})
+				So(err, ShouldBeNil)
+			})
+		})
+	}
+
+	Convey("Given keys that do not match the signing method", t, func() {
+		Convey("Then the JWTs should not be signed", func() {
+			_, err := jwt.NewWithClaims(SigningMethodES256, jwt.StandardClaims{}).SignedString(p384)
+			So(err, ShouldNotBeNil)
+			_, err = jwt.NewWithClaims(SigningMethodEdDSA, jwt.StandardClaims{}).SignedString(p256)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then the JWTs should not be verified", func() {
+			token, err := jwt.NewWithClaims(SigningMethodEdDSA, jwt.StandardClaims{}).SignedString(ed)
+			So(err, ShouldBeNil)
+			_, err = jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+				return &p256.PublicKey, nil
+			})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then unsupported curves should be rejected", func() {
+			p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+			So(err, ShouldBeNil)
+			_, err = SigningMethod(p224)
+			So(err, ShouldNotBeNil)
+			_, err = Sign(p224, []byte("data"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,21 @@
+package tokens
+
+import "github.com/aporeto-inc/trireme-lib/policy"
+
+// addAttestation adds the attested facts of the claims to their tags, so that
+// policies can select the remotes by their node. Any node tag provided by the
+// sender in its tags is removed, since only the facts of the attestation can
+// be trusted.
+func addAttestation(claims *ConnectionClaims) {
+
+	facts := [][2]string{}
+	if a := claims.A; a != nil {
+		facts = append(facts,
+			[2]string{policy.NodeKernelKey, a.KernelVersion},
+			[2]string{policy.NodeEnforcerKey, a.EnforcerHash},
+			[2]string{policy.NodeBootIDKey, a.BootID},
+		)
+	}
+
+	replaceTags(claims, policy.NodeAttestationPrefix, facts...)
+}
@@ -0,0 +1,114 @@
+package tokens
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/lookup"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAttestation(t *testing.T) {
+
+	attestation := &policy.Attestation{
+		KernelVersion: "4.15.0-45-generic",
+		EnforcerHash:  "bf7e66d7bbd0465cfcba5b1cf68a9b59",
+		BootID:        "6b4c0c1e-4f8b-4bd4-9c5f-e2a7fd1c3c0e",
+	}
+
+	s := secrets.NewPSKSecrets(psk)
+	j, _ := NewJWT(validity, "TRIREME", s)
+	c, _ := NewCustomToken(validity, "TRIREME", s)
+
+	engines := []struct {
+		name   string
+		engine TokenEngine
+	}{
+		{name: "JWT", engine: j},
+		{name: "custom", engine: c},
+	}
+
+	for _, e := range engines {
+		Convey("Given a "+e.name+" token engine", t, func() {
+
+			Convey("When I create a token with an attestation", func() {
+				spoofed := policy.NewTagStoreFromMap(map[string]string{"label1": "value1", policy.NodeKernelKey: "5.0.0"})
+				token, _, err := e.engine.CreateAndSign(false, &ConnectionClaims{T: spoofed, RMT: []byte(rmt), A: attestation})
+				So(err, ShouldBeNil)
+
+				recovered, _, _, err := e.engine.Decode(false, token, nil)
+				So(err, ShouldBeNil)
+
+				Convey("Then the facts of the node should be added to the tags", func() {
+					So(recovered.A, ShouldResemble, attestation)
+					value, ok := recovered.T.Get(policy.NodeEnforcerKey)
+					So(ok, ShouldBeTrue)
+					So(value, ShouldEqual, attestation.EnforcerHash)
+					value, ok = recovered.T.Get(policy.NodeBootIDKey)
+					So(ok, ShouldBeTrue)
+					So(value, ShouldEqual, attestation.BootID)
+					value, ok = recovered.T.Get("label1")
+					So(ok, ShouldBeTrue)
+					So(value, ShouldEqual, "value1")
+				})
+
+				Convey("Then the node tags of the sender should be replaced by the attested ones", func() {
+					kernels := 0
+					for _, tag := range recovered.T.Tags {
+						if tag == policy.NodeKernelKey+"=5.0.0" {
+							kernels++
+						}
+					}
+					So(kernels, ShouldEqual, 0)
+					value, _ := recovered.T.Get(policy.NodeKernelKey)
+					So(value, ShouldEqual, attestation.KernelVersion)
+				})
+
+				Convey("Then policies should select the token by the facts of the node", func() {
+					patched := lookup.NewPolicyDB()
+					patched.AddPolicy(policy.TagSelector{
+						Clause: []policy.KeyValueOperator{
+							{Key: policy.NodeKernelKey, Value: []string{"4.15*"}, Operator: policy.Equal},
+							{Key: policy.NodeEnforcerKey, Value: []string{attestation.EnforcerHash}, Operator: policy.Equal},
+						},
+						Policy: &policy.FlowPolicy{Action: policy.Accept},
+					})
+					index, _ := patched.Search(recovered.T)
+					So(index, ShouldBeGreaterThanOrEqualTo, 0)
+
+					unpatched := lookup.NewPolicyDB()
+					unpatched.AddPolicy(policy.TagSelector{
+						Clause: []policy.KeyValueOperator{
+							{Key: policy.NodeEnforcerKey, Value: []string{"0123456789abcdef0123456789abcdef"}, Operator: policy.Equal},
+						},
+						Policy: &policy.FlowPolicy{Action: policy.Accept},
+					})
+					index, _ = unpatched.Search(recovered.T)
+					So(index, ShouldEqual, -1)
+				})
+			})
+
+			Convey("When I create a token without an attestation", func() {
+				spoofed := policy.NewTagStoreFromMap(map[string]string{"label1": "value1", policy.NodeEnforcerKey: attestation.EnforcerHash})
+				token, _, err := e.engine.CreateAndSign(false, &ConnectionClaims{T: spoofed, RMT: []byte(rmt)})
+				So(err, ShouldBeNil)
+
+				Convey("Then the node tags provided by the sender should be removed", func() {
+					recovered, _, _, err := e.engine.Decode(false, token, nil)
+					So(err, ShouldBeNil)
+					So(recovered.A, ShouldBeNil)
+					_, ok := recovered.T.Get(policy.NodeEnforcerKey)
+					So(ok, ShouldBeFalse)
+					So(len(recovered.T.Tags), ShouldEqual, 1)
+				})
+			})
+
+			Convey("Then the attestation should not change the size of the Ack tokens", func() {
+				ack, _, err := e.engine.CreateAndSign(true, &ConnectionClaims{RMT: []byte(rmt), LCL: []byte(rmt), A: attestation})
+				So(err, ShouldBeNil)
+				So(len(ack), ShouldEqual, e.engine.AckSize())
+			})
+		})
+	}
+}
@@ -2,142 +2,460 @@ package tokens
 
 import (
 	"bytes"
-	"crypto/ecdsa"
+	gocrypto "crypto"
 	"crypto/hmac"
+	"crypto/sha256"
 	"crypto/x509"
-	"strings"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/revocation"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/signer"
 	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/cache"
 	"github.com/aporeto-inc/trireme-lib/utils/crypto"
 )
 
-// CustomTokenSignMethod describes the sign methods for the custome tokens
-type CustomTokenSignMethod int
-
 const (
-	// PreSharedKey defines a pre-shared key implementation
-	PreSharedKey CustomTokenSignMethod = iota
-	// PKI defines a public/private key implementation
-	PKI
+	// customTokenVersion is the version of the format of the custom tokens
+	customTokenVersion = 2
+	// expiryLength is the length of the expiration time of the custom tokens
+	expiryLength = 8
+	// customClaimFields is the number of fields of the custom tokens prefixed
+	// by their length: the issuer, RMT, LCL and EK
+	customClaimFields = 4
+	// maxFieldLength is the maximum length of the fields of the custom tokens
+	maxFieldLength = 255
+	// plainTagsVersion is the first byte of the tags of the custom tokens
+	// that are not encoded by a dictionary
+	plainTagsVersion = 0x00
+	// attestationFields is the number of fields of the attestation of the
+	// Syn and SynAck tokens: the kernel version, enforcer hash and boot ID
+	attestationFields = 3
 )
 
-const (
-	lclIndex         = 64
-	rmtIndex         = 96
-	minBufferLength  = 128
-	sizeOfRandom     = 32
-	sizeOfMessageMac = 32
-)
-
-// CustomTokenConfig configures the custom token generator with the standard parameters
+// CustomTokenConfig configures the custom token generator with the standard
+// parameters. Custom tokens are a binary encoding of the claims that is smaller
+// and faster to parse than the JWTs. The Syn and SynAck tokens are laid out as
+// the JWT ones:
+//
+//	length of the signed token (2 bytes) | nonce | signed token | transmitted key
+//
+// and the Ack tokens are the signed token only. The signed token is:
+//
+//	length of the signature (1 byte) | signature | version (1 byte) |
+//	expiration time (8 bytes) | issuer | RMT | LCL | EK | attestation |
+//	nonce | tags
+//
+// where the issuer, RMT, LCL, EK, the fields of the attestation and the nonce
+// are prefixed by their length (1 byte). The Ack tokens carry neither the
+// attestation, the nonce nor the tags. The signature covers everything that
+// follows it, so the nonce of the Syn and SynAck tokens is signed.
 type CustomTokenConfig struct {
-
 	// ValidityPeriod for the signed token
 	ValidityPeriod time.Duration
-
 	// Issuer is the server that signs the request
 	Issuer string
+	// Dictionary encodes the tags when it is not nil
+	Dictionary *TagDictionary
+	// Clock returns the time at which the tokens are issued and validated.
+	// The current time is used when it is nil
+	Clock func() time.Time
+	// secrets is the secrets used for signing and verifying the tokens
+	secrets secrets.Secrets
+	// tokenCache caches the claims of the verified tokens
+	tokenCache cache.DataStore
+}
 
-	// SignMethod is the method to use for signing the labels
-	SignMethod CustomTokenSignMethod
+// NewCustomToken creates a new token generator for custom tokens signed with
+// the secrets.
+func NewCustomToken(validity time.Duration, issuer string, s secrets.Secrets) (*CustomTokenConfig, error) {
+
+	if len(issuer) > MaxServerName {
+		return nil, fmt.Errorf("server id should be max %d chars. got %s", MaxServerName, issuer)
+	}
 
-	// Key is an interface for either the Private Key or the Preshared Key
-	Key interface{}
-	// CA is the certificate of the CA that has signed the server keys
-	CA *x509.Certificate
-	// Cert is the certificate of the server
-	Cert *x509.Certificate
-	// CertPEM is a buffer of the PEM file that is send to other servers - Cached for efficiency
-	CertPEM []byte
-	// IncludeCert instructs the engine to transmit the certificate with each token
-	IncludeCert bool
-	// CertPool is pool of certificates that are already distributed out of band
-	PublicKeyCache map[string]*ecdsa.PublicKey
+	if s == nil {
+		return nil, errors.New("secrets can not be nil")
+	}
+
+	return &CustomTokenConfig{
+		ValidityPeriod: validity,
+		Issuer:         issuer,
+		secrets:        s,
+		tokenCache:     cache.NewCacheWithExpiration("CustomTokenCache", time.Millisecond*500),
+	}, nil
 }
 
-// NewPSKCustomToken creates a new token generator for custom tokens
+// NewPSKCustomToken creates a new token generator for custom tokens signed with
+// a pre-shared key
 func NewPSKCustomToken(validity time.Duration, issuer string, psk []byte) *CustomTokenConfig {
 	return &CustomTokenConfig{
 		ValidityPeriod: validity,
 		Issuer:         issuer,
-		SignMethod:     PreSharedKey,
-		Key:            psk,
+		secrets:        secrets.NewPSKSecrets(psk),
+		tokenCache:     cache.NewCacheWithExpiration("CustomTokenCache", time.Millisecond*500),
 	}
 }
 
-// CreateAndSign  creates a buffer for a new custom token and signs the token. Format
-// is Signature, Random Local, Random Remote, Tags separated by the spaces
-func (c *CustomTokenConfig) CreateAndSign(isAck bool, claims *ConnectionClaims) []byte {
+// CreateAndSign creates a new token and signs it with the key of the secrets.
+// The Syn and SynAck tokens carry a random nonce and the transmitted key.
+func (c *CustomTokenConfig) CreateAndSign(isAck bool, claims *ConnectionClaims) (token []byte, nonce []byte, err error) {
 
-	buffer := make([]byte, minBufferLength)
+	if !isAck {
+		if claims, err = withNonce(claims); err != nil {
+			return []byte{}, []byte{}, err
+		}
+	}
+
+	data, err := c.encodeClaims(isAck, claims)
+	if err != nil {
+		return []byte{}, []byte{}, err
+	}
+
+	signature, err := c.sign(data)
+	if err != nil {
+		return []byte{}, []byte{}, err
+	}
+
+	signed := make([]byte, 0, 1+len(signature)+len(data))
+	signed = append(signed, byte(len(signature)))
+	signed = append(signed, signature...)
+	signed = append(signed, data...)
+
+	if isAck {
+		return signed, []byte{}, nil
+	}
+
+	if len(signed) > 0xffff {
+		return []byte{}, []byte{}, fmt.Errorf("token too large: %d bytes", len(signed))
+	}
+
+	nonce = claims.N
+
+	txKey := c.secrets.TransmittedKey()
+
+	token = make([]byte, tokenPosition+len(signed)+len(txKey))
+	binary.BigEndian.PutUint16(token[0:noncePosition], uint16(len(signed)))
+	copy(token[noncePosition:], nonce)
+	copy(token[tokenPosition:], signed)
+	copy(token[tokenPosition+len(signed):], txKey)
+
+	return token, nonce, nil
+}
 
-	// Copy the random part
-	//  copy(buffer[lclIndex:lclIndex+sizeOfRandom], claims.LCL)
-	copy(buffer[rmtIndex:rmtIndex+sizeOfRandom], claims.RMT)
+// Decode verifies a token and returns its claims. The Syn and SynAck tokens
+// are verified with the transmitted key of the sender and the Ack tokens with
+// the key received in the previous tokens.
+func (c *CustomTokenConfig) Decode(isAck bool, data []byte, previousCert interface{}) (claims *ConnectionClaims, nonce []byte, publicKey interface{}, err error) {
+
+	var ackCert interface{}
+	var cacheKey string
+
+	token := data
+
+	nonce = make([]byte, NonceLength)
 
-	// If not an ACK packet copy the tags
 	if !isAck {
-		for _, kv := range claims.T.GetSlice() {
-			tag := []byte(kv + " ")
-			buffer = append(buffer, tag...)
+
+		if len(data) < tokenPosition {
+			return nil, nil, nil, errors.New("not enough data")
+		}
+
+		tokenLength := int(binary.BigEndian.Uint16(data[0:noncePosition]))
+		if len(data) < tokenPosition+tokenLength {
+			return nil, nil, nil, errors.New("invalid token length")
+		}
+
+		copy(nonce, data[noncePosition:tokenPosition])
+
+		token = data[tokenPosition : tokenPosition+tokenLength]
+
+		ackCert, err = c.secrets.VerifyPublicKey(data[tokenPosition+tokenLength:])
+		if err != nil {
+			if revocation.IsRevoked(err) {
+				return nil, nil, nil, err
+			}
+			return nil, nil, nil, fmt.Errorf("invalid public key: %s", err)
+		}
+
+		// The claims carry the SPIFFE ID of the transmitted key. The cache is
+		// keyed on both the token and the key.
+		cacheKey = string(data[tokenPosition:])
+
+		if cachedClaims, cerr := c.tokenCache.Get(cacheKey); cerr == nil {
+			if err := checkNonce(cachedClaims.(*ConnectionClaims), nonce); err != nil {
+				return nil, nil, nil, err
+			}
+			return cachedClaims.(*ConnectionClaims), nonce, ackCert, nil
 		}
 	}
 
-	// Sign the buffer
-	signature, err := crypto.ComputeHmac256(buffer[lclIndex:], c.Key.([]byte))
+	claims, err = c.verify(isAck, token, ackCert, previousCert)
 	if err != nil {
-		return []byte{}
+		return nil, nil, nil, err
+	}
+
+	if c.secrets.Type() == secrets.SPIFFEType && ackCert != nil {
+		if err := addSPIFFEID(claims, ackCert.(*x509.Certificate)); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if !isAck {
+		if err := checkNonce(claims, nonce); err != nil {
+			return nil, nil, nil, err
+		}
+		addAttestation(claims)
+		c.tokenCache.AddOrUpdate(cacheKey, claims)
+	}
+
+	return claims, nonce, ackCert, nil
+}
+
+// RetrieveNonce returns the nonce of a token. It copies the value
+func (c *CustomTokenConfig) RetrieveNonce(token []byte) ([]byte, error) {
+	return retrieveNonce(token)
+}
+
+// AckSize returns the size of the Ack tokens. They carry the nonces of both
+// ends and no tags.
+func (c *CustomTokenConfig) AckSize() uint32 {
+	return uint32(1 + c.signatureSize() + 1 + expiryLength + customClaimFields + len(c.Issuer) + 2*NonceLength)
+}
+
+// now returns the current time of the clock of the configuration.
+func (c *CustomTokenConfig) now() time.Time {
+
+	if c.Clock != nil {
+		return c.Clock()
 	}
 
-	// Add the signature as the first part of the buffer
-	copy(buffer[0:], signature)
+	return time.Now()
+}
+
+// encodeClaims returns the signed part of a token without its signature.
+func (c *CustomTokenConfig) encodeClaims(isAck bool, claims *ConnectionClaims) ([]byte, error) {
 
-	// Return the buffer
-	return buffer
+	data := make([]byte, 1+expiryLength)
+	data[0] = customTokenVersion
+	binary.BigEndian.PutUint64(data[1:], uint64(c.now().Add(c.ValidityPeriod).Unix()))
 
+	for _, field := range [][]byte{[]byte(c.Issuer), claims.RMT, claims.LCL, claims.EK} {
+		if len(field) > maxFieldLength {
+			return nil, fmt.Errorf("claim too large: %d bytes", len(field))
+		}
+		data = append(data, byte(len(field)))
+		data = append(data, field...)
+	}
+
+	if !isAck {
+		a := claims.A
+		if a == nil {
+			a = &policy.Attestation{}
+		}
+		for _, field := range []string{a.KernelVersion, a.EnforcerHash, a.BootID} {
+			if len(field) > maxFieldLength {
+				return nil, fmt.Errorf("attestation too large: %d bytes", len(field))
+			}
+			data = append(data, byte(len(field)))
+			data = append(data, field...)
+		}
+		data = append(data, byte(len(claims.N)))
+		data = append(data, claims.N...)
+		data = append(data, c.encodeTags(claims.T)...)
+	}
+
+	return data, nil
 }
 
-// Decode decodes a string into the data structures for a custom token
-func (c *CustomTokenConfig) Decode(isAck bool, data []byte, previousCert interface{}) (*ConnectionClaims, interface{}) {
-	claims := &ConnectionClaims{}
+// verify verifies the signature of a signed token and returns its claims.
+func (c *CustomTokenConfig) verify(isAck bool, token []byte, ackCert interface{}, previousCert interface{}) (*ConnectionClaims, error) {
+
+	if len(token) < 1 || len(token) < 1+int(token[0])+1+expiryLength {
+		return nil, errors.New("token too small")
+	}
+
+	signature := token[1 : 1+int(token[0])]
+	data := token[1+int(token[0]):]
 
-	if len(data) < minBufferLength {
-		return nil, nil
+	if data[0] != customTokenVersion {
+		return nil, fmt.Errorf("unsupported token version: %d", data[0])
 	}
 
-	messageMac := data[:sizeOfMessageMac]
-	expectedMac, err := crypto.ComputeHmac256(data[lclIndex:], c.Key.([]byte))
+	if expiry := int64(binary.BigEndian.Uint64(data[1 : 1+expiryLength])); c.now().Unix() > expiry {
+		return nil, errors.New("token expired")
+	}
+
+	r := bytes.NewReader(data[1+expiryLength:])
+	fields, err := readFields(r, customClaimFields)
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
 
-	if !hmac.Equal(messageMac, expectedMac) {
-		return nil, nil
+	key, err := c.secrets.DecodingKey(string(fields[0]), ackCert, previousCert)
+	if err != nil {
+		return nil, err
 	}
 
-	// claims.LCL = data[lclIndex : lclIndex+sizeOfRandom]
-	claims.RMT = data[rmtIndex : rmtIndex+sizeOfRandom]
+	if !c.verifySignature(key, data, signature) {
+		return nil, errors.New("invalid signature")
+	}
+
+	claims := &ConnectionClaims{
+		RMT: fields[1],
+		LCL: fields[2],
+		EK:  fields[3],
+	}
 
 	if !isAck {
-		claims.T = policy.NewTagStore()
-		buffer := bytes.NewBuffer(data[minBufferLength:])
-		for {
-			tag, err := buffer.ReadBytes([]byte(" ")[0])
-
-			if err == nil {
-				values := strings.Split(string(tag[:len(tag)-1]), "=")
-				if len(values) != 2 {
-					continue
-				}
-
-				claims.T.AppendKeyValue(values[0], values[1])
-				continue
+		attestation, err := readFields(r, attestationFields)
+		if err != nil {
+			return nil, err
+		}
+		if len(attestation[0])+len(attestation[1])+len(attestation[2]) > 0 {
+			claims.A = &policy.Attestation{
+				KernelVersion: string(attestation[0]),
+				EnforcerHash:  string(attestation[1]),
+				BootID:        string(attestation[2]),
 			}
+		}
 
-			break
+		n, err := readFields(r, 1)
+		if err != nil {
+			return nil, err
 		}
+		claims.N = n[0]
+
+		t, err := c.decodeTags(data[len(data)-r.Len():])
+		if err != nil {
+			return nil, fmt.Errorf("invalid tags: %s", err)
+		}
+		claims.T = t
 	}
 
 	return claims, nil
 }
+
+// readFields reads the given number of fields prefixed by their length.
+func readFields(r *bytes.Reader, count int) ([][]byte, error) {
+
+	fields := make([][]byte, count)
+	for i := range fields {
+		size, err := r.ReadByte()
+		if err != nil || int(size) > r.Len() {
+			return nil, errors.New("invalid claim length")
+		}
+		if size > 0 {
+			fields[i] = make([]byte, size)
+			r.Read(fields[i]) // nolint
+		}
+	}
+
+	return fields, nil
+}
+
+// encodeTags encodes the tags with the dictionary or as a list of tags
+// prefixed by their length.
+func (c *CustomTokenConfig) encodeTags(t *policy.TagStore) []byte {
+
+	if c.Dictionary != nil {
+		return c.Dictionary.Encode(t)
+	}
+
+	tags := []string{}
+	if t != nil {
+		tags = t.Tags
+	}
+
+	return appendTags([]byte{plainTagsVersion}, tags)
+}
+
+// decodeTags decodes the tags returned by encodeTags.
+func (c *CustomTokenConfig) decodeTags(data []byte) (*policy.TagStore, error) {
+
+	if isCompactTags(data) {
+		if c.Dictionary == nil {
+			return nil, errors.New("compact tags received without a tag dictionary")
+		}
+		return c.Dictionary.Decode(data)
+	}
+
+	if len(data) == 0 || data[0] != plainTagsVersion {
+		return nil, errors.New("unknown tags encoding")
+	}
+
+	t := policy.NewTagStore()
+	if err := readTags(bytes.NewReader(data[1:]), t); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// sign signs the data with the key of the secrets. Tokens are not signed
+// with the null secrets.
+func (c *CustomTokenConfig) sign(data []byte) ([]byte, error) {
+
+	switch c.secrets.Type() {
+	case secrets.PKINull:
+		return []byte{}, nil
+	case secrets.PSKType:
+		psk, ok := c.secrets.EncodingKey().([]byte)
+		if !ok {
+			return nil, errors.New("invalid pre-shared key")
+		}
+		return crypto.ComputeHmac256(data, psk)
+	default:
+		s, ok := c.secrets.EncodingKey().(gocrypto.Signer)
+		if !ok {
+			return nil, errors.New("invalid signer")
+		}
+		return signer.Sign(s, data)
+	}
+}
+
+// verifySignature verifies the signature of the data with the key returned by
+// the secrets for the sender.
+func (c *CustomTokenConfig) verifySignature(key interface{}, data []byte, signature []byte) bool {
+
+	switch c.secrets.Type() {
+	case secrets.PKINull:
+		return len(signature) == 0
+	case secrets.PSKType:
+		psk, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		expected, err := crypto.ComputeHmac256(data, psk)
+		if err != nil {
+			return false
+		}
+		return hmac.Equal(signature, expected)
+	default:
+		if cert, ok := key.(*x509.Certificate); ok {
+			key = cert.PublicKey
+		}
+		return signer.Verify(key, data, signature)
+	}
+}
+
+// signatureSize returns the size of the signatures of the secrets.
+func (c *CustomTokenConfig) signatureSize() int {
+
+	switch c.secrets.Type() {
+	case secrets.PKINull:
+		return 0
+	case secrets.PSKType:
+		return sha256.Size
+	default:
+		size, err := signer.SignatureSize(c.secrets.EncodingKey())
+		if err != nil {
+			return 0
+		}
+		return size
+	}
+}
@@ -0,0 +1,275 @@
+package tokens
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"math/big"
+	mathrand "math/rand"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newTestAuthority returns a self signed authority for the tests.
+func newTestAuthority() (*x509.Certificate, *ecdsa.PrivateKey) {
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "authority"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	ca, _ := x509.ParseCertificate(caDER)
+
+	return ca, caKey
+}
+
+func TestConstructorNewCustomToken(t *testing.T) {
+
+	Convey("Given that I instantiate a custom token engine with a server name that is too long, it should fail", t, func() {
+		_, err := NewCustomToken(validity, "0123456789012345678901234567890123456789", secrets.NewPSKSecrets(psk))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Given that I instantiate a custom token engine with nil secrets, it should fail", t, func() {
+		_, err := NewCustomToken(validity, "TRIREME", nil)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Given that I instantiate a custom token engine with shared secrets, it should succeed", t, func() {
+		c, err := NewCustomToken(validity, "TRIREME", secrets.NewPSKSecrets(psk))
+		So(err, ShouldBeNil)
+		So(c.Issuer, ShouldEqual, "TRIREME")
+		So(c.ValidityPeriod, ShouldEqual, validity)
+	})
+}
+
+func TestCustomTokenCreateAndVerify(t *testing.T) {
+
+	ca, caKey := newTestAuthority()
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, ed, _ := ed25519.GenerateKey(rand.Reader)
+
+	clientSecrets, err := newKeyTypeSecrets(ca, caKey, p256, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverSecrets, err := newKeyTypeSecrets(ca, caKey, ed, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engines := []struct {
+		name   string
+		client secrets.Secrets
+		server secrets.Secrets
+	}{
+		{name: "pre-shared key", client: secrets.NewPSKSecrets(psk), server: secrets.NewPSKSecrets(psk)},
+		{name: "PKI", client: clientSecrets, server: serverSecrets},
+	}
+
+	for _, e := range engines {
+		Convey("Given custom token engines with "+e.name+" secrets", t, func() {
+			client, err := NewCustomToken(validity, "CLIENT", e.client)
+			So(err, ShouldBeNil)
+			server, err := NewCustomToken(validity, "SERVER", e.server)
+			So(err, ShouldBeNil)
+
+			claims := &ConnectionClaims{T: tags, RMT: []byte(rmt), EK: []byte("ephemeral key")}
+
+			Convey("When I create a Syn token", func() {
+				token, nonce, err := client.CreateAndSign(false, claims)
+				So(err, ShouldBeNil)
+				So(len(nonce), ShouldEqual, NonceLength)
+
+				Convey("Then the receiver should recover the claims and the nonce", func() {
+					recovered, recoveredNonce, cert, err := server.Decode(false, token, nil)
+					So(err, ShouldBeNil)
+					So(recovered.T.Tags, ShouldResemble, tags.Tags)
+					So(recovered.RMT, ShouldResemble, []byte(rmt))
+					So(recovered.EK, ShouldResemble, []byte("ephemeral key"))
+					So(recoveredNonce, ShouldResemble, nonce)
+
+					Convey("Then the Ack token should be verified with the key of the Syn token", func() {
+						ack, _, err := client.CreateAndSign(true, &ConnectionClaims{RMT: []byte(rmt), LCL: []byte(rmt)})
+						So(err, ShouldBeNil)
+						So(len(ack), ShouldEqual, client.AckSize())
+
+						recoveredAck, _, _, err := server.Decode(true, ack, cert)
+						So(err, ShouldBeNil)
+						So(recoveredAck.T, ShouldBeNil)
+						So(recoveredAck.LCL, ShouldResemble, []byte(rmt))
+						So(recoveredAck.RMT, ShouldResemble, []byte(rmt))
+					})
+				})
+
+				Convey("Then a token with another nonce should be rejected", func() {
+					_, _, _, err := server.Decode(false, token, nil)
+					So(err, ShouldBeNil)
+
+					copy(token[noncePosition:], make([]byte, NonceLength))
+					_, _, _, err = server.Decode(false, token, nil)
+					So(err, ShouldNotBeNil)
+				})
+
+				Convey("Then a tampered token should be rejected", func() {
+					length := int(binary.BigEndian.Uint16(token[0:noncePosition]))
+					token[tokenPosition+length-1] ^= 0xff
+					_, _, _, err := server.Decode(false, token, nil)
+					So(err, ShouldNotBeNil)
+				})
+			})
+
+			Convey("Then expired tokens should be rejected", func() {
+				client.ValidityPeriod = -time.Minute
+				token, _, err := client.CreateAndSign(false, claims)
+				So(err, ShouldBeNil)
+				_, _, _, err = server.Decode(false, token, nil)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then claims that are too large should be rejected", func() {
+				_, _, err := client.CreateAndSign(false, &ConnectionClaims{T: tags, EK: make([]byte, maxFieldLength+1)})
+				So(err, ShouldNotBeNil)
+			})
+		})
+	}
+
+	Convey("Given custom token engines with null secrets", t, func() {
+		s, err := secrets.NewNullPKI([]byte(keyPEM), []byte(certPEM), []byte(caPool))
+		So(err, ShouldBeNil)
+		c, err := NewCustomToken(validity, "TRIREME", s)
+		So(err, ShouldBeNil)
+
+		Convey("Then the Ack tokens should not be signed", func() {
+			ack, _, err := c.CreateAndSign(true, &ConnectionClaims{RMT: []byte(rmt), LCL: []byte(rmt)})
+			So(err, ShouldBeNil)
+			So(ack[0], ShouldEqual, 0)
+			So(len(ack), ShouldEqual, c.AckSize())
+
+			recovered, _, _, err := c.Decode(true, ack, nil)
+			So(err, ShouldBeNil)
+			So(recovered.LCL, ShouldResemble, []byte(rmt))
+		})
+	})
+}
+
+func TestCustomTokenInterop(t *testing.T) {
+
+	Convey("Given a JWT engine and a custom token engine with the same secrets", t, func() {
+		s := secrets.NewPSKSecrets(psk)
+		j, err := NewJWT(validity, "TRIREME", s)
+		So(err, ShouldBeNil)
+		c, err := NewCustomToken(validity, "TRIREME", s)
+		So(err, ShouldBeNil)
+
+		claims := &ConnectionClaims{T: tags, RMT: []byte(rmt), EK: []byte("ephemeral key")}
+
+		Convey("Then both engines should recover the same claims", func() {
+			jwtToken, _, err := j.CreateAndSign(false, claims)
+			So(err, ShouldBeNil)
+			customToken, _, err := c.CreateAndSign(false, claims)
+			So(err, ShouldBeNil)
+
+			fromJWT, _, _, err := j.Decode(false, jwtToken, nil)
+			So(err, ShouldBeNil)
+			fromCustom, _, _, err := c.Decode(false, customToken, nil)
+			So(err, ShouldBeNil)
+
+			So(fromCustom.T.Tags, ShouldResemble, fromJWT.T.Tags)
+			So(fromCustom.RMT, ShouldResemble, fromJWT.RMT)
+			So(fromCustom.EK, ShouldResemble, fromJWT.EK)
+
+			Convey("Then the custom tokens should be smaller", func() {
+				So(len(customToken), ShouldBeLessThan, len(jwtToken))
+				So(c.AckSize(), ShouldBeLessThan, j.AckSize())
+			})
+		})
+
+		Convey("Then each engine should reject the tokens of the other one", func() {
+			for _, isAck := range []bool{false, true} {
+				jwtToken, _, err := j.CreateAndSign(isAck, &ackClaims)
+				So(err, ShouldBeNil)
+				customToken, _, err := c.CreateAndSign(isAck, &ackClaims)
+				So(err, ShouldBeNil)
+
+				_, _, _, err = c.Decode(isAck, jwtToken, nil)
+				So(err, ShouldNotBeNil)
+				_, _, _, err = j.Decode(isAck, customToken, nil)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestCustomTokenFuzz(t *testing.T) {
+
+	Convey("Given a JWT engine and a custom token engine", t, func() {
+		s := secrets.NewPSKSecrets(psk)
+		j, err := NewJWT(validity, "TRIREME", s)
+		So(err, ShouldBeNil)
+		c, err := NewCustomToken(validity, "TRIREME", s)
+		So(err, ShouldBeNil)
+
+		engines := []TokenEngine{j, c}
+
+		seeds := [][]byte{}
+		for _, e := range engines {
+			for _, isAck := range []bool{false, true} {
+				token, _, err := e.CreateAndSign(isAck, &ConnectionClaims{T: tags, RMT: []byte(rmt), LCL: []byte(lcl), EK: []byte{}})
+				So(err, ShouldBeNil)
+				seeds = append(seeds, token)
+			}
+		}
+
+		Convey("Then mutated, truncated and random tokens should be rejected without panicking", func() {
+			r := mathrand.New(mathrand.NewSource(1))
+
+			panics := 0
+			decodeAll := func(data []byte) {
+				for _, e := range engines {
+					for _, isAck := range []bool{false, true} {
+						func() {
+							defer func() {
+								if recover() != nil {
+									panics++
+								}
+							}()
+							e.Decode(isAck, data, nil) // nolint
+						}()
+					}
+				}
+			}
+
+			for i := 0; i < 200; i++ {
+				seed := seeds[r.Intn(len(seeds))]
+
+				mutated := append([]byte{}, seed...)
+				for n := r.Intn(4) + 1; n > 0; n-- {
+					mutated[r.Intn(len(mutated))] = byte(r.Intn(256))
+				}
+				decodeAll(mutated)
+
+				decodeAll(seed[:r.Intn(len(seed))])
+
+				random := make([]byte, r.Intn(512))
+				r.Read(random) // nolint
+				decodeAll(random)
+			}
+
+			So(panics, ShouldEqual, 0)
+		})
+	})
+}
@@ -1,15 +1,17 @@
 package tokens
 
 import (
+	"crypto/x509"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/revocation"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/signer"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
-	"github.com/aporeto-inc/trireme-lib/utils/crypto"
 
 	"github.com/dgrijalva/jwt-go"
 )
@@ -32,8 +34,18 @@ type JWTConfig struct {
 	ValidityPeriod time.Duration
 	// Issuer is the server that issues the JWT
 	Issuer string
-	// signMethod is the method used to sign the JWT
+	// Dictionary encodes the tags of the claims when it is not nil
+	Dictionary *TagDictionary
+	// Clock returns the time at which the tokens are issued and validated.
+	// The current time is used when it is nil
+	Clock func() time.Time
+	// signMethod is the method used to sign the JWT. For PKI secrets it is the
+	// method of their key when the configuration is created
 	signMethod jwt.SigningMethod
+	// keyMethod is true when the method is chosen from the type of the key
+	// for every token, since the key of PKI secrets can be rotated to a key
+	// of another type
+	keyMethod bool
 	// secrets is the secrets used for signing and verifying the JWT
 	secrets secrets.Secrets
 	// cache test
@@ -52,14 +64,19 @@ func NewJWT(validity time.Duration, issuer string, s secrets.Secrets) (*JWTConfi
 	}
 
 	var signMethod jwt.SigningMethod
+	var keyMethod bool
 
 	if s == nil {
 		return nil, errors.New("secrets can not be nil")
 	}
 
 	switch s.Type() {
-	case secrets.PKIType, secrets.PKICompactType:
-		signMethod = jwt.SigningMethodES256
+	case secrets.PKIType, secrets.PKICompactType, secrets.SPIFFEType:
+		var err error
+		if signMethod, err = signer.SigningMethod(s.EncodingKey()); err != nil {
+			return nil, fmt.Errorf("unsupported key: %s", err)
+		}
+		keyMethod = true
 	case secrets.PSKType:
 		signMethod = jwt.SigningMethodHS256
 	default:
@@ -70,6 +87,7 @@ func NewJWT(validity time.Duration, issuer string, s secrets.Secrets) (*JWTConfi
 		ValidityPeriod: validity,
 		Issuer:         issuer,
 		signMethod:     signMethod,
+		keyMethod:      keyMethod,
 		secrets:        s,
 		tokenCache:     cache.NewCacheWithExpiration("JWTTokenCache", time.Millisecond*500),
 	}, nil
@@ -79,17 +97,51 @@ func NewJWT(validity time.Duration, issuer string, s secrets.Secrets) (*JWTConfi
 // key. It also randomizes the source nonce of the token. It returns back the token and the private key.
 func (c *JWTConfig) CreateAndSign(isAck bool, claims *ConnectionClaims) (token []byte, nonce []byte, err error) {
 
+	if isAck && claims.A != nil {
+		// The attestation is only carried by the Syn and SynAck tokens
+		claims = &ConnectionClaims{
+			T:   claims.T,
+			RMT: claims.RMT,
+			LCL: claims.LCL,
+			EK:  claims.EK,
+		}
+	}
+
+	if c.Dictionary != nil && claims.T != nil {
+		claims = &ConnectionClaims{
+			CT:  c.Dictionary.Encode(claims.T),
+			RMT: claims.RMT,
+			LCL: claims.LCL,
+			EK:  claims.EK,
+			A:   claims.A,
+		}
+	}
+
+	// The nonce of the Syn and SynAck tokens is signed
+	if !isAck {
+		if claims, err = withNonce(claims); err != nil {
+			return []byte{}, []byte{}, err
+		}
+	}
+
 	// Combine the application claims with the standard claims
 	allclaims := &JWTClaims{
 		claims,
 		jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(c.ValidityPeriod).Unix(),
+			ExpiresAt: c.now().Add(c.ValidityPeriod).Unix(),
 			Issuer:    c.Issuer,
 		},
 	}
 
+	key := c.secrets.EncodingKey()
+
+	signMethod, err := c.signingMethod(key)
+	if err != nil {
+		return []byte{}, []byte{}, err
+	}
+
 	// Create the token and sign with our key
-	strtoken, err := jwt.NewWithClaims(c.signMethod, allclaims).SignedString(c.secrets.EncodingKey())
+	strtoken, err := jwt.NewWithClaims(signMethod, allclaims).SignedString(key)
 	if err != nil {
 		return []byte{}, []byte{}, err
 	}
@@ -98,10 +150,7 @@ func (c *JWTConfig) CreateAndSign(isAck bool, claims *ConnectionClaims) (token [
 	// again for Ack packets to reduce overhead
 	if !isAck {
 
-		nonce, err := crypto.GenerateRandomBytes(NonceLength)
-		if err != nil {
-			return []byte{}, []byte{}, err
-		}
+		nonce := claims.N
 
 		txKey := c.secrets.TransmittedKey()
 
@@ -137,6 +186,7 @@ func (c *JWTConfig) CreateAndSign(isAck bool, claims *ConnectionClaims) (token [
 func (c *JWTConfig) Decode(isAck bool, data []byte, previousCert interface{}) (claims *ConnectionClaims, nonce []byte, publicKey interface{}, err error) {
 
 	var ackCert interface{}
+	var cacheKey string
 
 	token := data
 
@@ -168,19 +218,35 @@ func (c *JWTConfig) Decode(isAck bool, data []byte, previousCert interface{}) (c
 		certBytes := data[tokenPosition+tokenLength+1:]
 		ackCert, err = c.secrets.VerifyPublicKey(certBytes)
 		if err != nil {
+			if revocation.IsRevoked(err) {
+				return nil, nil, nil, err
+			}
 			return nil, nil, nil, fmt.Errorf("invalid public key: %s", err)
 		}
 
-		if cachedClaims, cerr := c.tokenCache.Get(string(token)); cerr == nil {
+		// The claims carry the SPIFFE ID of the transmitted key. The cache is
+		// keyed on both the token and the key.
+		cacheKey = string(data[tokenPosition:])
+
+		if cachedClaims, cerr := c.tokenCache.Get(cacheKey); cerr == nil {
+			if err := checkNonce(cachedClaims.(*ConnectionClaims), nonce); err != nil {
+				return nil, nil, nil, err
+			}
 			return cachedClaims.(*ConnectionClaims), nonce, ackCert, nil
 		}
 	}
 
-	// Parse the JWT token with the public key recovered
-	jwttoken, err := jwt.ParseWithClaims(string(token), jwtClaims, func(token *jwt.Token) (interface{}, error) {
+	// Parse the JWT token with the public key recovered. The claims are
+	// validated against the clock of the configuration.
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	jwttoken, err := parser.ParseWithClaims(string(token), jwtClaims, func(token *jwt.Token) (interface{}, error) {
 		server := token.Claims.(*JWTClaims).Issuer
 		server = strings.Trim(server, " ")
-		return c.secrets.DecodingKey(server, ackCert, previousCert)
+		key, err := c.secrets.DecodingKey(server, ackCert, previousCert)
+		if err != nil {
+			return nil, err
+		}
+		return c.verifyingKey(token, key)
 	})
 
 	// If error is returned or the token is not valid, reject it
@@ -190,31 +256,144 @@ func (c *JWTConfig) Decode(isAck bool, data []byte, previousCert interface{}) (c
 	if !jwttoken.Valid {
 		return nil, nil, nil, errors.New("invalid token")
 	}
+	if err := jwtClaims.validAt(c.now()); err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to parse token: %s", err)
+	}
+
+	if err := c.expandTags(jwtClaims.ConnectionClaims); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if c.secrets.Type() == secrets.SPIFFEType && ackCert != nil {
+		if err := addSPIFFEID(jwtClaims.ConnectionClaims, ackCert.(*x509.Certificate)); err != nil {
+			return nil, nil, nil, err
+		}
+	}
 
-	c.tokenCache.AddOrUpdate(string(token), jwtClaims.ConnectionClaims)
+	if !isAck {
+		if err := checkNonce(jwtClaims.ConnectionClaims, nonce); err != nil {
+			return nil, nil, nil, err
+		}
+		addAttestation(jwtClaims.ConnectionClaims)
+		c.tokenCache.AddOrUpdate(cacheKey, jwtClaims.ConnectionClaims)
+	}
 
 	return jwtClaims.ConnectionClaims, nonce, ackCert, nil
 }
 
-// Randomize adds a nonce to an existing token. Returns the nonce
-func (c *JWTConfig) Randomize(token []byte) (nonce []byte, err error) {
+// now returns the current time of the clock of the configuration.
+func (c *JWTConfig) now() time.Time {
 
-	if len(token) < tokenPosition {
-		return []byte{}, errors.New("token is too small")
+	if c.Clock != nil {
+		return c.Clock()
+	}
+
+	return time.Now()
+}
+
+// validAt validates the time based standard claims at the given time.
+func (c *JWTClaims) validAt(now time.Time) error {
+
+	if !c.VerifyExpiresAt(now.Unix(), false) {
+		return errors.New("token is expired")
 	}
 
-	nonce, err = crypto.GenerateRandomBytes(NonceLength)
+	if !c.VerifyIssuedAt(now.Unix(), false) {
+		return errors.New("token used before issued")
+	}
+
+	if !c.VerifyNotBefore(now.Unix(), false) {
+		return errors.New("token is not valid yet")
+	}
+
+	return nil
+}
+
+// expandTags decodes the compact tags of the claims with the dictionary.
+func (c *JWTConfig) expandTags(claims *ConnectionClaims) error {
+
+	if len(claims.CT) == 0 {
+		return nil
+	}
+
+	if c.Dictionary == nil {
+		return errors.New("compact tags received without a tag dictionary")
+	}
+
+	t, err := c.Dictionary.Decode(claims.CT)
 	if err != nil {
-		return []byte{}, err
+		return fmt.Errorf("invalid compact tags: %s", err)
 	}
 
-	copy(token[noncePosition:], nonce)
+	claims.T = t
+	claims.CT = nil
 
-	return nonce, nil
+	return nil
+}
+
+// signingMethod returns the method signing the tokens with the key.
+func (c *JWTConfig) signingMethod(key interface{}) (jwt.SigningMethod, error) {
+
+	if !c.keyMethod {
+		return c.signMethod, nil
+	}
+
+	return signer.SigningMethod(key)
+}
+
+// verifyingKey returns the public key verifying a token. With PKI secrets, the
+// token must be signed with the method of the key of the certificate of the
+// sender.
+func (c *JWTConfig) verifyingKey(token *jwt.Token, key interface{}) (interface{}, error) {
+
+	if !c.keyMethod {
+		return key, nil
+	}
+
+	if cert, ok := key.(*x509.Certificate); ok {
+		key = cert.PublicKey
+	}
+
+	signMethod, err := signer.SigningMethod(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Method.Alg() != signMethod.Alg() {
+		return nil, fmt.Errorf("token signed with %s instead of %s", token.Method.Alg(), signMethod.Alg())
+	}
+
+	return key, nil
+}
+
+// addSPIFFEID adds the SPIFFE ID of the verified SVID of the sender to the tags
+// of the claims. Any SPIFFE ID tag provided by the sender itself is removed so
+// that policies can trust it.
+func addSPIFFEID(claims *ConnectionClaims, cert *x509.Certificate) error {
+
+	id, err := secrets.SPIFFEID(cert)
+	if err != nil {
+		return fmt.Errorf("invalid svid: %s", err)
+	}
+
+	replaceTags(claims, SPIFFEIDKey+"=", [2]string{SPIFFEIDKey, id})
+
+	return nil
 }
 
 // RetrieveNonce returns the nonce of a token. It copies the value
 func (c *JWTConfig) RetrieveNonce(token []byte) ([]byte, error) {
+	return retrieveNonce(token)
+}
+
+// AckSize returns the size of the Ack tokens. It depends on the type of the
+// key of the secrets.
+func (c *JWTConfig) AckSize() uint32 {
+	return c.secrets.AckSize()
+}
+
+// retrieveNonce returns a copy of the nonce of a Syn or SynAck token.
+func retrieveNonce(token []byte) ([]byte, error) {
 
 	if len(token) < tokenPosition {
 		return []byte{}, errors.New("invalid token")
@@ -0,0 +1,187 @@
+package tokens
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+const (
+	// compactTagsVersion is the first byte of the compact encoding of the
+	// tags
+	compactTagsVersion = 0x01
+	// dictionaryIDLength is the length of the identifier of a dictionary
+	dictionaryIDLength = 4
+)
+
+// TagDictionary is a list of tags shared out of band by all the enforcers of
+// a cluster. The tags of the dictionary are transmitted as their index in the
+// list instead of their value, which keeps the tokens of PUs with many labels
+// small. Tags that are not in the dictionary are transmitted as is.
+//
+// The order of the tags matters: all the enforcers must use the same list.
+// Tokens encoded with another dictionary are rejected.
+type TagDictionary struct {
+	id    []byte
+	tags  []string
+	index map[string]uint64
+}
+
+// NewTagDictionary returns a dictionary of the given tags.
+func NewTagDictionary(tags []string) (*TagDictionary, error) {
+
+	if len(tags) == 0 {
+		return nil, errors.New("tag dictionary can not be empty")
+	}
+
+	d := &TagDictionary{
+		tags:  make([]string, len(tags)),
+		index: make(map[string]uint64, len(tags)),
+	}
+
+	for i, tag := range tags {
+		if _, ok := d.index[tag]; ok {
+			return nil, fmt.Errorf("duplicate tag in dictionary: %s", tag)
+		}
+		d.tags[i] = tag
+		d.index[tag] = uint64(i)
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(tags, "\n")))
+	d.id = sum[:dictionaryIDLength]
+
+	return d, nil
+}
+
+// ID returns the identifier of the dictionary. It is derived from its tags.
+func (d *TagDictionary) ID() []byte {
+	return d.id
+}
+
+// Tags returns the tags of the dictionary.
+func (d *TagDictionary) Tags() []string {
+	return append([]string{}, d.tags...)
+}
+
+// Encode returns the compact encoding of the tags. The buffer holds the
+// version of the encoding, the identifier of the dictionary, the number of
+// tags found in the dictionary followed by the differences between their
+// sorted indexes, and finally the other tags prefixed by their length.
+func (d *TagDictionary) Encode(t *policy.TagStore) []byte {
+
+	indexes := []uint64{}
+	literals := []string{}
+
+	if t != nil {
+		for _, tag := range t.Tags {
+			if i, ok := d.index[tag]; ok {
+				indexes = append(indexes, i)
+				continue
+			}
+			literals = append(literals, tag)
+		}
+	}
+
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	buffer := make([]byte, 0, 1+dictionaryIDLength+binary.MaxVarintLen64*(len(indexes)+1))
+	buffer = append(buffer, compactTagsVersion)
+	buffer = append(buffer, d.id...)
+	buffer = appendUvarint(buffer, uint64(len(indexes)))
+
+	previous := uint64(0)
+	for _, i := range indexes {
+		buffer = appendUvarint(buffer, i-previous)
+		previous = i
+	}
+
+	return appendTags(buffer, literals)
+}
+
+// Decode returns the tags of a buffer returned by Encode.
+func (d *TagDictionary) Decode(data []byte) (*policy.TagStore, error) {
+
+	if !isCompactTags(data) || len(data) < 1+dictionaryIDLength {
+		return nil, errors.New("invalid compact tags")
+	}
+
+	if !bytes.Equal(data[1:1+dictionaryIDLength], d.id) {
+		return nil, errors.New("tags encoded with another dictionary")
+	}
+
+	r := bytes.NewReader(data[1+dictionaryIDLength:])
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errors.New("invalid number of compact tags")
+	}
+
+	t := policy.NewTagStore()
+
+	i := uint64(0)
+	for n := uint64(0); n < count; n++ {
+		delta, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errors.New("invalid compact tag")
+		}
+		i += delta
+		if i >= uint64(len(d.tags)) {
+			return nil, fmt.Errorf("tag %d is not in the dictionary", i)
+		}
+		t.Tags = append(t.Tags, d.tags[i])
+	}
+
+	if err := readTags(r, t); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// isCompactTags returns true if the tags of a buffer are encoded by a
+// dictionary.
+func isCompactTags(data []byte) bool {
+	return len(data) > 0 && data[0] == compactTagsVersion
+}
+
+// appendTags appends the tags prefixed by their length to the buffer.
+func appendTags(buffer []byte, tags []string) []byte {
+
+	for _, tag := range tags {
+		buffer = appendUvarint(buffer, uint64(len(tag)))
+		buffer = append(buffer, tag...)
+	}
+
+	return buffer
+}
+
+// readTags reads the tags appended by appendTags until the end of the reader.
+func readTags(r *bytes.Reader, t *policy.TagStore) error {
+
+	for r.Len() > 0 {
+		size, err := binary.ReadUvarint(r)
+		if err != nil || size > uint64(r.Len()) {
+			return errors.New("invalid tag length")
+		}
+		tag := make([]byte, size)
+		r.Read(tag) // nolint
+		t.Tags = append(t.Tags, string(tag))
+	}
+
+	return nil
+}
+
+// appendUvarint appends the varint encoding of v to the buffer.
+func appendUvarint(buffer []byte, v uint64) []byte {
+
+	b := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(b, v)
+
+	return append(buffer, b[:n]...)
+}
@@ -0,0 +1,201 @@
+package tokens
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func largeIdentity(n int) (*policy.TagStore, []string) {
+
+	t := policy.NewTagStore()
+	for i := 0; i < n; i++ {
+		t.AppendKeyValue(fmt.Sprintf("app.kubernetes.io/label-%d", i), fmt.Sprintf("value-%d", i))
+	}
+
+	return t, t.GetSlice()
+}
+
+func TestTagDictionary(t *testing.T) {
+
+	Convey("Given that I create dictionaries", t, func() {
+
+		Convey("Then empty dictionaries and duplicate tags should be rejected", func() {
+			_, err := NewTagDictionary(nil)
+			So(err, ShouldNotBeNil)
+			_, err = NewTagDictionary([]string{"a=b", "a=b"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then the identifier should depend on the tags and their order", func() {
+			d1, err := NewTagDictionary([]string{"a=b", "c=d"})
+			So(err, ShouldBeNil)
+			d2, err := NewTagDictionary([]string{"a=b", "c=d"})
+			So(err, ShouldBeNil)
+			d3, err := NewTagDictionary([]string{"c=d", "a=b"})
+			So(err, ShouldBeNil)
+			So(d1.ID(), ShouldResemble, d2.ID())
+			So(d1.ID(), ShouldNotResemble, d3.ID())
+			So(d1.Tags(), ShouldResemble, []string{"a=b", "c=d"})
+		})
+	})
+
+	Convey("Given a dictionary and tags partially in the dictionary", t, func() {
+		identity, known := largeIdentity(50)
+		d, err := NewTagDictionary(known[:40])
+		So(err, ShouldBeNil)
+
+		Convey("When I encode the tags", func() {
+			data := d.Encode(identity)
+
+			Convey("Then they should be decoded with the same dictionary", func() {
+				decoded, err := d.Decode(data)
+				So(err, ShouldBeNil)
+				expected := identity.GetSlice()
+				sort.Strings(expected)
+				tags := decoded.GetSlice()
+				sort.Strings(tags)
+				So(tags, ShouldResemble, expected)
+			})
+
+			Convey("Then they should be smaller than the tags", func() {
+				size := 0
+				for _, tag := range identity.Tags {
+					size += len(tag) + 1
+				}
+				So(len(data), ShouldBeLessThan, size/4)
+			})
+
+			Convey("Then they should be rejected by another dictionary", func() {
+				other, err := NewTagDictionary(known[:39])
+				So(err, ShouldBeNil)
+				_, err = other.Decode(data)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then truncated buffers should be rejected", func() {
+				_, err := d.Decode(data[:len(data)-1])
+				So(err, ShouldNotBeNil)
+				_, err = d.Decode(data[:3])
+				So(err, ShouldNotBeNil)
+				_, err = d.Decode([]byte("label=value "))
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("Then empty tags should be encoded", func() {
+			decoded, err := d.Decode(d.Encode(nil))
+			So(err, ShouldBeNil)
+			So(decoded.IsEmpty(), ShouldBeTrue)
+		})
+	})
+}
+
+func TestCreateAndVerifyWithDictionary(t *testing.T) {
+
+	Convey("Given JWT engines sharing a dictionary", t, func() {
+		identity, known := largeIdentity(50)
+		d, err := NewTagDictionary(known)
+		So(err, ShouldBeNil)
+
+		s := secrets.NewPSKSecrets(psk)
+		plain, err := NewJWT(validity, "TRIREME", s)
+		So(err, ShouldBeNil)
+		sender, err := NewJWT(validity, "TRIREME", s)
+		So(err, ShouldBeNil)
+		sender.Dictionary = d
+		receiver, err := NewJWT(validity, "TRIREME", s)
+		So(err, ShouldBeNil)
+		receiver.Dictionary = d
+
+		claims := &ConnectionClaims{T: identity, RMT: []byte(rmt), EK: []byte{}}
+
+		Convey("When I create a token with the dictionary", func() {
+			token, _, err := sender.CreateAndSign(false, claims)
+			So(err, ShouldBeNil)
+
+			Convey("Then it should be smaller than the token without the dictionary", func() {
+				plainToken, _, err := plain.CreateAndSign(false, claims)
+				So(err, ShouldBeNil)
+				So(len(token), ShouldBeLessThan, len(plainToken)/2)
+			})
+
+			Convey("Then the claims should be decoded with the dictionary", func() {
+				recovered, _, _, err := receiver.Decode(false, token, nil)
+				So(err, ShouldBeNil)
+				So(recovered.CT, ShouldBeNil)
+				So(len(recovered.T.Tags), ShouldEqual, len(identity.Tags))
+				value, ok := recovered.T.Get("app.kubernetes.io/label-7")
+				So(ok, ShouldBeTrue)
+				So(value, ShouldEqual, "value-7")
+				So(string(recovered.RMT), ShouldEqual, rmt)
+			})
+
+			Convey("Then it should be rejected without the dictionary", func() {
+				_, _, _, err := plain.Decode(false, token, nil)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then the claims of the caller should not be modified", func() {
+				So(claims.T, ShouldEqual, identity)
+				So(claims.CT, ShouldBeNil)
+			})
+		})
+
+		Convey("Then the tokens without the dictionary should still be decoded", func() {
+			token, _, err := plain.CreateAndSign(false, claims)
+			So(err, ShouldBeNil)
+			recovered, _, _, err := receiver.Decode(false, token, nil)
+			So(err, ShouldBeNil)
+			So(len(recovered.T.Tags), ShouldEqual, len(identity.Tags))
+		})
+
+		Convey("Then the size of the ack tokens should not change", func() {
+			token, _, err := sender.CreateAndSign(true, &ackClaims)
+			So(err, ShouldBeNil)
+			plainToken, _, err := plain.CreateAndSign(true, &ackClaims)
+			So(err, ShouldBeNil)
+			So(len(token), ShouldEqual, len(plainToken))
+		})
+	})
+
+	Convey("Given custom token engines sharing a dictionary", t, func() {
+		identity, known := largeIdentity(50)
+		d, err := NewTagDictionary(known)
+		So(err, ShouldBeNil)
+
+		sender := NewPSKCustomToken(validity, "TRIREME", psk)
+		sender.Dictionary = d
+		receiver := NewPSKCustomToken(validity, "TRIREME", psk)
+		receiver.Dictionary = d
+		plain := NewPSKCustomToken(validity, "TRIREME", psk)
+
+		token, _, err := sender.CreateAndSign(false, &ConnectionClaims{T: identity, RMT: []byte(rmt)})
+		So(err, ShouldBeNil)
+
+		Convey("Then the claims should be decoded with the dictionary", func() {
+			recovered, _, _, err := receiver.Decode(false, token, nil)
+			So(err, ShouldBeNil)
+			value, ok := recovered.T.Get("app.kubernetes.io/label-7")
+			So(ok, ShouldBeTrue)
+			So(value, ShouldEqual, "value-7")
+		})
+
+		Convey("Then the token should be rejected without the dictionary", func() {
+			_, _, _, err := plain.Decode(false, token, nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then the tokens without the dictionary should still be decoded", func() {
+			plainToken, _, err := plain.CreateAndSign(false, &ConnectionClaims{T: identity, RMT: []byte(rmt)})
+			So(err, ShouldBeNil)
+			recovered, _, _, err := receiver.Decode(false, plainToken, nil)
+			So(err, ShouldBeNil)
+			So(len(recovered.T.Tags), ShouldEqual, len(identity.Tags))
+		})
+	})
+}
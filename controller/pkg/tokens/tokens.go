@@ -1,6 +1,13 @@
 package tokens
 
-import "github.com/aporeto-inc/trireme-lib/policy"
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/crypto"
+)
 
 // ConnectionClaims captures all the claim information
 type ConnectionClaims struct {
@@ -11,22 +18,38 @@ type ConnectionClaims struct {
 	LCL []byte
 	// EK is the ephemeral EC key for encryption
 	EK []byte
+	// CT is the compact encoding of T by a tag dictionary. It replaces T in
+	// the tokens exchanged by enforcers sharing a dictionary
+	CT []byte `json:",omitempty"`
+	// A is the attestation of the node of the sender. It is only carried by
+	// the Syn and SynAck tokens
+	A *policy.Attestation `json:",omitempty"`
+	// N is the nonce of the Syn and SynAck tokens. It is signed so that a
+	// captured token can not be replayed with another nonce
+	N []byte `json:",omitempty"`
 }
 
+// EngineType is the type of the token engine of the enforcers
+type EngineType int
+
+const (
+	// JWTEngine encodes the tokens as JWTs
+	JWTEngine EngineType = iota
+	// CustomEngine encodes the tokens in the binary format of CustomTokenConfig
+	CustomEngine
+)
+
 // TokenEngine is the interface to the different implementations of tokens
 type TokenEngine interface {
 	// CreteAndSign creates a token, signs it and produces the final byte string
 	CreateAndSign(isAck bool, claims *ConnectionClaims) (token []byte, nonce []byte, err error)
 	// Decode decodes an incoming buffer and returns the claims and the sender certificate
 	Decode(isAck bool, data []byte, previousCert interface{}) (claims *ConnectionClaims, nonce []byte, publicKey interface{}, err error)
-	// Randomize inserts a source nonce in an existing token - New nonce will be
-	// create every time the token is transmitted as a challenge to the other side
-	// even when the token is cached. There should be space in the token already.
-	// Returns an error if there is no space
-	Randomize([]byte) (nonce []byte, err error)
 	// RetrieveNonce retrieves the nonce from the token only. Returns the nonce
 	// or an error if the nonce cannot be decoded
 	RetrieveNonce([]byte) ([]byte, error)
+	// AckSize returns the size of the Ack tokens created by the engine
+	AckSize() uint32
 }
 
 const (
@@ -34,4 +57,56 @@ const (
 	MaxServerName = 36
 	// NonceLength is the length of the Nonce to be used in the secrets
 	NonceLength = 16
+	// SPIFFEIDKey is the key of the tag carrying the verified SPIFFE ID of the
+	// remote when the secrets are X.509-SVIDs
+	SPIFFEIDKey = "$sys:spiffeid"
 )
+
+// withNonce returns a copy of the claims of a Syn or SynAck token carrying a
+// new random nonce.
+func withNonce(claims *ConnectionClaims) (*ConnectionClaims, error) {
+
+	nonce, err := crypto.GenerateRandomBytes(NonceLength)
+	if err != nil {
+		return nil, err
+	}
+
+	c := *claims
+	c.N = nonce
+
+	return &c, nil
+}
+
+// checkNonce returns an error if the nonce of a Syn or SynAck token is not
+// the signed one.
+func checkNonce(claims *ConnectionClaims, nonce []byte) error {
+
+	if !bytes.Equal(claims.N, nonce) {
+		return errors.New("nonce does not match the signed nonce")
+	}
+
+	return nil
+}
+
+// replaceTags removes the tags of the claims starting with the prefix and
+// appends the key value pairs whose value is not empty. The tags derived from
+// verified data can not be forged by the sender this way.
+func replaceTags(claims *ConnectionClaims, prefix string, pairs ...[2]string) {
+
+	tags := policy.NewTagStore()
+	if claims.T != nil {
+		for _, tag := range claims.T.Tags {
+			if !strings.HasPrefix(tag, prefix) {
+				tags.Tags = append(tags.Tags, tag)
+			}
+		}
+	}
+
+	for _, pair := range pairs {
+		if pair[1] != "" {
+			tags.AppendKeyValue(pair[0], pair[1])
+		}
+	}
+
+	claims.T = tags
+}
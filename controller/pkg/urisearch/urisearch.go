@@ -1,6 +1,10 @@
 package urisearch
 
 import (
+	"net/http"
+	"strings"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/apiauth"
 	"github.com/aporeto-inc/trireme-lib/policy"
 )
 
@@ -14,11 +18,14 @@ type node struct {
 // APICache represents an API cache.
 type APICache struct {
 	External bool
-	root     *node
+	// GRPC is true for the caches of gRPC services
+	GRPC bool
+	root *node
 }
 
-// NewAPICache creates a new API cache
-func NewAPICache(rules []*policy.HTTPRule, external bool) *APICache {
+// NewAPICache creates a new API cache. The data of the URIs are the compiled
+// authorization rules (*apiauth.Rule).
+func NewAPICache(rules []*policy.HTTPRule, external bool) (*APICache, error) {
 	a := &APICache{
 		root:     &node{},
 		External: external,
@@ -26,17 +33,47 @@ func NewAPICache(rules []*policy.HTTPRule, external bool) *APICache {
 
 	empty := struct{}{}
 	for _, rule := range rules {
+		authorization, err := apiauth.NewRule(rule)
+		if err != nil {
+			return nil, err
+		}
+
 		verbs := map[string]struct{}{}
 		for _, verb := range rule.Verbs {
 			verbs[verb] = empty
 		}
 
 		for _, uri := range rule.URIs {
-			insert(a.root, uri, verbs, rule.Scopes)
+			insert(a.root, uri, verbs, authorization)
+		}
+	}
+
+	return a, nil
+}
+
+// NewGRPCAPICache creates a new API cache for a gRPC service. The URIs of the
+// rules are the names of the methods, package.Service/Method, that are called
+// with a POST on /package.Service/Method.
+func NewGRPCAPICache(rules []*policy.HTTPRule, external bool) (*APICache, error) {
+	a := &APICache{
+		root:     &node{},
+		External: external,
+		GRPC:     true,
+	}
+
+	verbs := map[string]struct{}{http.MethodPost: {}}
+	for _, rule := range rules {
+		authorization, err := apiauth.NewRule(rule)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, method := range rule.URIs {
+			insert(a.root, "/"+strings.TrimPrefix(method, "/"), verbs, authorization)
 		}
 	}
 
-	return a
+	return a, nil
 }
 
 // Find finds a URI in the cache and returns true and the data if found.
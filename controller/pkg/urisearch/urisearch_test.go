@@ -3,6 +3,7 @@ package urisearch
 import (
 	"testing"
 
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/apiauth"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -44,15 +45,31 @@ func TestNewAPICache(t *testing.T) {
 		rules := initTrieRules()
 
 		Convey("When I insert them in the cache, I should get a valid cache", func() {
-			c := NewAPICache(rules, false)
+			c, err := NewAPICache(rules, false)
+			So(err, ShouldBeNil)
 			So(c, ShouldNotBeNil)
 			So(c.root.leaf, ShouldBeTrue)
-			So(c.root.data.([]string), ShouldNotBeNil)
+			So(c.root.data.(*apiauth.Rule), ShouldNotBeNil)
 			So(c.root.verbs, ShouldResemble, map[string]struct{}{"POST": struct{}{}})
-			So(len(c.root.data.([]string)), ShouldEqual, 1)
+			So(c.root.data.(*apiauth.Rule).ID, ShouldEqual, "app=root")
 			So(len(c.root.children), ShouldEqual, 4)
 		})
 	})
+
+	Convey("Given a rule with an invalid expression", t, func() {
+		rules := []*policy.HTTPRule{
+			&policy.HTTPRule{
+				Verbs:      []string{"GET"},
+				URIs:       []string{"/users"},
+				Expression: "group=eng and (",
+			},
+		}
+
+		Convey("When I insert it in the cache, I should get an error", func() {
+			_, err := NewAPICache(rules, false)
+			So(err, ShouldNotBeNil)
+		})
+	})
 }
 
 func TestInsert(t *testing.T) {
@@ -161,7 +178,8 @@ func TestParse(t *testing.T) {
 
 func TestAPICacheFind(t *testing.T) {
 	Convey("Given valid API cache", t, func() {
-		c := NewAPICache(initTrieRules(), false)
+		c, err := NewAPICache(initTrieRules(), false)
+		So(err, ShouldBeNil)
 		Convey("When I search for correct URIs, I should get the right data", func() {
 			found, data := c.Find("GET", "/users/123/name")
 			So(found, ShouldBeTrue)
@@ -206,3 +224,47 @@ func TestAPICacheFind(t *testing.T) {
 		})
 	})
 }
+
+func TestGRPCAPICache(t *testing.T) {
+	Convey("Given a set of gRPC rules", t, func() {
+		rules := []*policy.HTTPRule{
+			&policy.HTTPRule{
+				URIs:   []string{"helloworld.Greeter/SayHello"},
+				Scopes: []string{"app=hello"},
+			},
+			&policy.HTTPRule{
+				URIs:   []string{"/routeguide.RouteGuide/*"},
+				Scopes: []string{"app=route"},
+			},
+		}
+
+		Convey("When I insert them in the cache", func() {
+			c, err := NewGRPCAPICache(rules, false)
+			So(err, ShouldBeNil)
+			So(c, ShouldNotBeNil)
+			So(c.GRPC, ShouldBeTrue)
+
+			Convey("Then the methods should be found", func() {
+				found, data := c.Find("POST", "/helloworld.Greeter/SayHello")
+				So(found, ShouldBeTrue)
+				So(data.(*apiauth.Rule).ID, ShouldEqual, "app=hello")
+			})
+
+			Convey("Then all the methods of a service should be found", func() {
+				found, data := c.Find("POST", "/routeguide.RouteGuide/GetFeature")
+				So(found, ShouldBeTrue)
+				So(data.(*apiauth.Rule).ID, ShouldEqual, "app=route")
+			})
+
+			Convey("Then unknown methods should not be found", func() {
+				found, _ := c.Find("POST", "/helloworld.Greeter/SayGoodbye")
+				So(found, ShouldBeFalse)
+			})
+
+			Convey("Then calls that are not a POST should not be found", func() {
+				found, _ := c.Find("GET", "/helloworld.Greeter/SayHello")
+				So(found, ShouldBeFalse)
+			})
+		})
+	})
+}
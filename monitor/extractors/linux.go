@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"os/user"
@@ -153,6 +154,45 @@ func ProcessInfo(pid int32) []string {
 	return userdata
 }
 
+// NodeAttestation returns the facts of the node attested by the enforcer in
+// its tokens: the kernel version, the md5 of the binary of the enforcer and
+// the boot ID.
+func NodeAttestation() (*policy.Attestation, error) {
+
+	binary, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("unable to find enforcer binary: %s", err)
+	}
+
+	return nodeAttestation("/proc", binary)
+}
+
+// nodeAttestation returns the attestation read from the proc filesystem
+// mounted on procMountPoint for the binary.
+func nodeAttestation(procMountPoint string, binary string) (*policy.Attestation, error) {
+
+	kernel, err := ioutil.ReadFile(procMountPoint + "/sys/kernel/osrelease")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read kernel version: %s", err)
+	}
+
+	bootID, err := ioutil.ReadFile(procMountPoint + "/sys/kernel/random/boot_id")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read boot id: %s", err)
+	}
+
+	hash, err := computeFileMd5(binary)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compute enforcer hash: %s", err)
+	}
+
+	return &policy.Attestation{
+		KernelVersion: strings.TrimSpace(string(kernel)),
+		EnforcerHash:  hex.EncodeToString(hash),
+		BootID:        strings.TrimSpace(string(bootID)),
+	}, nil
+}
+
 // computeFileMd5 computes the Md5 of a file
 func computeFileMd5(filePath string) ([]byte, error) {
 
@@ -4,7 +4,10 @@ package extractors
 
 import (
 	"encoding/hex"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -32,6 +35,38 @@ func TestComputeFileMd5(t *testing.T) {
 	})
 }
 
+func TestNodeAttestation(t *testing.T) {
+
+	Convey("Given a proc filesystem with the facts of the node", t, func() {
+		proc, err := ioutil.TempDir("", "proc")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(proc) // nolint
+
+		So(os.MkdirAll(filepath.Join(proc, "sys", "kernel", "random"), 0700), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(proc, "sys", "kernel", "osrelease"), []byte("4.15.0-45-generic\n"), 0600), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(proc, "sys", "kernel", "random", "boot_id"), []byte("6b4c0c1e-4f8b-4bd4-9c5f-e2a7fd1c3c0e\n"), 0600), ShouldBeNil)
+
+		Convey("When I read the attestation of a binary", func() {
+			a, err := nodeAttestation(proc, "testdata/curl")
+
+			Convey("I should get the facts of the node and the hash of the binary", func() {
+				So(err, ShouldBeNil)
+				So(a.KernelVersion, ShouldEqual, "4.15.0-45-generic")
+				So(a.BootID, ShouldEqual, "6b4c0c1e-4f8b-4bd4-9c5f-e2a7fd1c3c0e")
+				So(a.EnforcerHash, ShouldEqual, "bf7e66d7bbd0465cfcba5b1cf68a9b59")
+			})
+		})
+
+		Convey("When the binary does not exist", func() {
+			_, err := nodeAttestation(proc, "testdata/nofile")
+
+			Convey("I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
 func TestFindFQDN(t *testing.T) {
 
 	Convey("When I try to get the hostname of a good host", t, func() {
@@ -12,6 +12,7 @@ const (
 	ServiceTCP ServiceType = iota
 	ServiceHTTP
 	ServiceL3
+	ServiceGRPC
 )
 
 // ApplicationServicesList is a list of ApplicationServices.
@@ -33,8 +34,8 @@ type ApplicationService struct {
 	// Type is the type of the service.
 	Type ServiceType
 
-	// HTTPRules are only valid for HTTP Services and capture the list of APIs
-	// exposed by the service.
+	// HTTPRules are only valid for HTTP and gRPC Services and capture the list
+	// of APIs exposed by the service.
 	HTTPRules []*HTTPRule
 
 	// Tags are the tags of the service.
@@ -45,6 +46,11 @@ type ApplicationService struct {
 	// a JWT token. It is used to validate the JWT tokens.
 	JWTCertificate []byte
 
+	// OIDC is the configuration of the OpenID Connect provider that authenticates
+	// the users of HTTP services. Unauthenticated browser requests are redirected
+	// to the provider and the claims of the ID tokens become user attributes.
+	OIDC *OIDCConfig
+
 	// External indicates if this is an external service. For external services
 	// access control is implemented at the ingress.
 	External bool
@@ -57,6 +63,10 @@ type ApplicationService struct {
 // HTTPRule holds a rule for a particular HTTPService. The rule
 // relates a set of URIs defined as regular expressions with associated
 // verbs. The * VERB indicates all actions.
+//
+// For gRPC services the URIs are the full names of the methods, as in
+// package.Service/Method or package.Service/* for all the methods of a
+// service, and the verbs are ignored.
 type HTTPRule struct {
 	// URIs is a list of regular expressions that describe the URIs that
 	// a service is exposing.
@@ -70,4 +80,33 @@ type HTTPRule struct {
 	// API. The scopes are presented either in the Trireme identity or the
 	// JWT of HTTP Authorization header.
 	Scopes []string
+
+	// Expression is an authorization expression that must also hold for the
+	// requests. It combines attribute patterns such as group=eng-*, or
+	// conditions on the headers and the tags of the source PU, with and, or
+	// and not.
+	Expression string
+
+	// ID identifies the rule in the flow records of the requests.
+	ID string
+}
+
+// OIDCConfig is the configuration of an OpenID Connect provider.
+type OIDCConfig struct {
+	// ProviderURL is the URL of the issuer. The configuration of the provider
+	// is discovered from the .well-known/openid-configuration document.
+	ProviderURL string
+
+	// ClientID is the client identifier registered with the provider.
+	ClientID string
+
+	// ClientSecret is the secret of the client.
+	ClientSecret string
+
+	// RedirectURI is the callback URL registered with the provider. Requests
+	// to its path complete the authentication of the users.
+	RedirectURI string
+
+	// Scopes is a list of scopes requested in addition to openid.
+	Scopes []string
 }
@@ -0,0 +1,28 @@
+package policy
+
+const (
+	// NodeAttestationPrefix is the prefix of the keys of the tags carrying the
+	// attested facts of the node of the remote
+	NodeAttestationPrefix = "$sys:node:"
+	// NodeKernelKey is the key of the tag carrying the kernel version of the
+	// node of the remote
+	NodeKernelKey = NodeAttestationPrefix + "kernel"
+	// NodeEnforcerKey is the key of the tag carrying the hash of the binary of
+	// the enforcer of the remote
+	NodeEnforcerKey = NodeAttestationPrefix + "enforcer"
+	// NodeBootIDKey is the key of the tag carrying the boot ID of the node of
+	// the remote
+	NodeBootIDKey = NodeAttestationPrefix + "bootid"
+)
+
+// Attestation captures the facts of the node of an enforcer. They are
+// collected by the enforcer itself and signed with its key in the Syn and
+// SynAck tokens.
+type Attestation struct {
+	// KernelVersion is the release of the kernel of the node
+	KernelVersion string `json:"k,omitempty"`
+	// EnforcerHash is the hex encoded md5 of the binary of the enforcer
+	EnforcerHash string `json:"h,omitempty"`
+	// BootID is the random identifier of the current boot of the node
+	BootID string `json:"b,omitempty"`
+}
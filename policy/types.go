@@ -19,6 +19,7 @@ const (
 	actionPassthrough = "passthrough"
 	actionEncrypt     = "encrypt"
 	actionLog         = "log"
+	actionRateLimit   = "ratelimit"
 
 	oactionContinue = "continue"
 	oactionApply    = "apply"
@@ -69,6 +70,11 @@ func (f ActionType) Observed() bool {
 	return f&Observe > 0
 }
 
+// RateLimited returns if the action mask contains the RateLimit mask.
+func (f ActionType) RateLimited() bool {
+	return f&RateLimit > 0
+}
+
 // ActionString returns if the action if accepted of rejected as a long string.
 func (f ActionType) ActionString() string {
 	if f.Accepted() && !f.Rejected() {
@@ -92,6 +98,8 @@ func (f ActionType) String() string {
 		return actionEncrypt
 	case Log:
 		return actionLog
+	case RateLimit:
+		return actionRateLimit
 	}
 
 	return actionUnknown
@@ -108,6 +116,8 @@ const (
 	Log ActionType = 0x8
 	// Observe instructs the datapath to observe policy results
 	Observe ActionType = 0x10
+	// RateLimit instructs the datapath to apply the rate limits of the policy
+	RateLimit ActionType = 0x20
 )
 
 // ObserveActionType is the action that can be applied to a flow for an observation rule.
@@ -152,12 +162,27 @@ const (
 	ObserveApply ObserveActionType = 0x2
 )
 
+// RateLimitParameters are the token bucket parameters of the RateLimit action.
+// A zero rate disables the corresponding limit.
+type RateLimitParameters struct {
+	// ConnectionsPerSecond is the rate of new connections
+	ConnectionsPerSecond float64
+	// ConnectionBurst is the number of connections allowed above the rate
+	ConnectionBurst int
+	// BytesPerSecond is the bandwidth of the connections of the policy
+	BytesPerSecond float64
+	// ByteBurst is the number of bytes allowed above the bandwidth. It is one
+	// second of bandwidth when it is zero
+	ByteBurst int
+}
+
 // FlowPolicy captures the policy for a particular flow
 type FlowPolicy struct {
 	ObserveAction ObserveActionType
 	Action        ActionType
 	ServiceID     string
 	PolicyID      string
+	RateLimit     *RateLimitParameters
 }
 
 // LogPrefix is the prefix used in nf-log action. It must be less than
@@ -58,3 +58,19 @@ func TestEncodeDecodePrefix(t *testing.T) {
 		}
 	})
 }
+
+func TestRateLimitAction(t *testing.T) {
+	Convey("When I create a rate limited accept action", t, func() {
+		f := &FlowPolicy{
+			Action:    Accept | RateLimit,
+			RateLimit: &RateLimitParameters{ConnectionsPerSecond: 200, ConnectionBurst: 10},
+		}
+		Convey("It should be accepted and rate limited", func() {
+			So(f.Action.Accepted(), ShouldBeTrue)
+			So(f.Action.RateLimited(), ShouldBeTrue)
+			So(f.Action.ActionString(), ShouldEqual, actionAccept)
+			So(RateLimit.String(), ShouldEqual, actionRateLimit)
+			So(Accept.RateLimited(), ShouldBeFalse)
+		})
+	})
+}
@@ -378,3 +378,27 @@ func TestRemoveWithDelay(t *testing.T) {
 
 	})
 }
+
+func TestKeyList(t *testing.T) {
+	Convey("Given a cache with entries", t, func() {
+		c := NewCache("cache")
+		c.Add("info1", "info1") // nolint
+		c.Add("info2", "info2") // nolint
+
+		Convey("When I list the keys", func() {
+			keys := c.KeyList()
+			Convey("I should get all the keys", func() {
+				So(len(keys), ShouldEqual, 2)
+				So(keys, ShouldContain, "info1")
+				So(keys, ShouldContain, "info2")
+			})
+		})
+
+		Convey("When I list the keys of an empty cache", func() {
+			keys := NewCache("empty").KeyList()
+			Convey("I should get an empty list", func() {
+				So(keys, ShouldBeEmpty)
+			})
+		})
+	})
+}
@@ -2,7 +2,9 @@ package crypto
 
 import (
 	"bytes"
+	gocrypto "crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/hmac"
 	"crypto/rand"
@@ -121,6 +123,38 @@ func LoadEllipticCurveKey(keyPEM []byte) (*ecdsa.PrivateKey, error) {
 	return key, nil
 }
 
+// LoadPrivateKey parses an ECDSA or Ed25519 private key. EC keys are accepted
+// in SEC 1 and PKCS#8 encodings, Ed25519 keys in PKCS#8.
+func LoadPrivateKey(keyPEM []byte) (gocrypto.Signer, error) {
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("unable to parse pem block")
+	}
+
+	if block.Type == "EC PRIVATE KEY" {
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %T", key)
+	}
+}
+
 // LoadAndVerifyCertificate parses, validates, and creates a certificate structure from a PEM buffer
 // It must be provided with the a CertPool
 func LoadAndVerifyCertificate(certPEM []byte, roots *x509.CertPool) (*x509.Certificate, error) {
@@ -165,6 +199,28 @@ func LoadAndVerifyECSecrets(keyPEM, certPEM, caCertPEM []byte) (key *ecdsa.Priva
 
 }
 
+// LoadAndVerifySecrets is like LoadAndVerifyECSecrets but it also accepts
+// PKCS#8 and Ed25519 keys.
+func LoadAndVerifySecrets(keyPEM, certPEM, caCertPEM []byte) (key gocrypto.Signer, cert *x509.Certificate, rootCertPool *x509.CertPool, err error) {
+
+	key, err = LoadPrivateKey(keyPEM)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	rootCertPool = LoadRootCertificates(caCertPEM)
+	if rootCertPool == nil {
+		return nil, nil, nil, errors.New("unable to load root certificate pool")
+	}
+
+	cert, err = LoadAndVerifyCertificate(certPEM, rootCertPool)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return key, cert, rootCertPool, nil
+}
+
 // LoadCertificate loads a certificate from a PEM file without verifying
 // Should only be used for loading a root CA certificate. It will only read
 // the first certificate
@@ -1,6 +1,14 @@
 package crypto
 
 import (
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"testing"
 
@@ -103,6 +111,53 @@ AwEHoUQDQgAEAfAL+AfPj/DnxrU6tUkEyzEyCxnflOWxhouy1bdzhJ7vxMb1vQ31
 	})
 }
 
+// TestFuncLoadPrivateKey tests the loading of the keys of all types
+func TestFuncLoadPrivateKey(t *testing.T) {
+	Convey("Given ECDSA and Ed25519 keys", t, func() {
+		p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		_, ed, _ := ed25519.GenerateKey(rand.Reader)
+
+		Convey("I should be able to load them in PKCS#8", func() {
+			for _, key := range []gocrypto.Signer{p256, p384, ed} {
+				der, err := x509.MarshalPKCS8PrivateKey(key)
+				So(err, ShouldBeNil)
+
+				loaded, err := LoadPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+				So(err, ShouldBeNil)
+				So(loaded, ShouldResemble, key)
+			}
+		})
+
+		Convey("I should be able to load the EC keys in SEC 1", func() {
+			der, err := x509.MarshalECPrivateKey(p384)
+			So(err, ShouldBeNil)
+
+			loaded, err := LoadPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+			So(err, ShouldBeNil)
+			So(loaded, ShouldResemble, p384)
+		})
+	})
+
+	Convey("Given an RSA key", t, func() {
+		key, _ := rsa.GenerateKey(rand.Reader, 1024)
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		So(err, ShouldBeNil)
+
+		Convey("I should get an error", func() {
+			_, err := LoadPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given an invalid PEM BLOCK", t, func() {
+		Convey("I should get an error", func() {
+			_, err := LoadPrivateKey([]byte(""))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
 // TestFuncLoadRootCertificates test the loading of root certs in a cert pool
 func TestFuncLoadRootCertificates(t *testing.T) {
 	Convey("Given a valid certificate chain", t, func() {
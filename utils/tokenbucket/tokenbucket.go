@@ -0,0 +1,99 @@
+package tokenbucket
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket is a token bucket rate limiter. Tokens are added at a fixed
+// rate up to the burst size and every event consumes one or more tokens.
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+	sync.Mutex
+}
+
+// New creates a token bucket that allows rate events per second with bursts
+// of up to burst events. The bucket starts full. A burst smaller than one
+// is set to one.
+func New(rate float64, burst int) *TokenBucket {
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	t := &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+	t.last = t.now()
+
+	return t
+}
+
+// Burst returns the burst size of the bucket
+func (t *TokenBucket) Burst() int {
+	return int(t.burst)
+}
+
+// Allow consumes a token if one is available.
+func (t *TokenBucket) Allow() bool {
+	return t.AllowN(1)
+}
+
+// AllowN consumes n tokens if they are available. It returns false and
+// consumes nothing otherwise.
+func (t *TokenBucket) AllowN(n int) bool {
+
+	t.Lock()
+	defer t.Unlock()
+
+	t.refill()
+
+	if t.tokens < float64(n) {
+		return false
+	}
+	t.tokens -= float64(n)
+
+	return true
+}
+
+// Reserve consumes n tokens, borrowing from the future if needed, and
+// returns how long the caller must wait before the tokens are available.
+func (t *TokenBucket) Reserve(n int) time.Duration {
+
+	t.Lock()
+	defer t.Unlock()
+
+	t.refill()
+
+	t.tokens -= float64(n)
+	if t.tokens >= 0 || t.rate <= 0 {
+		return 0
+	}
+
+	return time.Duration(-t.tokens / t.rate * float64(time.Second))
+}
+
+// refill adds the tokens accumulated since the last call. Must be called
+// with the lock held.
+func (t *TokenBucket) refill() {
+
+	now := t.now()
+	elapsed := now.Sub(t.last).Seconds()
+	t.last = now
+
+	if elapsed <= 0 {
+		return
+	}
+
+	t.tokens += elapsed * t.rate
+	if t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+}
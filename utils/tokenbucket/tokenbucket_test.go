@@ -0,0 +1,74 @@
+package tokenbucket
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestBucket(rate float64, burst int) (*TokenBucket, *time.Time) {
+
+	now := time.Now()
+	t := New(rate, burst)
+	t.now = func() time.Time { return now }
+	t.last = now
+
+	return t, &now
+}
+
+func TestAllow(t *testing.T) {
+
+	Convey("Given a token bucket of 10 events per second and a burst of 2", t, func() {
+
+		b, now := newTestBucket(10, 2)
+
+		Convey("Then the burst should be allowed and the next event rejected", func() {
+			So(b.Burst(), ShouldEqual, 2)
+			So(b.Allow(), ShouldBeTrue)
+			So(b.Allow(), ShouldBeTrue)
+			So(b.Allow(), ShouldBeFalse)
+		})
+
+		Convey("Then tokens should be refilled over time up to the burst", func() {
+			So(b.AllowN(2), ShouldBeTrue)
+			So(b.Allow(), ShouldBeFalse)
+
+			*now = now.Add(100 * time.Millisecond)
+			So(b.Allow(), ShouldBeTrue)
+			So(b.Allow(), ShouldBeFalse)
+
+			*now = now.Add(10 * time.Second)
+			So(b.AllowN(3), ShouldBeFalse)
+			So(b.AllowN(2), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given a token bucket with an invalid burst", t, func() {
+
+		b, _ := newTestBucket(10, 0)
+
+		Convey("Then the burst should be set to one", func() {
+			So(b.Burst(), ShouldEqual, 1)
+			So(b.Allow(), ShouldBeTrue)
+			So(b.Allow(), ShouldBeFalse)
+		})
+	})
+}
+
+func TestReserve(t *testing.T) {
+
+	Convey("Given a token bucket of 1000 bytes per second and a burst of 500", t, func() {
+
+		b, _ := newTestBucket(1000, 500)
+
+		Convey("Then reservations within the burst should not wait", func() {
+			So(b.Reserve(500), ShouldEqual, 0)
+		})
+
+		Convey("Then reservations above the burst should wait for the missing tokens", func() {
+			So(b.Reserve(1000), ShouldEqual, 500*time.Millisecond)
+			So(b.Reserve(100), ShouldEqual, 600*time.Millisecond)
+		})
+	})
+}